@@ -0,0 +1,35 @@
+package buffers
+
+import (
+  "errors"
+  "time"
+
+  "antivape/schemas"
+)
+
+var ErrBufferFull = errors.New("Sensor data buffer is full")
+
+// Entries not acked for this long are considered lost by their consumer and delivered again
+const pendingClaimIdle = 30 * time.Second
+
+type Entry struct {
+  ID string
+  Data schemas.ExternalSensorDataSchema
+  // Deliveries counts fetches of entry, it is more than 1 once entry was not acked in time
+  Deliveries int
+}
+
+// SensorDataBuffer holds readings between ingestion and postgres. Fetched
+// entries stay pending until they are acked, so delivery is at-least-once.
+type SensorDataBuffer interface {
+  // Push stores readings, returned slice is index-aligned with items, nil meaning stored
+  Push(items ...schemas.ExternalSensorDataSchema) []error
+  // Fetch returns up to count entries, waiting up to block if there are none
+  Fetch(count int, block time.Duration) ([]Entry, error)
+  Ack(ids ...string) error
+  // DeadLetter removes entries which can't be stored and keeps them aside for inspection
+  DeadLetter(entries ...Entry) error
+  // Latest returns the newest pushed reading of every guid which has one in
+  // buffer, it may be already stored in postgres
+  Latest(guids ...string) (map[string]schemas.ExternalSensorDataSchema, error)
+}
@@ -0,0 +1,147 @@
+package buffers
+
+import (
+  "log"
+  "strconv"
+  "sync"
+  "time"
+
+  "antivape/schemas"
+)
+
+type pendingEntry struct {
+  entry Entry
+  fetchedAt time.Time
+}
+
+// memoryBuffer is bounded in-process ring buffer for single node deployments
+// and tests. Entries are lost on restart, dead letters are only logged.
+type memoryBuffer struct {
+  mu sync.Mutex
+  ring []Entry
+  head int
+  size int
+  pending map[string]pendingEntry
+  lastID uint64
+  notify chan struct{}
+}
+
+func (b *memoryBuffer) Push(items ...schemas.ExternalSensorDataSchema) []error {
+  errs := make([]error, len(items))
+  b.mu.Lock()
+  for i, item := range items {
+    // Pending entries are counted too, so unacked backlog can't grow past capacity
+    if b.size + len(b.pending) >= len(b.ring) {
+      errs[i] = ErrBufferFull
+      continue
+    }
+    b.lastID++
+    b.ring[(b.head + b.size) % len(b.ring)] = Entry{ID: strconv.FormatUint(b.lastID, 10), Data: item}
+    b.size++
+  }
+  b.mu.Unlock()
+
+  select {
+  case b.notify <- struct{}{}:
+  default:
+  }
+  return errs
+}
+
+func (b *memoryBuffer) Fetch(count int, block time.Duration) ([]Entry, error) {
+  if entries := b.take(count); len(entries) > 0 {
+    return entries, nil
+  }
+  select {
+  case <-b.notify:
+  case <-time.After(block):
+  }
+  return b.take(count), nil
+}
+
+func (b *memoryBuffer) Ack(ids ...string) error {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+  for _, id := range ids {
+    delete(b.pending, id)
+  }
+  return nil
+}
+
+func (b *memoryBuffer) DeadLetter(entries ...Entry) error {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+  for _, entry := range entries {
+    data := entry.Data
+    log.Println("Dead letter sensor data ", entry.ID, ": ", data.Guid, data.Co2, data.Tvoc, data.BatteryCharge, *data.MeasuredAt)
+    delete(b.pending, entry.ID)
+  }
+  return nil
+}
+
+func (b *memoryBuffer) Latest(guids ...string) (map[string]schemas.ExternalSensorDataSchema, error) {
+  wanted := make(map[string]struct{}, len(guids))
+  for _, guid := range guids {
+    wanted[guid] = struct{}{}
+  }
+  latest := make(map[string]schemas.ExternalSensorDataSchema)
+  keep := func(data schemas.ExternalSensorDataSchema) {
+    if _, ok := wanted[data.Guid]; !ok {
+      return
+    }
+    if current, ok := latest[data.Guid]; ok && current.MeasuredAt.After(*data.MeasuredAt) {
+      return
+    }
+    latest[data.Guid] = data
+  }
+
+  b.mu.Lock()
+  defer b.mu.Unlock()
+  for _, pending := range b.pending {
+    keep(pending.entry.Data)
+  }
+  for i := 0; i < b.size; i++ {
+    keep(b.ring[(b.head + i) % len(b.ring)].Data)
+  }
+  return latest, nil
+}
+
+// take returns stale pending entries first, then new ones
+func (b *memoryBuffer) take(count int) []Entry {
+  b.mu.Lock()
+  defer b.mu.Unlock()
+  now := time.Now()
+  var entries []Entry
+  for id, pending := range b.pending {
+    if len(entries) >= count {
+      break
+    }
+    if now.Sub(pending.fetchedAt) < pendingClaimIdle {
+      continue
+    }
+    pending.entry.Deliveries++
+    entries = append(entries, pending.entry)
+    b.pending[id] = pendingEntry{entry: pending.entry, fetchedAt: now}
+  }
+  for len(entries) < count && b.size > 0 {
+    entry := b.ring[b.head]
+    b.ring[b.head] = Entry{}
+    b.head = (b.head + 1) % len(b.ring)
+    b.size--
+    entry.Deliveries = 1
+    entries = append(entries, entry)
+    b.pending[entry.ID] = pendingEntry{entry: entry, fetchedAt: now}
+  }
+  return entries
+}
+
+func NewMemoryBuffer(capacity int) SensorDataBuffer {
+  if capacity < 1 {
+    capacity = 1
+  }
+  return &memoryBuffer{
+    ring: make([]Entry, capacity),
+    pending: make(map[string]pendingEntry),
+    notify: make(chan struct{}, 1),
+  }
+}
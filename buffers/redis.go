@@ -0,0 +1,306 @@
+package buffers
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "log"
+  "os"
+  "strconv"
+  "time"
+
+  "antivape/schemas"
+  "github.com/redis/go-redis/v9"
+)
+
+const (
+  sensorDataStream = "sensor_data"
+  transferGroup = "transfer"
+  // Entries which couldn't be stored are moved here and kept until removed by hand
+  deadLetterStream = "sensor_data_dead"
+  latestKeyPrefix = "sensor_data_latest:"
+  // Latest readings outlive their stream entries, so they are still found
+  // while postgres is behind
+  latestTTL = time.Hour
+)
+
+// latest_script keeps reading in guid hash unless hash has newer one
+const latest_script string = `
+local current = redis.call('HGET', KEYS[1], 'measuredAt')
+if current and tonumber(current) > tonumber(ARGV[5]) then
+  return 0
+end
+redis.call('HSET', KEYS[1], 'guid', ARGV[1], 'co2', ARGV[2], 'tvoc', ARGV[3], 'batteryCharge', ARGV[4], 'measuredAt', ARGV[5])
+redis.call('PEXPIRE', KEYS[1], ARGV[6])
+return 1
+`
+
+// redisBuffer keeps readings in redis stream. Every app replica reads it as
+// consumer of one group, entries of crashed replica are claimed by others.
+type redisBuffer struct {
+  redisConn *redis.Client
+  ctx context.Context
+  consumer string
+  capacity int
+}
+
+// Push rejects readings over capacity instead of trimming stream, so entries
+// which are not stored yet are never dropped while postgres is behind
+func (b redisBuffer) Push(items ...schemas.ExternalSensorDataSchema) []error {
+  errs := make([]error, len(items))
+  length, err := b.redisConn.XLen(b.ctx, sensorDataStream).Result()
+  if err != nil {
+    for i := range errs {
+      errs[i] = err
+    }
+    return errs
+  }
+  free := b.capacity - int(length)
+  if free < 0 {
+    free = 0
+  }
+  for i := free; i < len(items); i++ {
+    errs[i] = ErrBufferFull
+  }
+  if free < len(items) {
+    items = items[:free]
+  }
+  if len(items) == 0 {
+    return errs
+  }
+
+  cmds := make([]*redis.StringCmd, len(items))
+  _, err = b.redisConn.Pipelined(b.ctx, func(pipe redis.Pipeliner) error {
+    for i, item := range items {
+      cmds[i] = pipe.XAdd(b.ctx, &redis.XAddArgs{Stream: sensorDataStream, Values: streamValues(item)})
+      pipe.Eval(
+        b.ctx,
+        latest_script,
+        []string{latestKeyPrefix + item.Guid},
+        item.Guid, item.Co2, item.Tvoc, item.BatteryCharge, item.MeasuredAt.UnixMilli(), latestTTL.Milliseconds(),
+      )
+    }
+    return nil
+  })
+  if err != nil {
+    log.Println("Error push sensor data: ", err)
+  }
+  for i, cmd := range cmds {
+    errs[i] = cmd.Err()
+  }
+  return errs
+}
+
+func (b redisBuffer) Fetch(count int, block time.Duration) ([]Entry, error) {
+  messages, deliveries, err := b.claimStale(count)
+  if err == nil && len(messages) == 0 {
+    messages, err = b.read(count, block)
+  }
+  if redis.HasErrorPrefix(err, "NOGROUP") {
+    b.createGroup()
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  entries := make([]Entry, 0, len(messages))
+  for _, message := range messages {
+    schema, err := messageToSchema(message.Values)
+    if err != nil {
+      log.Println("Error parse sensor data ", message.ID, ": ", err)
+      b.Ack(message.ID)
+      continue
+    }
+    entry := Entry{ID: message.ID, Data: schema, Deliveries: 1}
+    if delivered, ok := deliveries[message.ID]; ok {
+      entry.Deliveries = int(delivered)
+    }
+    entries = append(entries, entry)
+  }
+  return entries, nil
+}
+
+func (b redisBuffer) Ack(ids ...string) error {
+  if len(ids) == 0 {
+    return nil
+  }
+  _, err := b.redisConn.Pipelined(b.ctx, func(pipe redis.Pipeliner) error {
+    pipe.XAck(b.ctx, sensorDataStream, transferGroup, ids...)
+    pipe.XDel(b.ctx, sensorDataStream, ids...)
+    return nil
+  })
+  return err
+}
+
+// DeadLetter moves entries into dead letter stream with their ids as entryId
+func (b redisBuffer) DeadLetter(entries ...Entry) error {
+  if len(entries) == 0 {
+    return nil
+  }
+  ids := make([]string, 0, len(entries))
+  _, err := b.redisConn.TxPipelined(b.ctx, func(pipe redis.Pipeliner) error {
+    for _, entry := range entries {
+      pipe.XAdd(b.ctx, &redis.XAddArgs{Stream: deadLetterStream, Values: append(streamValues(entry.Data), "entryId", entry.ID)})
+      ids = append(ids, entry.ID)
+    }
+    pipe.XAck(b.ctx, sensorDataStream, transferGroup, ids...)
+    pipe.XDel(b.ctx, sensorDataStream, ids...)
+    return nil
+  })
+  return err
+}
+
+func (b redisBuffer) Latest(guids ...string) (map[string]schemas.ExternalSensorDataSchema, error) {
+  cmds := make([]*redis.MapStringStringCmd, len(guids))
+  _, err := b.redisConn.Pipelined(b.ctx, func(pipe redis.Pipeliner) error {
+    for i, guid := range guids {
+      cmds[i] = pipe.HGetAll(b.ctx, latestKeyPrefix + guid)
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  latest := make(map[string]schemas.ExternalSensorDataSchema, len(guids))
+  for _, cmd := range cmds {
+    fields := cmd.Val()
+    if len(fields) == 0 {
+      continue
+    }
+    values := make(map[string]interface{}, len(fields))
+    for field, value := range fields {
+      values[field] = value
+    }
+    schema, err := messageToSchema(values)
+    if err != nil {
+      log.Println("Error parse latest sensor data: ", err)
+      continue
+    }
+    latest[schema.Guid] = schema
+  }
+  return latest, nil
+}
+
+// read returns new entries for this consumer, blocking until some arrive
+func (b redisBuffer) read(count int, block time.Duration) ([]redis.XMessage, error) {
+  streams, err := b.redisConn.XReadGroup(b.ctx, &redis.XReadGroupArgs{
+    Group: transferGroup,
+    Consumer: b.consumer,
+    Streams: []string{sensorDataStream, ">"},
+    Count: int64(count),
+    Block: block,
+  }).Result()
+  if errors.Is(err, redis.Nil) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  return streams[0].Messages, nil
+}
+
+// claimStale takes over entries delivered to consumers which didn't ack them
+// in time and returns how many times each of them was delivered
+func (b redisBuffer) claimStale(count int) ([]redis.XMessage, map[string]int64, error) {
+  messages, _, err := b.redisConn.XAutoClaim(b.ctx, &redis.XAutoClaimArgs{
+    Stream: sensorDataStream,
+    Group: transferGroup,
+    Consumer: b.consumer,
+    MinIdle: pendingClaimIdle,
+    Start: "0-0",
+    Count: int64(count),
+  }).Result()
+  if err != nil || len(messages) == 0 {
+    return messages, nil, err
+  }
+
+  cmds := make([]*redis.XPendingExtCmd, len(messages))
+  _, err = b.redisConn.Pipelined(b.ctx, func(pipe redis.Pipeliner) error {
+    for i, message := range messages {
+      cmds[i] = pipe.XPendingExt(b.ctx, &redis.XPendingExtArgs{
+        Stream: sensorDataStream,
+        Group: transferGroup,
+        Start: message.ID,
+        End: message.ID,
+        Count: 1,
+      })
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, nil, err
+  }
+  deliveries := make(map[string]int64, len(messages))
+  for i, cmd := range cmds {
+    if pending := cmd.Val(); len(pending) > 0 {
+      deliveries[messages[i].ID] = pending[0].RetryCount
+    }
+  }
+  return messages, deliveries, nil
+}
+
+func (b redisBuffer) createGroup() {
+  err := b.redisConn.XGroupCreateMkStream(b.ctx, sensorDataStream, transferGroup, "0").Err()
+  if err != nil && !redis.HasErrorPrefix(err, "BUSYGROUP") {
+    log.Println("Error create sensor data consumer group: ", err)
+  }
+}
+
+// removeIdleConsumers forgets consumers of previous runs which have nothing pending
+func (b redisBuffer) removeIdleConsumers() {
+  consumers, err := b.redisConn.XInfoConsumers(b.ctx, sensorDataStream, transferGroup).Result()
+  if err != nil {
+    log.Println("Error list sensor data consumers: ", err)
+    return
+  }
+  for _, consumer := range consumers {
+    if consumer.Pending > 0 || consumer.Idle < pendingClaimIdle {
+      continue
+    }
+    b.redisConn.XGroupDelConsumer(b.ctx, sensorDataStream, transferGroup, consumer.Name)
+  }
+}
+
+func NewRedisBuffer(redisConn *redis.Client, capacity int) SensorDataBuffer {
+  buffer := redisBuffer{redisConn: redisConn, ctx: context.Background(), consumer: consumerName(), capacity: capacity}
+  buffer.createGroup()
+  buffer.removeIdleConsumers()
+  return buffer
+}
+
+func streamValues(schema schemas.ExternalSensorDataSchema) []interface{} {
+  return []interface{}{
+    "guid", schema.Guid,
+    "co2", schema.Co2,
+    "tvoc", schema.Tvoc,
+    "batteryCharge", schema.BatteryCharge,
+    "measuredAt", schema.MeasuredAt.UnixMilli(),
+  }
+}
+
+func messageToSchema(values map[string]interface{}) (schemas.ExternalSensorDataSchema, error) {
+  var schema schemas.ExternalSensorDataSchema
+  var err error
+  ints := make(map[string]int64, 4)
+  for _, field := range []string{"co2", "tvoc", "batteryCharge", "measuredAt"} {
+    value, _ := values[field].(string)
+    if ints[field], err = strconv.ParseInt(value, 10, 64); err != nil {
+      return schema, fmt.Errorf("%s: %w", field, err)
+    }
+  }
+  measuredAt := time.UnixMilli(ints["measuredAt"])
+  schema.Guid, _ = values["guid"].(string)
+  schema.Co2 = int(ints["co2"])
+  schema.Tvoc = int(ints["tvoc"])
+  schema.BatteryCharge = int(ints["batteryCharge"])
+  schema.MeasuredAt = &measuredAt
+  return schema, nil
+}
+
+// consumerName is unique per process, so restarted replica doesn't take
+// pending entries of its previous run before they are idle
+func consumerName() string {
+  hostname, _ := os.Hostname()
+  return hostname + "-" + strconv.Itoa(os.Getpid())
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+  "log"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// GetString returns environment variable value or fallback if it is unset.
+func GetString(key, fallback string) string {
+  value := os.Getenv(key)
+  if len(value) == 0 {
+    return fallback
+  }
+  return value
+}
+
+// GetInt returns environment variable parsed as int or fallback if it is unset or invalid.
+func GetInt(key string, fallback int) int {
+  value := os.Getenv(key)
+  if len(value) == 0 {
+    return fallback
+  }
+  parsed, err := strconv.Atoi(value)
+  if err != nil {
+    log.Println("Invalid int in "+key+": ", err)
+    return fallback
+  }
+  return parsed
+}
+
+// GetFloat returns environment variable parsed as float64 or fallback if it is unset or invalid.
+func GetFloat(key string, fallback float64) float64 {
+  value := os.Getenv(key)
+  if len(value) == 0 {
+    return fallback
+  }
+  parsed, err := strconv.ParseFloat(value, 64)
+  if err != nil {
+    log.Println("Invalid float in "+key+": ", err)
+    return fallback
+  }
+  return parsed
+}
+
+// GetDuration returns environment variable parsed by time.ParseDuration
+// (e.g. "90s", "5m") or fallback if it is unset or invalid.
+func GetDuration(key string, fallback time.Duration) time.Duration {
+  value := os.Getenv(key)
+  if len(value) == 0 {
+    return fallback
+  }
+  parsed, err := time.ParseDuration(value)
+  if err != nil {
+    log.Println("Invalid duration in "+key+": ", err)
+    return fallback
+  }
+  return parsed
+}
+
+// GetFloats returns environment variable parsed as comma separated float64
+// list or fallback if it is unset or invalid.
+func GetFloats(key string, fallback []float64) []float64 {
+  value := os.Getenv(key)
+  if len(value) == 0 {
+    return fallback
+  }
+  items := strings.Split(value, ",")
+  parsed := make([]float64, 0, len(items))
+  for _, item := range items {
+    number, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+    if err != nil {
+      log.Println("Invalid float list in "+key+": ", err)
+      return fallback
+    }
+    parsed = append(parsed, number)
+  }
+  return parsed
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+    "fmt"
+    "time"
+
     "gorm.io/gorm"
 )
 
@@ -14,6 +17,35 @@ type Sensor struct {
   RoomID uint
   ZoneID uint
   OwnerID uint
+  // Measurement time of the newest stored reading, capped at its storing time
+  LastSeenAt *time.Time
+  LastBatteryCharge int
+  // Seconds without readings after which sensor is offline, default if 0
+  Heartbeat int
+  Offline bool
+}
+
+type SensorCredential struct {
+  gorm.Model
+  SensorID uint `gorm:"index"`
+  Secret string
+  RevokedAt *time.Time
+}
+
+// UsedSignature is signature of device request accepted once, it is shared by
+// all app replicas and kept until its timestamp leaves replay window
+type UsedSignature struct {
+  Key string `gorm:"primaryKey"`
+  ExpiresAt time.Time `gorm:"index"`
+}
+
+// Gateway relays batches of readings of its zone sensors with its own secret
+type Gateway struct {
+  gorm.Model
+  ZoneID uint `gorm:"index"`
+  Name string
+  Guid string `gorm:"uniqueIndex"`
+  Secret string
 }
 
 type Room struct {
@@ -41,10 +73,296 @@ type User struct {
 
 type SensorData struct {
   gorm.Model
-  Guid string `gorm:"index"`
+  Guid string `gorm:"index;uniqueIndex:idx_sensor_data_reading,priority:1"`
   Co2 int
   Tvoc int
   BatteryCharge int
+  MeasuredAt time.Time `gorm:"index;uniqueIndex:idx_sensor_data_reading,priority:2"`
+}
+
+// QuarantinedSensorData holds readings of guids which match no sensor until they are claimed
+type QuarantinedSensorData struct {
+  gorm.Model
+  Guid string `gorm:"index;uniqueIndex:idx_quarantined_sensor_data_reading,priority:1"`
+  Co2 int
+  Tvoc int
+  BatteryCharge int
+  MeasuredAt time.Time `gorm:"index;uniqueIndex:idx_quarantined_sensor_data_reading,priority:2"`
+}
+
+// DetectionState is rolling state of vape detector for one sensor
+type DetectionState struct {
+  SensorID uint `gorm:"primaryKey;autoIncrement:false"`
+  Baseline float64
+  LastTvoc int
+  LastAt time.Time
+  EpisodeID uint
+  EpisodeStartedAt *time.Time
+  EpisodeEndedAt *time.Time
+  EpisodeConfirmed bool
+  EpisodeBaseline float64
+  EpisodePeakTvoc int
+  EpisodePeakCo2 int
+  IncidentID uint
+  UpdatedAt time.Time
+}
+
+type VapeEpisode struct {
+  gorm.Model
+  SensorID uint `gorm:"index"`
+  RoomID uint `gorm:"index"`
+  ZoneID uint `gorm:"index"`
+  StartedAt time.Time `gorm:"index"`
+  EndedAt *time.Time
+  PeakTvoc int
+  PeakCo2 int
+  BaselineTvoc int
+}
+
+// DetectionRule belongs to zone when RoomID is empty. Room rule overrides
+// zone rule with the same metric and operator.
+type DetectionRule struct {
+  gorm.Model
+  ZoneID uint `gorm:"index"`
+  RoomID *uint `gorm:"index"`
+  Name string
+  Metric string
+  Operator string
+  Threshold int
+  // Seconds
+  MinDuration int
+  Cooldown int
+  Enabled bool
+  Severity string `gorm:"default:warning"`
+}
+
+type RuleState struct {
+  RuleID uint `gorm:"primaryKey;autoIncrement:false"`
+  SensorID uint `gorm:"primaryKey;autoIncrement:false"`
+  BreachStartedAt *time.Time
+  LastTriggeredAt *time.Time
+  LastAt time.Time
+  // Incident opened by the last trigger, until breach is over
+  IncidentID uint
+}
+
+type RuleTrigger struct {
+  gorm.Model
+  RuleID uint `gorm:"index"`
+  SensorID uint `gorm:"index"`
+  RoomID uint `gorm:"index"`
+  ZoneID uint `gorm:"index"`
+  Metric string
+  Value int
+  Threshold int
+  BreachStartedAt time.Time
+  TriggeredAt time.Time `gorm:"index"`
+}
+
+// Incident is opened by vape episode or rule trigger and goes through
+// open, acknowledged and resolved states
+type Incident struct {
+  gorm.Model
+  SensorID uint `gorm:"index"`
+  RoomID uint `gorm:"index"`
+  ZoneID uint `gorm:"index"`
+  RuleID *uint `gorm:"index"`
+  EpisodeID *uint `gorm:"index"`
+  Severity string
+  State string `gorm:"index"`
+  StartedAt time.Time `gorm:"index"`
+  EndedAt *time.Time
+  PeakTvoc int
+  PeakCo2 int
+  AcknowledgedAt *time.Time
+  AcknowledgedBy *uint
+  AcknowledgeNote string
+  ResolvedAt *time.Time
+  ResolvedBy *uint
+  ResolveNote string
+  // Triggers grouped into incident
+  Occurrences int `gorm:"default:1"`
+  EscalatedAt *time.Time
+}
+
+type Webhook struct {
+  gorm.Model
+  ZoneID uint `gorm:"index"`
+  Url string
+  Secret string
+  // Comma separated, empty means every event type
+  EventTypes string
+  Enabled bool
+}
+
+type WebhookDelivery struct {
+  gorm.Model
+  WebhookID uint `gorm:"index"`
+  EventType string
+  Payload string
+  State string `gorm:"index"`
+  Attempts int
+  NextAttemptAt time.Time `gorm:"index"`
+  LastStatusCode int
+  LastError string
+  DeliveredAt *time.Time
+}
+
+// NotificationSubscription sends events of room, or of whole zone when RoomID
+// is empty, to user channel target
+type NotificationSubscription struct {
+  gorm.Model
+  UserID uint `gorm:"index"`
+  ZoneID uint `gorm:"index"`
+  RoomID *uint `gorm:"index"`
+  Channel string
+  Target string
+  // Comma separated, empty means every event type
+  EventTypes string
+  Enabled bool
+  // Escalation contact, gets only escalated incidents
+  Escalation bool
+}
+
+type Notification struct {
+  gorm.Model
+  SubscriptionID uint `gorm:"index"`
+  RoomID uint
+  IncidentID uint
+  Channel string
+  Target string
+  EventType string
+  Subject string
+  Text string
+  State string `gorm:"index"`
+  Attempts int
+  NextAttemptAt time.Time `gorm:"index"`
+  LastError string
+  SentAt *time.Time
+}
+
+// NotificationPolicy of zone, zero values switch its parts off
+type NotificationPolicy struct {
+  gorm.Model
+  ZoneID uint `gorm:"uniqueIndex"`
+  // Seconds after incident end while new trigger of the same rule and sensor joins it
+  GroupWindow int
+  // Seconds after incident notification while next ones of the same room are suppressed
+  Cooldown int
+  // HH:MM in Timezone, notifications are held until quiet hours end
+  QuietStart string
+  QuietEnd string
+  Timezone string
+  QuietAllowCritical bool
+  // Minutes incident may stay open before it is escalated
+  EscalateAfter int
+}
+
+// DeviceState is rolling state of tamper checks of sensor
+type DeviceState struct {
+  SensorID uint `gorm:"primaryKey;autoIncrement:false"`
+  LastAt time.Time
+  FlatCo2 int
+  FlatTvoc int
+  FlatSince time.Time
+  FlatlineEventID uint
+  BatteryRef int
+  BatteryRefAt time.Time
+  BatteryLowEventID uint
+}
+
+// DeviceEvent is offline, flatline or battery_drop period of sensor
+type DeviceEvent struct {
+  gorm.Model
+  SensorID uint `gorm:"index"`
+  RoomID uint
+  ZoneID uint `gorm:"index"`
+  Type string
+  StartedAt time.Time `gorm:"index"`
+  EndedAt *time.Time
+  Value int
+}
+
+// SensorDataRollup sums readings of sensor within bucket starting at BucketAt,
+// so avg, min, max and stddev of any union of buckets can be computed
+type SensorDataRollup struct {
+  Guid string `gorm:"primaryKey"`
+  BucketAt time.Time `gorm:"primaryKey"`
+  Count int
+  Co2Sum int64
+  Co2Min int
+  Co2Max int
+  Co2Squares float64
+  TvocSum int64
+  TvocMin int
+  TvocMax int
+  TvocSquares float64
+}
+
+type SensorDataMinute struct {
+  SensorDataRollup
+}
+
+type SensorDataHour struct {
+  SensorDataRollup
+}
+
+type SensorDataDay struct {
+  SensorDataRollup
+}
+
+// RollupState is the single row with id of the last sensor data rolled up
+type RollupState struct {
+  ID uint `gorm:"primaryKey"`
+  LastID uint
+  // Last id of next batch, rolled up once transactions running when it was
+  // chosen, which are below PendingXmax, are over
+  PendingID uint
+  PendingXmax uint64
+  UpdatedAt time.Time
+}
+
+// RetentionPolicy overrides global retention of zone data, in days. 0 keeps global retention.
+type RetentionPolicy struct {
+  gorm.Model
+  ZoneID uint `gorm:"uniqueIndex"`
+  RawDays int
+  MinuteDays int
+  HourDays int
+  DayDays int
+}
+
+// ReportDefinition makes zone report every period, the next one for period
+// ending at NextRunAt
+type ReportDefinition struct {
+  gorm.Model
+  ZoneID uint `gorm:"index"`
+  Name string
+  Period string
+  Timezone string
+  // Comma separated emails
+  Recipients string
+  // Comma separated, empty means every zone room
+  RoomIDs string
+  Enabled bool
+  NextRunAt time.Time `gorm:"index"`
+  // Why report of the last period wasn't made, empty when it was
+  LastError string
+}
+
+type Report struct {
+  gorm.Model
+  DefinitionID uint `gorm:"index"`
+  ZoneID uint `gorm:"index"`
+  PeriodFrom time.Time
+  PeriodTo time.Time
+  HTML string
+  CSV string
+  // Comma separated recipients report is not mailed to yet
+  PendingRecipients string
+  MailAttempts int
+  NextMailAt time.Time `gorm:"index"`
+  LastError string
 }
 
 func MigrateModels(db *gorm.DB) {
@@ -52,5 +370,49 @@ func MigrateModels(db *gorm.DB) {
   db.AutoMigrate(&Room{})
   db.AutoMigrate(&Zone{})
   db.AutoMigrate(&User{})
+  uniqueReadings(db, &SensorData{}, "sensor_data", "idx_sensor_data_reading")
+  if db.Migrator().HasIndex(&SensorData{}, "idx_sensor_data_guid_measured_at") {
+    db.Migrator().DropIndex(&SensorData{}, "idx_sensor_data_guid_measured_at")
+  }
   db.AutoMigrate(&SensorData{})
+  db.Exec("UPDATE sensor_data SET measured_at = created_at WHERE measured_at IS NULL")
+  db.AutoMigrate(&SensorCredential{})
+  db.AutoMigrate(&UsedSignature{})
+  db.AutoMigrate(&Gateway{})
+  uniqueReadings(db, &QuarantinedSensorData{}, "quarantined_sensor_data", "idx_quarantined_sensor_data_reading")
+  db.AutoMigrate(&QuarantinedSensorData{})
+  db.AutoMigrate(&DetectionState{})
+  db.AutoMigrate(&VapeEpisode{})
+  db.AutoMigrate(&DetectionRule{})
+  db.AutoMigrate(&RuleState{})
+  db.AutoMigrate(&RuleTrigger{})
+  db.AutoMigrate(&Incident{})
+  db.AutoMigrate(&Webhook{})
+  db.AutoMigrate(&WebhookDelivery{})
+  db.AutoMigrate(&NotificationSubscription{})
+  db.AutoMigrate(&Notification{})
+  db.AutoMigrate(&NotificationPolicy{})
+  db.AutoMigrate(&DeviceState{})
+  db.AutoMigrate(&DeviceEvent{})
+  db.AutoMigrate(&SensorDataMinute{})
+  db.AutoMigrate(&SensorDataHour{})
+  db.AutoMigrate(&SensorDataDay{})
+  db.AutoMigrate(&RollupState{})
+  db.AutoMigrate(&RetentionPolicy{})
+  db.AutoMigrate(&ReportDefinition{})
+  db.AutoMigrate(&Report{})
+}
+
+// Readings delivered more than once before they were unique are kept once
+const dedupe_readings_query string = `
+DELETE FROM %[1]s a USING %[1]s b
+WHERE a.guid = b.guid AND a.measured_at = b.measured_at AND a.id > b.id;
+`
+
+// uniqueReadings removes duplicate readings of existing table which has no unique index yet
+func uniqueReadings(db *gorm.DB, model interface{}, table string, index string) {
+  if !db.Migrator().HasTable(model) || db.Migrator().HasIndex(model, index) {
+    return
+  }
+  db.Exec(fmt.Sprintf(dedupe_readings_query, table))
 }
@@ -109,98 +109,41 @@ const docTemplate = `{
                 }
             }
         },
-        "/external/sensors_data": {
-            "post": {
-                "description": "store sensordata",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "External"
-                ],
-                "summary": "store sensordata",
-                "parameters": [
-                    {
-                        "description": "Create room",
-                        "name": "account",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/schemas.ExternalSensorDataSchema"
-                        }
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK"
-                    }
-                }
-            }
-        },
-        "/room": {
-            "post": {
+        "/episode/": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "create room",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Find detected vape episodes in rooms owned by user, newest first",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Room"
+                    "Episode"
                 ],
-                "summary": "Create room",
+                "summary": "Find vape episodes",
                 "parameters": [
                     {
-                        "description": "Create room",
-                        "name": "account",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/schemas.RoomCreateSchema"
-                        }
-                    }
-                ],
-                "responses": {
-                    "201": {
-                        "description": "Created",
-                        "schema": {
-                            "$ref": "#/definitions/schemas.RoomSchema"
-                        }
-                    }
-                }
-            }
-        },
-        "/room/": {
-            "get": {
-                "security": [
+                        "type": "string",
+                        "description": "RFC3339, episodes started in [from, to)",
+                        "name": "from",
+                        "in": "query"
+                    },
                     {
-                        "ApiKeyAuth": []
-                    }
-                ],
-                "description": "Find rooms",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "Room"
-                ],
-                "summary": "Find rooms",
-                "parameters": [
+                        "type": "integer",
+                        "name": "room_id",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
-                        "name": "owner_id",
+                        "name": "sensor_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "to",
                         "in": "query"
                     },
                     {
@@ -215,35 +158,32 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/schemas.RoomSchema"
+                                "$ref": "#/definitions/schemas.VapeEpisodeSchema"
                             }
                         }
                     }
                 }
             }
         },
-        "/room/{id}": {
+        "/episode/{id}": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get room",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Get vape episode",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Room"
+                    "Episode"
                 ],
-                "summary": "Get room",
+                "summary": "Get vape episode",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Room ID",
+                        "description": "Episode ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -253,44 +193,89 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/schemas.RoomSchema"
+                            "$ref": "#/definitions/schemas.VapeEpisodeSchema"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/export/": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete by id room",
+                "description": "Stream readings of sensor, room or zone ordered by measured_at as CSV or NDJSON,\nwith sensor, room and zone names",
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
                 "tags": [
-                    "Room"
+                    "Export"
                 ],
-                "summary": "Delete an room",
+                "summary": "Export readings",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv or ndjson, csv by default",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured in [from, to)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d",
+                        "name": "last",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
-                        "description": "Room ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "name": "room_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "sensor_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "zone_id",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.ExportRowSchema"
+                            }
+                        }
                     }
                 }
-            },
-            "patch": {
+            }
+        },
+        "/external/sensors_data": {
+            "post": {
                 "security": [
                     {
-                        "ApiKeyAuth": []
+                        "SensorSecret": []
                     }
                 ],
-                "description": "Update by json room",
+                "description": "store sensordata",
                 "consumes": [
                     "application/json"
                 ],
@@ -298,76 +283,135 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Room"
+                    "External"
                 ],
-                "summary": "Update an room",
+                "summary": "store sensordata",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Room ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Update room",
-                        "name": "room",
+                        "description": "Create room",
+                        "name": "account",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/schemas.RoomUpdateSchema"
+                            "$ref": "#/definitions/schemas.ExternalSensorDataSchema"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sensor guid",
+                        "name": "X-Sensor-Guid",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Gateway guid instead of sensor guid",
+                        "name": "X-Gateway-Guid",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unix seconds, required with X-Signature",
+                        "name": "X-Timestamp",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Hex HMAC-SHA256 of timestamp.body with sensor or gateway secret",
+                        "name": "X-Signature",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK"
+                    },
+                    "429": {
+                        "description": "Too Many Requests"
+                    },
+                    "503": {
+                        "description": "Service Unavailable"
                     }
                 }
             }
         },
-        "/room/{id}/statistic": {
-            "get": {
+        "/external/sensors_data/batch": {
+            "post": {
                 "security": [
                     {
-                        "ApiKeyAuth": []
+                        "SensorSecret": []
                     }
                 ],
-                "description": "Get room statistic",
+                "description": "Store array of sensordata. Body is a JSON array or NDJSON (Content-Type: application/x-ndjson).\nEvery item is accepted or rejected separately, so only rejected items should be retried.",
+                "consumes": [
+                    "application/json",
+                    "application/x-ndjson"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Room"
+                    "External"
                 ],
-                "summary": "Get room statistic",
+                "summary": "store sensordata batch",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "Room ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Sensordata batch",
+                        "name": "batch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.ExternalSensorDataSchema"
+                            }
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sensor guid, items must be readings of this sensor",
+                        "name": "X-Sensor-Guid",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Gateway guid instead of sensor guid, items may be readings of any sensor of gateway zone",
+                        "name": "X-Gateway-Guid",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unix seconds, required with X-Signature",
+                        "name": "X-Timestamp",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Hex HMAC-SHA256 of timestamp.body with sensor or gateway secret",
+                        "name": "X-Signature",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                            "$ref": "#/definitions/schemas.ExternalBatchResultSchema"
                         }
+                    },
+                    "429": {
+                        "description": "Too Many Requests"
                     }
                 }
             }
         },
-        "/sensor": {
+        "/gateway": {
             "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "create sensor",
+                "description": "Register zone gateway. Gateway sends batches of readings of zone sensors with X-Gateway-Guid header\nand its secret, like sensor does. Secret is returned only in this response.",
                 "consumes": [
                     "application/json"
                 ],
@@ -375,57 +419,49 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Sensor"
+                    "Gateway"
                 ],
-                "summary": "Create sensor",
+                "summary": "Create gateway",
                 "parameters": [
                     {
-                        "description": "Create sensor",
-                        "name": "account",
+                        "description": "Create gateway",
+                        "name": "gateway",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/schemas.SensorCreateSchema"
+                            "$ref": "#/definitions/schemas.GatewayCreateSchema"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/schemas.SensorSchema"
+                            "$ref": "#/definitions/schemas.GatewaySchema"
                         }
                     }
                 }
             }
         },
-        "/sensor/": {
+        "/gateway/": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Find sensors",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Find gateways of zones owned by user",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Sensor"
+                    "Gateway"
                 ],
-                "summary": "Find sensors",
+                "summary": "Find gateways",
                 "parameters": [
                     {
                         "type": "integer",
-                        "name": "owner_id",
-                        "in": "query"
-                    },
-                    {
-                        "type": "integer",
-                        "name": "room_id",
+                        "name": "zone_id",
                         "in": "query"
                     }
                 ],
@@ -435,35 +471,32 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/schemas.SensorSchema"
+                                "$ref": "#/definitions/schemas.GatewaySchema"
                             }
                         }
                     }
                 }
             }
         },
-        "/sensor/{id}": {
+        "/gateway/{id}": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get sensor",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Get gateway, secret is never returned",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Sensor"
+                    "Gateway"
                 ],
-                "summary": "Get sensor",
+                "summary": "Get gateway",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Sensor ID",
+                        "description": "Gateway ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -473,7 +506,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/schemas.SensorSchema"
+                            "$ref": "#/definitions/schemas.GatewaySchema"
                         }
                     }
                 }
@@ -484,15 +517,15 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete by id sensor",
+                "description": "Delete gateway, it can't send readings anymore",
                 "tags": [
-                    "Sensor"
+                    "Gateway"
                 ],
-                "summary": "Delete an sensor",
+                "summary": "Delete gateway",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Sensor ID",
+                        "description": "Gateway ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -503,102 +536,122 @@ const docTemplate = `{
                         "description": "No Content"
                     }
                 }
-            },
-            "patch": {
+            }
+        },
+        "/gateway/{id}/credentials": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update by json sensor",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Issue new gateway secret, previous one stops working. Secret is returned only once",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Sensor"
+                    "Gateway"
                 ],
-                "summary": "Update an sensor",
+                "summary": "Rotate gateway credentials",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Sensor ID",
+                        "description": "Gateway ID",
                         "name": "id",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "description": "Update sensor",
-                        "name": "sensor",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/schemas.SensorUpdateSchema"
-                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.GatewaySchema"
+                        }
                     }
                 }
             }
         },
-        "/user/": {
+        "/incident/": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Find users",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Find incidents in rooms or zones owned by user, newest first. Superuser sees all incidents.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "Incident"
+                ],
+                "summary": "Find incidents",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "RFC3339, incidents started in [from, to)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "room_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "sensor_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "zone_id",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Find users",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/schemas.UserSchema"
+                                "$ref": "#/definitions/schemas.IncidentSchema"
                             }
                         }
                     }
                 }
             }
         },
-        "/user/{id}": {
+        "/incident/{id}": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get user",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Get incident",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "Incident"
                 ],
-                "summary": "Get user",
+                "summary": "Get incident",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "user ID",
+                        "description": "Incident ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -608,140 +661,155 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/schemas.UserSchema"
+                            "$ref": "#/definitions/schemas.IncidentSchema"
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/incident/{id}/acknowledge": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete by id user",
+                "description": "Acknowledge open incident with optional note",
+                "consumes": [
+                    "application/json"
+                ],
                 "tags": [
-                    "user"
+                    "Incident"
                 ],
-                "summary": "Delete an user",
+                "summary": "Acknowledge incident",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "user ID",
+                        "description": "Incident ID",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Note",
+                        "name": "note",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.IncidentNoteSchema"
+                        }
                     }
                 ],
                 "responses": {
                     "204": {
                         "description": "No Content"
+                    },
+                    "409": {
+                        "description": "Conflict"
                     }
                 }
-            },
-            "patch": {
+            }
+        },
+        "/incident/{id}/resolve": {
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update by json user",
+                "description": "Resolve open or acknowledged incident with optional note",
                 "consumes": [
                     "application/json"
                 ],
-                "produces": [
-                    "application/json"
-                ],
                 "tags": [
-                    "user"
+                    "Incident"
                 ],
-                "summary": "Update an user",
+                "summary": "Resolve incident",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "user ID",
+                        "description": "Incident ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Update user",
-                        "name": "user",
+                        "description": "Note",
+                        "name": "note",
                         "in": "body",
-                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/schemas.UserUpdateSchema"
+                            "$ref": "#/definitions/schemas.IncidentNoteSchema"
                         }
                     }
                 ],
                 "responses": {
                     "204": {
                         "description": "No Content"
+                    },
+                    "409": {
+                        "description": "Conflict"
                     }
                 }
             }
         },
-        "/zone": {
-            "post": {
+        "/report/": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "create zone",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Last 100 reports of zones owned by user, newest first",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Zone"
+                    "Report"
                 ],
-                "summary": "Create zone",
+                "summary": "Find reports",
                 "parameters": [
                     {
-                        "description": "Create zone",
-                        "name": "account",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/schemas.ZoneCreateSchema"
-                        }
+                        "type": "integer",
+                        "name": "definition_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "zone_id",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/schemas.ZoneSchema"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.ReportSchema"
+                            }
                         }
                     }
                 }
             }
         },
-        "/zone/": {
+        "/report/definition": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Find zones",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Find report definitions of zones owned by user",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Zone"
+                    "Report"
                 ],
-                "summary": "Find zones",
+                "summary": "Find report definitions",
                 "parameters": [
                     {
                         "type": "integer",
-                        "name": "owner_id",
+                        "name": "zone_id",
                         "in": "query"
                     }
                 ],
@@ -751,21 +819,19 @@ const docTemplate = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/schemas.ZoneSchema"
+                                "$ref": "#/definitions/schemas.ReportDefinitionSchema"
                             }
                         }
                     }
                 }
-            }
-        },
-        "/zone/{id}": {
-            "get": {
+            },
+            "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get zone",
+                "description": "Define zone report made after every period and mailed as HTML to recipients",
                 "consumes": [
                     "application/json"
                 ],
@@ -773,13 +839,49 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Zone"
+                    "Report"
                 ],
-                "summary": "Get zone",
+                "summary": "Create report definition",
+                "parameters": [
+                    {
+                        "description": "Create report definition",
+                        "name": "definition",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.ReportDefinitionCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.ReportDefinitionSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/report/definition/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get report definition",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Report"
+                ],
+                "summary": "Get report definition",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Zone ID",
+                        "description": "Report definition ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -789,7 +891,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/schemas.ZoneSchema"
+                            "$ref": "#/definitions/schemas.ReportDefinitionSchema"
                         }
                     }
                 }
@@ -800,15 +902,15 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete by id zone",
+                "description": "Delete report definition, its reports are kept",
                 "tags": [
-                    "Zone"
+                    "Report"
                 ],
-                "summary": "Delete an zone",
+                "summary": "Delete report definition",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Zone ID",
+                        "description": "Report definition ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -826,32 +928,29 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update by json zone",
+                "description": "Update report definition. Change of period, timezone or enabled schedules next report for period in progress",
                 "consumes": [
                     "application/json"
                 ],
-                "produces": [
-                    "application/json"
-                ],
                 "tags": [
-                    "Zone"
+                    "Report"
                 ],
-                "summary": "Update an zone",
+                "summary": "Update report definition",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Zone ID",
+                        "description": "Report definition ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Update zone",
-                        "name": "zone",
+                        "description": "Update report definition",
+                        "name": "definition",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/schemas.ZoneUpdateSchema"
+                            "$ref": "#/definitions/schemas.ReportDefinitionUpdateSchema"
                         }
                     }
                 ],
@@ -862,25 +961,25 @@ const docTemplate = `{
                 }
             }
         },
-        "/zone/{id}/statistic": {
+        "/report/{id}": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get zone statistic",
+                "description": "Get report with its mailing status",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Zone"
+                    "Report"
                 ],
-                "summary": "Get zone statistic",
+                "summary": "Get report",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Zone ID",
+                        "description": "Report ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -890,247 +989,4785 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/schemas.SensorDataZoneSchema"
+                            "$ref": "#/definitions/schemas.ReportSchema"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "schemas.ExternalSensorDataSchema": {
-            "type": "object",
-            "required": [
-                "batteryCharge",
-                "co2",
-                "guid",
-                "tvoc"
-            ],
-            "properties": {
-                "batteryCharge": {
-                    "type": "integer"
-                },
-                "co2": {
-                    "type": "integer"
-                },
-                "guid": {
-                    "type": "string"
-                },
-                "tvoc": {
-                    "type": "integer"
+        },
+        "/report/{id}/csv": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Download report as CSV: rooms table, empty line and sensors table",
+                "produces": [
+                    "text/csv"
+                ],
+                "tags": [
+                    "Report"
+                ],
+                "summary": "Download CSV report",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Report ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
                 }
             }
         },
-        "schemas.LoginSchema": {
-            "type": "object",
-            "required": [
-                "password",
-                "username"
+        "/report/{id}/html": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Download report as HTML page",
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "Report"
+                ],
+                "summary": "Download HTML report",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Report ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/room": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "create room",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Create room",
+                "parameters": [
+                    {
+                        "description": "Create room",
+                        "name": "account",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RoomCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RoomSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/room/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find rooms",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Find rooms",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "name": "owner_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "zone_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.RoomSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/room/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get room",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Get room",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RoomSchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete by id room",
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Delete an room",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update by json room",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Update an room",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update room",
+                        "name": "room",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RoomUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/room/{id}/bands": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Time room readings spent in every air quality band of co2, tvoc and the worse of them, with its share",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Get room air quality bands",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RoomBandsSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/room/{id}/compare": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Statistic of room in interval and previous interval side by side with deltas",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Compare room statistic",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 1h or 30d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, previous interval start, interval of the same length before from by default",
+                        "name": "previous_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, previous interval end",
+                        "name": "previous_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated avg, min, max, median, p95, stddev, count, time_above",
+                        "name": "aggregates",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "CO2 threshold of time_above",
+                        "name": "co2_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "TVOC threshold of time_above",
+                        "name": "tvoc_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sample or time",
+                        "name": "weighting",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RoomComparisonSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/room/{id}/rules": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Rules effective for room: its own rules and zone rules which it doesn't override.\nRoom rule overrides zone rule with the same metric and operator.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Find room rules",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.RuleSchema"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Create room rule",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Create room rule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Create rule",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RuleCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RuleSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/room/{id}/rules/triggers": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find rule triggers of room sensors, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Find room rule triggers",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.RuleTriggerSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/room/{id}/rules/{ruleID}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete room rule, zone rule it overrides becomes effective again",
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Delete room rule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rule ID",
+                        "name": "ruleID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update room rule, inherited zone rules are changed on zone",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Update room rule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rule ID",
+                        "name": "ruleID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update rule",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RuleUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/room/{id}/series": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "CO2 and TVOC avg, min, max and count per time bucket, one series for room or per its sensor",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Get room series",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "1m, 5m, 1h or 1d",
+                        "name": "bucket",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Add empty buckets",
+                        "name": "fill",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "room or sensor",
+                        "name": "granularity",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.SeriesSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/room/{id}/statistic": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get room statistic",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Room"
+                ],
+                "summary": "Get room statistic",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Room ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated avg, min, max, median, p95, stddev, count, time_above",
+                        "name": "aggregates",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "CO2 threshold of time_above",
+                        "name": "co2_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "TVOC threshold of time_above",
+                        "name": "tvoc_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sample or time",
+                        "name": "weighting",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/sensor": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "create sensor",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Create sensor",
+                "parameters": [
+                    {
+                        "description": "Create sensor",
+                        "name": "account",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorSchema"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found"
+                    },
+                    "503": {
+                        "description": "Service Unavailable"
+                    }
+                }
+            }
+        },
+        "/sensor/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find sensors",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Find sensors",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "name": "owner_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "name": "room_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.SensorSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/sensor/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get sensor",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Get sensor",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorSchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete by id sensor",
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Delete an sensor",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update by json sensor",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Update an sensor",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update sensor",
+                        "name": "sensor",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/sensor/{id}/battery": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Battery level, discharge rate and estimated time until it is empty",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Get sensor battery",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.BatterySchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/sensor/{id}/credentials": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get sensor credentials status, secret is never returned",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Get sensor credentials",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorCredentialSchema"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Issue new sensor secret and revoke previous ones. Secret is returned only once",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Rotate sensor credentials",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorCredentialSchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke sensor secret, sensor can't send data until credentials are rotated",
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Revoke sensor credentials",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/sensor/{id}/events": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Last 100 offline, flatline and battery_drop events of sensor, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Find sensor events",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.DeviceEventSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/sensor/{id}/latest": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "The newest reading of sensor, including reading which is still in ingestion buffer",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Get sensor latest reading",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.LatestReadingSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/sensor/{id}/series": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "CO2 and TVOC avg, min, max and count per time bucket of sensor",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Get sensor series",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "1m, 5m, 1h or 1d",
+                        "name": "bucket",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Add empty buckets",
+                        "name": "fill",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.SeriesSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/sensor/{id}/statistic": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get statistic of single sensor readings",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Sensor"
+                ],
+                "summary": "Get sensor statistic",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Sensor ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated avg, min, max, median, p95, stddev, count, time_above",
+                        "name": "aggregates",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "CO2 threshold of time_above",
+                        "name": "co2_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "TVOC threshold of time_above",
+                        "name": "tvoc_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sample or time",
+                        "name": "weighting",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorDataSensorSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/storage": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get size of sensor data and rollup tables, rows and estimated size per zone\nwith effective retention of zone",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Retention"
+                ],
+                "summary": "Get storage usage",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.StorageSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/subscription": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Subscribe to events of owned zone or room by email or sms",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Subscription"
+                ],
+                "summary": "Create subscription",
+                "parameters": [
+                    {
+                        "description": "Create subscription",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SubscriptionCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SubscriptionSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/subscription/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find subscriptions of user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Subscription"
+                ],
+                "summary": "Find subscriptions",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.SubscriptionSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/subscription/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete subscription, its pending notifications are failed",
+                "tags": [
+                    "Subscription"
+                ],
+                "summary": "Delete subscription",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update subscription",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Subscription"
+                ],
+                "summary": "Update subscription",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update subscription",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SubscriptionUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/subscription/{id}/notifications": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Last 100 notifications of subscription with delivery status, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Subscription"
+                ],
+                "summary": "Find subscription notifications",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.NotificationSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/unclaimed/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Guids which sent readings but match no sensor, with first/last seen time and latest readings.\nAvailable for superusers and room owners",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Unclaimed"
+                ],
+                "summary": "Find unclaimed devices",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.UnclaimedDeviceSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/unclaimed/{guid}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete quarantined readings of guid",
+                "tags": [
+                    "Unclaimed"
+                ],
+                "summary": "Discard unclaimed device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device guid",
+                        "name": "guid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/unclaimed/{guid}/claim": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Create sensor for guid in room owned by user, optionally importing quarantined readings.\nImported readings count in statistics, but don't raise detections, rule triggers or health events.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Unclaimed"
+                ],
+                "summary": "Claim unclaimed device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device guid",
+                        "name": "guid",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Claim device",
+                        "name": "claim",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.UnclaimedDeviceClaimSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find users",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Find users",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.UserSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/user/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.UserSchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete by id user",
+                "tags": [
+                    "user"
+                ],
+                "summary": "Delete an user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update by json user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Update an user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "user ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update user",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.UserUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/webhook": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Register zone webhook. Events are posted as JSON with X-Webhook-Delivery, X-Webhook-Event,\nX-Webhook-Timestamp and X-Webhook-Signature (hex HMAC-SHA256 of timestamp.body with webhook secret) headers.\nSecret is returned only in this response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Create webhook",
+                "parameters": [
+                    {
+                        "description": "Create webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.WebhookCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.WebhookSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find webhooks of zones owned by user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Find webhooks",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "name": "zone_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.WebhookSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get webhook",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Get webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.WebhookSchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete webhook, its pending deliveries are failed",
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Delete webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update webhook",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Update webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.WebhookUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/webhook/{id}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Last 100 deliveries of webhook, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Find webhook deliveries",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.WebhookDeliverySchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhook/{id}/test": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Send test event to webhook once and return delivery result",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Send test event",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.WebhookDeliverySchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/zone": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "create zone",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Create zone",
+                "parameters": [
+                    {
+                        "description": "Create zone",
+                        "name": "account",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.ZoneCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.ZoneSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find zones",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Find zones",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "name": "owner_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.ZoneSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get zone",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Get zone",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.ZoneSchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete by id zone",
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Delete an zone",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "503": {
+                        "description": "Service Unavailable"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update by json zone",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Update an zone",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update zone",
+                        "name": "zone",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.ZoneUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/zone/{id}/bands": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Time readings of every zone room spent in every air quality band of co2, tvoc and the worse of them, with its share",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Get zone air quality bands",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.RoomBandsSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/{id}/batteries": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Zone sensors with low battery or battery estimated to be empty within days, the ones to be empty sooner first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Find zone batteries to replace",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Days ahead, 7 by default",
+                        "name": "days",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.BatterySchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/{id}/compare": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Statistic of zone rooms in interval and previous interval side by side with deltas, rooms are ranked by change of rank metric",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Compare zone statistic",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 1h or 30d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, previous interval start, interval of the same length before from by default",
+                        "name": "previous_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, previous interval end",
+                        "name": "previous_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated avg, min, max, median, p95, stddev, count, time_above",
+                        "name": "aggregates",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "CO2 threshold of time_above",
+                        "name": "co2_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "TVOC threshold of time_above",
+                        "name": "tvoc_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sample or time",
+                        "name": "weighting",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Metric rooms are ranked by change of: co2, tvoc (default) or episodes",
+                        "name": "rank",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.ZoneComparisonSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/{id}/health": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Status, last seen time, battery and unfinished offline and flatline events of every zone sensor",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Get zone device health",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.SensorHealthSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/{id}/latest": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "The newest reading of every zone sensor for wall displays, including readings which are still in ingestion buffer",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Get zone latest readings",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.LatestReadingSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/{id}/policy": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get zone notification policy, everything is switched off if zone has no policy",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Policy"
+                ],
+                "summary": "Get zone notification policy",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.PolicySchema"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Set zone notification policy: grouping of repeated triggers into one incident,\nnotification cooldown, quiet hours and escalation of unacknowledged incidents",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Policy"
+                ],
+                "summary": "Set zone notification policy",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Policy",
+                        "name": "policy",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.PolicySchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.PolicySchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete zone notification policy",
+                "tags": [
+                    "Policy"
+                ],
+                "summary": "Delete zone notification policy",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/zone/{id}/retention": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get days zone data is kept, 0 means global retention is used",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Retention"
+                ],
+                "summary": "Get zone retention",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RetentionSchema"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Set days raw data and minute, hour and day rollups of zone are kept.\n0 means global retention is used. Older data is deleted by background job.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Retention"
+                ],
+                "summary": "Set zone retention",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Retention",
+                        "name": "retention",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RetentionSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RetentionSchema"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete zone retention, global retention is used for zone",
+                "tags": [
+                    "Retention"
+                ],
+                "summary": "Delete zone retention",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/zone/{id}/rules": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Find zone rules, they are inherited by every zone room",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Find zone rules",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/schemas.RuleSchema"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Create zone rule",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Create zone rule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Create rule",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RuleCreateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RuleSchema"
+                        }
+                    }
+                }
+            }
+        },
+        "/zone/{id}/rules/{ruleID}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete zone rule",
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Delete zone rule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rule ID",
+                        "name": "ruleID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Update zone rule",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Rule"
+                ],
+                "summary": "Update zone rule",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Rule ID",
+                        "name": "ruleID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update rule",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/schemas.RuleUpdateSchema"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/zone/{id}/statistic": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get zone statistic",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Zone"
+                ],
+                "summary": "Get zone statistic",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Zone ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured since",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339, readings measured before",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Interval before now like 30m, 1h or 7d, instead of from and to",
+                        "name": "last",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated avg, min, max, median, p95, stddev, count, time_above",
+                        "name": "aggregates",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "CO2 threshold of time_above",
+                        "name": "co2_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "TVOC threshold of time_above",
+                        "name": "tvoc_above",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "sample or time",
+                        "name": "weighting",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/schemas.SensorDataZoneSchema"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "schemas.AggregatesSchema": {
+            "type": "object",
+            "properties": {
+                "avg": {
+                    "type": "number"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "max": {
+                    "type": "number"
+                },
+                "median": {
+                    "type": "number"
+                },
+                "min": {
+                    "type": "number"
+                },
+                "p95": {
+                    "type": "number"
+                },
+                "stddev": {
+                    "description": "Population standard deviation",
+                    "type": "number"
+                },
+                "time_above": {
+                    "description": "Seconds value stayed above threshold, summed over room sensors",
+                    "type": "number"
+                }
+            }
+        },
+        "schemas.BandShareSchema": {
+            "type": "object",
+            "required": [
+                "band",
+                "seconds",
+                "share"
+            ],
+            "properties": {
+                "band": {
+                    "type": "string"
+                },
+                "seconds": {
+                    "type": "number"
+                },
+                "share": {
+                    "description": "Part of room seconds, from 0 to 1",
+                    "type": "number"
+                }
+            }
+        },
+        "schemas.BatterySchema": {
+            "type": "object",
+            "required": [
+                "level",
+                "low",
+                "name",
+                "room_id",
+                "sensor_id"
+            ],
+            "properties": {
+                "days_until_empty": {
+                    "type": "number"
+                },
+                "discharge_rate": {
+                    "description": "Percents per day, empty when there is too little data or battery doesn't discharge",
+                    "type": "number"
+                },
+                "empty_at": {
+                    "type": "string"
+                },
+                "last_seen_at": {
+                    "type": "string"
+                },
+                "level": {
+                    "description": "Percent in the last reading",
+                    "type": "integer"
+                },
+                "low": {
+                    "description": "Level is below DEVICE_BATTERY_LOW",
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.ChangeSchema": {
+            "type": "object",
+            "properties": {
+                "current": {
+                    "type": "number"
+                },
+                "delta": {
+                    "description": "Current minus previous, empty when either is empty",
+                    "type": "number"
+                },
+                "percent": {
+                    "description": "Delta in percents of previous, empty when previous is empty or zero",
+                    "type": "number"
+                },
+                "previous": {
+                    "type": "number"
+                }
+            }
+        },
+        "schemas.DeviceEventSchema": {
+            "type": "object",
+            "required": [
+                "id",
+                "room_id",
+                "sensor_id",
+                "started_at",
+                "type",
+                "zone_id"
+            ],
+            "properties": {
+                "ended_at": {
+                    "description": "Empty while condition lasts, battery drops have no duration",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "type": {
+                    "description": "offline, flatline, battery_drop or battery_low",
+                    "type": "string"
+                },
+                "value": {
+                    "description": "Battery percent lost for battery_drop, charge for battery_low",
+                    "type": "integer"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.ExportRowSchema": {
+            "type": "object",
+            "properties": {
+                "battery_charge": {
+                    "type": "integer"
+                },
+                "co2": {
+                    "type": "integer"
+                },
+                "guid": {
+                    "type": "string"
+                },
+                "measured_at": {
+                    "type": "string"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "room_name": {
+                    "type": "string"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                },
+                "sensor_name": {
+                    "type": "string"
+                },
+                "tvoc": {
+                    "type": "integer"
+                },
+                "zone_id": {
+                    "type": "integer"
+                },
+                "zone_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.ExternalBatchItemResultSchema": {
+            "type": "object",
+            "required": [
+                "accepted",
+                "index"
+            ],
+            "properties": {
+                "accepted": {
+                    "type": "boolean"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "guid": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.ExternalBatchResultSchema": {
+            "type": "object",
+            "required": [
+                "accepted",
+                "items",
+                "rejected"
+            ],
+            "properties": {
+                "accepted": {
+                    "type": "integer"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.ExternalBatchItemResultSchema"
+                    }
+                },
+                "rejected": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.ExternalSensorDataSchema": {
+            "type": "object",
+            "required": [
+                "batteryCharge",
+                "co2",
+                "guid",
+                "tvoc"
+            ],
+            "properties": {
+                "batteryCharge": {
+                    "type": "integer"
+                },
+                "co2": {
+                    "type": "integer"
+                },
+                "guid": {
+                    "type": "string"
+                },
+                "measured_at": {
+                    "description": "Time of measurement on device, receive time is used if it is omitted",
+                    "type": "string"
+                },
+                "tvoc": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.GatewayCreateSchema": {
+            "type": "object",
+            "required": [
+                "name",
+                "zone_id"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.GatewaySchema": {
+            "type": "object",
+            "required": [
+                "created_at",
+                "guid",
+                "id",
+                "name",
+                "zone_id"
+            ],
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "guid": {
+                    "description": "Sent by gateway in X-Gateway-Guid header",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "secret": {
+                    "description": "Returned only on create and rotation",
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.IncidentNoteSchema": {
+            "type": "object",
+            "properties": {
+                "note": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.IncidentSchema": {
+            "type": "object",
+            "required": [
+                "id",
+                "occurrences",
+                "peak_co2",
+                "peak_tvoc",
+                "room_id",
+                "sensor_id",
+                "severity",
+                "started_at",
+                "state",
+                "zone_id"
+            ],
+            "properties": {
+                "acknowledge_note": {
+                    "type": "string"
+                },
+                "acknowledged_at": {
+                    "type": "string"
+                },
+                "acknowledged_by": {
+                    "type": "integer"
+                },
+                "ended_at": {
+                    "description": "Empty while condition lasts",
+                    "type": "string"
+                },
+                "episode_id": {
+                    "type": "integer"
+                },
+                "escalated_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "occurrences": {
+                    "description": "Triggers grouped into incident by zone policy",
+                    "type": "integer"
+                },
+                "peak_co2": {
+                    "type": "integer"
+                },
+                "peak_tvoc": {
+                    "type": "integer"
+                },
+                "resolve_note": {
+                    "type": "string"
+                },
+                "resolved_at": {
+                    "type": "string"
+                },
+                "resolved_by": {
+                    "type": "integer"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "rule_id": {
+                    "description": "Rule which opened incident, empty for vape episodes",
+                    "type": "integer"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                },
+                "severity": {
+                    "description": "info, warning or critical",
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "open, acknowledged or resolved",
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.LatestReadingSchema": {
+            "type": "object",
+            "required": [
+                "name",
+                "room_id",
+                "sensor_id",
+                "status"
+            ],
+            "properties": {
+                "band": {
+                    "type": "string"
+                },
+                "battery_charge": {
+                    "type": "integer"
+                },
+                "buffered": {
+                    "description": "Reading is in ingestion buffer and may be not stored yet",
+                    "type": "boolean"
+                },
+                "co2": {
+                    "type": "integer"
+                },
+                "co2_band": {
+                    "description": "Air quality bands of reading, the worse of them in band",
+                    "type": "string"
+                },
+                "measured_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "online, offline or never_seen",
+                    "type": "string"
+                },
+                "tvoc": {
+                    "type": "integer"
+                },
+                "tvoc_band": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.LoginSchema": {
+            "type": "object",
+            "required": [
+                "password",
+                "username"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.NotificationSchema": {
+            "type": "object",
+            "required": [
+                "attempts",
+                "channel",
+                "created_at",
+                "event_type",
+                "id",
+                "next_attempt_at",
+                "state",
+                "subject",
+                "subscription_id",
+                "target",
+                "text"
+            ],
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "channel": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "event_type": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "next_attempt_at": {
+                    "description": "Pending notification is held until this time during quiet hours",
+                    "type": "string"
+                },
+                "sent_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "pending, delivered, failed or suppressed",
+                    "type": "string"
+                },
+                "subject": {
+                    "type": "string"
+                },
+                "subscription_id": {
+                    "type": "integer"
+                },
+                "target": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.PolicySchema": {
+            "type": "object",
+            "properties": {
+                "cooldown": {
+                    "description": "Seconds after incident notification while notifications of next incidents in the same room are suppressed",
+                    "type": "integer"
+                },
+                "escalate_after": {
+                    "description": "Minutes incident may stay open before it is escalated to escalation contacts, 0 disables escalation",
+                    "type": "integer"
+                },
+                "group_window": {
+                    "description": "Seconds after incident end while new trigger of the same rule and sensor joins it instead of opening new incident",
+                    "type": "integer"
+                },
+                "quiet_allow_critical": {
+                    "description": "Notify about critical incidents during quiet hours too",
+                    "type": "boolean"
+                },
+                "quiet_end": {
+                    "type": "string"
+                },
+                "quiet_start": {
+                    "description": "HH:MM, notifications are held until quiet hours end. Both empty disable quiet hours",
+                    "type": "string"
+                },
+                "timezone": {
+                    "description": "IANA time zone of quiet hours, UTC by default",
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RegisterSchema": {
+            "type": "object",
+            "required": [
+                "password",
+                "username"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.ReportDefinitionCreateSchema": {
+            "type": "object",
+            "required": [
+                "name",
+                "period",
+                "zone_id"
+            ],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "period": {
+                    "description": "daily, weekly (from monday) or monthly",
+                    "type": "string"
+                },
+                "recipients": {
+                    "description": "Emails report is mailed to, report is only stored if empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "room_ids": {
+                    "description": "Rooms in report, every zone room if empty",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "timezone": {
+                    "description": "IANA timezone of period bounds, UTC by default",
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.ReportDefinitionSchema": {
+            "type": "object",
+            "required": [
+                "enabled",
+                "id",
+                "name",
+                "next_run_at",
+                "period",
+                "recipients",
+                "room_ids",
+                "timezone",
+                "zone_id"
+            ],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "description": "Why report of the last period wasn't made, the period is skipped",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "next_run_at": {
+                    "description": "End of period report is made for next",
+                    "type": "string"
+                },
+                "period": {
+                    "type": "string"
+                },
+                "recipients": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "room_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "timezone": {
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.ReportDefinitionUpdateSchema": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "period": {
+                    "type": "string"
+                },
+                "recipients": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "room_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "timezone": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.ReportSchema": {
+            "type": "object",
+            "required": [
+                "created_at",
+                "definition_id",
+                "from",
+                "id",
+                "pending_recipients",
+                "to",
+                "zone_id"
+            ],
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "definition_id": {
+                    "type": "integer"
+                },
+                "from": {
+                    "description": "Readings measured in [from, to) are reported",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "pending_recipients": {
+                    "description": "Recipients report is not mailed to yet",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RetentionSchema": {
+            "type": "object",
+            "properties": {
+                "day_days": {
+                    "type": "integer"
+                },
+                "hour_days": {
+                    "type": "integer"
+                },
+                "minute_days": {
+                    "description": "Minute, hour and day rollups",
+                    "type": "integer"
+                },
+                "raw_days": {
+                    "description": "Raw sensor data",
+                    "type": "integer"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RoomBandsSchema": {
+            "type": "object",
+            "required": [
+                "co2",
+                "overall",
+                "room_id",
+                "seconds",
+                "tvoc"
+            ],
+            "properties": {
+                "co2": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.BandShareSchema"
+                    }
+                },
+                "overall": {
+                    "description": "Band of reading is the worse of its co2 and tvoc bands",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.BandShareSchema"
+                    }
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "seconds": {
+                    "description": "Seconds covered by readings summed over room sensors, gaps between\nreadings longer than 5 minutes aren't covered",
+                    "type": "number"
+                },
+                "tvoc": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.BandShareSchema"
+                    }
+                }
+            }
+        },
+        "schemas.RoomChangeSchema": {
+            "type": "object",
+            "required": [
+                "co2",
+                "current",
+                "episodes",
+                "previous",
+                "rank",
+                "room_id",
+                "tvoc"
+            ],
+            "properties": {
+                "co2": {
+                    "description": "Averages",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.ChangeSchema"
+                        }
+                    ]
+                },
+                "co2_aggregates": {
+                    "description": "Changes of selected aggregates by aggregate name",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/schemas.ChangeSchema"
+                    }
+                },
+                "current": {
+                    "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                },
+                "episodes": {
+                    "description": "Vape episodes started in interval",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.ChangeSchema"
+                        }
+                    ]
+                },
+                "previous": {
+                    "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                },
+                "rank": {
+                    "description": "Place of zone room by delta of ranked metric, the most grown first. 0\nwhen delta is empty, such rooms are the last, and for single room",
+                    "type": "integer"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "tvoc": {
+                    "$ref": "#/definitions/schemas.ChangeSchema"
+                },
+                "tvoc_aggregates": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/schemas.ChangeSchema"
+                    }
+                }
+            }
+        },
+        "schemas.RoomComparisonSchema": {
+            "type": "object",
+            "required": [
+                "co2",
+                "current",
+                "episodes",
+                "from",
+                "previous",
+                "previous_from",
+                "previous_to",
+                "rank",
+                "room_id",
+                "to",
+                "tvoc"
+            ],
+            "properties": {
+                "co2": {
+                    "description": "Averages",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.ChangeSchema"
+                        }
+                    ]
+                },
+                "co2_aggregates": {
+                    "description": "Changes of selected aggregates by aggregate name",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/schemas.ChangeSchema"
+                    }
+                },
+                "current": {
+                    "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                },
+                "episodes": {
+                    "description": "Vape episodes started in interval",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.ChangeSchema"
+                        }
+                    ]
+                },
+                "from": {
+                    "type": "string"
+                },
+                "previous": {
+                    "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                },
+                "previous_from": {
+                    "type": "string"
+                },
+                "previous_to": {
+                    "type": "string"
+                },
+                "rank": {
+                    "description": "Place of zone room by delta of ranked metric, the most grown first. 0\nwhen delta is empty, such rooms are the last, and for single room",
+                    "type": "integer"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "to": {
+                    "type": "string"
+                },
+                "tvoc": {
+                    "$ref": "#/definitions/schemas.ChangeSchema"
+                },
+                "tvoc_aggregates": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/schemas.ChangeSchema"
+                    }
+                }
+            }
+        },
+        "schemas.RoomCreateSchema": {
+            "type": "object",
+            "required": [
+                "name",
+                "owner_id",
+                "zone_id"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RoomSchema": {
+            "type": "object",
+            "required": [
+                "id",
+                "name",
+                "owner_id",
+                "zone_id"
+            ],
+            "properties": {
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "sensors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.SensorSchema"
+                    }
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RoomUpdateSchema": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.RuleCreateSchema": {
+            "type": "object",
+            "required": [
+                "metric",
+                "name",
+                "operator",
+                "threshold"
+            ],
+            "properties": {
+                "cooldown": {
+                    "description": "Seconds after trigger while rule doesn't trigger again for the same sensor",
+                    "type": "integer"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "metric": {
+                    "description": "co2, tvoc or battery",
+                    "type": "string"
+                },
+                "min_duration": {
+                    "description": "Seconds threshold must be breached before rule triggers",
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "operator": {
+                    "description": "above or below",
+                    "type": "string"
+                },
+                "severity": {
+                    "description": "info, warning or critical severity of incidents opened by rule, warning by default",
+                    "type": "string"
+                },
+                "threshold": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RuleSchema": {
+            "type": "object",
+            "required": [
+                "cooldown",
+                "enabled",
+                "id",
+                "inherited",
+                "metric",
+                "min_duration",
+                "name",
+                "operator",
+                "severity",
+                "threshold",
+                "zone_id"
+            ],
+            "properties": {
+                "cooldown": {
+                    "type": "integer"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "inherited": {
+                    "description": "Zone rule which applies to room",
+                    "type": "boolean"
+                },
+                "metric": {
+                    "type": "string"
+                },
+                "min_duration": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "operator": {
+                    "type": "string"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "severity": {
+                    "type": "string"
+                },
+                "threshold": {
+                    "type": "integer"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RuleTriggerSchema": {
+            "type": "object",
+            "required": [
+                "breach_started_at",
+                "id",
+                "metric",
+                "room_id",
+                "rule_id",
+                "sensor_id",
+                "threshold",
+                "triggered_at",
+                "value",
+                "zone_id"
+            ],
+            "properties": {
+                "breach_started_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "metric": {
+                    "type": "string"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "rule_id": {
+                    "type": "integer"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                },
+                "threshold": {
+                    "type": "integer"
+                },
+                "triggered_at": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "integer"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.RuleUpdateSchema": {
+            "type": "object",
+            "properties": {
+                "cooldown": {
+                    "type": "integer"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "min_duration": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "severity": {
+                    "type": "string"
+                },
+                "threshold": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.SensorCreateSchema": {
+            "type": "object",
+            "required": [
+                "guid",
+                "name",
+                "owner_id",
+                "room_id"
+            ],
+            "properties": {
+                "guid": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "room_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.SensorCredentialSchema": {
+            "type": "object",
+            "required": [
+                "active",
+                "guid",
+                "issued_at",
+                "sensor_id"
+            ],
+            "properties": {
+                "active": {
+                    "type": "boolean"
+                },
+                "guid": {
+                    "type": "string"
+                },
+                "issued_at": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.SensorDataRoomSchema": {
+            "type": "object",
+            "properties": {
+                "band": {
+                    "type": "string"
+                },
+                "co2": {
+                    "type": "integer"
+                },
+                "co2Aggregates": {
+                    "description": "Selected aggregates, empty when none is selected",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.AggregatesSchema"
+                        }
+                    ]
+                },
+                "co2Band": {
+                    "description": "Air quality bands of averages, the worse of them in Band. Empty when\nroom has no data in the interval",
+                    "type": "string"
+                },
+                "roomID": {
+                    "type": "integer"
+                },
+                "samples": {
+                    "description": "Number of readings averaged, 0 when room has no data in the interval",
+                    "type": "integer"
+                },
+                "tvoc": {
+                    "type": "integer"
+                },
+                "tvocAggregates": {
+                    "$ref": "#/definitions/schemas.AggregatesSchema"
+                },
+                "tvocBand": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.SensorDataSensorSchema": {
+            "type": "object",
+            "properties": {
+                "band": {
+                    "type": "string"
+                },
+                "co2": {
+                    "type": "integer"
+                },
+                "co2Aggregates": {
+                    "$ref": "#/definitions/schemas.AggregatesSchema"
+                },
+                "co2Band": {
+                    "type": "string"
+                },
+                "samples": {
+                    "type": "integer"
+                },
+                "sensorID": {
+                    "type": "integer"
+                },
+                "tvoc": {
+                    "type": "integer"
+                },
+                "tvocAggregates": {
+                    "$ref": "#/definitions/schemas.AggregatesSchema"
+                },
+                "tvocBand": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.SensorDataZoneSchema": {
+            "type": "object",
+            "properties": {
+                "rooms": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                    }
+                },
+                "zoneID": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.SensorHealthSchema": {
+            "type": "object",
+            "required": [
+                "battery_charge",
+                "guid",
+                "heartbeat",
+                "name",
+                "open_events",
+                "room_id",
+                "sensor_id",
+                "status"
             ],
             "properties": {
-                "password": {
+                "battery_charge": {
+                    "type": "integer"
+                },
+                "guid": {
+                    "type": "string"
+                },
+                "heartbeat": {
+                    "description": "Seconds",
+                    "type": "integer"
+                },
+                "last_seen_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "open_events": {
+                    "description": "Offline, flatline and battery_low events which are not over",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.DeviceEventSchema"
+                    }
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "sensor_id": {
+                    "type": "integer"
+                },
+                "status": {
+                    "description": "online, offline or never_seen",
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.SensorSchema": {
+            "type": "object",
+            "required": [
+                "guid",
+                "id",
+                "name",
+                "owner_id",
+                "room_id",
+                "zone_id"
+            ],
+            "properties": {
+                "guid": {
+                    "type": "string"
+                },
+                "heartbeat": {
+                    "description": "Seconds without readings after which sensor is offline, 0 for default",
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "integer"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.SensorUpdateSchema": {
+            "type": "object",
+            "properties": {
+                "guid": {
+                    "type": "string"
+                },
+                "heartbeat": {
+                    "description": "Seconds without readings after which sensor is offline, 0 for default",
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.SeriesPointSchema": {
+            "type": "object",
+            "required": [
+                "at",
+                "count"
+            ],
+            "properties": {
+                "at": {
+                    "description": "Bucket start",
+                    "type": "string"
+                },
+                "co2_avg": {
+                    "description": "Empty for filled buckets without readings",
+                    "type": "number"
+                },
+                "co2_max": {
+                    "type": "number"
+                },
+                "co2_min": {
+                    "type": "number"
+                },
+                "count": {
+                    "type": "integer"
+                },
+                "tvoc_avg": {
+                    "type": "number"
+                },
+                "tvoc_max": {
+                    "type": "number"
+                },
+                "tvoc_min": {
+                    "type": "number"
+                }
+            }
+        },
+        "schemas.SeriesSchema": {
+            "type": "object",
+            "required": [
+                "points",
+                "room_id"
+            ],
+            "properties": {
+                "points": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.SeriesPointSchema"
+                    }
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "sensor_id": {
+                    "description": "Set for sensor granularity",
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.StorageSchema": {
+            "type": "object",
+            "required": [
+                "retention",
+                "tables",
+                "zones"
+            ],
+            "properties": {
+                "retention": {
+                    "description": "Global retention",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.RetentionSchema"
+                        }
+                    ]
+                },
+                "tables": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.StorageTableSchema"
+                    }
+                },
+                "zones": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.ZoneStorageSchema"
+                    }
+                }
+            }
+        },
+        "schemas.StorageTableSchema": {
+            "type": "object",
+            "required": [
+                "bytes",
+                "rows",
+                "table"
+            ],
+            "properties": {
+                "bytes": {
+                    "description": "Bytes of table with indexes",
+                    "type": "integer"
+                },
+                "rows": {
+                    "description": "Planner estimate of rows",
+                    "type": "integer"
+                },
+                "table": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.SubscriptionCreateSchema": {
+            "type": "object",
+            "required": [
+                "channel",
+                "target",
+                "zone_id"
+            ],
+            "properties": {
+                "channel": {
+                    "description": "email or sms",
+                    "type": "string"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "escalation": {
+                    "description": "Subscription of escalation contact, it gets only escalated incidents",
+                    "type": "boolean"
+                },
+                "event_types": {
+                    "description": "Event types to send, every type if empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "room_id": {
+                    "description": "Only events of this room, events of whole zone if empty",
+                    "type": "integer"
+                },
+                "target": {
+                    "description": "Email address or phone number",
+                    "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.SubscriptionSchema": {
+            "type": "object",
+            "required": [
+                "channel",
+                "enabled",
+                "escalation",
+                "event_types",
+                "id",
+                "target",
+                "user_id",
+                "zone_id"
+            ],
+            "properties": {
+                "channel": {
+                    "type": "string"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "escalation": {
+                    "type": "boolean"
+                },
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "room_id": {
+                    "type": "integer"
+                },
+                "target": {
                     "type": "string"
                 },
-                "username": {
+                "user_id": {
+                    "type": "integer"
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "schemas.SubscriptionUpdateSchema": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "target": {
                     "type": "string"
                 }
             }
         },
-        "schemas.RegisterSchema": {
+        "schemas.TokenSchema": {
             "type": "object",
             "required": [
-                "password",
-                "username"
+                "token"
             ],
             "properties": {
-                "password": {
-                    "type": "string"
-                },
-                "username": {
+                "token": {
                     "type": "string"
                 }
             }
         },
-        "schemas.RoomCreateSchema": {
+        "schemas.UnclaimedDeviceClaimSchema": {
             "type": "object",
             "required": [
                 "name",
-                "owner_id",
-                "zone_id"
+                "room_id"
             ],
             "properties": {
+                "import_readings": {
+                    "description": "Move quarantined readings into sensor data, otherwise they are discarded",
+                    "type": "boolean"
+                },
                 "name": {
                     "type": "string"
                 },
-                "owner_id": {
-                    "type": "integer"
-                },
-                "zone_id": {
+                "room_id": {
                     "type": "integer"
                 }
             }
         },
-        "schemas.RoomSchema": {
+        "schemas.UnclaimedDeviceSchema": {
             "type": "object",
             "required": [
-                "id",
-                "name",
-                "owner_id",
-                "zone_id"
+                "first_seen",
+                "guid",
+                "last_seen",
+                "readings",
+                "samples"
             ],
             "properties": {
-                "id": {
-                    "type": "integer"
+                "first_seen": {
+                    "type": "string"
                 },
-                "name": {
+                "guid": {
                     "type": "string"
                 },
-                "owner_id": {
+                "last_seen": {
+                    "type": "string"
+                },
+                "readings": {
                     "type": "integer"
                 },
-                "sensors": {
+                "samples": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/schemas.SensorSchema"
+                        "$ref": "#/definitions/schemas.UnclaimedReadingSchema"
                     }
+                }
+            }
+        },
+        "schemas.UnclaimedReadingSchema": {
+            "type": "object",
+            "required": [
+                "batteryCharge",
+                "co2",
+                "measured_at",
+                "tvoc"
+            ],
+            "properties": {
+                "batteryCharge": {
+                    "type": "integer"
                 },
-                "zone_id": {
+                "co2": {
+                    "type": "integer"
+                },
+                "measured_at": {
+                    "type": "string"
+                },
+                "tvoc": {
                     "type": "integer"
                 }
             }
         },
-        "schemas.RoomUpdateSchema": {
+        "schemas.UserSchema": {
             "type": "object",
+            "required": [
+                "id",
+                "is_superuser",
+                "name"
+            ],
             "properties": {
+                "id": {
+                    "type": "integer"
+                },
+                "is_superuser": {
+                    "type": "boolean"
+                },
                 "name": {
                     "type": "string"
                 }
             }
         },
-        "schemas.SensorCreateSchema": {
+        "schemas.UserUpdateSchema": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "schemas.VapeEpisodeSchema": {
             "type": "object",
             "required": [
-                "guid",
-                "name",
-                "owner_id",
-                "room_id"
+                "baseline_tvoc",
+                "id",
+                "peak_co2",
+                "peak_tvoc",
+                "room_id",
+                "sensor_id",
+                "started_at",
+                "zone_id"
             ],
             "properties": {
-                "guid": {
-                    "type": "string"
+                "baseline_tvoc": {
+                    "type": "integer"
                 },
-                "name": {
+                "ended_at": {
+                    "description": "Empty while episode is ongoing",
                     "type": "string"
                 },
-                "owner_id": {
+                "id": {
                     "type": "integer"
                 },
-                "room_id": {
+                "peak_co2": {
                     "type": "integer"
-                }
-            }
-        },
-        "schemas.SensorDataRoomSchema": {
-            "type": "object",
-            "properties": {
-                "co2": {
+                },
+                "peak_tvoc": {
                     "type": "integer"
                 },
-                "roomID": {
+                "room_id": {
                     "type": "integer"
                 },
-                "tvoc": {
+                "sensor_id": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "zone_id": {
                     "type": "integer"
                 }
             }
         },
-        "schemas.SensorDataZoneSchema": {
+        "schemas.WebhookCreateSchema": {
             "type": "object",
+            "required": [
+                "url",
+                "zone_id"
+            ],
             "properties": {
-                "rooms": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "event_types": {
+                    "description": "Event types to send, every type if empty",
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/schemas.SensorDataRoomSchema"
+                        "type": "string"
                     }
                 },
-                "zoneID": {
+                "url": {
+                    "type": "string"
+                },
+                "zone_id": {
                     "type": "integer"
                 }
             }
         },
-        "schemas.SensorSchema": {
+        "schemas.WebhookDeliverySchema": {
             "type": "object",
             "required": [
-                "guid",
+                "attempts",
+                "created_at",
+                "event_type",
                 "id",
-                "name",
-                "owner_id",
-                "room_id"
+                "next_attempt_at",
+                "state",
+                "webhook_id"
             ],
             "properties": {
-                "guid": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "delivered_at": {
+                    "type": "string"
+                },
+                "event_type": {
                     "type": "string"
                 },
                 "id": {
                     "type": "integer"
                 },
-                "name": {
+                "last_error": {
                     "type": "string"
                 },
-                "owner_id": {
+                "last_status_code": {
                     "type": "integer"
                 },
-                "room_id": {
+                "next_attempt_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "pending, delivered or failed",
+                    "type": "string"
+                },
+                "webhook_id": {
                     "type": "integer"
                 }
             }
         },
-        "schemas.SensorUpdateSchema": {
+        "schemas.WebhookSchema": {
             "type": "object",
+            "required": [
+                "enabled",
+                "event_types",
+                "id",
+                "url",
+                "zone_id"
+            ],
             "properties": {
-                "guid": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "secret": {
+                    "description": "Signing secret, returned only on create",
                     "type": "string"
                 },
-                "name": {
+                "url": {
                     "type": "string"
+                },
+                "zone_id": {
+                    "type": "integer"
                 }
             }
         },
-        "schemas.TokenSchema": {
+        "schemas.WebhookUpdateSchema": {
             "type": "object",
-            "required": [
-                "token"
-            ],
             "properties": {
-                "token": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "event_types": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "url": {
                     "type": "string"
                 }
             }
         },
-        "schemas.UserSchema": {
+        "schemas.ZoneComparisonSchema": {
             "type": "object",
             "required": [
-                "id",
-                "is_superuser",
-                "name"
+                "co2",
+                "episodes",
+                "from",
+                "previous_from",
+                "previous_to",
+                "rank",
+                "rooms",
+                "to",
+                "tvoc",
+                "zone_id"
             ],
             "properties": {
-                "id": {
-                    "type": "integer"
+                "co2": {
+                    "description": "Averages of zone rooms weighted by their samples",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.ChangeSchema"
+                        }
+                    ]
                 },
-                "is_superuser": {
-                    "type": "boolean"
+                "episodes": {
+                    "$ref": "#/definitions/schemas.ChangeSchema"
                 },
-                "name": {
+                "from": {
                     "type": "string"
-                }
-            }
-        },
-        "schemas.UserUpdateSchema": {
-            "type": "object",
-            "properties": {
-                "name": {
+                },
+                "previous_from": {
                     "type": "string"
+                },
+                "previous_to": {
+                    "type": "string"
+                },
+                "rank": {
+                    "description": "Metric rooms are ranked by",
+                    "type": "string"
+                },
+                "rooms": {
+                    "description": "Rooms ordered by rank",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/schemas.RoomChangeSchema"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                },
+                "tvoc": {
+                    "$ref": "#/definitions/schemas.ChangeSchema"
+                },
+                "zone_id": {
+                    "type": "integer"
                 }
             }
         },
@@ -1174,6 +5811,54 @@ const docTemplate = `{
                 }
             }
         },
+        "schemas.ZoneStorageSchema": {
+            "type": "object",
+            "required": [
+                "day_rows",
+                "estimated_bytes",
+                "hour_rows",
+                "minute_rows",
+                "name",
+                "raw_rows",
+                "retention",
+                "zone_id"
+            ],
+            "properties": {
+                "day_rows": {
+                    "type": "integer"
+                },
+                "estimated_bytes": {
+                    "description": "Rows of zone times average row size of their tables",
+                    "type": "integer"
+                },
+                "hour_rows": {
+                    "type": "integer"
+                },
+                "minute_rows": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "oldest_raw_at": {
+                    "type": "string"
+                },
+                "raw_rows": {
+                    "type": "integer"
+                },
+                "retention": {
+                    "description": "Effective retention of zone",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/schemas.RetentionSchema"
+                        }
+                    ]
+                },
+                "zone_id": {
+                    "type": "integer"
+                }
+            }
+        },
         "schemas.ZoneUpdateSchema": {
             "type": "object",
             "properties": {
@@ -1188,6 +5873,12 @@ const docTemplate = `{
             "type": "apiKey",
             "name": "Authorization",
             "in": "header"
+        },
+        "SensorSecret": {
+            "description": "\"Bearer \u003csecret\u003e\" issued on sensor creation, or X-Timestamp and X-Signature headers",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -1,20 +1,26 @@
 import aiohttp
 import random
 import asyncio
+import json
+import os
 import time
 
 baseurl = "http://localhost:8000"
 guids = ["string", "killer", "a", "b"]
+# Secrets returned on sensor creation, e.g. SENSOR_SECRETS='{"string": "..."}'
+secrets = json.loads(os.environ.get("SENSOR_SECRETS", "{}"))
 
 
 async def create_sensor_data(session):
+    guid = guids[int(time.time() * 100 % 4)]
     data = {
-        "guid": guids[int(time.time() * 100 % 4)],
+        "guid": guid,
         "co2": random.randint(1, 2000),
         "tvoc": random.randint(1, 2000),
         "batteryCharge": random.randint(1, 120)
     }
-    async with session.post("/external/sensors_data", json=data) as resp:
+    headers = {"X-Sensor-Guid": guid, "Authorization": "Bearer " + secrets.get(guid, "")}
+    async with session.post("/external/sensors_data", json=data, headers=headers) as resp:
         assert resp.status == 200
 
 
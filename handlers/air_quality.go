@@ -0,0 +1,79 @@
+package handlers
+
+import (
+  "strconv"
+
+  "antivape/schemas"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Get room air quality bands godoc
+//
+//	@Summary		Get room air quality bands
+//	@Description	Time room readings spent in every air quality band of co2, tvoc and the worse of them, with its share
+//	@Tags			Room
+//	@Produce		json
+//	@Param			id	path		int	true	"Room ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 30m, 1h or 7d, instead of from and to"
+//	@Success		200		{object}	schemas.RoomBandsSchema
+//	@Router			/room/{id}/bands [get]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleFindBands(c *fiber.Ctx) error {
+  roomID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.BandFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  room := h.roomService.Take(uint(roomID))
+  if room.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  bands := h.airQualityService.FindRoomBands(uint(roomID), schema)
+  return c.JSON(bands)
+}
+
+// Get zone air quality bands godoc
+//
+//	@Summary		Get zone air quality bands
+//	@Description	Time readings of every zone room spent in every air quality band of co2, tvoc and the worse of them, with its share
+//	@Tags			Zone
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 30m, 1h or 7d, instead of from and to"
+//	@Success		200		{array}	schemas.RoomBandsSchema
+//	@Router			/zone/{id}/bands [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleFindBands(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.BandFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  bands, err := h.airQualityService.FindZoneBands(uint(zoneID), schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(bands)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+  "errors"
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Get sensor battery godoc
+//
+//	@Summary		Get sensor battery
+//	@Description	Battery level, discharge rate and estimated time until it is empty
+//	@Tags			Sensor
+//	@Produce		json
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Success		200		{object}	schemas.BatterySchema
+//	@Router			/sensor/{id}/battery [get]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleTakeBattery(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  battery, err := h.batteryService.Take(uint(sensorID))
+  if errors.Is(err, services.ErrSensorNotFound) {
+    return c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(battery)
+}
+
+// Find zone batteries to replace godoc
+//
+//	@Summary		Find zone batteries to replace
+//	@Description	Zone sensors with low battery or battery estimated to be empty within days, the ones to be empty sooner first
+//	@Tags			Zone
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			days	query		int	false	"Days ahead, 7 by default"
+//	@Success		200		{array}	schemas.BatterySchema
+//	@Router			/zone/{id}/batteries [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleFindBatteries(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.BatteryFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  if schema.Days == 0 {
+    schema.Days = 7
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  batteries, err := h.batteryService.FindReplacements(uint(zoneID), schema.Days)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(batteries)
+}
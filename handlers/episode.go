@@ -0,0 +1,82 @@
+package handlers
+
+import (
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type EpisodeHandler interface {
+  Register(app *fiber.App)
+}
+
+type episodeHandler struct {
+  detectionService services.DetectionService
+  roomService services.RoomService
+  authService services.AuthService
+}
+
+// Find vape episodes godoc
+//
+//	@Summary		Find vape episodes
+//	@Description	Find detected vape episodes in rooms owned by user, newest first
+//	@Tags			Episode
+//	@Produce		json
+//	@Param			q	query		schemas.VapeEpisodeFindSchema false	"find filters"
+//	@Success		200		{array}	schemas.VapeEpisodeSchema
+//	@Router			/episode/ [get]
+//	@Security ApiKeyAuth
+func (h episodeHandler) handleFind(c *fiber.Ctx) error {
+  var schema schemas.VapeEpisodeFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  episodes, err := h.detectionService.FindEpisodes(h.authService.CurrentUserID(c), schema)
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(episodes)
+}
+
+// Get vape episode godoc
+//
+//	@Summary		Get vape episode
+//	@Description	Get vape episode
+//	@Tags			Episode
+//	@Produce		json
+//	@Param			id	path		int	true	"Episode ID"
+//	@Success		200		{object}	schemas.VapeEpisodeSchema
+//	@Router			/episode/{id} [get]
+//	@Security ApiKeyAuth
+func (h episodeHandler) handleTake(c *fiber.Ctx) error {
+  episodeID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  episode, err := h.detectionService.TakeEpisode(uint(episodeID))
+  if err != nil {
+    return c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  room := h.roomService.Take(episode.RoomID)
+  if room.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  return c.JSON(episode)
+}
+
+func (h episodeHandler) Register(app *fiber.App) {
+  router := app.Group("/episode", middlewares.Protected(), logger.New())
+
+  router.Get("/", h.handleFind)
+  router.Get("/:id<int>", h.handleTake)
+}
+
+func NewEpisodeHandler(detectionService services.DetectionService, roomService services.RoomService, authService services.AuthService) EpisodeHandler {
+  return episodeHandler{detectionService: detectionService, roomService: roomService, authService: authService}
+}
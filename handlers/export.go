@@ -0,0 +1,97 @@
+package handlers
+
+import (
+  "bufio"
+  "log"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type ExportHandler interface {
+  Register(app *fiber.App)
+}
+
+type exportHandler struct {
+  exportService services.ExportService
+  sensorService services.SensorService
+  roomService services.RoomService
+  zoneService services.ZoneService
+  authService services.AuthService
+}
+
+// Export readings godoc
+//
+//	@Summary		Export readings
+//	@Description	Stream readings of sensor, room or zone ordered by measured_at as CSV or NDJSON,
+//	@Description	with sensor, room and zone names
+//	@Tags			Export
+//	@Produce		text/csv
+//	@Produce		application/x-ndjson
+//	@Param			q	query		schemas.ExportFindSchema false	"export filters"
+//	@Success		200		{array}	schemas.ExportRowSchema
+//	@Router			/export/ [get]
+//	@Security ApiKeyAuth
+func (h exportHandler) handleExport(c *fiber.Ctx) error {
+  var schema schemas.ExportFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  userID := h.authService.CurrentUserID(c)
+  allowed := false
+  if schema.SensorID != nil {
+    sensor := h.sensorService.Take(*schema.SensorID)
+    allowed = sensor.OwnerID == userID || h.authService.IsSuperuser(c)
+  } else if schema.RoomID != nil {
+    allowed = h.roomService.Take(*schema.RoomID).OwnerID == userID || h.authService.IsSuperuser(c)
+  } else {
+    allowed = h.zoneService.Take(*schema.ZoneID).OwnerID == userID || h.authService.IsSuperuser(c)
+  }
+  if !allowed {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+
+  if schema.Format == schemas.ExportNDJSON {
+    c.Set(fiber.HeaderContentType, "application/x-ndjson")
+    c.Set(fiber.HeaderContentDisposition, `attachment; filename="readings.ndjson"`)
+  } else {
+    c.Set(fiber.HeaderContentType, "text/csv")
+    c.Set(fiber.HeaderContentDisposition, `attachment; filename="readings.csv"`)
+  }
+  // Rows are streamed after handler returns, so error can only cut the stream
+  c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+    if err := h.exportService.Export(schema, w); err != nil {
+      log.Println("Error export sensor data: ", err)
+    }
+  })
+  return nil
+}
+
+func (h exportHandler) Register(app *fiber.App) {
+  router := app.Group("/export", middlewares.Protected(), logger.New())
+
+  router.Get("/", h.handleExport)
+}
+
+func NewExportHandler(
+  exportService services.ExportService,
+  sensorService services.SensorService,
+  roomService services.RoomService,
+  zoneService services.ZoneService,
+  authService services.AuthService,
+) ExportHandler {
+  return exportHandler{
+    exportService: exportService,
+    sensorService: sensorService,
+    roomService: roomService,
+    zoneService: zoneService,
+    authService: authService,
+  }
+}
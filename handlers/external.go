@@ -1,17 +1,32 @@
 package handlers
 
 import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "errors"
+  "strings"
+  "time"
+
   "antivape/schemas"
   "antivape/services"
+  "antivape/middlewares"
 	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/limiter"
 )
 
+const maxBatchSize = 1000
+
 type ExternalHandler interface {
   Register(app *fiber.App)
 }
 
 type externalHandler struct {
-  externalService services.ExternalService  
+  externalService services.ExternalService
+  credentialService services.CredentialService
+  quarantineQuota services.QuarantineQuota
+  // Requests of unknown guids per minute from one address
+  quarantineRate int
 }
 
 // Store sensordata godoc
@@ -22,24 +37,186 @@ type externalHandler struct {
 //	@Accept			json
 //	@Produce		json
 //	@Param			account	body		schemas.ExternalSensorDataSchema true	"Create room"
+//	@Param			X-Sensor-Guid	header	string	false	"Sensor guid"
+//	@Param			X-Gateway-Guid	header	string	false	"Gateway guid instead of sensor guid"
+//	@Param			X-Timestamp	header	string	false	"Unix seconds, required with X-Signature"
+//	@Param			X-Signature	header	string	false	"Hex HMAC-SHA256 of timestamp.body with sensor or gateway secret"
 //	@Success		200		{object}	nil
+//	@Failure		429		{object}	nil
+//	@Failure		503		{object}	nil
 //	@Router			/external/sensors_data [post]
+//	@Security SensorSecret
 func (h externalHandler) handleStore(c *fiber.Ctx) error {
   var schema schemas.ExternalSensorDataSchema
   if err := c.BodyParser(&schema); err != nil {
     return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
   }
+  allowed, err := h.allowedGuids(c, []string{schema.Guid})
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  if !allowed[schema.Guid] {
+    return c.Status(401).SendString("Guid does not match sensor credentials")
+  }
+  if err := h.admitQuarantined(c, 1); err != nil {
+    return c.Status(429).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
 
-  h.externalService.Store(schema)
+  err = h.externalService.Store(schema)
+  if errors.Is(err, services.ErrInvalidSensorData) {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
   return nil
 }
 
+// Store sensordata batch godoc
+//
+//	@Summary		store sensordata batch
+//	@Description	Store array of sensordata. Body is a JSON array or NDJSON (Content-Type: application/x-ndjson).
+//	@Description	Every item is accepted or rejected separately, so only rejected items should be retried.
+//	@Tags			External
+//	@Accept			json
+//	@Accept			application/x-ndjson
+//	@Produce		json
+//	@Param			batch	body		[]schemas.ExternalSensorDataSchema true	"Sensordata batch"
+//	@Param			X-Sensor-Guid	header	string	false	"Sensor guid, items must be readings of this sensor"
+//	@Param			X-Gateway-Guid	header	string	false	"Gateway guid instead of sensor guid, items may be readings of any sensor of gateway zone"
+//	@Param			X-Timestamp	header	string	false	"Unix seconds, required with X-Signature"
+//	@Param			X-Signature	header	string	false	"Hex HMAC-SHA256 of timestamp.body with sensor or gateway secret"
+//	@Success		200		{object}	schemas.ExternalBatchResultSchema
+//	@Failure		429		{object}	nil
+//	@Router			/external/sensors_data/batch [post]
+//	@Security SensorSecret
+func (h externalHandler) handleStoreBatch(c *fiber.Ctx) error {
+  var items []json.RawMessage
+  if strings.HasPrefix(c.Get(fiber.HeaderContentType), "application/x-ndjson") {
+    items = splitNDJSON(c.Body())
+  } else if err := json.Unmarshal(c.Body(), &items); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  if len(items) > maxBatchSize {
+    return c.Status(413).JSON(fiber.Map{"status": "error", "data": "Too many items in batch"})
+  }
+
+  resp := schemas.ExternalBatchResultSchema{Items: make([]schemas.ExternalBatchItemResultSchema, len(items))}
+  parsed := make([]*schemas.ExternalSensorDataSchema, len(items))
+  guids := make([]string, 0, len(items))
+  for i, item := range items {
+    var schema schemas.ExternalSensorDataSchema
+    resp.Items[i].Index = i
+    if err := json.Unmarshal(item, &schema); err != nil {
+      resp.Items[i].Error = err.Error()
+      continue
+    }
+    resp.Items[i].Guid = schema.Guid
+    parsed[i] = &schema
+    guids = append(guids, schema.Guid)
+  }
+  allowed, err := h.allowedGuids(c, guids)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  batch := make([]schemas.ExternalSensorDataSchema, 0, len(items))
+  batchIndexes := make([]int, 0, len(items))
+  for i, schema := range parsed {
+    if schema == nil {
+      continue
+    }
+    if !allowed[schema.Guid] {
+      resp.Items[i].Error = "guid does not match sensor or gateway credentials"
+      continue
+    }
+    batch = append(batch, *schema)
+    batchIndexes = append(batchIndexes, i)
+  }
+  if err := h.admitQuarantined(c, len(batch)); err != nil {
+    return c.Status(429).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  for j, err := range h.externalService.StoreBatch(batch) {
+    if err != nil {
+      resp.Items[batchIndexes[j]].Error = err.Error()
+      continue
+    }
+    resp.Items[batchIndexes[j]].Accepted = true
+  }
+  for _, item := range resp.Items {
+    if item.Accepted {
+      resp.Accepted++
+    } else {
+      resp.Rejected++
+    }
+  }
+  return c.JSON(resp)
+}
+
 func (h externalHandler) Register(app *fiber.App) {
-  router := app.Group("/external")
+  router := app.Group(
+    "/external",
+    middlewares.DeviceAuthenticated(h.credentialService),
+    limiter.New(limiter.Config{
+      Next: func(c *fiber.Ctx) bool {
+        quarantined, _ := c.Locals("quarantined").(bool)
+        return !quarantined
+      },
+      Max: h.quarantineRate,
+      Expiration: time.Minute,
+    }),
+  )
 
   router.Post("/sensors_data", h.handleStore)
+  router.Post("/sensors_data/batch", h.handleStoreBatch)
+}
+
+func NewExternalHandler(
+  externalService services.ExternalService,
+  credentialService services.CredentialService,
+  quarantineQuota services.QuarantineQuota,
+  quarantineRate int,
+) ExternalHandler {
+  return externalHandler{
+    externalService: externalService,
+    credentialService: credentialService,
+    quarantineQuota: quarantineQuota,
+    quarantineRate: quarantineRate,
+  }
+}
+
+// admitQuarantined checks that readings of unknown guid, sent without
+// credentials, fit into quarantine
+func (h externalHandler) admitQuarantined(c *fiber.Ctx, readings int) error {
+  if quarantined, _ := c.Locals("quarantined").(bool); !quarantined || readings == 0 {
+    return nil
+  }
+  sensor, _ := c.Locals("sensor_guid").(string)
+  return h.quarantineQuota.Admit(sensor, readings)
 }
 
-func NewExternalHandler(externalService services.ExternalService) ExternalHandler {
-  return externalHandler{externalService: externalService}
+// allowedGuids tells which of guids request credentials may store readings
+// of. Sensor stores only its own readings, gateway readings of its zone sensors
+func (h externalHandler) allowedGuids(c *fiber.Ctx, guids []string) (map[string]bool, error) {
+  if gateway, ok := c.Locals("gateway_guid").(string); ok {
+    return h.credentialService.GatewayGuids(gateway, guids)
+  }
+  sensor, _ := c.Locals("sensor_guid").(string)
+  allowed := make(map[string]bool, 1)
+  allowed[sensor] = true
+  return allowed, nil
+}
+
+func splitNDJSON(body []byte) []json.RawMessage {
+  var items []json.RawMessage
+  scanner := bufio.NewScanner(bytes.NewReader(body))
+  scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+  for scanner.Scan() {
+    line := bytes.TrimSpace(scanner.Bytes())
+    if len(line) == 0 {
+      continue
+    }
+    items = append(items, json.RawMessage(append([]byte(nil), line...)))
+  }
+  return items
 }
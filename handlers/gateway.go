@@ -0,0 +1,169 @@
+package handlers
+
+import (
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type GatewayHandler interface {
+  Register(app *fiber.App)
+}
+
+type gatewayHandler struct {
+  gatewayService services.GatewayService
+  zoneService services.ZoneService
+  authService services.AuthService
+}
+
+// Create gateway godoc
+//
+//	@Summary		Create gateway
+//	@Description	Register zone gateway. Gateway sends batches of readings of zone sensors with X-Gateway-Guid header
+//	@Description	and its secret, like sensor does. Secret is returned only in this response.
+//	@Tags			Gateway
+//	@Accept			json
+//	@Produce		json
+//	@Param			gateway	body		schemas.GatewayCreateSchema true	"Create gateway"
+//	@Success		201		{object}	schemas.GatewaySchema
+//	@Router			/gateway [post]
+//	@Security ApiKeyAuth
+func (h gatewayHandler) handleCreate(c *fiber.Ctx) error {
+  var schema schemas.GatewayCreateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(schema.ZoneID)
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  gateway, err := h.gatewayService.Create(schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(gateway)
+}
+
+// Find gateways godoc
+//
+//	@Summary		Find gateways
+//	@Description	Find gateways of zones owned by user
+//	@Tags			Gateway
+//	@Produce		json
+//	@Param			q	query		schemas.GatewayFindSchema false	"find filters"
+//	@Success		200		{array}	schemas.GatewaySchema
+//	@Router			/gateway/ [get]
+//	@Security ApiKeyAuth
+func (h gatewayHandler) handleFind(c *fiber.Ctx) error {
+  var schema schemas.GatewayFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  gateways, err := h.gatewayService.Find(h.authService.CurrentUserID(c), h.authService.IsSuperuser(c), schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(gateways)
+}
+
+// Get gateway godoc
+//
+//	@Summary		Get gateway
+//	@Description	Get gateway, secret is never returned
+//	@Tags			Gateway
+//	@Produce		json
+//	@Param			id	path		int	true	"Gateway ID"
+//	@Success		200		{object}	schemas.GatewaySchema
+//	@Router			/gateway/{id} [get]
+//	@Security ApiKeyAuth
+func (h gatewayHandler) handleTake(c *fiber.Ctx) error {
+  gateway, ok, err := h.takeGateway(c)
+  if !ok {
+    return err
+  }
+  return c.JSON(gateway)
+}
+
+// Rotate gateway credentials godoc
+//
+//	@Summary		Rotate gateway credentials
+//	@Description	Issue new gateway secret, previous one stops working. Secret is returned only once
+//	@Tags			Gateway
+//	@Produce		json
+//	@Param			id	path		int	true	"Gateway ID"
+//	@Success		201		{object}	schemas.GatewaySchema
+//	@Router			/gateway/{id}/credentials [post]
+//	@Security ApiKeyAuth
+func (h gatewayHandler) handleRotate(c *fiber.Ctx) error {
+  gateway, ok, err := h.takeGateway(c)
+  if !ok {
+    return err
+  }
+
+  gateway, err = h.gatewayService.Rotate(gateway.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(gateway)
+}
+
+// Delete gateway godoc
+//
+//	@Summary		Delete gateway
+//	@Description	Delete gateway, it can't send readings anymore
+//	@Tags			Gateway
+//	@Param			id	path		int	true	"Gateway ID"
+//	@Success		204		{object}	nil
+//	@Router			/gateway/{id} [delete]
+//	@Security ApiKeyAuth
+func (h gatewayHandler) handleDelete(c *fiber.Ctx) error {
+  gateway, ok, err := h.takeGateway(c)
+  if !ok {
+    return err
+  }
+
+  if err := h.gatewayService.Delete(gateway.ID); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// takeGateway takes gateway by path param and checks that user owns its
+// zone. When ok is false response is already written.
+func (h gatewayHandler) takeGateway(c *fiber.Ctx) (schemas.GatewaySchema, bool, error) {
+  gatewayID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.GatewaySchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  gateway, err := h.gatewayService.Take(uint(gatewayID))
+  if err != nil {
+    return schemas.GatewaySchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  zone := h.zoneService.Take(gateway.ZoneID)
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return schemas.GatewaySchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+  }
+  return gateway, true, nil
+}
+
+func (h gatewayHandler) Register(app *fiber.App) {
+  router := app.Group("/gateway", middlewares.Protected(), logger.New())
+
+  router.Post("/", h.handleCreate)
+  router.Get("/", h.handleFind)
+  router.Get("/:id<int>", h.handleTake)
+  router.Post("/:id<int>/credentials", h.handleRotate)
+  router.Delete("/:id<int>", h.handleDelete)
+}
+
+func NewGatewayHandler(gatewayService services.GatewayService, zoneService services.ZoneService, authService services.AuthService) GatewayHandler {
+  return gatewayHandler{gatewayService: gatewayService, zoneService: zoneService, authService: authService}
+}
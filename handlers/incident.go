@@ -0,0 +1,162 @@
+package handlers
+
+import (
+  "errors"
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type IncidentHandler interface {
+  Register(app *fiber.App)
+}
+
+type incidentHandler struct {
+  incidentService services.IncidentService
+  roomService services.RoomService
+  zoneService services.ZoneService
+  authService services.AuthService
+}
+
+// Find incidents godoc
+//
+//	@Summary		Find incidents
+//	@Description	Find incidents in rooms or zones owned by user, newest first. Superuser sees all incidents.
+//	@Tags			Incident
+//	@Produce		json
+//	@Param			q	query		schemas.IncidentFindSchema false	"find filters"
+//	@Success		200		{array}	schemas.IncidentSchema
+//	@Router			/incident/ [get]
+//	@Security ApiKeyAuth
+func (h incidentHandler) handleFind(c *fiber.Ctx) error {
+  var schema schemas.IncidentFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  incidents, err := h.incidentService.Find(h.authService.CurrentUserID(c), h.authService.IsSuperuser(c), schema)
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(incidents)
+}
+
+// Get incident godoc
+//
+//	@Summary		Get incident
+//	@Description	Get incident
+//	@Tags			Incident
+//	@Produce		json
+//	@Param			id	path		int	true	"Incident ID"
+//	@Success		200		{object}	schemas.IncidentSchema
+//	@Router			/incident/{id} [get]
+//	@Security ApiKeyAuth
+func (h incidentHandler) handleTake(c *fiber.Ctx) error {
+  incident, ok, err := h.takeIncident(c)
+  if !ok {
+    return err
+  }
+  return c.JSON(incident)
+}
+
+// Acknowledge incident godoc
+//
+//	@Summary		Acknowledge incident
+//	@Description	Acknowledge open incident with optional note
+//	@Tags			Incident
+//	@Accept			json
+//	@Param			id	path		int	true	"Incident ID"
+//	@Param			note	body		schemas.IncidentNoteSchema false	"Note"
+//	@Success		204		{object}	nil
+//	@Failure		409		{object}	nil
+//	@Router			/incident/{id}/acknowledge [post]
+//	@Security ApiKeyAuth
+func (h incidentHandler) handleAcknowledge(c *fiber.Ctx) error {
+  return h.transition(c, h.incidentService.Acknowledge)
+}
+
+// Resolve incident godoc
+//
+//	@Summary		Resolve incident
+//	@Description	Resolve open or acknowledged incident with optional note
+//	@Tags			Incident
+//	@Accept			json
+//	@Param			id	path		int	true	"Incident ID"
+//	@Param			note	body		schemas.IncidentNoteSchema false	"Note"
+//	@Success		204		{object}	nil
+//	@Failure		409		{object}	nil
+//	@Router			/incident/{id}/resolve [post]
+//	@Security ApiKeyAuth
+func (h incidentHandler) handleResolve(c *fiber.Ctx) error {
+  return h.transition(c, h.incidentService.Resolve)
+}
+
+func (h incidentHandler) transition(c *fiber.Ctx, action func(incidentID uint, userID uint, note string) error) error {
+  incident, ok, err := h.takeIncident(c)
+  if !ok {
+    return err
+  }
+  var schema schemas.IncidentNoteSchema
+  if len(c.Body()) > 0 {
+    if err := c.BodyParser(&schema); err != nil {
+      return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+    }
+  }
+
+  err = action(incident.ID, h.authService.CurrentUserID(c), schema.Note)
+  if errors.Is(err, services.ErrIncidentState) {
+    return c.Status(409).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// takeIncident takes incident by path param and checks that user owns its
+// room or zone. When ok is false response is already written.
+func (h incidentHandler) takeIncident(c *fiber.Ctx) (schemas.IncidentSchema, bool, error) {
+  incidentID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.IncidentSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  incident, err := h.incidentService.Take(uint(incidentID))
+  if err != nil {
+    return schemas.IncidentSchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  if !h.authService.IsSuperuser(c) {
+    userID := h.authService.CurrentUserID(c)
+    if h.roomService.Take(incident.RoomID).OwnerID != userID && h.zoneService.Take(incident.ZoneID).OwnerID != userID {
+      return schemas.IncidentSchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+    }
+  }
+  return incident, true, nil
+}
+
+func (h incidentHandler) Register(app *fiber.App) {
+  router := app.Group("/incident", middlewares.Protected(), logger.New())
+
+  router.Get("/", h.handleFind)
+  router.Get("/:id<int>", h.handleTake)
+  router.Post("/:id<int>/acknowledge", h.handleAcknowledge)
+  router.Post("/:id<int>/resolve", h.handleResolve)
+}
+
+func NewIncidentHandler(
+  incidentService services.IncidentService,
+  roomService services.RoomService,
+  zoneService services.ZoneService,
+  authService services.AuthService,
+) IncidentHandler {
+  return incidentHandler{
+    incidentService: incidentService,
+    roomService: roomService,
+    zoneService: zoneService,
+    authService: authService,
+  }
+}
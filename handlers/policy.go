@@ -0,0 +1,98 @@
+package handlers
+
+import (
+  "strconv"
+
+  "antivape/schemas"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Get zone policy godoc
+//
+//	@Summary		Get zone notification policy
+//	@Description	Get zone notification policy, everything is switched off if zone has no policy
+//	@Tags			Policy
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Success		200		{object}	schemas.PolicySchema
+//	@Router			/zone/{id}/policy [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleTakePolicy(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  policy, err := h.policyService.Take(zone.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(policy)
+}
+
+// Put zone policy godoc
+//
+//	@Summary		Set zone notification policy
+//	@Description	Set zone notification policy: grouping of repeated triggers into one incident,
+//	@Description	notification cooldown, quiet hours and escalation of unacknowledged incidents
+//	@Tags			Policy
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			policy	body		schemas.PolicySchema true	"Policy"
+//	@Success		200		{object}	schemas.PolicySchema
+//	@Router			/zone/{id}/policy [put]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handlePutPolicy(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.PolicySchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  policy, err := h.policyService.Put(zone.ID, schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(policy)
+}
+
+// Delete zone policy godoc
+//
+//	@Summary		Delete zone notification policy
+//	@Description	Delete zone notification policy
+//	@Tags			Policy
+//	@Param			id	path		int	true	"Zone ID"
+//	@Success		204		{object}	nil
+//	@Router			/zone/{id}/policy [delete]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleDeletePolicy(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  if err := h.policyService.Delete(zone.ID); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+  "errors"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type QuarantineHandler interface {
+  Register(app *fiber.App)
+}
+
+type quarantineHandler struct {
+  quarantineService services.QuarantineService
+  roomService services.RoomService
+  authService services.AuthService
+}
+
+// Find unclaimed devices godoc
+//
+//	@Summary		Find unclaimed devices
+//	@Description	Guids which sent readings but match no sensor, with first/last seen time and latest readings.
+//	@Description	Available for superusers and room owners
+//	@Tags			Unclaimed
+//	@Produce		json
+//	@Success		200		{array}	schemas.UnclaimedDeviceSchema
+//	@Router			/unclaimed/ [get]
+//	@Security ApiKeyAuth
+func (h quarantineHandler) handleFind(c *fiber.Ctx) error {
+  if !h.authService.IsSuperuser(c) && !h.ownsRoom(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+
+  devices, err := h.quarantineService.FindUnclaimed()
+  if err != nil {
+    return c.Status(500).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(devices)
+}
+
+// Claim unclaimed device godoc
+//
+//	@Summary		Claim unclaimed device
+//	@Description	Create sensor for guid in room owned by user, optionally importing quarantined readings.
+//	@Description	Imported readings count in statistics, but don't raise detections, rule triggers or health events.
+//	@Tags			Unclaimed
+//	@Accept			json
+//	@Produce		json
+//	@Param			guid	path		string	true	"Device guid"
+//	@Param			claim	body		schemas.UnclaimedDeviceClaimSchema true	"Claim device"
+//	@Success		201		{object}	schemas.SensorSchema
+//	@Router			/unclaimed/{guid}/claim [post]
+//	@Security ApiKeyAuth
+func (h quarantineHandler) handleClaim(c *fiber.Ctx) error {
+  var schema schemas.UnclaimedDeviceClaimSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  room := h.roomService.Take(schema.RoomID)
+  if room.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+
+  // Sensor claimed by superuser belongs to room owner too
+  sensor, err := h.quarantineService.Claim(c.Params("guid"), schema, room.OwnerID)
+  if errors.Is(err, services.ErrUnknownDevice) {
+    return c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if errors.Is(err, services.ErrSensorExists) {
+    return c.Status(409).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(500).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(sensor)
+}
+
+// Discard unclaimed device godoc
+//
+//	@Summary		Discard unclaimed device
+//	@Description	Delete quarantined readings of guid
+//	@Tags			Unclaimed
+//	@Param			guid	path		string	true	"Device guid"
+//	@Success		204		{object}	nil
+//	@Router			/unclaimed/{guid} [delete]
+//	@Security ApiKeyAuth
+func (h quarantineHandler) handleDiscard(c *fiber.Ctx) error {
+  if !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+
+  if err := h.quarantineService.Discard(c.Params("guid")); err != nil {
+    return c.Status(500).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+func (h quarantineHandler) ownsRoom(c *fiber.Ctx) bool {
+  userID := h.authService.CurrentUserID(c)
+  return len(h.roomService.Find(schemas.RoomFindSchema{OwnerID: &userID})) > 0
+}
+
+func (h quarantineHandler) Register(app *fiber.App) {
+  router := app.Group("/unclaimed", middlewares.Protected(), logger.New())
+
+  router.Get("/", h.handleFind)
+  router.Post("/:guid/claim", h.handleClaim)
+  router.Delete("/:guid", h.handleDiscard)
+}
+
+func NewQuarantineHandler(quarantineService services.QuarantineService, roomService services.RoomService, authService services.AuthService) QuarantineHandler {
+  return quarantineHandler{quarantineService: quarantineService, roomService: roomService, authService: authService}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+  "errors"
+  "strconv"
+
+  "antivape/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Get sensor latest reading godoc
+//
+//	@Summary		Get sensor latest reading
+//	@Description	The newest reading of sensor, including reading which is still in ingestion buffer
+//	@Tags			Sensor
+//	@Produce		json
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Success		200		{object}	schemas.LatestReadingSchema
+//	@Router			/sensor/{id}/latest [get]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleTakeLatest(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  latest, err := h.readingService.TakeLatest(uint(sensorID))
+  if errors.Is(err, services.ErrSensorNotFound) {
+    return c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(latest)
+}
+
+// Get zone latest readings godoc
+//
+//	@Summary		Get zone latest readings
+//	@Description	The newest reading of every zone sensor for wall displays, including readings which are still in ingestion buffer
+//	@Tags			Zone
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Success		200		{array}	schemas.LatestReadingSchema
+//	@Router			/zone/{id}/latest [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleFindLatest(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  latest, err := h.readingService.FindZoneLatest(uint(zoneID))
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(latest)
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+  "fmt"
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type ReportHandler interface {
+  Register(app *fiber.App)
+}
+
+type reportHandler struct {
+  reportService services.ReportService
+  zoneService services.ZoneService
+  authService services.AuthService
+}
+
+// Create report definition godoc
+//
+//	@Summary		Create report definition
+//	@Description	Define zone report made after every period and mailed as HTML to recipients
+//	@Tags			Report
+//	@Accept			json
+//	@Produce		json
+//	@Param			definition	body		schemas.ReportDefinitionCreateSchema true	"Create report definition"
+//	@Success		201		{object}	schemas.ReportDefinitionSchema
+//	@Router			/report/definition [post]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleCreateDefinition(c *fiber.Ctx) error {
+  var schema schemas.ReportDefinitionCreateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  zone := h.zoneService.Take(schema.ZoneID)
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  definition, err := h.reportService.CreateDefinition(schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(definition)
+}
+
+// Find report definitions godoc
+//
+//	@Summary		Find report definitions
+//	@Description	Find report definitions of zones owned by user
+//	@Tags			Report
+//	@Produce		json
+//	@Param			q	query		schemas.ReportDefinitionFindSchema false	"find filters"
+//	@Success		200		{array}	schemas.ReportDefinitionSchema
+//	@Router			/report/definition [get]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleFindDefinitions(c *fiber.Ctx) error {
+  var schema schemas.ReportDefinitionFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  definitions, err := h.reportService.FindDefinitions(h.authService.CurrentUserID(c), h.authService.IsSuperuser(c), schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(definitions)
+}
+
+// Get report definition godoc
+//
+//	@Summary		Get report definition
+//	@Description	Get report definition
+//	@Tags			Report
+//	@Produce		json
+//	@Param			id	path		int	true	"Report definition ID"
+//	@Success		200		{object}	schemas.ReportDefinitionSchema
+//	@Router			/report/definition/{id} [get]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleTakeDefinition(c *fiber.Ctx) error {
+  definition, ok, err := h.takeDefinition(c)
+  if !ok {
+    return err
+  }
+  return c.JSON(definition)
+}
+
+// Update report definition godoc
+//
+//	@Summary		Update report definition
+//	@Description	Update report definition. Change of period, timezone or enabled schedules next report for period in progress
+//	@Tags			Report
+//	@Accept			json
+//	@Param			id	path		int	true	"Report definition ID"
+//	@Param			definition	body		schemas.ReportDefinitionUpdateSchema true	"Update report definition"
+//	@Success		204		{object}	nil
+//	@Router			/report/definition/{id} [patch]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleUpdateDefinition(c *fiber.Ctx) error {
+  definition, ok, err := h.takeDefinition(c)
+  if !ok {
+    return err
+  }
+  var schema schemas.ReportDefinitionUpdateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  if err := h.reportService.UpdateDefinition(definition.ID, schema); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Delete report definition godoc
+//
+//	@Summary		Delete report definition
+//	@Description	Delete report definition, its reports are kept
+//	@Tags			Report
+//	@Param			id	path		int	true	"Report definition ID"
+//	@Success		204		{object}	nil
+//	@Router			/report/definition/{id} [delete]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleDeleteDefinition(c *fiber.Ctx) error {
+  definition, ok, err := h.takeDefinition(c)
+  if !ok {
+    return err
+  }
+
+  if err := h.reportService.DeleteDefinition(definition.ID); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Find reports godoc
+//
+//	@Summary		Find reports
+//	@Description	Last 100 reports of zones owned by user, newest first
+//	@Tags			Report
+//	@Produce		json
+//	@Param			q	query		schemas.ReportFindSchema false	"find filters"
+//	@Success		200		{array}	schemas.ReportSchema
+//	@Router			/report/ [get]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleFind(c *fiber.Ctx) error {
+  var schema schemas.ReportFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  reports, err := h.reportService.Find(h.authService.CurrentUserID(c), h.authService.IsSuperuser(c), schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(reports)
+}
+
+// Get report godoc
+//
+//	@Summary		Get report
+//	@Description	Get report with its mailing status
+//	@Tags			Report
+//	@Produce		json
+//	@Param			id	path		int	true	"Report ID"
+//	@Success		200		{object}	schemas.ReportSchema
+//	@Router			/report/{id} [get]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleTake(c *fiber.Ctx) error {
+  report, ok, err := h.takeReport(c)
+  if !ok {
+    return err
+  }
+  return c.JSON(report)
+}
+
+// Download HTML report godoc
+//
+//	@Summary		Download HTML report
+//	@Description	Download report as HTML page
+//	@Tags			Report
+//	@Produce		html
+//	@Param			id	path		int	true	"Report ID"
+//	@Success		200		{string}	string
+//	@Router			/report/{id}/html [get]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleDownloadHTML(c *fiber.Ctx) error {
+  return h.download(c, "html", fiber.MIMETextHTMLCharsetUTF8)
+}
+
+// Download CSV report godoc
+//
+//	@Summary		Download CSV report
+//	@Description	Download report as CSV: rooms table, empty line and sensors table
+//	@Tags			Report
+//	@Produce		text/csv
+//	@Param			id	path		int	true	"Report ID"
+//	@Success		200		{string}	string
+//	@Router			/report/{id}/csv [get]
+//	@Security ApiKeyAuth
+func (h reportHandler) handleDownloadCSV(c *fiber.Ctx) error {
+  return h.download(c, schemas.ExportCSV, "text/csv; charset=utf-8")
+}
+
+func (h reportHandler) download(c *fiber.Ctx, format string, contentType string) error {
+  report, ok, err := h.takeReport(c)
+  if !ok {
+    return err
+  }
+
+  content, err := h.reportService.TakeFile(report.ID, format)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Set(fiber.HeaderContentType, contentType)
+  c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="report-%d.%s"`, report.ID, format))
+  return c.SendString(content)
+}
+
+// takeDefinition takes report definition by path param and checks that user
+// owns its zone. When ok is false response is already written.
+func (h reportHandler) takeDefinition(c *fiber.Ctx) (schemas.ReportDefinitionSchema, bool, error) {
+  definitionID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.ReportDefinitionSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  definition, err := h.reportService.TakeDefinition(uint(definitionID))
+  if err != nil {
+    return schemas.ReportDefinitionSchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  zone := h.zoneService.Take(definition.ZoneID)
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return schemas.ReportDefinitionSchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+  }
+  return definition, true, nil
+}
+
+// takeReport takes report by path param and checks that user owns its zone
+func (h reportHandler) takeReport(c *fiber.Ctx) (schemas.ReportSchema, bool, error) {
+  reportID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.ReportSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  report, err := h.reportService.Take(uint(reportID))
+  if err != nil {
+    return schemas.ReportSchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  zone := h.zoneService.Take(report.ZoneID)
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return schemas.ReportSchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+  }
+  return report, true, nil
+}
+
+func (h reportHandler) Register(app *fiber.App) {
+  router := app.Group("/report", middlewares.Protected(), logger.New())
+
+  router.Post("/definition", h.handleCreateDefinition)
+  router.Get("/definition", h.handleFindDefinitions)
+  router.Get("/definition/:id<int>", h.handleTakeDefinition)
+  router.Patch("/definition/:id<int>", h.handleUpdateDefinition)
+  router.Delete("/definition/:id<int>", h.handleDeleteDefinition)
+  router.Get("/", h.handleFind)
+  router.Get("/:id<int>", h.handleTake)
+  router.Get("/:id<int>/html", h.handleDownloadHTML)
+  router.Get("/:id<int>/csv", h.handleDownloadCSV)
+}
+
+func NewReportHandler(reportService services.ReportService, zoneService services.ZoneService, authService services.AuthService) ReportHandler {
+  return reportHandler{reportService: reportService, zoneService: zoneService, authService: authService}
+}
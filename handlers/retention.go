@@ -0,0 +1,157 @@
+package handlers
+
+import (
+  "errors"
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+// Get zone retention godoc
+//
+//	@Summary		Get zone retention
+//	@Description	Get days zone data is kept, 0 means global retention is used
+//	@Tags			Retention
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Success		200		{object}	schemas.RetentionSchema
+//	@Router			/zone/{id}/retention [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleTakeRetention(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  retention, err := h.retentionService.Take(zone.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(retention)
+}
+
+// Put zone retention godoc
+//
+//	@Summary		Set zone retention
+//	@Description	Set days raw data and minute, hour and day rollups of zone are kept.
+//	@Description	0 means global retention is used. Older data is deleted by background job.
+//	@Tags			Retention
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			retention	body		schemas.RetentionSchema true	"Retention"
+//	@Success		200		{object}	schemas.RetentionSchema
+//	@Router			/zone/{id}/retention [put]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handlePutRetention(c *fiber.Ctx) error {
+  if !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.RetentionSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.ID == 0 {
+    return c.Status(404).JSON(fiber.Map{"status": "error", "data": "Zone not found"})
+  }
+  retention, err := h.retentionService.Put(zone.ID, schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(retention)
+}
+
+// Delete zone retention godoc
+//
+//	@Summary		Delete zone retention
+//	@Description	Delete zone retention, global retention is used for zone
+//	@Tags			Retention
+//	@Param			id	path		int	true	"Zone ID"
+//	@Success		204		{object}	nil
+//	@Router			/zone/{id}/retention [delete]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleDeleteRetention(c *fiber.Ctx) error {
+  if !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  if err := h.retentionService.Delete(uint(zoneID)); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+type StorageHandler interface {
+  Register(app *fiber.App)
+}
+
+type storageHandler struct {
+  retentionService services.RetentionService
+  authService services.AuthService
+}
+
+// Get storage godoc
+//
+//	@Summary		Get storage usage
+//	@Description	Get size of sensor data and rollup tables, rows and estimated size per zone
+//	@Description	with effective retention of zone
+//	@Tags			Retention
+//	@Produce		json
+//	@Success		200		{object}	schemas.StorageSchema
+//	@Router			/storage [get]
+//	@Security ApiKeyAuth
+func (h storageHandler) handleTake(c *fiber.Ctx) error {
+  if !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+
+  storage, err := h.retentionService.Storage()
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(storage)
+}
+
+func (h storageHandler) Register(app *fiber.App) {
+  router := app.Group("/storage", middlewares.Protected(), logger.New())
+
+  router.Get("/", h.handleTake)
+}
+
+func NewStorageHandler(retentionService services.RetentionService, authService services.AuthService) StorageHandler {
+  return storageHandler{retentionService: retentionService, authService: authService}
+}
+
+// checkRetention answers 422 when statistics of zone need data purged by
+// retention. When ok is false response is already written.
+func checkRetention(c *fiber.Ctx, retentionService services.RetentionService, zoneID uint, statistics ...schemas.StatisticFindSchema) (bool, error) {
+  err := retentionService.CheckStatistic(zoneID, statistics...)
+  if errors.Is(err, services.ErrStatisticRetention) {
+    return false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return false, c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return true, nil
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
   "strconv"
+  "time"
 
   "antivape/services"
   "antivape/schemas"
@@ -17,6 +18,10 @@ type RoomHandler interface {
 type roomHandler struct {
   roomService services.RoomService
   authService services.AuthService
+  ruleService services.RuleService
+  seriesService services.SeriesService
+  airQualityService services.AirQualityService
+  retentionService services.RetentionService
 }
 
 // Create room godoc
@@ -51,6 +56,13 @@ func (h roomHandler) handleCreate(c *fiber.Ctx) error {
 //	@Tags			Room
 //	@Produce		json
 //	@Param			id	path		int	true	"Room ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 30m, 1h or 7d, instead of from and to"
+//	@Param			aggregates	query		string	false	"Comma separated avg, min, max, median, p95, stddev, count, time_above"
+//	@Param			co2_above	query		number	false	"CO2 threshold of time_above"
+//	@Param			tvoc_above	query		number	false	"TVOC threshold of time_above"
+//	@Param			weighting	query		string	false	"sample or time"
 //	@Success		200		{object}	schemas.SensorDataRoomSchema
 //	@Router			/room/{id}/statistic [get]
 //	@Security ApiKeyAuth
@@ -59,15 +71,72 @@ func (h roomHandler) handleStatistic(c *fiber.Ctx) error {
   if err != nil {
     return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
   }
+  var schema schemas.StatisticFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
 
   room := h.roomService.Take(uint(roomID))
-  if room.OwnerID != h.authService.CurrentUserID(c) {
+  if room.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
     return c.Status(401).SendString("Not enough rights for this request")
   }
-  statistic := h.roomService.GetStatistic(uint(roomID))
+  if ok, err := checkRetention(c, h.retentionService, room.ZoneID, schema); !ok {
+    return err
+  }
+  statistic := h.roomService.GetStatistic(uint(roomID), schema)
   return c.JSON(statistic)
 }
 
+// Compare room statistic godoc
+//
+//	@Summary		Compare room statistic
+//	@Description	Statistic of room in interval and previous interval side by side with deltas
+//	@Tags			Room
+//	@Produce		json
+//	@Param			id	path		int	true	"Room ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 1h or 30d, instead of from and to"
+//	@Param			previous_from	query		string	false	"RFC3339, previous interval start, interval of the same length before from by default"
+//	@Param			previous_to	query		string	false	"RFC3339, previous interval end"
+//	@Param			aggregates	query		string	false	"Comma separated avg, min, max, median, p95, stddev, count, time_above"
+//	@Param			co2_above	query		number	false	"CO2 threshold of time_above"
+//	@Param			tvoc_above	query		number	false	"TVOC threshold of time_above"
+//	@Param			weighting	query		string	false	"sample or time"
+//	@Success		200		{object}	schemas.RoomComparisonSchema
+//	@Router			/room/{id}/compare [get]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleCompare(c *fiber.Ctx) error {
+  roomID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.ComparisonFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  room := h.roomService.Take(uint(roomID))
+  if room.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  window, _ := schema.Windows(time.Now())
+  if ok, err := checkRetention(c, h.retentionService, room.ZoneID, schema.Statistic(window.From, window.To), schema.Statistic(window.PreviousFrom, window.PreviousTo)); !ok {
+    return err
+  }
+  comparison, err := h.roomService.Compare(uint(roomID), schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(comparison)
+}
+
 // Get room godoc
 //
 //	@Summary		Get room
@@ -173,11 +242,33 @@ func (h roomHandler) Register(app *fiber.App) {
   router.Post("/", h.handleCreate)
   router.Get("/:id<int>/", h.handleTake)
   router.Get("/:id<int>/statistic", h.handleStatistic)
+  router.Get("/:id<int>/compare", h.handleCompare)
+  router.Get("/:id<int>/series", h.handleSeries)
+  router.Get("/:id<int>/bands", h.handleFindBands)
   router.Get("/", h.handleFind)
   router.Patch("/:id<int>", h.handleUpdate)
   router.Delete("/:id<int>", h.handleDelete)
+  router.Get("/:id<int>/rules/triggers", h.handleFindRuleTriggers)
+  router.Get("/:id<int>/rules", h.handleFindRules)
+  router.Post("/:id<int>/rules", h.handleCreateRule)
+  router.Patch("/:id<int>/rules/:ruleID<int>", h.handleUpdateRule)
+  router.Delete("/:id<int>/rules/:ruleID<int>", h.handleDeleteRule)
 }
 
-func NewRoomHandler(roomService services.RoomService, authService services.AuthService) RoomHandler {
-  return roomHandler{roomService: roomService, authService: authService}
+func NewRoomHandler(
+  roomService services.RoomService,
+  authService services.AuthService,
+  ruleService services.RuleService,
+  seriesService services.SeriesService,
+  airQualityService services.AirQualityService,
+  retentionService services.RetentionService,
+) RoomHandler {
+  return roomHandler{
+    roomService: roomService,
+    authService: authService,
+    ruleService: ruleService,
+    seriesService: seriesService,
+    airQualityService: airQualityService,
+    retentionService: retentionService,
+  }
 }
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+  "strconv"
+
+  "antivape/schemas"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Find room rules godoc
+//
+//	@Summary		Find room rules
+//	@Description	Rules effective for room: its own rules and zone rules which it doesn't override.
+//	@Description	Room rule overrides zone rule with the same metric and operator.
+//	@Tags			Rule
+//	@Produce		json
+//	@Param			id	path		int	true	"Room ID"
+//	@Success		200		{array}	schemas.RuleSchema
+//	@Router			/room/{id}/rules [get]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleFindRules(c *fiber.Ctx) error {
+  roomID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  room := h.roomService.Take(uint(roomID))
+  if room.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  rules, err := h.ruleService.FindForRoom(room.ID, room.ZoneID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(rules)
+}
+
+// Create room rule godoc
+//
+//	@Summary		Create room rule
+//	@Description	Create room rule
+//	@Tags			Rule
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"Room ID"
+//	@Param			rule	body		schemas.RuleCreateSchema true	"Create rule"
+//	@Success		201		{object}	schemas.RuleSchema
+//	@Router			/room/{id}/rules [post]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleCreateRule(c *fiber.Ctx) error {
+  roomID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.RuleCreateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  room := h.roomService.Take(uint(roomID))
+  if room.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  rule, err := h.ruleService.Create(room.ZoneID, &room.ID, schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(rule)
+}
+
+// Update room rule godoc
+//
+//	@Summary		Update room rule
+//	@Description	Update room rule, inherited zone rules are changed on zone
+//	@Tags			Rule
+//	@Accept			json
+//	@Param			id	path		int	true	"Room ID"
+//	@Param			ruleID	path		int	true	"Rule ID"
+//	@Param			rule	body		schemas.RuleUpdateSchema true	"Update rule"
+//	@Success		204		{object}	nil
+//	@Router			/room/{id}/rules/{ruleID} [patch]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleUpdateRule(c *fiber.Ctx) error {
+  rule, ok, err := h.takeRoomRule(c)
+  if !ok {
+    return err
+  }
+  var schema schemas.RuleUpdateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  if err := h.ruleService.Update(rule.ID, schema); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Delete room rule godoc
+//
+//	@Summary		Delete room rule
+//	@Description	Delete room rule, zone rule it overrides becomes effective again
+//	@Tags			Rule
+//	@Param			id	path		int	true	"Room ID"
+//	@Param			ruleID	path		int	true	"Rule ID"
+//	@Success		204		{object}	nil
+//	@Router			/room/{id}/rules/{ruleID} [delete]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleDeleteRule(c *fiber.Ctx) error {
+  rule, ok, err := h.takeRoomRule(c)
+  if !ok {
+    return err
+  }
+
+  if err := h.ruleService.Delete(rule.ID); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Find room rule triggers godoc
+//
+//	@Summary		Find room rule triggers
+//	@Description	Find rule triggers of room sensors, newest first
+//	@Tags			Rule
+//	@Produce		json
+//	@Param			id	path		int	true	"Room ID"
+//	@Success		200		{array}	schemas.RuleTriggerSchema
+//	@Router			/room/{id}/rules/triggers [get]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleFindRuleTriggers(c *fiber.Ctx) error {
+  roomID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  room := h.roomService.Take(uint(roomID))
+  if room.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  triggers, err := h.ruleService.FindTriggers(room.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(triggers)
+}
+
+// takeRoomRule takes rule by path params and checks that it is own rule of
+// room owned by user. When ok is false response is already written.
+func (h roomHandler) takeRoomRule(c *fiber.Ctx) (schemas.RuleSchema, bool, error) {
+  roomID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.RuleSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  ruleID, err := strconv.Atoi(c.Params("ruleID"))
+  if err != nil {
+    return schemas.RuleSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  room := h.roomService.Take(uint(roomID))
+  if room.OwnerID != h.authService.CurrentUserID(c) {
+    return schemas.RuleSchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+  }
+  rule, err := h.ruleService.Take(uint(ruleID))
+  if err != nil || rule.RoomID == nil || *rule.RoomID != room.ID {
+    return schemas.RuleSchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": "Rule not found"})
+  }
+  return rule, true, nil
+}
+
+// Find zone rules godoc
+//
+//	@Summary		Find zone rules
+//	@Description	Find zone rules, they are inherited by every zone room
+//	@Tags			Rule
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Success		200		{array}	schemas.RuleSchema
+//	@Router			/zone/{id}/rules [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleFindRules(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  rules, err := h.ruleService.FindForZone(zone.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(rules)
+}
+
+// Create zone rule godoc
+//
+//	@Summary		Create zone rule
+//	@Description	Create zone rule
+//	@Tags			Rule
+//	@Accept			json
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			rule	body		schemas.RuleCreateSchema true	"Create rule"
+//	@Success		201		{object}	schemas.RuleSchema
+//	@Router			/zone/{id}/rules [post]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleCreateRule(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.RuleCreateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  rule, err := h.ruleService.Create(zone.ID, nil, schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(rule)
+}
+
+// Update zone rule godoc
+//
+//	@Summary		Update zone rule
+//	@Description	Update zone rule
+//	@Tags			Rule
+//	@Accept			json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			ruleID	path		int	true	"Rule ID"
+//	@Param			rule	body		schemas.RuleUpdateSchema true	"Update rule"
+//	@Success		204		{object}	nil
+//	@Router			/zone/{id}/rules/{ruleID} [patch]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleUpdateRule(c *fiber.Ctx) error {
+  rule, ok, err := h.takeZoneRule(c)
+  if !ok {
+    return err
+  }
+  var schema schemas.RuleUpdateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  if err := h.ruleService.Update(rule.ID, schema); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Delete zone rule godoc
+//
+//	@Summary		Delete zone rule
+//	@Description	Delete zone rule
+//	@Tags			Rule
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			ruleID	path		int	true	"Rule ID"
+//	@Success		204		{object}	nil
+//	@Router			/zone/{id}/rules/{ruleID} [delete]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleDeleteRule(c *fiber.Ctx) error {
+  rule, ok, err := h.takeZoneRule(c)
+  if !ok {
+    return err
+  }
+
+  if err := h.ruleService.Delete(rule.ID); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// takeZoneRule takes rule by path params and checks that it is rule of zone
+// owned by user. When ok is false response is already written.
+func (h zoneHandler) takeZoneRule(c *fiber.Ctx) (schemas.RuleSchema, bool, error) {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.RuleSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  ruleID, err := strconv.Atoi(c.Params("ruleID"))
+  if err != nil {
+    return schemas.RuleSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) {
+    return schemas.RuleSchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+  }
+  rule, err := h.ruleService.Take(uint(ruleID))
+  if err != nil || rule.RoomID != nil || rule.ZoneID != zone.ID {
+    return schemas.RuleSchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": "Rule not found"})
+  }
+  return rule, true, nil
+}
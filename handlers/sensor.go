@@ -1,6 +1,7 @@
 package handlers
 
 import (
+  "errors"
   "strconv"
 
   "antivape/services"
@@ -17,6 +18,12 @@ type SensorHandler interface {
 type sensorHandler struct {
   sensorService services.SensorService
   authService services.AuthService
+  credentialService services.CredentialService
+  healthService services.HealthService
+  batteryService services.BatteryService
+  seriesService services.SeriesService
+  readingService services.ReadingService
+  retentionService services.RetentionService
 }
 
 // Create sensor godoc
@@ -28,6 +35,8 @@ type sensorHandler struct {
 //	@Produce		json
 //	@Param			account	body		schemas.SensorCreateSchema true	"Create sensor"
 //	@Success		200		{object}	schemas.SensorSchema
+//	@Failure		404		{object}	nil
+//	@Failure		503		{object}	nil
 //	@Router			/sensor [post]
 //	@Security ApiKeyAuth
 func (h sensorHandler) handleCreate(c *fiber.Ctx) error {
@@ -40,7 +49,12 @@ func (h sensorHandler) handleCreate(c *fiber.Ctx) error {
     return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
   }
 
-  resp := h.sensorService.Create(schema)
+  resp, err := h.sensorService.Create(schema)
+  if errors.Is(err, services.ErrRoomNotFound) {
+    return c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
   return c.JSON(resp)
 }
 
@@ -111,6 +125,9 @@ func (h sensorHandler) handleUpdate(c *fiber.Ctx) error {
   if err := c.BodyParser(&schema); err != nil {
     return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
   }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
 
   sensor := h.sensorService.Take(uint(sensorID))
   if sensor.OwnerID != h.authService.CurrentUserID(c) {
@@ -145,16 +162,190 @@ func (h sensorHandler) handleDelete(c *fiber.Ctx) error {
   return nil
 }
 
+// Get sensor credentials godoc
+//
+//	@Summary		Get sensor credentials
+//	@Description	Get sensor credentials status, secret is never returned
+//	@Tags			Sensor
+//	@Produce		json
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Success		200		{object}	schemas.SensorCredentialSchema
+//	@Router			/sensor/{id}/credentials [get]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleTakeCredentials(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  credential, err := h.credentialService.Take(uint(sensorID))
+  if err != nil {
+    return c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(credential)
+}
+
+// Rotate sensor credentials godoc
+//
+//	@Summary		Rotate sensor credentials
+//	@Description	Issue new sensor secret and revoke previous ones. Secret is returned only once
+//	@Tags			Sensor
+//	@Produce		json
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Success		201		{object}	schemas.SensorCredentialSchema
+//	@Router			/sensor/{id}/credentials [post]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleRotateCredentials(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  credential, err := h.credentialService.Issue(uint(sensorID))
+  if err != nil {
+    return c.Status(500).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(credential)
+}
+
+// Revoke sensor credentials godoc
+//
+//	@Summary		Revoke sensor credentials
+//	@Description	Revoke sensor secret, sensor can't send data until credentials are rotated
+//	@Tags			Sensor
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Success		204		{object}	nil
+//	@Router			/sensor/{id}/credentials [delete]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleRevokeCredentials(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  if err := h.credentialService.Revoke(uint(sensorID)); err != nil {
+    return c.Status(500).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Find sensor events godoc
+//
+//	@Summary		Find sensor events
+//	@Description	Last 100 offline, flatline and battery_drop events of sensor, newest first
+//	@Tags			Sensor
+//	@Produce		json
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Success		200		{array}	schemas.DeviceEventSchema
+//	@Router			/sensor/{id}/events [get]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleFindEvents(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  events, err := h.healthService.FindEvents(uint(sensorID))
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(events)
+}
+
+// Get sensor statistic godoc
+//
+//	@Summary		Get sensor statistic
+//	@Description	Get statistic of single sensor readings
+//	@Tags			Sensor
+//	@Produce		json
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 30m, 1h or 7d, instead of from and to"
+//	@Param			aggregates	query		string	false	"Comma separated avg, min, max, median, p95, stddev, count, time_above"
+//	@Param			co2_above	query		number	false	"CO2 threshold of time_above"
+//	@Param			tvoc_above	query		number	false	"TVOC threshold of time_above"
+//	@Param			weighting	query		string	false	"sample or time"
+//	@Success		200		{object}	schemas.SensorDataSensorSchema
+//	@Router			/sensor/{id}/statistic [get]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleStatistic(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.StatisticFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  if ok, err := checkRetention(c, h.retentionService, sensor.ZoneID, schema); !ok {
+    return err
+  }
+  statistic := h.sensorService.GetStatistic(uint(sensorID), schema)
+  return c.JSON(statistic)
+}
+
 func (h sensorHandler) Register(app *fiber.App) {
   router := app.Group("/sensor", middlewares.Protected(), logger.New())
 
   router.Post("/", h.handleCreate)
   router.Get("/:id<int>/", h.handleTake)
+  router.Get("/:id<int>/credentials", h.handleTakeCredentials)
+  router.Post("/:id<int>/credentials", h.handleRotateCredentials)
+  router.Delete("/:id<int>/credentials", h.handleRevokeCredentials)
+  router.Get("/:id<int>/events", h.handleFindEvents)
+  router.Get("/:id<int>/battery", h.handleTakeBattery)
+  router.Get("/:id<int>/series", h.handleSeries)
+  router.Get("/:id<int>/statistic", h.handleStatistic)
+  router.Get("/:id<int>/latest", h.handleTakeLatest)
   router.Get("/", h.handleFind)
   router.Patch("/:id", h.handleUpdate)
   router.Delete("/:id", h.handleDelete)
 }
 
-func NewSensorHandler(sensorService services.SensorService, authService services.AuthService) SensorHandler {
-  return sensorHandler{sensorService: sensorService, authService: authService}
+func NewSensorHandler(
+  sensorService services.SensorService,
+  authService services.AuthService,
+  credentialService services.CredentialService,
+  healthService services.HealthService,
+  batteryService services.BatteryService,
+  seriesService services.SeriesService,
+  readingService services.ReadingService,
+  retentionService services.RetentionService,
+) SensorHandler {
+  return sensorHandler{
+    sensorService: sensorService,
+    authService: authService,
+    credentialService: credentialService,
+    healthService: healthService,
+    batteryService: batteryService,
+    seriesService: seriesService,
+    readingService: readingService,
+    retentionService: retentionService,
+  }
 }
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+  "errors"
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Get room series godoc
+//
+//	@Summary		Get room series
+//	@Description	CO2 and TVOC avg, min, max and count per time bucket, one series for room or per its sensor
+//	@Tags			Room
+//	@Produce		json
+//	@Param			id	path		int	true	"Room ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 30m, 1h or 7d, instead of from and to"
+//	@Param			bucket	query		string	false	"1m, 5m, 1h or 1d"
+//	@Param			fill	query		bool	false	"Add empty buckets"
+//	@Param			granularity	query		string	false	"room or sensor"
+//	@Success		200		{array}	schemas.SeriesSchema
+//	@Router			/room/{id}/series [get]
+//	@Security ApiKeyAuth
+func (h roomHandler) handleSeries(c *fiber.Ctx) error {
+  roomID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.SeriesFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  room := h.roomService.Take(uint(roomID))
+  if room.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  series, err := h.seriesService.FindRoomSeries(uint(roomID), schema)
+  if errors.Is(err, services.ErrTooManyBuckets) {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(series)
+}
+
+// Get sensor series godoc
+//
+//	@Summary		Get sensor series
+//	@Description	CO2 and TVOC avg, min, max and count per time bucket of sensor
+//	@Tags			Sensor
+//	@Produce		json
+//	@Param			id	path		int	true	"Sensor ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 30m, 1h or 7d, instead of from and to"
+//	@Param			bucket	query		string	false	"1m, 5m, 1h or 1d"
+//	@Param			fill	query		bool	false	"Add empty buckets"
+//	@Success		200		{array}	schemas.SeriesSchema
+//	@Router			/sensor/{id}/series [get]
+//	@Security ApiKeyAuth
+func (h sensorHandler) handleSeries(c *fiber.Ctx) error {
+  sensorID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.SeriesFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  sensor := h.sensorService.Take(uint(sensorID))
+  if sensor.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  series, err := h.seriesService.FindSensorSeries(uint(sensorID), schema)
+  if errors.Is(err, services.ErrTooManyBuckets) {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(series)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+  "errors"
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type SubscriptionHandler interface {
+  Register(app *fiber.App)
+}
+
+type subscriptionHandler struct {
+  notificationService services.NotificationService
+  roomService services.RoomService
+  zoneService services.ZoneService
+  authService services.AuthService
+}
+
+// Create subscription godoc
+//
+//	@Summary		Create subscription
+//	@Description	Subscribe to events of owned zone or room by email or sms
+//	@Tags			Subscription
+//	@Accept			json
+//	@Produce		json
+//	@Param			subscription	body		schemas.SubscriptionCreateSchema true	"Create subscription"
+//	@Success		201		{object}	schemas.SubscriptionSchema
+//	@Router			/subscription [post]
+//	@Security ApiKeyAuth
+func (h subscriptionHandler) handleCreate(c *fiber.Ctx) error {
+  var schema schemas.SubscriptionCreateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  userID := h.authService.CurrentUserID(c)
+  owner := h.authService.IsSuperuser(c) || h.zoneService.Take(schema.ZoneID).OwnerID == userID
+  if schema.RoomID != nil {
+    room := h.roomService.Take(*schema.RoomID)
+    if room.ZoneID != schema.ZoneID {
+      return c.Status(422).JSON(fiber.Map{"status": "error", "data": "Room is not in zone"})
+    }
+    owner = owner || room.OwnerID == userID
+  }
+  if !owner {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+
+  subscription, err := h.notificationService.Create(userID, schema)
+  if errors.Is(err, services.ErrChannelUnavailable) {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  } else if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(subscription)
+}
+
+// Find subscriptions godoc
+//
+//	@Summary		Find subscriptions
+//	@Description	Find subscriptions of user
+//	@Tags			Subscription
+//	@Produce		json
+//	@Success		200		{array}	schemas.SubscriptionSchema
+//	@Router			/subscription/ [get]
+//	@Security ApiKeyAuth
+func (h subscriptionHandler) handleFind(c *fiber.Ctx) error {
+  subscriptions, err := h.notificationService.Find(h.authService.CurrentUserID(c))
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(subscriptions)
+}
+
+// Update subscription godoc
+//
+//	@Summary		Update subscription
+//	@Description	Update subscription
+//	@Tags			Subscription
+//	@Accept			json
+//	@Param			id	path		int	true	"Subscription ID"
+//	@Param			subscription	body		schemas.SubscriptionUpdateSchema true	"Update subscription"
+//	@Success		204		{object}	nil
+//	@Router			/subscription/{id} [patch]
+//	@Security ApiKeyAuth
+func (h subscriptionHandler) handleUpdate(c *fiber.Ctx) error {
+  subscription, ok, err := h.takeSubscription(c)
+  if !ok {
+    return err
+  }
+  var schema schemas.SubscriptionUpdateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  if err := h.notificationService.Update(subscription.ID, schema); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Delete subscription godoc
+//
+//	@Summary		Delete subscription
+//	@Description	Delete subscription, its pending notifications are failed
+//	@Tags			Subscription
+//	@Param			id	path		int	true	"Subscription ID"
+//	@Success		204		{object}	nil
+//	@Router			/subscription/{id} [delete]
+//	@Security ApiKeyAuth
+func (h subscriptionHandler) handleDelete(c *fiber.Ctx) error {
+  subscription, ok, err := h.takeSubscription(c)
+  if !ok {
+    return err
+  }
+
+  if err := h.notificationService.Delete(subscription.ID); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Find subscription notifications godoc
+//
+//	@Summary		Find subscription notifications
+//	@Description	Last 100 notifications of subscription with delivery status, newest first
+//	@Tags			Subscription
+//	@Produce		json
+//	@Param			id	path		int	true	"Subscription ID"
+//	@Success		200		{array}	schemas.NotificationSchema
+//	@Router			/subscription/{id}/notifications [get]
+//	@Security ApiKeyAuth
+func (h subscriptionHandler) handleFindNotifications(c *fiber.Ctx) error {
+  subscription, ok, err := h.takeSubscription(c)
+  if !ok {
+    return err
+  }
+
+  notifications, err := h.notificationService.FindNotifications(subscription.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(notifications)
+}
+
+// takeSubscription takes subscription of user by path param. When ok is
+// false response is already written.
+func (h subscriptionHandler) takeSubscription(c *fiber.Ctx) (schemas.SubscriptionSchema, bool, error) {
+  subscriptionID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.SubscriptionSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  subscription, err := h.notificationService.Take(uint(subscriptionID))
+  if err != nil {
+    return schemas.SubscriptionSchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  if subscription.UserID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return schemas.SubscriptionSchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+  }
+  return subscription, true, nil
+}
+
+func (h subscriptionHandler) Register(app *fiber.App) {
+  router := app.Group("/subscription", middlewares.Protected(), logger.New())
+
+  router.Post("/", h.handleCreate)
+  router.Get("/", h.handleFind)
+  router.Patch("/:id<int>", h.handleUpdate)
+  router.Delete("/:id<int>", h.handleDelete)
+  router.Get("/:id<int>/notifications", h.handleFindNotifications)
+}
+
+func NewSubscriptionHandler(
+  notificationService services.NotificationService,
+  roomService services.RoomService,
+  zoneService services.ZoneService,
+  authService services.AuthService,
+) SubscriptionHandler {
+  return subscriptionHandler{
+    notificationService: notificationService,
+    roomService: roomService,
+    zoneService: zoneService,
+    authService: authService,
+  }
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+  "strconv"
+
+  "antivape/services"
+  "antivape/schemas"
+  "antivape/middlewares"
+	"github.com/gofiber/fiber/v2"
+  "github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+type WebhookHandler interface {
+  Register(app *fiber.App)
+}
+
+type webhookHandler struct {
+  webhookService services.WebhookService
+  zoneService services.ZoneService
+  authService services.AuthService
+}
+
+// Create webhook godoc
+//
+//	@Summary		Create webhook
+//	@Description	Register zone webhook. Events are posted as JSON with X-Webhook-Delivery, X-Webhook-Event,
+//	@Description	X-Webhook-Timestamp and X-Webhook-Signature (hex HMAC-SHA256 of timestamp.body with webhook secret) headers.
+//	@Description	Secret is returned only in this response.
+//	@Tags			Webhook
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook	body		schemas.WebhookCreateSchema true	"Create webhook"
+//	@Success		201		{object}	schemas.WebhookSchema
+//	@Router			/webhook [post]
+//	@Security ApiKeyAuth
+func (h webhookHandler) handleCreate(c *fiber.Ctx) error {
+  var schema schemas.WebhookCreateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  zone := h.zoneService.Take(schema.ZoneID)
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  webhook, err := h.webhookService.Create(schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.Status(201).JSON(webhook)
+}
+
+// Find webhooks godoc
+//
+//	@Summary		Find webhooks
+//	@Description	Find webhooks of zones owned by user
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			q	query		schemas.WebhookFindSchema false	"find filters"
+//	@Success		200		{array}	schemas.WebhookSchema
+//	@Router			/webhook/ [get]
+//	@Security ApiKeyAuth
+func (h webhookHandler) handleFind(c *fiber.Ctx) error {
+  var schema schemas.WebhookFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  webhooks, err := h.webhookService.Find(h.authService.CurrentUserID(c), h.authService.IsSuperuser(c), schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(webhooks)
+}
+
+// Get webhook godoc
+//
+//	@Summary		Get webhook
+//	@Description	Get webhook
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200		{object}	schemas.WebhookSchema
+//	@Router			/webhook/{id} [get]
+//	@Security ApiKeyAuth
+func (h webhookHandler) handleTake(c *fiber.Ctx) error {
+  webhook, ok, err := h.takeWebhook(c)
+  if !ok {
+    return err
+  }
+  return c.JSON(webhook)
+}
+
+// Update webhook godoc
+//
+//	@Summary		Update webhook
+//	@Description	Update webhook
+//	@Tags			Webhook
+//	@Accept			json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Param			webhook	body		schemas.WebhookUpdateSchema true	"Update webhook"
+//	@Success		204		{object}	nil
+//	@Router			/webhook/{id} [patch]
+//	@Security ApiKeyAuth
+func (h webhookHandler) handleUpdate(c *fiber.Ctx) error {
+  webhook, ok, err := h.takeWebhook(c)
+  if !ok {
+    return err
+  }
+  var schema schemas.WebhookUpdateSchema
+  if err := c.BodyParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  if err := h.webhookService.Update(webhook.ID, schema); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Delete webhook godoc
+//
+//	@Summary		Delete webhook
+//	@Description	Delete webhook, its pending deliveries are failed
+//	@Tags			Webhook
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		204		{object}	nil
+//	@Router			/webhook/{id} [delete]
+//	@Security ApiKeyAuth
+func (h webhookHandler) handleDelete(c *fiber.Ctx) error {
+  webhook, ok, err := h.takeWebhook(c)
+  if !ok {
+    return err
+  }
+
+  if err := h.webhookService.Delete(webhook.ID); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  c.Status(204)
+  return nil
+}
+
+// Find webhook deliveries godoc
+//
+//	@Summary		Find webhook deliveries
+//	@Description	Last 100 deliveries of webhook, newest first
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200		{array}	schemas.WebhookDeliverySchema
+//	@Router			/webhook/{id}/deliveries [get]
+//	@Security ApiKeyAuth
+func (h webhookHandler) handleFindDeliveries(c *fiber.Ctx) error {
+  webhook, ok, err := h.takeWebhook(c)
+  if !ok {
+    return err
+  }
+
+  deliveries, err := h.webhookService.FindDeliveries(webhook.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(deliveries)
+}
+
+// Send test event godoc
+//
+//	@Summary		Send test event
+//	@Description	Send test event to webhook once and return delivery result
+//	@Tags			Webhook
+//	@Produce		json
+//	@Param			id	path		int	true	"Webhook ID"
+//	@Success		200		{object}	schemas.WebhookDeliverySchema
+//	@Router			/webhook/{id}/test [post]
+//	@Security ApiKeyAuth
+func (h webhookHandler) handleTest(c *fiber.Ctx) error {
+  webhook, ok, err := h.takeWebhook(c)
+  if !ok {
+    return err
+  }
+
+  delivery, err := h.webhookService.SendTest(webhook.ID)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(delivery)
+}
+
+// takeWebhook takes webhook by path param and checks that user owns its
+// zone. When ok is false response is already written.
+func (h webhookHandler) takeWebhook(c *fiber.Ctx) (schemas.WebhookSchema, bool, error) {
+  webhookID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return schemas.WebhookSchema{}, false, c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  webhook, err := h.webhookService.Take(uint(webhookID))
+  if err != nil {
+    return schemas.WebhookSchema{}, false, c.Status(404).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  zone := h.zoneService.Take(webhook.ZoneID)
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return schemas.WebhookSchema{}, false, c.Status(401).SendString("Not enough rights for this request")
+  }
+  return webhook, true, nil
+}
+
+func (h webhookHandler) Register(app *fiber.App) {
+  router := app.Group("/webhook", middlewares.Protected(), logger.New())
+
+  router.Post("/", h.handleCreate)
+  router.Get("/", h.handleFind)
+  router.Get("/:id<int>", h.handleTake)
+  router.Patch("/:id<int>", h.handleUpdate)
+  router.Delete("/:id<int>", h.handleDelete)
+  router.Get("/:id<int>/deliveries", h.handleFindDeliveries)
+  router.Post("/:id<int>/test", h.handleTest)
+}
+
+func NewWebhookHandler(webhookService services.WebhookService, zoneService services.ZoneService, authService services.AuthService) WebhookHandler {
+  return webhookHandler{webhookService: webhookService, zoneService: zoneService, authService: authService}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
   "strconv"
+  "time"
 
   "antivape/services"
   "antivape/schemas"
@@ -17,6 +18,13 @@ type ZoneHandler interface {
 type zoneHandler struct {
   zoneService services.ZoneService
   authService services.AuthService
+  ruleService services.RuleService
+  policyService services.PolicyService
+  healthService services.HealthService
+  batteryService services.BatteryService
+  retentionService services.RetentionService
+  readingService services.ReadingService
+  airQualityService services.AirQualityService
 }
 
 // Create zone godoc
@@ -126,6 +134,7 @@ func (h zoneHandler) handleUpdate(c *fiber.Ctx) error {
 //	@Tags			Zone
 //	@Param			id		path		int					true	"Zone ID"
 //	@Success		204		{object}	nil
+//	@Failure		503		{object}	nil
 //	@Router			/zone/{id} [delete]
 //	@Security ApiKeyAuth
 func (h zoneHandler) handleDelete(c *fiber.Ctx) error {
@@ -138,7 +147,9 @@ func (h zoneHandler) handleDelete(c *fiber.Ctx) error {
   if zone.OwnerID != h.authService.CurrentUserID(c) {
     return c.Status(401).SendString("Not enough rights for this request")
   }
-  h.zoneService.Delete(uint(zoneID))
+  if err := h.zoneService.Delete(uint(zoneID)); err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
   c.Status(204)
   return nil
 }
@@ -150,6 +161,13 @@ func (h zoneHandler) handleDelete(c *fiber.Ctx) error {
 //	@Tags			Zone
 //	@Produce		json
 //	@Param			id	path		int	true	"Zone ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 30m, 1h or 7d, instead of from and to"
+//	@Param			aggregates	query		string	false	"Comma separated avg, min, max, median, p95, stddev, count, time_above"
+//	@Param			co2_above	query		number	false	"CO2 threshold of time_above"
+//	@Param			tvoc_above	query		number	false	"TVOC threshold of time_above"
+//	@Param			weighting	query		string	false	"sample or time"
 //	@Success		200		{object}	schemas.SensorDataZoneSchema
 //	@Router			/zone/{id}/statistic [get]
 //	@Security ApiKeyAuth
@@ -158,26 +176,146 @@ func (h zoneHandler) handleStatistic(c *fiber.Ctx) error {
   if err != nil {
     return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
   }
+  var schema schemas.StatisticFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
 
   zone := h.zoneService.Take(uint(zoneID))
-  if zone.OwnerID != h.authService.CurrentUserID(c) {
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
     return c.Status(401).SendString("Not enough rights for this request")
   }
-  statistic := h.zoneService.GetStatistic(uint(zoneID))
+  if ok, err := checkRetention(c, h.retentionService, zone.ID, schema); !ok {
+    return err
+  }
+  statistic := h.zoneService.GetStatistic(uint(zoneID), schema)
   return c.JSON(statistic)
 }
 
+// Compare zone statistic godoc
+//
+//	@Summary		Compare zone statistic
+//	@Description	Statistic of zone rooms in interval and previous interval side by side with deltas, rooms are ranked by change of rank metric
+//	@Tags			Zone
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Param			from	query		string	false	"RFC3339, readings measured since"
+//	@Param			to	query		string	false	"RFC3339, readings measured before"
+//	@Param			last	query		string	false	"Interval before now like 1h or 30d, instead of from and to"
+//	@Param			previous_from	query		string	false	"RFC3339, previous interval start, interval of the same length before from by default"
+//	@Param			previous_to	query		string	false	"RFC3339, previous interval end"
+//	@Param			aggregates	query		string	false	"Comma separated avg, min, max, median, p95, stddev, count, time_above"
+//	@Param			co2_above	query		number	false	"CO2 threshold of time_above"
+//	@Param			tvoc_above	query		number	false	"TVOC threshold of time_above"
+//	@Param			weighting	query		string	false	"sample or time"
+//	@Param			rank	query		string	false	"Metric rooms are ranked by change of: co2, tvoc (default) or episodes"
+//	@Success		200		{object}	schemas.ZoneComparisonSchema
+//	@Router			/zone/{id}/compare [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleCompare(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  var schema schemas.ComparisonFindSchema
+  if err := c.QueryParser(&schema); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+  if err := schema.Validate(); err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  window, _ := schema.Windows(time.Now())
+  if ok, err := checkRetention(c, h.retentionService, zone.ID, schema.Statistic(window.From, window.To), schema.Statistic(window.PreviousFrom, window.PreviousTo)); !ok {
+    return err
+  }
+  comparison, err := h.zoneService.Compare(uint(zoneID), schema)
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(comparison)
+}
+
+// Get zone health godoc
+//
+//	@Summary		Get zone device health
+//	@Description	Status, last seen time, battery and unfinished offline and flatline events of every zone sensor
+//	@Tags			Zone
+//	@Produce		json
+//	@Param			id	path		int	true	"Zone ID"
+//	@Success		200		{array}	schemas.SensorHealthSchema
+//	@Router			/zone/{id}/health [get]
+//	@Security ApiKeyAuth
+func (h zoneHandler) handleHealth(c *fiber.Ctx) error {
+  zoneID, err := strconv.Atoi(c.Params("id"))
+  if err != nil {
+    return c.Status(422).JSON(fiber.Map{"status": "error", "data": err})
+  }
+
+  zone := h.zoneService.Take(uint(zoneID))
+  if zone.OwnerID != h.authService.CurrentUserID(c) && !h.authService.IsSuperuser(c) {
+    return c.Status(401).SendString("Not enough rights for this request")
+  }
+  health, err := h.healthService.FindZoneHealth(uint(zoneID))
+  if err != nil {
+    return c.Status(503).JSON(fiber.Map{"status": "error", "data": err.Error()})
+  }
+  return c.JSON(health)
+}
+
 func (h zoneHandler) Register(app *fiber.App) {
   router := app.Group("/zone", middlewares.Protected(), logger.New())
 
   router.Post("/", h.handleCreate)
   router.Get("/:id<int>/", h.handleTake)
   router.Get("/:id<int>/statistic", h.handleStatistic)
+  router.Get("/:id<int>/compare", h.handleCompare)
+  router.Get("/:id<int>/health", h.handleHealth)
+  router.Get("/:id<int>/batteries", h.handleFindBatteries)
+  router.Get("/:id<int>/latest", h.handleFindLatest)
+  router.Get("/:id<int>/bands", h.handleFindBands)
   router.Get("/", h.handleFind)
   router.Patch("/:id<int>", h.handleUpdate)
   router.Delete("/:id<int>", h.handleDelete)
+  router.Get("/:id<int>/rules", h.handleFindRules)
+  router.Post("/:id<int>/rules", h.handleCreateRule)
+  router.Patch("/:id<int>/rules/:ruleID<int>", h.handleUpdateRule)
+  router.Delete("/:id<int>/rules/:ruleID<int>", h.handleDeleteRule)
+  router.Get("/:id<int>/policy", h.handleTakePolicy)
+  router.Put("/:id<int>/policy", h.handlePutPolicy)
+  router.Delete("/:id<int>/policy", h.handleDeletePolicy)
+  router.Get("/:id<int>/retention", h.handleTakeRetention)
+  router.Put("/:id<int>/retention", h.handlePutRetention)
+  router.Delete("/:id<int>/retention", h.handleDeleteRetention)
 }
 
-func NewZoneHandler(zoneService services.ZoneService, authService services.AuthService) ZoneHandler {
-  return zoneHandler{zoneService: zoneService, authService: authService}
+func NewZoneHandler(
+  zoneService services.ZoneService,
+  authService services.AuthService,
+  ruleService services.RuleService,
+  policyService services.PolicyService,
+  healthService services.HealthService,
+  batteryService services.BatteryService,
+  retentionService services.RetentionService,
+  readingService services.ReadingService,
+  airQualityService services.AirQualityService,
+) ZoneHandler {
+  return zoneHandler{
+    zoneService: zoneService,
+    authService: authService,
+    ruleService: ruleService,
+    policyService: policyService,
+    healthService: healthService,
+    batteryService: batteryService,
+    retentionService: retentionService,
+    readingService: readingService,
+    airQualityService: airQualityService,
+  }
 }
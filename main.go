@@ -3,45 +3,172 @@ package main
 import (
   "log"
   "os"
+  "time"
 
+  "antivape/buffers"
+  "antivape/config"
   "antivape/db"
   "antivape/services"
   "antivape/repositories"
   "antivape/handlers"
+  "antivape/notifiers"
+  "antivape/schemas"
   _ "antivape/docs"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
   "github.com/gofiber/swagger"
 )
 
-func InitApp() *fiber.App {
+// databaseConfig returns DSN of DB_CONFIG or of local database
+func databaseConfig() string {
   dsn := os.Getenv("DB_CONFIG")
   if len(dsn) == 0 {
     dsn = "host=localhost port=5432 user=postgres dbname=db password=postgres sslmode=disable"
   }
+  return dsn
+}
 
-  dbConnection, err := db.InitDatabase(dsn)
+func InitApp() *fiber.App {
+  dbConnection, err := db.InitDatabase(databaseConfig())
   if err != nil {
     log.Fatal(err)
   }
-  redisConnection := db.InitRedis()
+  var sensorDataBuffer buffers.SensorDataBuffer
+  if config.GetString("BUFFER_BACKEND", "redis") == "memory" {
+    sensorDataBuffer = buffers.NewMemoryBuffer(config.GetInt("BUFFER_CAPACITY", 100000))
+  } else {
+    sensorDataBuffer = buffers.NewRedisBuffer(db.InitRedis(), config.GetInt("BUFFER_CAPACITY", 1000000))
+  }
 
   userRepository := repositories.NewUserRepository(dbConnection)
   sensorDataRepository := repositories.NewSensorDataRepository(dbConnection)
+  quarantineRepository := repositories.NewQuarantineRepository(dbConnection)
+
+  airQualityConfig := services.AirQualityConfig{
+    Co2Bounds: config.GetFloats("AIR_QUALITY_CO2_BOUNDS", []float64{800, 1000, 1500, 2000}),
+    TvocBounds: config.GetFloats("AIR_QUALITY_TVOC_BOUNDS", []float64{65, 220, 660, 2200}),
+  }
+  if err := airQualityConfig.Validate(); err != nil {
+    log.Fatal(err)
+  }
+  airQuality := services.NewAirQuality(airQualityConfig)
 
-  sensorService := services.NewSensorService(dbConnection)
-  roomService := services.NewRoomService(dbConnection, sensorDataRepository)
+  credentialService := services.NewCredentialService(dbConnection, config.GetDuration("DEVICE_REPLAY_WINDOW", 5*time.Minute))
+  sensorService := services.NewSensorService(dbConnection, sensorDataRepository, airQuality)
+  roomService := services.NewRoomService(dbConnection, sensorDataRepository, airQuality)
   authService := services.NewAuthService(userRepository)
-  zoneService := services.NewZoneService(dbConnection, sensorDataRepository)
-  externalService := services.NewExternalService(redisConnection, dbConnection)
+  zoneService := services.NewZoneService(dbConnection, sensorDataRepository, airQuality)
+  measurementLimits := services.MeasurementLimits{
+    MaxFutureSkew: config.GetDuration("MEASUREMENT_MAX_FUTURE_SKEW", time.Minute),
+    MaxAge: config.GetDuration("MEASUREMENT_MAX_AGE", 7*24*time.Hour),
+  }
+  detector := services.NewVapeDetector(services.DetectionConfig{
+    BaselineWindow: config.GetDuration("DETECTION_BASELINE_WINDOW", 10*time.Minute),
+    MinRise: config.GetFloat("DETECTION_MIN_RISE", 250),
+    MinRiseRate: config.GetFloat("DETECTION_MIN_RISE_RATE", 2),
+    MinDuration: config.GetDuration("DETECTION_MIN_DURATION", 5*time.Second),
+    MaxDuration: config.GetDuration("DETECTION_MAX_DURATION", 30*time.Minute),
+    EndRatio: config.GetFloat("DETECTION_END_RATIO", 0.5),
+    MaxGap: config.GetDuration("DETECTION_MAX_GAP", 5*time.Minute),
+  })
+  eventBus := services.NewEventBus()
+  webhookService := services.NewWebhookService(dbConnection, services.WebhookConfig{
+    MaxAttempts: config.GetInt("WEBHOOK_MAX_ATTEMPTS", 8),
+    BackoffBase: config.GetDuration("WEBHOOK_BACKOFF_BASE", 30*time.Second),
+    BackoffMax: config.GetDuration("WEBHOOK_BACKOFF_MAX", time.Hour),
+    Timeout: config.GetDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+  })
+  channels := initNotifiers()
+  notificationService := services.NewNotificationService(dbConnection, channels, services.NotificationConfig{
+    MaxAttempts: config.GetInt("NOTIFICATION_MAX_ATTEMPTS", 5),
+    BackoffBase: config.GetDuration("NOTIFICATION_BACKOFF_BASE", time.Minute),
+    BackoffMax: config.GetDuration("NOTIFICATION_BACKOFF_MAX", 30*time.Minute),
+    Lease: config.GetDuration("NOTIFICATION_TIMEOUT", 10*time.Second) + 30*time.Second,
+  })
+  eventBus.Subscribe(webhookService)
+  eventBus.Subscribe(notificationService)
+  incidentService := services.NewIncidentService(dbConnection, eventBus)
+  policyService := services.NewPolicyService(dbConnection)
+  detectionService := services.NewDetectionService(dbConnection, detector, incidentService)
+  ruleService := services.NewRuleService(dbConnection, incidentService)
+  healthService := services.NewHealthService(dbConnection, services.HealthConfig{
+    Heartbeat: config.GetDuration("DEVICE_HEARTBEAT", 5*time.Minute),
+    FlatlineDuration: config.GetDuration("DEVICE_FLATLINE_DURATION", 2*time.Hour),
+    BatteryDrop: config.GetInt("DEVICE_BATTERY_DROP", 20),
+    BatteryDropWindow: config.GetDuration("DEVICE_BATTERY_DROP_WINDOW", time.Hour),
+    BatteryLow: config.GetInt("DEVICE_BATTERY_LOW", 20),
+  }, eventBus)
+  batteryService := services.NewBatteryService(dbConnection, services.BatteryConfig{
+    Window: config.GetDuration("BATTERY_FORECAST_WINDOW", 72*time.Hour),
+    LowLevel: config.GetInt("DEVICE_BATTERY_LOW", 20),
+  })
+  externalService := services.NewExternalService(
+    sensorDataBuffer,
+    dbConnection,
+    measurementLimits,
+    detectionService,
+    ruleService,
+    healthService,
+  )
+  seriesService := services.NewSeriesService(dbConnection, sensorDataRepository)
+  rollupService := services.NewRollupService(dbConnection)
+  readingService := services.NewReadingService(dbConnection, sensorDataBuffer, airQuality)
+  airQualityService := services.NewAirQualityService(dbConnection, sensorDataRepository, airQuality)
+  exportService := services.NewExportService(dbConnection)
+  reportService := services.NewReportService(
+    dbConnection,
+    sensorDataRepository,
+    airQuality,
+    airQualityService,
+    healthService,
+    batteryService,
+    channels[schemas.ChannelEmail],
+    services.ReportConfig{
+      MaxAttempts: config.GetInt("REPORT_MAIL_MAX_ATTEMPTS", 5),
+      BackoffBase: config.GetDuration("NOTIFICATION_BACKOFF_BASE", time.Minute),
+      BackoffMax: config.GetDuration("NOTIFICATION_BACKOFF_MAX", 30*time.Minute),
+      Lease: config.GetDuration("NOTIFICATION_TIMEOUT", 10*time.Second) + 5*time.Minute,
+    },
+  )
+  retentionService := services.NewRetentionService(dbConnection, schemas.RetentionSchema{
+    RawDays: config.GetInt("RETENTION_RAW_DAYS", 0),
+    MinuteDays: config.GetInt("RETENTION_MINUTE_DAYS", 0),
+    HourDays: config.GetInt("RETENTION_HOUR_DAYS", 0),
+    DayDays: config.GetInt("RETENTION_DAY_DAYS", 0),
+  })
   userService := services.NewUserService(dbConnection)
+  gatewayService := services.NewGatewayService(dbConnection)
+  quarantineService := services.NewQuarantineService(
+    dbConnection,
+    quarantineRepository,
+    sensorService,
+    config.GetDuration("QUARANTINE_RETENTION", 7*24*time.Hour),
+  )
+  quarantineQuota := services.NewQuarantineQuota(dbConnection, services.QuarantineLimits{
+    MaxReadings: config.GetInt("QUARANTINE_MAX_READINGS", 10000),
+    MaxDevices: config.GetInt("QUARANTINE_MAX_DEVICES", 1000),
+  })
 
   authHandler := handlers.NewAuthHandler(authService)
-  zoneHandler := handlers.NewZoneHandler(zoneService, authService)
-  sensorHandler := handlers.NewSensorHandler(sensorService, authService)
-  roomHandler := handlers.NewRoomHandler(roomService, authService)
-  externalHandler := handlers.NewExternalHandler(externalService)
+  zoneHandler := handlers.NewZoneHandler(zoneService, authService, ruleService, policyService, healthService, batteryService, retentionService, readingService, airQualityService)
+  sensorHandler := handlers.NewSensorHandler(sensorService, authService, credentialService, healthService, batteryService, seriesService, readingService, retentionService)
+  roomHandler := handlers.NewRoomHandler(roomService, authService, ruleService, seriesService, airQualityService, retentionService)
+  externalHandler := handlers.NewExternalHandler(
+    externalService,
+    credentialService,
+    quarantineQuota,
+    config.GetInt("QUARANTINE_RATE_LIMIT", 60),
+  )
   userHandler := handlers.NewUserHandler(userService, authService)
+  quarantineHandler := handlers.NewQuarantineHandler(quarantineService, roomService, authService)
+  episodeHandler := handlers.NewEpisodeHandler(detectionService, roomService, authService)
+  incidentHandler := handlers.NewIncidentHandler(incidentService, roomService, zoneService, authService)
+  gatewayHandler := handlers.NewGatewayHandler(gatewayService, zoneService, authService)
+  webhookHandler := handlers.NewWebhookHandler(webhookService, zoneService, authService)
+  subscriptionHandler := handlers.NewSubscriptionHandler(notificationService, roomService, zoneService, authService)
+  storageHandler := handlers.NewStorageHandler(retentionService, authService)
+  exportHandler := handlers.NewExportHandler(exportService, sensorService, roomService, zoneService, authService)
+  reportHandler := handlers.NewReportHandler(reportService, zoneService, authService)
 
   app := fiber.New()
   app.Get("/swagger/*", swagger.HandlerDefault) // default
@@ -51,12 +178,61 @@ func InitApp() *fiber.App {
   sensorHandler.Register(app)
   roomHandler.Register(app)
   userHandler.Register(app)
+  quarantineHandler.Register(app)
+  episodeHandler.Register(app)
+  incidentHandler.Register(app)
+  gatewayHandler.Register(app)
+  webhookHandler.Register(app)
+  subscriptionHandler.Register(app)
+  storageHandler.Register(app)
+  exportHandler.Register(app)
+  reportHandler.Register(app)
   externalHandler.Register(app)
   go externalService.RunTransferingCycle()
+  go quarantineService.RunPurgeCycle()
+  go credentialService.RunPurgeCycle()
+  go webhookService.RunDeliveryCycle()
+  go notificationService.RunNotificationCycle()
+  go incidentService.RunEscalationCycle()
+  go healthService.RunHealthCycle()
+  go rollupService.RunRollupCycle()
+  go retentionService.RunRetentionCycle()
+  go reportService.RunReportCycle()
 
   return app
 }
 
+// initNotifiers returns notifiers of channels configured by environment,
+// email needs SMTP_HOST and sms needs SMS_GATEWAY_URL
+func initNotifiers() map[string]notifiers.Notifier {
+  channels := make(map[string]notifiers.Notifier)
+  if host := config.GetString("SMTP_HOST", ""); len(host) > 0 {
+    channels[schemas.ChannelEmail] = notifiers.NewSMTPNotifier(notifiers.SMTPConfig{
+      Host: host,
+      Port: config.GetInt("SMTP_PORT", 25),
+      Username: config.GetString("SMTP_USERNAME", ""),
+      Password: config.GetString("SMTP_PASSWORD", ""),
+      From: config.GetString("SMTP_FROM", "antivape@localhost"),
+      Timeout: config.GetDuration("NOTIFICATION_TIMEOUT", 10*time.Second),
+    })
+  }
+  if url := config.GetString("SMS_GATEWAY_URL", ""); len(url) > 0 {
+    notifier, err := notifiers.NewGatewayNotifier(notifiers.GatewayConfig{
+      Url: url,
+      Method: config.GetString("SMS_GATEWAY_METHOD", "POST"),
+      Body: config.GetString("SMS_GATEWAY_BODY", `{"to": {{json .To}}, "text": {{json .Text}}}`),
+      ContentType: config.GetString("SMS_GATEWAY_CONTENT_TYPE", "application/json"),
+      Authorization: config.GetString("SMS_GATEWAY_AUTHORIZATION", ""),
+      Timeout: config.GetDuration("NOTIFICATION_TIMEOUT", 10*time.Second),
+    })
+    if err != nil {
+      log.Fatal("Invalid SMS gateway template: ", err)
+    }
+    channels[schemas.ChannelSms] = notifier
+  }
+  return channels
+}
+
 // @title AntiVape API in golang
 // @version 1.0
 // @description AntiVape API
@@ -66,6 +242,10 @@ func InitApp() *fiber.App {
 //	@securityDefinitions.apikey	ApiKeyAuth
 //	@in							header
 //	@name						Authorization
+//	@securityDefinitions.apikey	SensorSecret
+//	@in							header
+//	@name						Authorization
+//	@description				"Bearer <secret>" issued on sensor creation, or X-Timestamp and X-Signature headers
 func main() {
   app := InitApp()
   app.Listen(":8080")
@@ -9,12 +9,30 @@ import (
   "bufio"
   "io"
   "fmt"
+  "net"
+  "strings"
+  "sync/atomic"
+  "os"
   "strconv"
+  "time"
 
+  "antivape/buffers"
+  "antivape/notifiers"
+  models "antivape/db"
+  "antivape/schemas"
+  "antivape/services"
+  "antivape/repositories"
   "github.com/stretchr/testify/assert"
 	"github.com/gofiber/fiber/v2"
 )
 
+func init() {
+  // Tests don't need redis unless it is asked explicitly
+  if len(os.Getenv("BUFFER_BACKEND")) == 0 {
+    os.Setenv("BUFFER_BACKEND", "memory")
+  }
+}
+
 type testCase struct {
   description string
   route string
@@ -255,6 +273,10 @@ func TestSensor(t *testing.T) {
     "POST",
     sensor,
   }
+  missingRoomTest := testCase{"Test sensor create in missing room", "/sensor", 404, "POST", map[string]interface{}{"name": "Test sensor", "room_id": 999999999}}
+  resp := doRequest(t, app, missingRoomTest, token)
+  assert.Equalf(t, missingRoomTest.expectedCode, resp.StatusCode, missingRoomTest.description)
+
   sensor, err = doRequestReturningJson(app, createTest, token)
   sensor["id"] = strconv.Itoa(int(sensor["id"].(float64)))
   assert.NoError(t, err)
@@ -266,7 +288,7 @@ func TestSensor(t *testing.T) {
     "GET",
     nil,
   }
-  resp := doRequest(t, app, takeTest, token)
+  resp = doRequest(t, app, takeTest, token)
   respBody, _ := io.ReadAll(resp.Body)
   assert.Equalf(t, takeTest.expectedCode, resp.StatusCode, takeTest.description + " " + string(respBody))
 
@@ -280,3 +302,1167 @@ func TestSensor(t *testing.T) {
   resp = doRequest(t, app, deleteTest, token)
   assert.Equalf(t, deleteTest.expectedCode, resp.StatusCode, deleteTest.description)
 }
+
+func createSensor(app *fiber.App, name, guid string, ownerID uint, roomID uint, token string) (map[string]interface{}, error) {
+  sensor := make(map[string]interface{}, 4)
+  sensor["name"] = name
+  sensor["guid"] = guid
+  sensor["owner_id"] = ownerID
+  sensor["room_id"] = roomID
+  test := testCase{"sensor create", "/sensor", 200, "POST", sensor}
+  return doRequestReturningJson(app, test, token)
+}
+
+func deleteSensor(t *testing.T, app *fiber.App, sensor map[string]interface{}, token string) {
+  test := testCase{"sensor delete", "/sensor/" + strconv.Itoa(int(sensor["id"].(float64))), 204, "DELETE", nil}
+  resp := doRequest(t, app, test, token)
+  assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+}
+
+func TestExternalBatch(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+  room, err := createRoom(app, "room with batch sensor", 1, 1, token)
+  assert.NoError(t, err)
+  sensor, err := createSensor(app, "batch sensor", "batch-a", 1, uint(room["id"].(float64)), token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, sensor, token)
+
+  tests := []struct {
+    description string
+    contentType string
+    body string
+    expectedAccepted int
+    expectedRejected int
+  }{
+    {
+      "Test json batch",
+      "application/json",
+      `[{"guid": "batch-a", "co2": 400, "tvoc": 50, "batteryCharge": 90}, {"guid": "batch-a", "co2": -1, "tvoc": 50, "batteryCharge": 90}]`,
+      1,
+      1,
+    },
+    {
+      "Test late and future readings",
+      "application/json",
+      `[{"guid": "batch-a", "co2": 400, "tvoc": 50, "batteryCharge": 90, "measured_at": "` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"},` +
+      `{"guid": "batch-a", "co2": 400, "tvoc": 50, "batteryCharge": 90, "measured_at": "2100-01-01T00:00:00Z"},` +
+      `{"guid": "batch-a", "co2": 400, "tvoc": 50, "batteryCharge": 90, "measured_at": "2000-01-01T00:00:00Z"}]`,
+      1,
+      2,
+    },
+    {
+      "Test ndjson batch",
+      "application/x-ndjson",
+      "{\"guid\": \"batch-a\", \"co2\": 400, \"tvoc\": 50, \"batteryCharge\": 90}\n{\"guid\": \"batch-b\", \"co2\": 400}\nnot json\n",
+      1,
+      2,
+    },
+  }
+
+  for _, test := range tests {
+    req := httptest.NewRequest("POST", "/external/sensors_data/batch", bytes.NewBufferString(test.body))
+    req.Header.Set("Content-Type", test.contentType)
+    req.Header.Set("X-Sensor-Guid", "batch-a")
+    req.Header.Set("Authorization", "Bearer " + sensor["secret"].(string))
+    resp, err := app.Test(req, -1)
+    assert.NoError(t, err)
+    assert.Equalf(t, 200, resp.StatusCode, test.description)
+
+    var result map[string]interface{}
+    respBody, _ := io.ReadAll(resp.Body)
+    assert.NoError(t, json.Unmarshal(respBody, &result))
+    assert.Equalf(t, float64(test.expectedAccepted), result["accepted"], test.description)
+    assert.Equalf(t, float64(test.expectedRejected), result["rejected"], test.description)
+  }
+
+  // Gateway relays readings of every sensor of its zone in one batch
+  otherRoom, err := createRoom(app, "other room with batch sensor", 1, 1, token)
+  assert.NoError(t, err)
+  otherSensor, err := createSensor(app, "other batch sensor", "batch-b", 1, uint(otherRoom["id"].(float64)), token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, otherSensor, token)
+  foreignZone, err := createZone(app, "zone of foreign batch sensor", 1, token)
+  assert.NoError(t, err)
+  foreignRoom, err := createRoom(app, "room of foreign batch sensor", 1, uint(foreignZone["id"].(float64)), token)
+  assert.NoError(t, err)
+  foreignSensor, err := createSensor(app, "foreign batch sensor", "batch-c", 1, uint(foreignRoom["id"].(float64)), token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, foreignSensor, token)
+  gateway, err := doRequestReturningJson(app, testCase{"gateway create", "/gateway", 201, "POST", map[string]interface{}{"zone_id": 1, "name": "batch gateway"}}, token)
+  assert.NoError(t, err)
+  defer doRequest(t, app, testCase{"gateway delete", "/gateway/" + strconv.Itoa(int(gateway["id"].(float64))), 204, "DELETE", nil}, token)
+
+  body := `[{"guid": "batch-a", "co2": 400, "tvoc": 50, "batteryCharge": 90}, {"guid": "batch-b", "co2": 410, "tvoc": 60, "batteryCharge": 80}, {"guid": "batch-c", "co2": 420, "tvoc": 70, "batteryCharge": 70}]`
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  credentials := []map[string]string{
+    {"Authorization": "Bearer " + gateway["secret"].(string)},
+    {"X-Timestamp": timestamp, "X-Signature": services.Sign(gateway["secret"].(string), timestamp, []byte(body))},
+  }
+  for _, headers := range credentials {
+    req := httptest.NewRequest("POST", "/external/sensors_data/batch", bytes.NewBufferString(body))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Gateway-Guid", gateway["guid"].(string))
+    for name, value := range headers {
+      req.Header.Set(name, value)
+    }
+    resp, err := app.Test(req, -1)
+    assert.NoError(t, err)
+    assert.Equal(t, 200, resp.StatusCode)
+
+    var result schemas.ExternalBatchResultSchema
+    respBody, _ := io.ReadAll(resp.Body)
+    assert.NoError(t, json.Unmarshal(respBody, &result))
+    assert.Equal(t, 2, result.Accepted, "Readings of both zone sensors are accepted")
+    assert.Equal(t, 1, result.Rejected)
+    assert.True(t, result.Items[0].Accepted)
+    assert.True(t, result.Items[1].Accepted)
+    assert.NotEmpty(t, result.Items[2].Error, "Sensor of other zone is rejected")
+  }
+
+  req := httptest.NewRequest("POST", "/external/sensors_data/batch", bytes.NewBufferString(body))
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Gateway-Guid", gateway["guid"].(string))
+  req.Header.Set("Authorization", "Bearer wrong")
+  resp, err := app.Test(req, -1)
+  assert.NoError(t, err)
+  assert.Equal(t, 401, resp.StatusCode, "Wrong gateway secret")
+}
+
+func TestExternalCredentials(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+  room, err := createRoom(app, "room with signed sensor", 1, 1, token)
+  assert.NoError(t, err)
+  sensor, err := createSensor(app, "signed sensor", "signed-a", 1, uint(room["id"].(float64)), token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, sensor, token)
+  secret := sensor["secret"].(string)
+  body := `{"guid": "signed-a", "co2": 400, "tvoc": 50, "batteryCharge": 90}`
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  signature := services.Sign(secret, timestamp, []byte(body))
+  staleTimestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+  tests := []struct {
+    description string
+    headers map[string]string
+    expectedCode int
+  }{
+    {"Test missing credentials", map[string]string{"X-Sensor-Guid": "signed-a"}, 400},
+    {"Test wrong secret", map[string]string{"X-Sensor-Guid": "signed-a", "Authorization": "Bearer wrong"}, 401},
+    {"Test bearer secret", map[string]string{"X-Sensor-Guid": "signed-a", "Authorization": "Bearer " + secret}, 200},
+    {"Test signature", map[string]string{"X-Sensor-Guid": "signed-a", "X-Timestamp": timestamp, "X-Signature": signature}, 200},
+    {"Test replayed signature", map[string]string{"X-Sensor-Guid": "signed-a", "X-Timestamp": timestamp, "X-Signature": signature}, 401},
+    {
+      "Test stale signature",
+      map[string]string{"X-Sensor-Guid": "signed-a", "X-Timestamp": staleTimestamp, "X-Signature": services.Sign(secret, staleTimestamp, []byte(body))},
+      401,
+    },
+  }
+
+  for _, test := range tests {
+    req := httptest.NewRequest("POST", "/external/sensors_data", bytes.NewBufferString(body))
+    req.Header.Set("Content-Type", "application/json")
+    for key, value := range test.headers {
+      req.Header.Set(key, value)
+    }
+    resp, err := app.Test(req, -1)
+    assert.NoError(t, err)
+    assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+  }
+
+  // Used signatures are shared by app replicas
+  req := httptest.NewRequest("POST", "/external/sensors_data", bytes.NewBufferString(body))
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Sensor-Guid", "signed-a")
+  req.Header.Set("X-Timestamp", timestamp)
+  req.Header.Set("X-Signature", signature)
+  resp, err := InitApp().Test(req, -1)
+  assert.NoError(t, err)
+  assert.Equal(t, 401, resp.StatusCode, "Signature replayed on another replica")
+
+  revokeTest := testCase{"Test revoke credentials", "/sensor/" + strconv.Itoa(int(sensor["id"].(float64))) + "/credentials", 204, "DELETE", nil}
+  resp = doRequest(t, app, revokeTest, token)
+  assert.Equalf(t, revokeTest.expectedCode, resp.StatusCode, revokeTest.description)
+
+  req = httptest.NewRequest("POST", "/external/sensors_data", bytes.NewBufferString(body))
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Sensor-Guid", "signed-a")
+  req.Header.Set("Authorization", "Bearer " + secret)
+  resp, err = app.Test(req, -1)
+  assert.NoError(t, err)
+  assert.Equalf(t, 401, resp.StatusCode, "Test revoked secret")
+}
+
+func TestExternalMeasuredAt(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+  room, err := createRoom(app, "room with timestamped sensor", 1, 1, token)
+  assert.NoError(t, err)
+  sensor, err := createSensor(app, "timestamped sensor", "measured-a", 1, uint(room["id"].(float64)), token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, sensor, token)
+
+  now := time.Now()
+  tests := []struct {
+    description string
+    measuredAt string
+    expectedCode int
+  }{
+    {"Test reading without measured_at", "", 200},
+    {"Test reading within future skew", now.Add(30 * time.Second).Format(time.RFC3339), 200},
+    {"Test late reading", now.Add(-time.Hour).Format(time.RFC3339), 200},
+    {"Test future reading", now.Add(time.Hour).Format(time.RFC3339), 422},
+    {"Test stale reading", now.Add(-8 * 24 * time.Hour).Format(time.RFC3339), 422},
+  }
+
+  for _, test := range tests {
+    body := `{"guid": "measured-a", "co2": 400, "tvoc": 50, "batteryCharge": 90`
+    if len(test.measuredAt) > 0 {
+      body += `, "measured_at": "` + test.measuredAt + `"`
+    }
+    req := httptest.NewRequest("POST", "/external/sensors_data", bytes.NewBufferString(body + "}"))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Sensor-Guid", "measured-a")
+    req.Header.Set("Authorization", "Bearer " + sensor["secret"].(string))
+    resp, err := app.Test(req, -1)
+    assert.NoError(t, err)
+    assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+
+    if test.expectedCode == 200 && len(test.measuredAt) == 0 {
+      latestTest := testCase{"latest reading", "/sensor/" + strconv.Itoa(int(sensor["id"].(float64))) + "/latest", 200, "GET", nil}
+      latest, err := doRequestReturningJson(app, latestTest, token)
+      assert.NoError(t, err)
+      measuredAt, err := time.Parse(time.RFC3339, latest["measured_at"].(string))
+      assert.NoError(t, err)
+      assert.WithinDuration(t, time.Now(), measuredAt, 5 * time.Second, "Reading without measured_at gets server time")
+    }
+  }
+}
+
+func TestExternalRepeatedReading(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+  dbConnection, err := models.InitDatabase(databaseConfig())
+  assert.NoError(t, err)
+  room, err := createRoom(app, "room with repeating sensor", 1, 1, token)
+  assert.NoError(t, err)
+  sensor, err := createSensor(app, "repeating sensor", "repeated-a", 1, uint(room["id"].(float64)), token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, sensor, token)
+
+  store := func(measuredAt time.Time) {
+    body := `{"guid": "repeated-a", "co2": 400, "tvoc": 50, "batteryCharge": 90, "measured_at": "` + measuredAt.Format(time.RFC3339) + `"}`
+    req := httptest.NewRequest("POST", "/external/sensors_data", bytes.NewBufferString(body))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Sensor-Guid", "repeated-a")
+    req.Header.Set("Authorization", "Bearer " + sensor["secret"].(string))
+    resp, err := app.Test(req, -1)
+    assert.NoError(t, err)
+    assert.Equal(t, 200, resp.StatusCode)
+  }
+  // Reading sent again is stored once, like entry delivered again after failed ack
+  measuredAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+  store(measuredAt)
+  store(measuredAt)
+  store(measuredAt.Add(time.Second))
+
+  stored := func() int64 {
+    var count int64
+    dbConnection.Model(&models.SensorData{}).Where("guid = ? AND measured_at >= ?", "repeated-a", measuredAt).Count(&count)
+    return count
+  }
+  assert.Eventually(t, func() bool { return stored() >= 2 }, 10 * time.Second, 500 * time.Millisecond)
+  assert.Equal(t, int64(2), stored(), "Repeated reading is stored once")
+}
+
+// unclaimedGuids returns guids listed by GET /unclaimed
+func unclaimedGuids(t *testing.T, app *fiber.App, token string) map[string]float64 {
+  resp := doRequest(t, app, testCase{"unclaimed devices", "/unclaimed", 200, "GET", nil}, token)
+  var devices []map[string]interface{}
+  assert.NoError(t, json.NewDecoder(resp.Body).Decode(&devices))
+  guids := make(map[string]float64, len(devices))
+  for _, device := range devices {
+    guids[device["guid"].(string)] = device["readings"].(float64)
+  }
+  return guids
+}
+
+func TestQuarantine(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+  room, err := createRoom(app, "room with claimed sensors", 1, 1, token)
+  assert.NoError(t, err)
+  roomID := room["id"].(float64)
+
+  store := func(guid string) int {
+    req := httptest.NewRequest("POST", "/external/sensors_data", bytes.NewBufferString(`{"guid": "` + guid + `", "co2": 400, "tvoc": 50, "batteryCharge": 90}`))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Sensor-Guid", guid)
+    resp, err := app.Test(req, -1)
+    assert.NoError(t, err)
+    return resp.StatusCode
+  }
+  for _, guid := range []string{"quarantine-a", "quarantine-a", "quarantine-b", "quarantine-c"} {
+    assert.Equal(t, 200, store(guid), "Unknown guid is accepted without credentials")
+  }
+  assert.Eventually(t, func() bool {
+    guids := unclaimedGuids(t, app, token)
+    return guids["quarantine-a"] == 2 && guids["quarantine-b"] == 1 && guids["quarantine-c"] == 1
+  }, 10 * time.Second, 500 * time.Millisecond, "Readings of unknown guids are quarantined")
+
+  samples := func(sensor map[string]interface{}) float64 {
+    test := testCase{"sensor statistic", "/sensor/" + strconv.Itoa(int(sensor["id"].(float64))) + "/statistic?last=1h", 200, "GET", nil}
+    statistic, err := doRequestReturningJson(app, test, token)
+    assert.NoError(t, err)
+    return statistic["Samples"].(float64)
+  }
+
+  claim := map[string]interface{}{"name": "claimed sensor", "room_id": roomID, "import_readings": true}
+  sensor, err := doRequestReturningJson(app, testCase{"Test claim with import", "/unclaimed/quarantine-a/claim", 201, "POST", claim}, token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, sensor, token)
+  assert.NotEmpty(t, sensor["secret"])
+  assert.Equal(t, 2.0, samples(sensor), "Imported readings are readings of claimed sensor")
+  assert.Equal(t, 400, store("quarantine-a"), "Claimed sensor needs credentials")
+  resp := doRequest(t, app, testCase{"Test claim of claimed guid", "/unclaimed/quarantine-a/claim", 409, "POST", claim}, token)
+  assert.Equal(t, 409, resp.StatusCode)
+
+  claim = map[string]interface{}{"name": "claimed sensor", "room_id": roomID}
+  sensor, err = doRequestReturningJson(app, testCase{"Test claim without import", "/unclaimed/quarantine-b/claim", 201, "POST", claim}, token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, sensor, token)
+  assert.Equal(t, 0.0, samples(sensor), "Quarantined readings are discarded")
+
+  resp = doRequest(t, app, testCase{"Test discard", "/unclaimed/quarantine-c", 204, "DELETE", nil}, token)
+  assert.Equal(t, 204, resp.StatusCode)
+  guids := unclaimedGuids(t, app, token)
+  for _, guid := range []string{"quarantine-a", "quarantine-b", "quarantine-c"} {
+    assert.NotContains(t, guids, guid)
+  }
+  resp = doRequest(t, app, testCase{"Test claim of unknown guid", "/unclaimed/quarantine-c/claim", 404, "POST", claim}, token)
+  assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestMemoryBuffer(t *testing.T) {
+  t.Parallel()
+  buffer := buffers.NewMemoryBuffer(2)
+  measuredAt := time.Now()
+  reading := schemas.ExternalSensorDataSchema{Guid: "memory", Co2: 400, Tvoc: 50, BatteryCharge: 90, MeasuredAt: &measuredAt}
+
+  errs := buffer.Push(reading, reading, reading)
+  assert.NoError(t, errs[0])
+  assert.NoError(t, errs[1])
+  assert.ErrorIs(t, errs[2], buffers.ErrBufferFull)
+
+  entries, err := buffer.Fetch(10, time.Millisecond)
+  assert.NoError(t, err)
+  assert.Len(t, entries, 2)
+  assert.Equal(t, reading.Guid, entries[0].Data.Guid)
+
+  errs = buffer.Push(reading)
+  assert.ErrorIs(t, errs[0], buffers.ErrBufferFull, "Pending entries are counted until ack")
+
+  assert.NoError(t, buffer.Ack(entries[0].ID, entries[1].ID))
+  errs = buffer.Push(reading)
+  assert.NoError(t, errs[0])
+  entries, err = buffer.Fetch(10, time.Millisecond)
+  assert.NoError(t, err)
+  assert.Len(t, entries, 1)
+  assert.Equal(t, 1, entries[0].Deliveries)
+
+  assert.NoError(t, buffer.DeadLetter(entries[0]))
+  errs = buffer.Push(reading, reading)
+  assert.NoError(t, errs[0])
+  assert.NoError(t, errs[1], "Dead letters are not pending anymore")
+}
+
+func TestMemoryBufferLatest(t *testing.T) {
+  t.Parallel()
+  buffer := buffers.NewMemoryBuffer(10)
+  now := time.Now()
+  late := now.Add(-time.Minute)
+  buffer.Push(
+    schemas.ExternalSensorDataSchema{Guid: "latest", Co2: 500, MeasuredAt: &now},
+    schemas.ExternalSensorDataSchema{Guid: "latest", Co2: 400, MeasuredAt: &late},
+    schemas.ExternalSensorDataSchema{Guid: "other", Co2: 600, MeasuredAt: &now},
+  )
+  entries, err := buffer.Fetch(1, time.Millisecond)
+  assert.NoError(t, err)
+  assert.Len(t, entries, 1)
+
+  latest, err := buffer.Latest("latest", "missing")
+  assert.NoError(t, err)
+  assert.Len(t, latest, 1)
+  assert.Equal(t, 500, latest["latest"].Co2, "Pending reading is newer than late one")
+}
+
+func TestVapeDetector(t *testing.T) {
+  t.Parallel()
+  detector := services.NewVapeDetector(services.DetectionConfig{
+    BaselineWindow: 10 * time.Minute,
+    MinRise: 250,
+    MinRiseRate: 2,
+    MinDuration: 5 * time.Second,
+    MaxDuration: 30 * time.Minute,
+    EndRatio: 0.5,
+    MaxGap: 5 * time.Minute,
+  })
+  start := time.Now()
+  feed := func(state *models.DetectionState, tvoc []int) []services.DetectionEvent {
+    var events []services.DetectionEvent
+    for _, value := range tvoc {
+      reading := models.SensorData{Tvoc: value, Co2: 600, MeasuredAt: start.Add(time.Duration(len(events)) * 5 * time.Second)}
+      events = append(events, detector.Feed(state, reading))
+    }
+    start = start.Add(time.Hour)
+    return events
+  }
+
+  var state models.DetectionState
+  events := feed(&state, []int{100, 110, 100, 900, 1200, 800, 400, 120, 100})
+  assert.Equal(t, services.DetectionStarted, events[4], "Spike is confirmed after min duration")
+  assert.Equal(t, services.DetectionUpdated, events[5])
+  assert.Equal(t, services.DetectionEnded, events[7], "Episode ends when tvoc is back near baseline")
+  assert.Equal(t, 1200, state.EpisodePeakTvoc)
+
+  state = models.DetectionState{}
+  events = feed(&state, []int{100, 900, 100, 100})
+  assert.NotContains(t, events, services.DetectionStarted, "Short spike is ignored")
+
+  state = models.DetectionState{}
+  drift := make([]int, 0, 100)
+  for i := 0; i < 100; i++ {
+    drift = append(drift, 100 + i * 5)
+  }
+  events = feed(&state, drift)
+  assert.NotContains(t, events, services.DetectionStarted, "Slow drift moves baseline")
+}
+
+func TestRuleEvaluator(t *testing.T) {
+  t.Parallel()
+  evaluator := services.RuleEvaluator{}
+  rule := models.DetectionRule{Metric: "co2", Operator: "above", Threshold: 1000, MinDuration: 10, Cooldown: 60}
+  start := time.Now()
+  feed := func(state *models.RuleState, co2 []int) []bool {
+    var triggered []bool
+    for _, value := range co2 {
+      reading := models.SensorData{Co2: value, MeasuredAt: start.Add(time.Duration(len(triggered)) * 5 * time.Second)}
+      triggered = append(triggered, evaluator.Feed(rule, state, reading))
+    }
+    start = start.Add(time.Hour)
+    return triggered
+  }
+
+  var state models.RuleState
+  triggered := feed(&state, []int{800, 1200, 1200, 1200, 1200, 900})
+  assert.Equal(t, []bool{false, false, false, true, false, false}, triggered, "Rule triggers once after min duration")
+
+  triggered = feed(&state, []int{1200, 900, 1200, 1200})
+  assert.NotContains(t, triggered, true, "Interrupted breach restarts min duration")
+
+  rule.MinDuration = 0
+  rule.Cooldown = 10
+  triggered = feed(&state, []int{1200, 1200, 1200})
+  assert.Equal(t, []bool{true, false, true}, triggered, "Rule triggers again after cooldown")
+}
+
+func TestIncident(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+
+  tests := []testCase{
+    {"Test incident find", "/incident?state=open", 200, "GET", nil},
+    {"Test missing incident take", "/incident/999999999", 404, "GET", nil},
+    {"Test missing incident acknowledge", "/incident/999999999/acknowledge", 404, "POST", map[string]interface{}{"note": "checked"}},
+  }
+  for _, test := range tests {
+    resp := doRequest(t, app, test, token)
+    assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+  }
+
+  room, err := createRoom(app, "room with incidents", 1, 1, token)
+  assert.NoError(t, err)
+  roomID := strconv.Itoa(int(room["id"].(float64)))
+  sensor, err := createSensor(app, "incident sensor", "incident-a", 1, uint(room["id"].(float64)), token)
+  assert.NoError(t, err)
+  defer deleteSensor(t, app, sensor, token)
+  rule := map[string]interface{}{"name": "co2 above 1000", "metric": "co2", "operator": "above", "threshold": 1000}
+  created, err := doRequestReturningJson(app, testCase{"rule create", "/room/" + roomID + "/rules", 201, "POST", rule}, token)
+  assert.NoError(t, err)
+  ruleID := strconv.Itoa(int(created["id"].(float64)))
+
+  req := httptest.NewRequest("POST", "/external/sensors_data", bytes.NewBufferString(`{"guid": "incident-a", "co2": 1500, "tvoc": 50, "batteryCharge": 90}`))
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Sensor-Guid", "incident-a")
+  req.Header.Set("Authorization", "Bearer " + sensor["secret"].(string))
+  resp, err := app.Test(req, -1)
+  assert.NoError(t, err)
+  assert.Equal(t, 200, resp.StatusCode)
+
+  var incidents []map[string]interface{}
+  assert.Eventually(t, func() bool {
+    resp := doRequest(t, app, testCase{"room incidents", "/incident?room_id=" + roomID, 200, "GET", nil}, token)
+    incidents = nil
+    return json.NewDecoder(resp.Body).Decode(&incidents) == nil && len(incidents) == 1
+  }, 10 * time.Second, 500 * time.Millisecond, "Rule trigger opens incident")
+  if len(incidents) == 0 {
+    return
+  }
+  route := "/incident/" + strconv.Itoa(int(incidents[0]["id"].(float64)))
+  assert.Equal(t, "open", incidents[0]["state"])
+  assert.Nil(t, incidents[0]["ended_at"], "Incident lasts while breach does")
+
+  resp = doRequest(t, app, testCase{"rule delete", "/room/" + roomID + "/rules/" + ruleID, 204, "DELETE", nil}, token)
+  assert.Equal(t, 204, resp.StatusCode)
+  incident, err := doRequestReturningJson(app, testCase{"incident take", route, 200, "GET", nil}, token)
+  assert.NoError(t, err)
+  assert.NotNil(t, incident["ended_at"], "Incident of deleted rule is ended")
+
+  tests = []testCase{
+    {"Test incident acknowledge", route + "/acknowledge", 204, "POST", map[string]interface{}{"note": "checked"}},
+    {"Test incident acknowledged twice", route + "/acknowledge", 409, "POST", nil},
+    {"Test incident resolve", route + "/resolve", 204, "POST", map[string]interface{}{"note": "window opened"}},
+    {"Test resolved incident acknowledge", route + "/acknowledge", 409, "POST", nil},
+    {"Test incident resolved twice", route + "/resolve", 409, "POST", nil},
+  }
+  for _, test := range tests {
+    resp := doRequest(t, app, test, token)
+    assert.Equalf(t, test.expectedCode, resp.StatusCode, test.description)
+  }
+  incident, err = doRequestReturningJson(app, testCase{"incident take", route, 200, "GET", nil}, token)
+  assert.NoError(t, err)
+  assert.Equal(t, "resolved", incident["state"])
+  assert.Equal(t, "checked", incident["acknowledge_note"])
+  assert.Equal(t, "window opened", incident["resolve_note"])
+}
+
+func TestWebhookSender(t *testing.T) {
+  t.Parallel()
+  var calls atomic.Int32
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    body, _ := io.ReadAll(r.Body)
+    signature := services.Sign("secret", r.Header.Get(services.WebhookTimestampHeader), body)
+    if calls.Add(1) == 1 || r.Header.Get(services.WebhookSignatureHeader) != signature {
+      w.WriteHeader(http.StatusBadGateway)
+      return
+    }
+    assert.Equal(t, "42", r.Header.Get(services.WebhookDeliveryHeader))
+    w.WriteHeader(http.StatusNoContent)
+  }))
+  defer server.Close()
+  sender := services.NewWebhookSender(time.Second)
+  body := []byte(`{"type":"test","zone_id":1}`)
+
+  status, err := sender.Send(server.URL, "secret", 42, "test", body)
+  assert.Error(t, err, "Non-2xx response is failed delivery")
+  assert.Equal(t, http.StatusBadGateway, status)
+
+  status, err = sender.Send(server.URL, "secret", 42, "test", body)
+  assert.NoError(t, err)
+  assert.Equal(t, http.StatusNoContent, status)
+
+  _, err = sender.Send(server.URL, "wrong secret", 42, "test", body)
+  assert.Error(t, err, "Receiver rejects bad signature")
+}
+
+// serveSMTP accepts one SMTP session on listener and sends received DATA to messages
+func serveSMTP(listener net.Listener, messages chan<- string) {
+  conn, err := listener.Accept()
+  if err != nil {
+    return
+  }
+  defer conn.Close()
+  reader := bufio.NewReader(conn)
+  fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+  for {
+    line, err := reader.ReadString('\n')
+    if err != nil {
+      return
+    }
+    switch command := strings.ToUpper(strings.TrimSpace(line)); {
+    case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+      fmt.Fprint(conn, "250 localhost\r\n")
+    case command == "DATA":
+      fmt.Fprint(conn, "354 go ahead\r\n")
+      var data strings.Builder
+      for {
+        line, err := reader.ReadString('\n')
+        if err != nil || line == ".\r\n" {
+          break
+        }
+        data.WriteString(line)
+      }
+      messages <- data.String()
+      fmt.Fprint(conn, "250 OK\r\n")
+    case command == "QUIT":
+      fmt.Fprint(conn, "221 bye\r\n")
+      return
+    default:
+      fmt.Fprint(conn, "250 OK\r\n")
+    }
+  }
+}
+
+func TestSMTPNotifier(t *testing.T) {
+  t.Parallel()
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  assert.NoError(t, err)
+  defer listener.Close()
+  messages := make(chan string, 1)
+  go serveSMTP(listener, messages)
+
+  addr := listener.Addr().(*net.TCPAddr)
+  notifier := notifiers.NewSMTPNotifier(notifiers.SMTPConfig{
+    Host: "127.0.0.1",
+    Port: addr.Port,
+    From: "antivape@localhost",
+    Timeout: time.Second,
+  })
+  err = notifier.Notify(notifiers.Message{To: "staff@school.test", Subject: "[critical] Incident #1 opened", Text: "Vape aerosol detected"})
+  assert.NoError(t, err)
+  message := <-messages
+  assert.Contains(t, message, "To: staff@school.test")
+  assert.Contains(t, message, "Subject: [critical] Incident #1 opened")
+  assert.Contains(t, message, "Vape aerosol detected")
+}
+
+func TestGatewayNotifier(t *testing.T) {
+  t.Parallel()
+  requests := make(chan map[string]string, 1)
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    var body map[string]string
+    json.NewDecoder(r.Body).Decode(&body)
+    body["token"] = r.URL.Query().Get("token")
+    requests <- body
+  }))
+  defer server.Close()
+
+  notifier, err := notifiers.NewGatewayNotifier(notifiers.GatewayConfig{
+    Url: server.URL + "/send?token=abc",
+    Method: "POST",
+    Body: `{"phone": {{json .To}}, "message": {{json .Text}}}`,
+    ContentType: "application/json",
+    Timeout: time.Second,
+  })
+  assert.NoError(t, err)
+  err = notifier.Notify(notifiers.Message{To: "+10000000000", Text: "Incident \"1\" opened"})
+  assert.NoError(t, err)
+  request := <-requests
+  assert.Equal(t, "+10000000000", request["phone"])
+  assert.Equal(t, `Incident "1" opened`, request["message"], "Template escapes text as JSON")
+  assert.Equal(t, "abc", request["token"])
+}
+
+func TestQuietHours(t *testing.T) {
+  t.Parallel()
+  location, err := time.LoadLocation("Europe/Moscow")
+  assert.NoError(t, err)
+  night := services.QuietHours{Start: "22:00", End: "07:00", Location: location}
+  at := func(hour, minute int) time.Time {
+    return time.Date(2024, 3, 5, hour, minute, 0, 0, location)
+  }
+
+  until, quiet := night.Until(at(23, 30))
+  assert.True(t, quiet)
+  assert.Equal(t, time.Date(2024, 3, 6, 7, 0, 0, 0, location), until, "Quiet hours end next morning")
+
+  until, quiet = night.Until(at(3, 0).UTC())
+  assert.True(t, quiet, "Time is compared in quiet hours location")
+  assert.Equal(t, at(7, 0), until)
+
+  _, quiet = night.Until(at(12, 0))
+  assert.False(t, quiet)
+
+  lunch := services.QuietHours{Start: "12:00", End: "13:00", Location: location}
+  until, quiet = lunch.Until(at(12, 15))
+  assert.True(t, quiet)
+  assert.Equal(t, at(13, 0), until)
+  _, quiet = lunch.Until(at(13, 0))
+  assert.False(t, quiet, "End of quiet hours is not quiet")
+}
+
+func TestDeviceMonitor(t *testing.T) {
+  t.Parallel()
+  monitor := services.NewDeviceMonitor(services.HealthConfig{
+    FlatlineDuration: 10 * time.Minute,
+    BatteryDrop: 20,
+    BatteryDropWindow: time.Hour,
+  })
+  start := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+  reading := func(minute, co2, tvoc, battery int) models.SensorData {
+    return models.SensorData{Co2: co2, Tvoc: tvoc, BatteryCharge: battery, MeasuredAt: start.Add(time.Duration(minute) * time.Minute)}
+  }
+
+  var state models.DeviceState
+  assert.Equal(t, services.DeviceChange{}, monitor.Feed(&state, reading(0, 400, 50, 90)))
+  assert.False(t, monitor.Feed(&state, reading(5, 400, 50, 90)).Flatlined)
+  assert.True(t, monitor.Feed(&state, reading(10, 400, 50, 90)).Flatlined, "Same values for flatline duration")
+  state.FlatlineEventID = 1
+  assert.False(t, monitor.Feed(&state, reading(20, 400, 50, 90)).Flatlined, "Flatline is reported once")
+  assert.False(t, monitor.Feed(&state, reading(15, 410, 50, 90)).Recovered, "Old reading is ignored")
+  assert.True(t, monitor.Feed(&state, reading(25, 410, 50, 90)).Recovered)
+  state.FlatlineEventID = 0
+
+  assert.Equal(t, 0, monitor.Feed(&state, reading(30, 420, 50, 80)).BatteryDrop)
+  assert.Equal(t, 25, monitor.Feed(&state, reading(40, 430, 50, 65)).BatteryDrop, "Drop is counted from highest charge in window")
+  assert.Equal(t, 0, monitor.Feed(&state, reading(200, 440, 50, 40)).BatteryDrop, "Slow discharge is not a drop")
+}
+
+func TestForecastBattery(t *testing.T) {
+  t.Parallel()
+  start := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+  point := func(hour int, level float64) services.BatteryPoint {
+    return services.BatteryPoint{At: start.Add(time.Duration(hour) * time.Hour), Level: level}
+  }
+
+  _, ok := services.ForecastBattery([]services.BatteryPoint{point(0, 80)})
+  assert.False(t, ok, "One point is not enough")
+
+  rate, ok := services.ForecastBattery([]services.BatteryPoint{point(0, 80), point(12, 78), point(24, 76)})
+  assert.True(t, ok)
+  assert.InDelta(t, 4, rate, 0.001)
+
+  rate, ok = services.ForecastBattery([]services.BatteryPoint{point(0, 20), point(12, 10), point(13, 100), point(25, 99)})
+  assert.True(t, ok)
+  assert.InDelta(t, 2, rate, 0.001, "Points before recharge are ignored")
+
+  _, ok = services.ForecastBattery([]services.BatteryPoint{point(0, 50), point(24, 52)})
+  assert.False(t, ok, "Charging battery has no forecast")
+}
+
+func TestDeviceMonitorBatteryLow(t *testing.T) {
+  t.Parallel()
+  monitor := services.NewDeviceMonitor(services.HealthConfig{BatteryLow: 20, BatteryDropWindow: time.Hour})
+  start := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+  reading := func(minute, battery int) models.SensorData {
+    return models.SensorData{BatteryCharge: battery, MeasuredAt: start.Add(time.Duration(minute) * time.Minute)}
+  }
+
+  var state models.DeviceState
+  assert.False(t, monitor.Feed(&state, reading(0, 21)).BatteryLow)
+  assert.True(t, monitor.Feed(&state, reading(1, 19)).BatteryLow)
+  state.BatteryLowEventID = 1
+  assert.Equal(t, services.DeviceChange{}, monitor.Feed(&state, reading(2, 21)), "Low battery isn't over at threshold")
+  assert.True(t, monitor.Feed(&state, reading(3, 100)).BatteryReplaced)
+}
+
+func TestStatisticRange(t *testing.T) {
+  t.Parallel()
+  now := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+
+  from, to, err := schemas.StatisticFindSchema{}.Range(now)
+  assert.NoError(t, err)
+  assert.Nil(t, from)
+  assert.Nil(t, to)
+
+  from, to, err = schemas.StatisticFindSchema{Last: "7d"}.Range(now)
+  assert.NoError(t, err)
+  assert.Equal(t, now.AddDate(0, 0, -7), *from)
+  assert.Equal(t, now, *to)
+
+  from, to, err = schemas.StatisticFindSchema{From: "2024-03-01T00:00:00Z"}.Range(now)
+  assert.NoError(t, err)
+  assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), *from)
+  assert.Nil(t, to)
+
+  for _, schema := range []schemas.StatisticFindSchema{
+    {Last: "1h", From: "2024-03-01T00:00:00Z"},
+    {Last: "-1h"},
+    {Last: "week"},
+    {From: "2024-03-02T00:00:00Z", To: "2024-03-01T00:00:00Z"},
+    {To: "yesterday"},
+  } {
+    _, _, err := schema.Range(now)
+    assert.Error(t, err, schema)
+  }
+}
+
+func TestFillSeries(t *testing.T) {
+  t.Parallel()
+  from := time.Date(2024, 3, 5, 12, 2, 0, 0, time.UTC)
+  bucket := 5 * time.Minute
+  value := 400.0
+  points := []schemas.SeriesPointSchema{
+    {At: from.Truncate(bucket).Add(bucket), Count: 3, Co2Avg: &value},
+  }
+
+  filled := services.FillSeries(points, from, from.Add(15 * time.Minute), bucket)
+  assert.Len(t, filled, 4, "First bucket starts before from")
+  assert.Equal(t, time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC), filled[0].At)
+  assert.Equal(t, 0, filled[0].Count)
+  assert.Nil(t, filled[0].Co2Avg)
+  assert.Equal(t, points[0], filled[1])
+  assert.Equal(t, time.Date(2024, 3, 5, 12, 15, 0, 0, time.UTC), filled[3].At)
+}
+
+func TestStatisticAggregates(t *testing.T) {
+  t.Parallel()
+  threshold := 1000.0
+  schema := schemas.StatisticFindSchema{Aggregates: "max, p95,time_above", Co2Above: &threshold}
+  assert.NoError(t, schema.Validate())
+  assert.Equal(t, []string{"max", "p95", "time_above"}, schema.AggregateList())
+
+  assert.Error(t, schemas.StatisticFindSchema{Aggregates: "time_above"}.Validate(), "time_above needs threshold")
+  assert.Error(t, schemas.StatisticFindSchema{Aggregates: "avg,mode"}.Validate())
+  assert.Nil(t, schemas.StatisticFindSchema{}.AggregateList())
+
+  assert.NoError(t, schemas.StatisticFindSchema{Weighting: schemas.WeightingTime, Aggregates: "avg,stddev"}.Validate())
+  assert.Error(t, schemas.StatisticFindSchema{Weighting: "count"}.Validate())
+}
+
+func TestTimeWeightedStatistic(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+  room, err := createRoom(app, "room with fast and slow sensors", 1, 1, token)
+  assert.NoError(t, err)
+  roomID := strconv.Itoa(int(room["id"].(float64)))
+  // Readings of previous runs must not fall into interval
+  suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+  from := time.Now().Add(-time.Hour).UTC().Truncate(time.Minute)
+  to := from.Add(10 * time.Minute)
+
+  store := func(guid string, co2 int, offsets []int) {
+    sensor, err := createSensor(app, guid, guid, 1, uint(room["id"].(float64)), token)
+    assert.NoError(t, err)
+    t.Cleanup(func() { deleteSensor(t, app, sensor, token) })
+    items := make([]string, 0, len(offsets))
+    for _, offset := range offsets {
+      measuredAt := from.Add(time.Duration(offset) * time.Second).Format(time.RFC3339)
+      items = append(items, `{"guid": "` + guid + `", "co2": ` + strconv.Itoa(co2) + `, "tvoc": 50, "batteryCharge": 90, "measured_at": "` + measuredAt + `"}`)
+    }
+    req := httptest.NewRequest("POST", "/external/sensors_data/batch", bytes.NewBufferString("[" + strings.Join(items, ",") + "]"))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Sensor-Guid", guid)
+    req.Header.Set("Authorization", "Bearer " + sensor["secret"].(string))
+    resp, err := app.Test(req, -1)
+    assert.NoError(t, err)
+    assert.Equal(t, 200, resp.StatusCode)
+  }
+  // Fast sensor reads every second during the last minute of interval, so
+  // its readings span 60s. Slow one reads every 60s, but misses readings for
+  // 420s, which counts as max gap of 300s: its readings span 60+60+300+60s.
+  fast := make([]int, 0, 60)
+  for offset := 540; offset < 600; offset++ {
+    fast = append(fast, offset)
+  }
+  store("weighted-fast-" + suffix, 1000, fast)
+  store("weighted-slow-" + suffix, 400, []int{0, 60, 120, 540})
+
+  route := "/room/" + roomID + "/statistic?aggregates=avg&from=" + from.Format(time.RFC3339) + "&to=" + to.Format(time.RFC3339)
+  var statistic map[string]interface{}
+  assert.Eventually(t, func() bool {
+    statistic, err = doRequestReturningJson(app, testCase{"sample weighted statistic", route, 200, "GET", nil}, token)
+    return err == nil && statistic["Samples"].(float64) == 64
+  }, 10 * time.Second, 500 * time.Millisecond, "Readings are stored")
+  if statistic == nil {
+    return
+  }
+  assert.InDelta(t, (60*1000.0 + 4*400.0) / 64, statistic["Co2Aggregates"].(map[string]interface{})["avg"], 0.01, "Every sample weighs the same")
+
+  statistic, err = doRequestReturningJson(app, testCase{"time weighted statistic", route + "&weighting=time", 200, "GET", nil}, token)
+  assert.NoError(t, err)
+  assert.InDelta(t, (60*1000.0 + 480*400.0) / 540, statistic["Co2Aggregates"].(map[string]interface{})["avg"], 0.01, "Every reading weighs its span capped by max gap")
+}
+
+func TestPlanRollups(t *testing.T) {
+  t.Parallel()
+  from := time.Date(2024, 3, 5, 22, 30, 15, 0, time.UTC)
+  to := time.Date(2024, 3, 8, 1, 10, 0, 0, time.UTC)
+
+  parts := services.PlanRollups(from, to, 24 * time.Hour)
+  assert.Equal(t, []repositories.RollupPart{
+    {From: from, To: time.Date(2024, 3, 5, 22, 31, 0, 0, time.UTC)},
+    {Table: "sensor_data_minutes", From: time.Date(2024, 3, 5, 22, 31, 0, 0, time.UTC), To: time.Date(2024, 3, 5, 23, 0, 0, 0, time.UTC)},
+    {Table: "sensor_data_hours", From: time.Date(2024, 3, 5, 23, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)},
+    {Table: "sensor_data_days", From: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)},
+    {Table: "sensor_data_hours", From: time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 8, 1, 0, 0, 0, time.UTC)},
+    {Table: "sensor_data_minutes", From: time.Date(2024, 3, 8, 1, 0, 0, 0, time.UTC), To: to},
+  }, parts)
+
+  parts = services.PlanRollups(from, to, 5 * time.Minute)
+  assert.Len(t, parts, 2, "Series of 5 minute buckets use only minute rollup")
+  assert.Equal(t, "sensor_data_minutes", parts[1].Table)
+
+  parts = services.PlanRollups(from, from.Add(30 * time.Second), 24 * time.Hour)
+  assert.Equal(t, []repositories.RollupPart{{From: from, To: from.Add(30 * time.Second)}}, parts, "Short interval is raw")
+}
+
+func TestEffectiveRetention(t *testing.T) {
+  t.Parallel()
+  global := schemas.RetentionSchema{RawDays: 30, HourDays: 730}
+  zone := schemas.RetentionSchema{ZoneID: 3, RawDays: 7, DayDays: 3650}
+
+  assert.Equal(t, schemas.RetentionSchema{ZoneID: 3, RawDays: 7, HourDays: 730, DayDays: 3650}, services.EffectiveRetention(zone, global))
+  assert.Equal(t, global, services.EffectiveRetention(schemas.RetentionSchema{}, global), "Zone without retention uses global")
+  assert.Error(t, schemas.RetentionSchema{RawDays: -1}.Validate())
+}
+
+func TestStatisticRetained(t *testing.T) {
+  t.Parallel()
+  now := time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+  retention := schemas.RetentionSchema{RawDays: 7, MinuteDays: 30, HourDays: 365}
+
+  tests := []struct {
+    description string
+    schema schemas.StatisticFindSchema
+    retained bool
+  }{
+    {"Test interval without from", schemas.StatisticFindSchema{Aggregates: "median"}, true},
+    {"Test recent raw interval", schemas.StatisticFindSchema{Last: "1d", Aggregates: "median"}, true},
+    {"Test old interval served by rollups", schemas.StatisticFindSchema{From: "2024-03-10T00:00:00Z"}, true},
+    {"Test old interval of raw aggregate", schemas.StatisticFindSchema{From: "2024-03-10T00:00:00Z", Aggregates: "p95"}, false},
+    {"Test old interval of time weighting", schemas.StatisticFindSchema{From: "2024-03-10T00:00:00Z", Weighting: schemas.WeightingTime}, false},
+    {"Test old interval with raw edge", schemas.StatisticFindSchema{From: "2024-03-10T00:00:30Z"}, false},
+    {"Test interval with purged minute edge", schemas.StatisticFindSchema{From: "2024-02-01T00:30:00Z"}, false},
+  }
+  for _, test := range tests {
+    err := services.StatisticRetained(test.schema, retention, now)
+    if test.retained {
+      assert.NoErrorf(t, err, test.description)
+    } else {
+      assert.ErrorIsf(t, err, services.ErrStatisticRetention, test.description)
+    }
+  }
+}
+
+func TestAirQuality(t *testing.T) {
+  t.Parallel()
+  config := services.AirQualityConfig{Co2Bounds: []float64{800, 1000, 1500, 2000}, TvocBounds: []float64{65, 220, 660, 2200}}
+  assert.NoError(t, config.Validate())
+  assert.Error(t, services.AirQualityConfig{Co2Bounds: []float64{800, 1000}, TvocBounds: config.TvocBounds}.Validate())
+  assert.Error(t, services.AirQualityConfig{Co2Bounds: []float64{800, 700, 1500, 2000}, TvocBounds: config.TvocBounds}.Validate())
+  airQuality := services.NewAirQuality(config)
+
+  co2Band, tvocBand, band := airQuality.Classify(650, 300)
+  assert.Equal(t, schemas.BandExcellent, co2Band)
+  assert.Equal(t, schemas.BandModerate, tvocBand)
+  assert.Equal(t, schemas.BandModerate, band, "Band is the worse one")
+  co2Band, _, _ = airQuality.Classify(1000, 0)
+  assert.Equal(t, schemas.BandModerate, co2Band, "Bound belongs to the next band")
+  co2Band, _, _ = airQuality.Classify(5000, 0)
+  assert.Equal(t, schemas.BandUnhealthy, co2Band)
+
+  bands := airQuality.RoomBands(1, []repositories.BandSpan{
+    {RoomID: 1, Co2Band: 0, TvocBand: 0, Seconds: 300},
+    {RoomID: 1, Co2Band: 3, TvocBand: 1, Seconds: 100},
+    {RoomID: 2, Co2Band: 4, TvocBand: 4, Seconds: 1000},
+  })
+  assert.Equal(t, 400.0, bands.Seconds)
+  assert.Len(t, bands.Co2, len(schemas.Bands))
+  assert.Equal(t, schemas.BandShareSchema{Band: schemas.BandExcellent, Seconds: 300, Share: 0.75}, bands.Co2[0])
+  assert.Equal(t, schemas.BandShareSchema{Band: schemas.BandPoor, Seconds: 100, Share: 0.25}, bands.Overall[3])
+  assert.Equal(t, 0.25, bands.Tvoc[1].Share)
+
+  empty := airQuality.RoomBands(3, nil)
+  assert.Equal(t, 0.0, empty.Overall[0].Share, "Room without readings has zero shares")
+}
+
+func TestExportWriter(t *testing.T) {
+  t.Parallel()
+  row := schemas.ExportRowSchema{
+    MeasuredAt: time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC),
+    SensorID: 1, SensorName: "Sensor, hall", Guid: "export", RoomID: 2, RoomName: "Hall", ZoneID: 3, ZoneName: "Block B",
+    Co2: 800, Tvoc: 120, BatteryCharge: 90,
+  }
+
+  var csvBuffer bytes.Buffer
+  writer := services.NewExportWriter(schemas.ExportCSV, &csvBuffer)
+  assert.NoError(t, writer.Write(row))
+  assert.NoError(t, writer.Flush())
+  assert.Equal(
+    t,
+    "measured_at,sensor_id,sensor_name,guid,room_id,room_name,zone_id,zone_name,co2,tvoc,battery_charge\n" +
+      "2024-03-05T10:00:00Z,1,\"Sensor, hall\",export,2,Hall,3,Block B,800,120,90\n",
+    csvBuffer.String(),
+  )
+
+  csvBuffer.Reset()
+  assert.NoError(t, services.NewExportWriter(schemas.ExportCSV, &csvBuffer).Flush())
+  assert.True(t, strings.HasPrefix(csvBuffer.String(), "measured_at,"), "Empty export has header")
+
+  var ndjsonBuffer bytes.Buffer
+  writer = services.NewExportWriter(schemas.ExportNDJSON, &ndjsonBuffer)
+  assert.NoError(t, writer.Write(row))
+  assert.NoError(t, writer.Write(row))
+  lines := strings.Split(strings.TrimSpace(ndjsonBuffer.String()), "\n")
+  assert.Len(t, lines, 2)
+  var decoded schemas.ExportRowSchema
+  assert.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+  assert.Equal(t, row, decoded)
+
+  sensorID := uint(1)
+  assert.NoError(t, schemas.ExportFindSchema{SensorID: &sensorID, Last: "7d"}.Validate())
+  assert.Error(t, schemas.ExportFindSchema{}.Validate(), "Scope is required")
+  assert.Error(t, schemas.ExportFindSchema{SensorID: &sensorID, Format: "xlsx"}.Validate())
+}
+
+func TestReport(t *testing.T) {
+  t.Parallel()
+  location, err := time.LoadLocation("Europe/Berlin")
+  assert.NoError(t, err)
+  // Wednesday
+  at := time.Date(2024, 3, 6, 15, 30, 0, 0, location)
+  assert.Equal(t, time.Date(2024, 3, 7, 0, 0, 0, 0, location), services.ReportPeriodEnd(schemas.ReportDaily, at, location))
+  assert.Equal(t, time.Date(2024, 3, 11, 0, 0, 0, 0, location), services.ReportPeriodEnd(schemas.ReportWeekly, at, location))
+  assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, location), services.ReportPeriodEnd(schemas.ReportMonthly, at, location))
+  monday := time.Date(2024, 3, 11, 0, 0, 0, 0, location)
+  assert.Equal(t, time.Date(2024, 3, 18, 0, 0, 0, 0, location), services.ReportPeriodEnd(schemas.ReportWeekly, monday, location), "Bound is after at")
+  assert.Equal(t, time.Date(2024, 3, 4, 0, 0, 0, 0, location), services.ReportPeriodStart(schemas.ReportWeekly, monday, location))
+  assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, location), services.ReportPeriodStart(schemas.ReportMonthly, time.Date(2024, 4, 1, 0, 0, 0, 0, location), location))
+  // Daylight saving time starts on 2024-03-31 in Berlin, day is 23 hours long
+  end := services.ReportPeriodEnd(schemas.ReportDaily, time.Date(2024, 3, 31, 12, 0, 0, 0, location), location)
+  assert.Equal(t, 23*time.Hour, end.Sub(services.ReportPeriodStart(schemas.ReportDaily, end, location)))
+
+  co2Max := 1450.0
+  data := schemas.ReportDataSchema{
+    ZoneID: 1, ZoneName: "Block <B>", Name: "Weekly",
+    From: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+    Rooms: []schemas.ReportRoomSchema{
+      {RoomID: 2, Name: "Hall", Samples: 10, Co2Avg: 900, TvocAvg: 100, Co2Max: &co2Max, Band: schemas.BandGood},
+      {RoomID: 3, Name: "Gym"},
+    },
+    Sensors: []schemas.ReportSensorSchema{
+      {SensorID: 4, Name: "Hall sensor", RoomID: 2, Status: "offline", BatteryCharge: 15, ReplaceBattery: true},
+    },
+  }
+  report, err := services.RenderReportCSV(data)
+  assert.NoError(t, err)
+  assert.Equal(
+    t,
+    "room_id,room,readings,co2_avg,co2_max,tvoc_avg,tvoc_max,band,excellent_share,good_share,moderate_share,poor_share,unhealthy_share\n" +
+      "2,Hall,10,900,1450,100,,good\n" +
+      "3,Gym,0,0,,0,,\n" +
+      "\n" +
+      "sensor_id,sensor,room_id,status,battery_charge,battery_empty_at,replace_battery\n" +
+      "4,Hall sensor,2,offline,15,,true\n",
+    report,
+  )
+  html, err := services.RenderReportHTML(data)
+  assert.NoError(t, err)
+  assert.Contains(t, html, "Block &lt;B&gt;", "Names are escaped")
+  assert.Contains(t, html, "No readings")
+}
+
+func TestReportCycle(t *testing.T) {
+  t.Parallel()
+  app := InitApp()
+  token := generateToken(t, app, "user", "password")
+  dbConnection, err := models.InitDatabase(databaseConfig())
+  assert.NoError(t, err)
+
+  createDefinition := func(name string) (string, string) {
+    zone, err := createZone(app, name, 1, token)
+    assert.NoError(t, err)
+    zoneID := strconv.Itoa(int(zone["id"].(float64)))
+    definition := map[string]interface{}{"zone_id": zone["id"], "name": name, "period": schemas.ReportDaily}
+    resp, err := doRequestReturningJson(app, testCase{"definition create", "/report/definition", 201, "POST", definition}, token)
+    assert.NoError(t, err)
+    return zoneID, strconv.Itoa(int(resp["id"].(float64)))
+  }
+
+  zoneID, definitionID := createDefinition("zone deleted with report")
+  zoneIDValue, _ := strconv.Atoi(zoneID)
+  gateway, err := doRequestReturningJson(app, testCase{"gateway create", "/gateway", 201, "POST", map[string]interface{}{"zone_id": zoneIDValue, "name": "gateway of deleted zone"}}, token)
+  assert.NoError(t, err)
+  resp := doRequest(t, app, testCase{"zone delete", "/zone/" + zoneID, 204, "DELETE", nil}, token)
+  assert.Equal(t, 204, resp.StatusCode)
+  resp = doRequest(t, app, testCase{"Test definition of deleted zone", "/report/definition/" + definitionID, 404, "GET", nil}, token)
+  assert.Equal(t, 404, resp.StatusCode, "Definitions are deleted with zone")
+  req := httptest.NewRequest("POST", "/external/sensors_data/batch", bytes.NewBufferString("[]"))
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set("X-Gateway-Guid", gateway["guid"].(string))
+  req.Header.Set("Authorization", "Bearer " + gateway["secret"].(string))
+  resp, err = app.Test(req, -1)
+  assert.NoError(t, err)
+  assert.Equal(t, 401, resp.StatusCode, "Gateways are deleted with zone")
+
+  // Definition of zone deleted before definitions were deleted with it is
+  // due before the one of existing zone
+  due := time.Now().Add(-2 * time.Hour)
+  orphan := models.ReportDefinition{ZoneID: 999999999, Name: "orphan", Period: schemas.ReportDaily, Timezone: "UTC", Enabled: true, NextRunAt: due}
+  assert.NoError(t, dbConnection.Create(&orphan).Error)
+  defer dbConnection.Unscoped().Delete(&orphan)
+  _, definitionID = createDefinition("zone with report")
+  defer doRequest(t, app, testCase{"definition delete", "/report/definition/" + definitionID, 204, "DELETE", nil}, token)
+  err = dbConnection.Model(&models.ReportDefinition{}).Where("id = ?", definitionID).Update("next_run_at", time.Now().Add(-time.Hour)).Error
+  assert.NoError(t, err)
+
+  // Fresh app runs report cycle at once
+  InitApp()
+  assert.Eventually(t, func() bool {
+    resp := doRequest(t, app, testCase{"definition reports", "/report?definition_id=" + definitionID, 200, "GET", nil}, token)
+    var reports []map[string]interface{}
+    return json.NewDecoder(resp.Body).Decode(&reports) == nil && len(reports) == 1
+  }, 10 * time.Second, 500 * time.Millisecond, "Failing definition doesn't hold back others")
+
+  assert.NoError(t, dbConnection.Where("id = ?", orphan.ID).Take(&orphan).Error)
+  assert.False(t, orphan.Enabled, "Definition of deleted zone is disabled")
+  assert.Equal(t, services.ErrReportZoneNotFound.Error(), orphan.LastError)
+  assert.True(t, orphan.NextRunAt.After(due), "Failed period is skipped")
+}
+
+func TestComparison(t *testing.T) {
+  t.Parallel()
+  now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+  window, err := schemas.ComparisonFindSchema{Last: "30d"}.Windows(now)
+  assert.NoError(t, err)
+  assert.Equal(t, now.AddDate(0, 0, -30), window.From)
+  assert.Equal(t, window.From, window.PreviousTo, "Previous interval is right before by default")
+  assert.Equal(t, now.AddDate(0, 0, -60), window.PreviousFrom)
+  window, err = schemas.ComparisonFindSchema{
+    From: "2024-03-01T00:00:00Z", To: "2024-04-01T00:00:00Z",
+    PreviousFrom: "2023-03-01T00:00:00Z", PreviousTo: "2023-04-01T00:00:00Z",
+  }.Windows(now)
+  assert.NoError(t, err)
+  assert.Equal(t, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), window.PreviousFrom)
+  _, err = schemas.ComparisonFindSchema{From: "2024-03-01T00:00:00Z"}.Windows(now)
+  assert.Error(t, err, "Interval is bounded")
+  _, err = schemas.ComparisonFindSchema{Last: "7d", PreviousFrom: "2024-03-01T00:00:00Z"}.Windows(now)
+  assert.Error(t, err)
+  assert.Error(t, schemas.ComparisonFindSchema{Last: "7d", Rank: "battery"}.Validate())
+
+  current, previous, zero := 150.0, 200.0, 0.0
+  change := services.CompareValues(&current, &previous)
+  assert.Equal(t, -50.0, *change.Delta)
+  assert.Equal(t, -25.0, *change.Percent)
+  change = services.CompareValues(&current, &zero)
+  assert.Equal(t, 150.0, *change.Delta)
+  assert.Nil(t, change.Percent, "No percent of zero")
+  assert.Nil(t, services.CompareValues(nil, &previous).Delta)
+
+  tvocMax := 900.0
+  rooms := []schemas.RoomChangeSchema{
+    services.CompareRoom(
+      schemas.SensorDataRoomSchema{RoomID: 1, Co2: 800, Tvoc: 200, Samples: 10, TvocAggregates: &schemas.AggregatesSchema{Max: &tvocMax}},
+      schemas.SensorDataRoomSchema{RoomID: 1, Co2: 700, Tvoc: 300, Samples: 30, TvocAggregates: &schemas.AggregatesSchema{}},
+      1, 4, []string{schemas.AggregateMax},
+    ),
+    services.CompareRoom(schemas.SensorDataRoomSchema{RoomID: 2}, schemas.SensorDataRoomSchema{RoomID: 2, Tvoc: 100, Samples: 5}, 0, 0, nil),
+    services.CompareRoom(
+      schemas.SensorDataRoomSchema{RoomID: 3, Co2: 600, Tvoc: 150, Samples: 10},
+      schemas.SensorDataRoomSchema{RoomID: 3, Co2: 600, Tvoc: 100, Samples: 10},
+      2, 1, nil,
+    ),
+  }
+  assert.Equal(t, -3.0, *rooms[0].Episodes.Delta)
+  assert.Equal(t, 900.0, *rooms[0].TvocAggregates[schemas.AggregateMax].Current)
+  assert.Nil(t, rooms[0].TvocAggregates[schemas.AggregateMax].Delta, "Aggregate without previous value has no delta")
+
+  services.RankRooms(rooms, schemas.CompareTvoc)
+  assert.Equal(t, []uint{3, 1, 2}, []uint{rooms[0].RoomID, rooms[1].RoomID, rooms[2].RoomID})
+  assert.Equal(t, []int{1, 2, 0}, []int{rooms[0].Rank, rooms[1].Rank, rooms[2].Rank}, "Room without readings is not ranked")
+
+  co2, tvoc, episodes := services.CompareZone(rooms)
+  assert.Equal(t, 700.0, *co2.Current)
+  assert.Equal(t, 175.0, *tvoc.Current)
+  assert.Equal(t, 3.0, *episodes.Current)
+  assert.Equal(t, 5.0, *episodes.Previous)
+}
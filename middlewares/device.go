@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+  "strings"
+
+  "github.com/gofiber/fiber/v2"
+)
+
+const (
+  SensorGuidHeader = "X-Sensor-Guid"
+  GatewayGuidHeader = "X-Gateway-Guid"
+  SignatureHeader = "X-Signature"
+  TimestampHeader = "X-Timestamp"
+)
+
+// DeviceVerifier checks sensor and gateway credentials, implemented by services.CredentialService
+type DeviceVerifier interface {
+  VerifySecret(guid, secret string) error
+  VerifySignature(guid, timestamp, signature string, body []byte) error
+  IsKnown(guid string) bool
+  VerifyGatewaySecret(guid, secret string) error
+  VerifyGatewaySignature(guid, timestamp, signature string, body []byte) error
+}
+
+// DeviceAuthenticated protect ingestion routes. Sensor sends its guid in X-Sensor-Guid,
+// gateway relaying readings of many sensors sends its guid in X-Gateway-Guid instead.
+// Both send either "Authorization: Bearer <secret>" or X-Timestamp with X-Signature.
+// Authenticated guid is stored in "sensor_guid" or "gateway_guid" local.
+// Guids of no sensor pass without credentials with "quarantined" local set, their readings
+// are quarantined until claimed.
+func DeviceAuthenticated(verifier DeviceVerifier) fiber.Handler {
+  return func(c *fiber.Ctx) error {
+    if gateway := c.Get(GatewayGuidHeader); len(gateway) > 0 {
+      present, err := verifyCredentials(c, gateway, verifier.VerifyGatewaySecret, verifier.VerifyGatewaySignature)
+      if !present {
+        return deviceError(c, fiber.StatusBadRequest, "Missing or malformed gateway credentials")
+      }
+      if err != nil {
+        return deviceError(c, fiber.StatusUnauthorized, err.Error())
+      }
+      c.Locals("gateway_guid", gateway)
+      return c.Next()
+    }
+
+    guid := c.Get(SensorGuidHeader)
+    if len(guid) == 0 {
+      return deviceError(c, fiber.StatusBadRequest, "Missing "+SensorGuidHeader+" header")
+    }
+
+    if !verifier.IsKnown(guid) {
+      c.Locals("sensor_guid", guid)
+      c.Locals("quarantined", true)
+      return c.Next()
+    }
+
+    present, err := verifyCredentials(c, guid, verifier.VerifySecret, verifier.VerifySignature)
+    if !present {
+      return deviceError(c, fiber.StatusBadRequest, "Missing or malformed sensor credentials")
+    }
+    if err != nil {
+      return deviceError(c, fiber.StatusUnauthorized, err.Error())
+    }
+
+    c.Locals("sensor_guid", guid)
+    return c.Next()
+  }
+}
+
+// verifyCredentials checks signature or secret of request, present is false
+// when request has neither
+func verifyCredentials(
+  c *fiber.Ctx,
+  guid string,
+  verifySecret func(guid, secret string) error,
+  verifySignature func(guid, timestamp, signature string, body []byte) error,
+) (bool, error) {
+  if signature := c.Get(SignatureHeader); len(signature) > 0 {
+    return true, verifySignature(guid, c.Get(TimestampHeader), signature, c.Body())
+  }
+  if secret, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+    return true, verifySecret(guid, secret)
+  }
+  return false, nil
+}
+
+func deviceError(c *fiber.Ctx, status int, message string) error {
+  return c.Status(status).
+    JSON(fiber.Map{"status": "error", "message": message, "data": nil})
+}
@@ -0,0 +1,81 @@
+package notifiers
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "text/template"
+  "time"
+)
+
+// GatewayConfig describes generic HTTP gateway, e.g. SMS provider. Url and
+// Body are text/template over Message, with json func which quotes value
+// as JSON string.
+type GatewayConfig struct {
+  Url string
+  Method string
+  Body string
+  ContentType string
+  // Authorization header value, not sent if empty
+  Authorization string
+  Timeout time.Duration
+}
+
+type gatewayNotifier struct {
+  config GatewayConfig
+  url *template.Template
+  body *template.Template
+  client *http.Client
+}
+
+func (n gatewayNotifier) Notify(message Message) error {
+  var url, body bytes.Buffer
+  if err := n.url.Execute(&url, message); err != nil {
+    return err
+  }
+  if err := n.body.Execute(&body, message); err != nil {
+    return err
+  }
+
+  req, err := http.NewRequest(n.config.Method, url.String(), &body)
+  if err != nil {
+    return err
+  }
+  req.Header.Set("Content-Type", n.config.ContentType)
+  if len(n.config.Authorization) > 0 {
+    req.Header.Set("Authorization", n.config.Authorization)
+  }
+  resp, err := n.client.Do(req)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    return fmt.Errorf("Unexpected status code %d", resp.StatusCode)
+  }
+  return nil
+}
+
+func NewGatewayNotifier(config GatewayConfig) (Notifier, error) {
+  funcs := template.FuncMap{
+    "json": func(value string) (string, error) {
+      encoded, err := json.Marshal(value)
+      return string(encoded), err
+    },
+  }
+  url, err := template.New("url").Funcs(funcs).Parse(config.Url)
+  if err != nil {
+    return nil, err
+  }
+  body, err := template.New("body").Funcs(funcs).Parse(config.Body)
+  if err != nil {
+    return nil, err
+  }
+  return gatewayNotifier{
+    config: config,
+    url: url,
+    body: body,
+    client: &http.Client{Timeout: config.Timeout},
+  }, nil
+}
@@ -0,0 +1,15 @@
+package notifiers
+
+type Message struct {
+  // Email address or phone number
+  To string
+  Subject string
+  Text string
+  // Optional HTML alternative of Text, only email sends it
+  HTML string
+}
+
+// Notifier delivers message over one channel, error means it should be retried
+type Notifier interface {
+  Notify(message Message) error
+}
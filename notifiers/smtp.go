@@ -0,0 +1,109 @@
+package notifiers
+
+import (
+  "crypto/tls"
+  "encoding/base64"
+  "fmt"
+  "net"
+  "mime/multipart"
+  "net/smtp"
+  "net/textproto"
+  "strings"
+  "time"
+)
+
+type SMTPConfig struct {
+  Host string
+  Port int
+  // Auth is skipped if Username is empty
+  Username string
+  Password string
+  From string
+  Timeout time.Duration
+}
+
+type smtpNotifier struct {
+  config SMTPConfig
+}
+
+// Notify works like smtp.SendMail, but whole session is bounded by Timeout
+func (n smtpNotifier) Notify(message Message) error {
+  addr := net.JoinHostPort(n.config.Host, fmt.Sprint(n.config.Port))
+  conn, err := net.DialTimeout("tcp", addr, n.config.Timeout)
+  if err != nil {
+    return err
+  }
+  conn.SetDeadline(time.Now().Add(n.config.Timeout))
+  client, err := smtp.NewClient(conn, n.config.Host)
+  if err != nil {
+    conn.Close()
+    return err
+  }
+  defer client.Close()
+
+  if ok, _ := client.Extension("STARTTLS"); ok {
+    if err := client.StartTLS(&tls.Config{ServerName: n.config.Host}); err != nil {
+      return err
+    }
+  }
+  if len(n.config.Username) > 0 {
+    auth := smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+    if err := client.Auth(auth); err != nil {
+      return err
+    }
+  }
+  if err := client.Mail(n.config.From); err != nil {
+    return err
+  }
+  if err := client.Rcpt(message.To); err != nil {
+    return err
+  }
+  w, err := client.Data()
+  if err != nil {
+    return err
+  }
+  if _, err := w.Write(n.format(message)); err != nil {
+    return err
+  }
+  if err := w.Close(); err != nil {
+    return err
+  }
+  return client.Quit()
+}
+
+func (n smtpNotifier) format(message Message) []byte {
+  var b strings.Builder
+  fmt.Fprintf(&b, "From: %s\r\n", n.config.From)
+  fmt.Fprintf(&b, "To: %s\r\n", message.To)
+  fmt.Fprintf(&b, "Subject: %s\r\n", strings.ReplaceAll(message.Subject, "\n", " "))
+  fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+  b.WriteString("MIME-Version: 1.0\r\n")
+  if len(message.HTML) == 0 {
+    b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+    b.WriteString(strings.ReplaceAll(message.Text, "\n", "\r\n"))
+    b.WriteString("\r\n")
+    return []byte(b.String())
+  }
+
+  // HTML lines may be longer than SMTP allows, so it is base64 encoded
+  parts := multipart.NewWriter(&b)
+  fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+  text, _ := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+  text.Write([]byte(strings.ReplaceAll(message.Text, "\n", "\r\n")))
+  html, _ := parts.CreatePart(textproto.MIMEHeader{
+    "Content-Type": {"text/html; charset=UTF-8"},
+    "Content-Transfer-Encoding": {"base64"},
+  })
+  encoded := base64.StdEncoding.EncodeToString([]byte(message.HTML))
+  for len(encoded) > 76 {
+    html.Write([]byte(encoded[:76] + "\r\n"))
+    encoded = encoded[76:]
+  }
+  html.Write([]byte(encoded))
+  parts.Close()
+  return []byte(b.String())
+}
+
+func NewSMTPNotifier(config SMTPConfig) Notifier {
+  return smtpNotifier{config: config}
+}
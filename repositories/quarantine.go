@@ -0,0 +1,115 @@
+package repositories
+
+import (
+  "time"
+
+  "gorm.io/gorm"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+const unclaimed_devices_query string = `
+SELECT guid, MIN(measured_at) AS first_seen, MAX(measured_at) AS last_seen, COUNT(*) AS readings
+FROM quarantined_sensor_data WHERE deleted_at IS NULL GROUP BY guid ORDER BY last_seen DESC;
+`
+
+const unclaimed_samples_query string = `
+SELECT guid, co2, tvoc, battery_charge, measured_at FROM (
+  SELECT *, ROW_NUMBER() OVER (PARTITION BY guid ORDER BY measured_at DESC) AS position
+  FROM quarantined_sensor_data WHERE deleted_at IS NULL
+) AS samples WHERE position <= ? ORDER BY guid, measured_at DESC;
+`
+
+const import_quarantined_query string = `
+INSERT INTO sensor_data (created_at, updated_at, guid, co2, tvoc, battery_charge, measured_at)
+SELECT NOW(), NOW(), guid, co2, tvoc, battery_charge, measured_at
+FROM quarantined_sensor_data WHERE guid = ? AND deleted_at IS NULL
+ON CONFLICT DO NOTHING;
+`
+
+type dbUnclaimedDevice struct {
+  Guid string
+  FirstSeen time.Time
+  LastSeen time.Time
+  Readings int
+}
+
+type dbUnclaimedSample struct {
+  Guid string
+  Co2 int
+  Tvoc int
+  BatteryCharge int
+  MeasuredAt time.Time
+}
+
+type QuarantineRepository interface {
+  FindDevices(samples int) ([]schemas.UnclaimedDeviceSchema, error)
+  Import(guid string) error
+  Delete(guid string) error
+  DeleteOlderThan(before time.Time) error
+  // WithTx returns repository running its queries in transaction tx
+  WithTx(tx *gorm.DB) QuarantineRepository
+}
+
+type quarantineRepository struct {
+  baseRepository
+}
+
+func (s quarantineRepository) FindDevices(samples int) ([]schemas.UnclaimedDeviceSchema, error) {
+  var devices []dbUnclaimedDevice
+  if err := s.db.Raw(unclaimed_devices_query).Scan(&devices).Error; err != nil {
+    return nil, err
+  }
+  var rows []dbUnclaimedSample
+  if err := s.db.Raw(unclaimed_samples_query, samples).Scan(&rows).Error; err != nil {
+    return nil, err
+  }
+
+  byGuid := make(map[string][]schemas.UnclaimedReadingSchema, len(devices))
+  for _, row := range rows {
+    byGuid[row.Guid] = append(
+      byGuid[row.Guid],
+      schemas.UnclaimedReadingSchema{Co2: row.Co2, Tvoc: row.Tvoc, BatteryCharge: row.BatteryCharge, MeasuredAt: row.MeasuredAt},
+    )
+  }
+  resp := make([]schemas.UnclaimedDeviceSchema, 0, len(devices))
+  for _, device := range devices {
+    resp = append(
+      resp,
+      schemas.UnclaimedDeviceSchema{
+        Guid: device.Guid,
+        FirstSeen: device.FirstSeen,
+        LastSeen: device.LastSeen,
+        Readings: device.Readings,
+        Samples: byGuid[device.Guid],
+      },
+    )
+  }
+  return resp, nil
+}
+
+// Import moves quarantined readings of guid into sensor data
+func (s quarantineRepository) Import(guid string) error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    if err := tx.Exec(import_quarantined_query, guid).Error; err != nil {
+      return err
+    }
+    return tx.Unscoped().Where("guid = ?", guid).Delete(&models.QuarantinedSensorData{}).Error
+  })
+}
+
+func (s quarantineRepository) Delete(guid string) error {
+  return s.db.Unscoped().Where("guid = ?", guid).Delete(&models.QuarantinedSensorData{}).Error
+}
+
+func (s quarantineRepository) DeleteOlderThan(before time.Time) error {
+  return s.db.Unscoped().Where("measured_at < ?", before).Delete(&models.QuarantinedSensorData{}).Error
+}
+
+func (s quarantineRepository) WithTx(tx *gorm.DB) QuarantineRepository {
+  return quarantineRepository{baseRepository: baseRepository{db: tx}}
+}
+
+func NewQuarantineRepository(db *gorm.DB) QuarantineRepository {
+  return quarantineRepository{baseRepository: baseRepository{db: db}}
+}
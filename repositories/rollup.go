@@ -0,0 +1,72 @@
+package repositories
+
+import (
+  "fmt"
+  "strings"
+  "time"
+)
+
+// RollupResolution is rollup table with buckets of Size
+type RollupResolution struct {
+  Table string
+  Size time.Duration
+}
+
+// RollupResolutions are rollup tables, the coarsest first
+var RollupResolutions = []RollupResolution{
+  {Table: "sensor_data_days", Size: 24 * time.Hour},
+  {Table: "sensor_data_hours", Size: time.Hour},
+  {Table: "sensor_data_minutes", Size: time.Minute},
+}
+
+// RollupPart is interval [From, To) served by rollup table, or by raw sensor
+// data when Table is empty
+type RollupPart struct {
+  Table string
+  From time.Time
+  To time.Time
+}
+
+// rollup_part_query selects rollup rows of part with sensor, room and bucket
+// start. %s are replaced by rollup table and conditions.
+const rollup_part_query string = `
+SELECT sensors.room_id, sensors.id AS sensor_id, r.bucket_at AS at, r.count,
+  r.co2_sum, r.co2_min, r.co2_max, r.co2_squares, r.tvoc_sum, r.tvoc_min, r.tvoc_max, r.tvoc_squares
+FROM %s r JOIN sensors ON r.guid = sensors.guid JOIN rooms ON rooms.id = sensors.room_id
+WHERE %s AND r.bucket_at >= @%s AND r.bucket_at < @%s`
+
+// raw_part_query selects readings in the shape of rollup rows
+const raw_part_query string = `
+SELECT sensors.room_id, sensors.id AS sensor_id, sensor_data.measured_at AS at, 1 AS count,
+  sensor_data.co2::int8 AS co2_sum, sensor_data.co2 AS co2_min, sensor_data.co2 AS co2_max,
+  sensor_data.co2::float8 * sensor_data.co2 AS co2_squares,
+  sensor_data.tvoc::int8 AS tvoc_sum, sensor_data.tvoc AS tvoc_min, sensor_data.tvoc AS tvoc_max,
+  sensor_data.tvoc::float8 * sensor_data.tvoc AS tvoc_squares
+FROM sensor_data JOIN sensors ON sensor_data.guid = sensors.guid JOIN rooms ON rooms.id = sensors.room_id
+WHERE sensor_data.deleted_at IS NULL AND %s AND sensor_data.measured_at >= @%s AND sensor_data.measured_at < @%s%s`
+
+// rollupPartsQuery unions rows of parts within scope condition. Rollup parts
+// add readings not rolled up yet, which have id above watermark, so result is
+// the same as of raw sensor data.
+func rollupPartsQuery(parts []RollupPart, scope string, watermark uint, args map[string]interface{}) string {
+  args["watermark"] = watermark
+  queries := make([]string, 0, len(parts) * 2)
+  for i, part := range parts {
+    from, to := fmt.Sprintf("part_from_%d", i), fmt.Sprintf("part_to_%d", i)
+    args[from] = part.From
+    args[to] = part.To
+    if len(part.Table) == 0 {
+      queries = append(queries, fmt.Sprintf(raw_part_query, scope, from, to, ""))
+      continue
+    }
+    queries = append(
+      queries,
+      fmt.Sprintf(rollup_part_query, part.Table, scope, from, to),
+      fmt.Sprintf(raw_part_query, scope, from, to, " AND sensor_data.id > @watermark"),
+    )
+  }
+  if len(queries) == 0 {
+    return fmt.Sprintf(raw_part_query, "false", "watermark", "watermark", "")
+  }
+  return strings.Join(queries, "\nUNION ALL")
+}
@@ -1,35 +1,186 @@
 package repositories
 
 import (
-  "log"
+  "fmt"
   "strconv"
+  "strings"
   "gorm.io/gorm"
   models "antivape/db"
   "antivape/schemas"
 )
 
-const room_statistic_query string = `
-SELECT AVG(sensor_data.co2) AS co2, AVG(sensor_data.tvoc) AS tvoc, sensors.room_id
-FROM sensor_data JOIN sensors ON sensor_data.guid=sensors.guid
-WHERE sensor_data.guid in (select sensors.guid from sensors where sensors.room_id=?) GROUP BY sensors.room_id;
+// Rooms are left joined, so room without readings in the interval has NULL
+// aggregates and zero samples. Span is seconds until next reading of the same
+// sensor, or until interval end for the last one, capped by max gap. It is
+// time reading represents, used for time above threshold and time weighting.
+// %s are replaced by aggregate columns, readings scope, interval conditions
+// and room scope.
+const statistic_query string = `
+WITH readings AS (` + readings_query + `)
+SELECT rooms.id AS room_id, COUNT(readings.id) AS samples%[1]s
+FROM rooms
+LEFT JOIN readings ON readings.room_id = rooms.id
+WHERE rooms.deleted_at IS NULL AND %[4]s
+GROUP BY rooms.id ORDER BY rooms.id;
 `
 
-const zone_statistic_query string = `
-SELECT AVG(sensor_data.co2) AS co2, AVG(sensor_data.tvoc) AS tvoc, sensors.room_id
-FROM sensor_data JOIN sensors ON sensor_data.guid=sensors.guid
-WHERE sensor_data.guid in (select sensors.guid from sensors where sensors.zone_id=?) GROUP BY sensors.room_id;
+// readings_query selects readings with span, %[2]s and %[3]s are replaced by
+// readings scope and interval conditions
+const readings_query string = `
+  SELECT sensors.room_id, sensor_data.id, sensor_data.co2, sensor_data.tvoc,
+    GREATEST(LEAST(
+      EXTRACT(EPOCH FROM COALESCE(
+        LEAD(sensor_data.measured_at) OVER (PARTITION BY sensor_data.guid ORDER BY sensor_data.measured_at),
+        @end
+      ) - sensor_data.measured_at),
+      @max_gap
+    ), 0) AS span
+  FROM sensor_data
+  JOIN sensors ON sensor_data.guid = sensors.guid
+  JOIN rooms ON rooms.id = sensors.room_id
+  WHERE sensor_data.deleted_at IS NULL AND %[2]s%[3]s
 `
 
+// band_spans_query sums spans of readings by room and band indexes of co2
+// and tvoc, %[1]s and %[4]s are replaced by co2 and tvoc band bounds
+const band_spans_query string = `
+WITH readings AS (` + readings_query + `)
+SELECT readings.room_id,
+  width_bucket(readings.co2, ARRAY[%[1]s]::float8[]) AS co2_band,
+  width_bucket(readings.tvoc, ARRAY[%[4]s]::float8[]) AS tvoc_band,
+  SUM(readings.span)::float8 AS seconds
+FROM readings
+GROUP BY 1, 2, 3 ORDER BY 1, 2, 3;
+`
+
+// statistic_aggregates are columns of aggregates over %[1]s metric column,
+// scanned into <metric>_<aggregate> fields
+var statistic_aggregates = map[string]string{
+  schemas.AggregateAvg: "AVG(readings.%[1]s)::float8",
+  schemas.AggregateMin: "MIN(readings.%[1]s)::float8",
+  schemas.AggregateMax: "MAX(readings.%[1]s)::float8",
+  schemas.AggregateMedian: "percentile_cont(0.5) WITHIN GROUP (ORDER BY readings.%[1]s)",
+  schemas.AggregateP95: "percentile_cont(0.95) WITHIN GROUP (ORDER BY readings.%[1]s)",
+  schemas.AggregateStddev: "stddev_pop(readings.%[1]s)::float8",
+  schemas.AggregateTimeAbove: "COALESCE(SUM(readings.span) FILTER (WHERE readings.%[1]s > @%[1]s_above), 0)::float8",
+}
+
+// time_weighted_aggregates replace sample aggregates in time weighting, every
+// reading weighs its span
+var time_weighted_aggregates = map[string]string{
+  schemas.AggregateAvg: "(SUM(readings.%[1]s * readings.span) / NULLIF(SUM(readings.span), 0))::float8",
+  schemas.AggregateStddev: `sqrt(GREATEST(
+    SUM(readings.%[1]s::float8 * readings.%[1]s * readings.span) / NULLIF(SUM(readings.span), 0)
+      - (SUM(readings.%[1]s * readings.span) / NULLIF(SUM(readings.span), 0))::float8 ^ 2,
+    0
+  ))`,
+}
+
+// %s are replaced by series key columns and filter conditions, buckets are
+// aligned to unix epoch
+const series_query string = `
+SELECT %s, to_timestamp(floor(extract(epoch FROM sensor_data.measured_at) / @bucket) * @bucket) AS at,
+  COUNT(*) AS count,
+  AVG(sensor_data.co2)::float8 AS co2_avg, MIN(sensor_data.co2)::float8 AS co2_min, MAX(sensor_data.co2)::float8 AS co2_max,
+  AVG(sensor_data.tvoc)::float8 AS tvoc_avg, MIN(sensor_data.tvoc)::float8 AS tvoc_min, MAX(sensor_data.tvoc)::float8 AS tvoc_max
+FROM sensor_data JOIN sensors ON sensor_data.guid = sensors.guid
+WHERE sensor_data.deleted_at IS NULL AND sensor_data.measured_at >= @from AND sensor_data.measured_at < @to%s
+GROUP BY %s, at ORDER BY %s, at;
+`
+
+// rollup_statistic_query is statistic_query over rollup parts, %s are replaced
+// by aggregate columns, parts and room scope
+const rollup_statistic_query string = `
+WITH parts AS (%[2]s
+)
+SELECT rooms.id AS room_id, COALESCE(SUM(parts.count), 0) AS samples%[1]s
+FROM rooms
+LEFT JOIN parts ON parts.room_id = rooms.id
+WHERE rooms.deleted_at IS NULL AND %[3]s
+GROUP BY rooms.id ORDER BY rooms.id;
+`
+
+// rollup_aggregates are aggregates which can be computed from rollups
+var rollup_aggregates = map[string]string{
+  schemas.AggregateAvg: "(SUM(parts.%[1]s_sum)::float8 / NULLIF(SUM(parts.count), 0))",
+  schemas.AggregateMin: "MIN(parts.%[1]s_min)::float8",
+  schemas.AggregateMax: "MAX(parts.%[1]s_max)::float8",
+  schemas.AggregateStddev: `sqrt(GREATEST(
+    SUM(parts.%[1]s_squares) / NULLIF(SUM(parts.count), 0) - (SUM(parts.%[1]s_sum)::float8 / NULLIF(SUM(parts.count), 0)) ^ 2,
+    0
+  ))`,
+}
+
+// rollup_series_query is series_query over rollup parts, %s are replaced by
+// parts and series key columns
+const rollup_series_query string = `
+WITH parts AS (%[1]s
+)
+SELECT %[2]s, to_timestamp(floor(extract(epoch FROM parts.at) / @bucket) * @bucket) AS at,
+  SUM(parts.count) AS count,
+  (SUM(parts.co2_sum)::float8 / SUM(parts.count)) AS co2_avg, MIN(parts.co2_min)::float8 AS co2_min, MAX(parts.co2_max)::float8 AS co2_max,
+  (SUM(parts.tvoc_sum)::float8 / SUM(parts.count)) AS tvoc_avg, MIN(parts.tvoc_min)::float8 AS tvoc_min, MAX(parts.tvoc_max)::float8 AS tvoc_max
+FROM parts
+GROUP BY %[3]s, at ORDER BY %[3]s, at;
+`
+
+// BandSpan is seconds room readings spent in co2 and tvoc bands of indexes
+type BandSpan struct {
+  RoomID uint
+  Co2Band int
+  TvocBand int
+  Seconds float64
+}
+
+type dbSeriesSchema struct {
+  RoomID uint
+  SensorID uint
+  schemas.SeriesPointSchema
+}
+
 type dbDataSchema struct {
-  Co2 string
-  Tvoc string
-  RoomID string
+  RoomID uint
+  Samples int
+  Co2Avg *float64
+  Co2Min *float64
+  Co2Max *float64
+  Co2Median *float64
+  Co2P95 *float64
+  Co2Stddev *float64
+  Co2TimeAbove *float64
+  TvocAvg *float64
+  TvocMin *float64
+  TvocMax *float64
+  TvocMedian *float64
+  TvocP95 *float64
+  TvocStddev *float64
+  TvocTimeAbove *float64
+}
+
+func (s dbDataSchema) aggregates(metric string, aggregates []string) *schemas.AggregatesSchema {
+  if len(aggregates) == 0 {
+    return nil
+  }
+  values := schemas.AggregatesSchema{}
+  if metric == "co2" {
+    values = schemas.AggregatesSchema{
+      Avg: s.Co2Avg, Min: s.Co2Min, Max: s.Co2Max, Median: s.Co2Median, P95: s.Co2P95, Stddev: s.Co2Stddev, TimeAbove: s.Co2TimeAbove,
+    }
+  } else {
+    values = schemas.AggregatesSchema{
+      Avg: s.TvocAvg, Min: s.TvocMin, Max: s.TvocMax, Median: s.TvocMedian, P95: s.TvocP95, Stddev: s.TvocStddev, TimeAbove: s.TvocTimeAbove,
+    }
+  }
+  values.Count = s.Samples
+  return &values
 }
 
 type SensorDataRepository interface {
   Take(sensorDataID uint) models.SensorData
   Create(guid string, co2, tvoc, batteryCharge int) models.SensorData
   GetStatistic(filters map[string]interface{}) []schemas.SensorDataRoomSchema
+  GetBandSpans(filters map[string]interface{}) []BandSpan
+  GetSeries(filters map[string]interface{}) []schemas.SeriesSchema
   Delete(sensorDataID uint)
 }
 
@@ -55,28 +206,184 @@ func (s sensorDataRepository) Create(guid string, co2, tvoc, batteryCharge int)
   return model
 }
 
+// GetStatistic aggregates readings by room of room_id or zone_id filter, or
+// readings of sensor_id filter in its room, measured in optional [from, to)
+// interval, or in rollup parts filter with watermark. Every room is in result,
+// with zero samples if it has no readings. Averages are always computed, aggregates
+// filter selects other aggregates, time_above needs co2_above or tvoc_above
+// threshold of metric. With time weighting avg and stddev weigh every reading
+// by its span instead of counting readings equally.
 func (s sensorDataRepository) GetStatistic(filters map[string]interface{}) []schemas.SensorDataRoomSchema {
-  statistic := make([]dbDataSchema, 0)
-  if roomID, ok := filters["room_id"]; ok {
-    var schema dbDataSchema
-    s.baseRepository.db.Raw(room_statistic_query, roomID).Take(&schema)
-    statistic = append(statistic, schema)
-  } else if zoneID, ok := filters["zone_id"]; ok {
-    s.baseRepository.db.Raw(zone_statistic_query, zoneID).Find(&statistic)
+  args := map[string]interface{}{"max_gap": filters["max_gap"], "end": filters["end"]}
+  scope, readingsScope, ok := statisticScope(filters, args)
+  if !ok {
+    return []schemas.SensorDataRoomSchema{}
+  }
+  interval := statisticInterval(filters, args)
+
+  aggregates, _ := filters["aggregates"].([]string)
+  parts, useRollups := filters["parts"].([]RollupPart)
+  columns := ""
+  for _, metric := range []string{"co2", "tvoc"} {
+    threshold, hasThreshold := filters[metric + "_above"]
+    selected := make(map[string]bool)
+    for _, aggregate := range append([]string{schemas.AggregateAvg}, aggregates...) {
+      column, ok := statistic_aggregates[aggregate]
+      if weighted, isWeighted := time_weighted_aggregates[aggregate]; isWeighted && filters["weighting"] == schemas.WeightingTime {
+        column = weighted
+      }
+      if useRollups {
+        if column, ok = rollup_aggregates[aggregate]; !ok {
+          continue
+        }
+      }
+      if !ok || selected[aggregate] || (aggregate == schemas.AggregateTimeAbove && !hasThreshold) {
+        continue
+      }
+      selected[aggregate] = true
+      columns += fmt.Sprintf(", " + column + " AS %[1]s_%[2]s", metric, aggregate)
+    }
+    if hasThreshold {
+      args[metric + "_above"] = threshold
+    }
   }
+
+  query := fmt.Sprintf(statistic_query, columns, readingsScope, interval, scope)
+  if useRollups {
+    watermark, _ := filters["watermark"].(uint)
+    query = fmt.Sprintf(rollup_statistic_query, columns, rollupPartsQuery(parts, readingsScope, watermark, args), scope)
+  }
+  statistic := make([]dbDataSchema, 0)
+  s.baseRepository.db.Raw(query, args).Scan(&statistic)
   resp := make([]schemas.SensorDataRoomSchema, 0, len(statistic))
   for _, schema := range statistic {
-    co2, _ := strconv.ParseFloat(schema.Co2, 64)
-    tvoc, _ := strconv.ParseFloat(schema.Tvoc, 64)
-    roomID, _ := strconv.Atoi(schema.RoomID)
-    resp = append(
-      resp,
-      schemas.SensorDataRoomSchema{Co2: int(co2), Tvoc: int(tvoc), RoomID: uint(roomID)},
-    )
+    var co2, tvoc float64
+    if schema.Co2Avg != nil {
+      co2 = *schema.Co2Avg
+    }
+    if schema.TvocAvg != nil {
+      tvoc = *schema.TvocAvg
+    }
+    resp = append(resp, schemas.SensorDataRoomSchema{
+      Co2: int(co2),
+      Tvoc: int(tvoc),
+      RoomID: schema.RoomID,
+      Samples: schema.Samples,
+      Co2Aggregates: schema.aggregates("co2", aggregates),
+      TvocAggregates: schema.aggregates("tvoc", aggregates),
+    })
   }
   return resp
 }
 
+// GetBandSpans sums spans of readings scoped like in GetStatistic by room and
+// indexes of their co2 and tvoc bands, bands are bounded by co2_bounds and
+// tvoc_bounds filters
+func (s sensorDataRepository) GetBandSpans(filters map[string]interface{}) []BandSpan {
+  args := map[string]interface{}{"max_gap": filters["max_gap"], "end": filters["end"]}
+  _, readingsScope, ok := statisticScope(filters, args)
+  if !ok {
+    return []BandSpan{}
+  }
+  interval := statisticInterval(filters, args)
+  co2Bounds, _ := filters["co2_bounds"].([]float64)
+  tvocBounds, _ := filters["tvoc_bounds"].([]float64)
+
+  query := fmt.Sprintf(band_spans_query, sqlFloats(co2Bounds), readingsScope, interval, sqlFloats(tvocBounds))
+  spans := make([]BandSpan, 0)
+  s.baseRepository.db.Raw(query, args).Scan(&spans)
+  return spans
+}
+
+// statisticScope returns room scope and readings scope conditions of room_id,
+// zone_id or sensor_id filter, false if there is none
+func statisticScope(filters map[string]interface{}, args map[string]interface{}) (string, string, bool) {
+  if roomID, ok := filters["room_id"]; ok {
+    args["scope"] = roomID
+    return "rooms.id = @scope", "rooms.id = @scope", true
+  } else if zoneID, ok := filters["zone_id"]; ok {
+    args["scope"] = zoneID
+    return "rooms.zone_id = @scope", "rooms.zone_id = @scope", true
+  } else if sensorID, ok := filters["sensor_id"]; ok {
+    args["scope"] = sensorID
+    scope := "rooms.id = (SELECT room_id FROM sensors WHERE id = @scope)"
+    return scope, scope + " AND sensors.id = @scope", true
+  }
+  return "", "", false
+}
+
+// statisticInterval returns conditions of optional from and to filters
+func statisticInterval(filters map[string]interface{}, args map[string]interface{}) string {
+  interval := ""
+  if from, ok := filters["from"]; ok {
+    interval += " AND sensor_data.measured_at >= @from"
+    args["from"] = from
+  }
+  if to, ok := filters["to"]; ok {
+    interval += " AND sensor_data.measured_at < @to"
+    args["to"] = to
+  }
+  return interval
+}
+
+// sqlFloats formats numbers as SQL list
+func sqlFloats(numbers []float64) string {
+  items := make([]string, 0, len(numbers))
+  for _, number := range numbers {
+    items = append(items, strconv.FormatFloat(number, 'g', -1, 64))
+  }
+  return strings.Join(items, ", ")
+}
+
+// GetSeries groups readings measured in [from, to) filters, or in rollup parts
+// filter with watermark, into buckets of bucket seconds by room of room_id or
+// by sensor of room_id or sensor_id. Buckets without readings are omitted.
+func (s sensorDataRepository) GetSeries(filters map[string]interface{}) []schemas.SeriesSchema {
+  key := "sensors.room_id"
+  if filters["by_sensor"] == true {
+    key = "sensors.room_id, sensors.id"
+  }
+  condition := ""
+  args := map[string]interface{}{"bucket": filters["bucket"], "from": filters["from"], "to": filters["to"]}
+  if roomID, ok := filters["room_id"]; ok {
+    condition = " AND sensors.room_id = @room"
+    args["room"] = roomID
+  } else if sensorID, ok := filters["sensor_id"]; ok {
+    condition = " AND sensors.id = @sensor"
+    args["sensor"] = sensorID
+  }
+  columns := "sensors.room_id AS room_id"
+  if filters["by_sensor"] == true {
+    columns += ", sensors.id AS sensor_id"
+  }
+
+  query := fmt.Sprintf(series_query, columns, condition, key, key)
+  if parts, ok := filters["parts"].([]RollupPart); ok {
+    watermark, _ := filters["watermark"].(uint)
+    scope := "true" + condition
+    columns = strings.ReplaceAll(strings.ReplaceAll(columns, "sensors.room_id", "parts.room_id"), "sensors.id", "parts.sensor_id")
+    key = strings.ReplaceAll(strings.ReplaceAll(key, "sensors.room_id", "parts.room_id"), "sensors.id", "parts.sensor_id")
+    query = fmt.Sprintf(rollup_series_query, rollupPartsQuery(parts, scope, watermark, args), columns, key)
+  }
+  rows := make([]dbSeriesSchema, 0)
+  s.baseRepository.db.Raw(query, args).Scan(&rows)
+  series := make([]schemas.SeriesSchema, 0)
+  for _, row := range rows {
+    last := len(series) - 1
+    if last < 0 || series[last].RoomID != row.RoomID || (row.SensorID != 0 && *series[last].SensorID != row.SensorID) {
+      line := schemas.SeriesSchema{RoomID: row.RoomID, Points: make([]schemas.SeriesPointSchema, 0)}
+      if row.SensorID != 0 {
+        sensorID := row.SensorID
+        line.SensorID = &sensorID
+      }
+      series = append(series, line)
+      last++
+    }
+    series[last].Points = append(series[last].Points, row.SeriesPointSchema)
+  }
+  return series
+}
+
 func (s sensorDataRepository) Update(sensorDataID uint, fields map[string]interface{}) {
   s.update(&models.SensorData{}, sensorDataID, fields)
 }
@@ -0,0 +1,50 @@
+package schemas
+
+const (
+  BandExcellent = "excellent"
+  BandGood = "good"
+  BandModerate = "moderate"
+  BandPoor = "poor"
+  BandUnhealthy = "unhealthy"
+)
+
+// Bands are air quality bands from the best one
+var Bands = []string{BandExcellent, BandGood, BandModerate, BandPoor, BandUnhealthy}
+
+// BandFindSchema is time interval like of StatisticFindSchema
+type BandFindSchema struct {
+  // RFC3339, readings measured in [from, to)
+  From string `json:"from,omitempty" query:"from"`
+  To string `json:"to,omitempty" query:"to"`
+  // Interval before now like 30m, 1h or 7d
+  Last string `json:"last,omitempty" query:"last"`
+}
+
+func (s BandFindSchema) Validate() error {
+  return s.Statistic().Validate()
+}
+
+// Statistic returns statistic find schema of the same interval
+func (s BandFindSchema) Statistic() StatisticFindSchema {
+  return StatisticFindSchema{From: s.From, To: s.To, Last: s.Last}
+}
+
+type BandShareSchema struct {
+  Band string `json:"band" binding:"required"`
+  Seconds float64 `json:"seconds" binding:"required"`
+  // Part of room seconds, from 0 to 1
+  Share float64 `json:"share" binding:"required"`
+}
+
+// RoomBandsSchema is time room spent in every band, with share of each band
+// listed from excellent to unhealthy
+type RoomBandsSchema struct {
+  RoomID uint `json:"room_id" binding:"required"`
+  // Seconds covered by readings summed over room sensors, gaps between
+  // readings longer than 5 minutes aren't covered
+  Seconds float64 `json:"seconds" binding:"required"`
+  Co2 []BandShareSchema `json:"co2" binding:"required"`
+  Tvoc []BandShareSchema `json:"tvoc" binding:"required"`
+  // Band of reading is the worse of its co2 and tvoc bands
+  Overall []BandShareSchema `json:"overall" binding:"required"`
+}
@@ -0,0 +1,33 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+type BatterySchema struct {
+  SensorID uint `json:"sensor_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  // Percent in the last reading
+  Level int `json:"level" binding:"required"`
+  LastSeenAt *time.Time `json:"last_seen_at"`
+  // Percents per day, empty when there is too little data or battery doesn't discharge
+  DischargeRate *float64 `json:"discharge_rate"`
+  DaysUntilEmpty *float64 `json:"days_until_empty"`
+  EmptyAt *time.Time `json:"empty_at"`
+  // Level is below DEVICE_BATTERY_LOW
+  Low bool `json:"low" binding:"required"`
+}
+
+type BatteryFindSchema struct {
+  // Batteries which are low or get empty within this many days, 7 by default
+  Days int `json:"days,omitempty" query:"days"`
+}
+
+func (s BatteryFindSchema) Validate() error {
+  if s.Days < 0 {
+    return errors.New("days must not be negative")
+  }
+  return nil
+}
@@ -0,0 +1,151 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+const (
+  CompareCo2 = "co2"
+  CompareTvoc = "tvoc"
+  CompareEpisodes = "episodes"
+)
+
+// ComparisonFindSchema compares statistic of interval with previous interval,
+// by default the one of the same length right before it
+type ComparisonFindSchema struct {
+  // RFC3339, readings measured in [from, to)
+  From string `json:"from,omitempty" query:"from"`
+  To string `json:"to,omitempty" query:"to"`
+  // Interval before now like 1h or 30d, instead of from and to
+  Last string `json:"last,omitempty" query:"last"`
+  // RFC3339, previous interval [previous_from, previous_to)
+  PreviousFrom string `json:"previous_from,omitempty" query:"previous_from"`
+  PreviousTo string `json:"previous_to,omitempty" query:"previous_to"`
+  Aggregates string `json:"aggregates,omitempty" query:"aggregates"`
+  Co2Above *float64 `json:"co2_above,omitempty" query:"co2_above"`
+  TvocAbove *float64 `json:"tvoc_above,omitempty" query:"tvoc_above"`
+  Weighting string `json:"weighting,omitempty" query:"weighting"`
+  // Metric rooms are ranked by change of: co2, tvoc (default) or episodes
+  Rank string `json:"rank,omitempty" query:"rank"`
+}
+
+func (s ComparisonFindSchema) Validate() error {
+  switch s.Rank {
+  case "", CompareCo2, CompareTvoc, CompareEpisodes:
+  default:
+    return errors.New("rank must be co2, tvoc or episodes")
+  }
+  window, err := s.Windows(time.Now())
+  if err != nil {
+    return err
+  }
+  return s.Statistic(window.From, window.To).Validate()
+}
+
+// Windows resolves both intervals at now, interval must be bounded
+func (s ComparisonFindSchema) Windows(now time.Time) (ComparisonWindowSchema, error) {
+  interval := StatisticFindSchema{From: s.From, To: s.To, Last: s.Last}
+  from, to, err := interval.Range(now)
+  if err != nil {
+    return ComparisonWindowSchema{}, err
+  }
+  if from == nil || to == nil {
+    return ComparisonWindowSchema{}, errors.New("from and to or last is required")
+  }
+  window := ComparisonWindowSchema{From: *from, To: *to}
+
+  if len(s.PreviousFrom) == 0 && len(s.PreviousTo) == 0 {
+    window.PreviousTo = window.From
+    window.PreviousFrom = window.From.Add(-window.To.Sub(window.From))
+    return window, nil
+  }
+  previous := StatisticFindSchema{From: s.PreviousFrom, To: s.PreviousTo}
+  previousFrom, previousTo, err := previous.Range(now)
+  if err != nil {
+    return ComparisonWindowSchema{}, errors.New("previous_" + err.Error())
+  }
+  if previousFrom == nil || previousTo == nil {
+    return ComparisonWindowSchema{}, errors.New("previous_from and previous_to must be set together")
+  }
+  window.PreviousFrom = *previousFrom
+  window.PreviousTo = *previousTo
+  return window, nil
+}
+
+// Statistic returns statistic filters of [from, to)
+func (s ComparisonFindSchema) Statistic(from time.Time, to time.Time) StatisticFindSchema {
+  return StatisticFindSchema{
+    From: from.Format(time.RFC3339Nano),
+    To: to.Format(time.RFC3339Nano),
+    Aggregates: s.Aggregates,
+    Co2Above: s.Co2Above,
+    TvocAbove: s.TvocAbove,
+    Weighting: s.Weighting,
+  }
+}
+
+func (s ComparisonFindSchema) AggregateList() []string {
+  return StatisticFindSchema{Aggregates: s.Aggregates}.AggregateList()
+}
+
+// RankMetric returns metric rooms are ranked by
+func (s ComparisonFindSchema) RankMetric() string {
+  if len(s.Rank) == 0 {
+    return CompareTvoc
+  }
+  return s.Rank
+}
+
+type ComparisonWindowSchema struct {
+  From time.Time `json:"from" binding:"required"`
+  To time.Time `json:"to" binding:"required"`
+  PreviousFrom time.Time `json:"previous_from" binding:"required"`
+  PreviousTo time.Time `json:"previous_to" binding:"required"`
+}
+
+// ChangeSchema is value of interval against value of previous interval,
+// values are empty when interval has no readings
+type ChangeSchema struct {
+  Current *float64 `json:"current"`
+  Previous *float64 `json:"previous"`
+  // Current minus previous, empty when either is empty
+  Delta *float64 `json:"delta"`
+  // Delta in percents of previous, empty when previous is empty or zero
+  Percent *float64 `json:"percent"`
+}
+
+type RoomChangeSchema struct {
+  RoomID uint `json:"room_id" binding:"required"`
+  // Place of zone room by delta of ranked metric, the most grown first. 0
+  // when delta is empty, such rooms are the last, and for single room
+  Rank int `json:"rank" binding:"required"`
+  Current SensorDataRoomSchema `json:"current" binding:"required"`
+  Previous SensorDataRoomSchema `json:"previous" binding:"required"`
+  // Averages
+  Co2 ChangeSchema `json:"co2" binding:"required"`
+  Tvoc ChangeSchema `json:"tvoc" binding:"required"`
+  // Vape episodes started in interval
+  Episodes ChangeSchema `json:"episodes" binding:"required"`
+  // Changes of selected aggregates by aggregate name
+  Co2Aggregates map[string]ChangeSchema `json:"co2_aggregates,omitempty"`
+  TvocAggregates map[string]ChangeSchema `json:"tvoc_aggregates,omitempty"`
+}
+
+type RoomComparisonSchema struct {
+  ComparisonWindowSchema
+  RoomChangeSchema
+}
+
+type ZoneComparisonSchema struct {
+  ComparisonWindowSchema
+  ZoneID uint `json:"zone_id" binding:"required"`
+  // Metric rooms are ranked by
+  Rank string `json:"rank" binding:"required"`
+  // Averages of zone rooms weighted by their samples
+  Co2 ChangeSchema `json:"co2" binding:"required"`
+  Tvoc ChangeSchema `json:"tvoc" binding:"required"`
+  Episodes ChangeSchema `json:"episodes" binding:"required"`
+  // Rooms ordered by rank
+  Rooms []RoomChangeSchema `json:"rooms" binding:"required"`
+}
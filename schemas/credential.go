@@ -0,0 +1,14 @@
+package schemas
+
+import (
+  "time"
+)
+
+type SensorCredentialSchema struct {
+  SensorID uint `json:"sensor_id" binding:"required"`
+  Guid string `json:"guid" binding:"required"`
+  Secret string `json:"secret,omitempty"`
+  Active bool `json:"active" binding:"required"`
+  IssuedAt time.Time `json:"issued_at" binding:"required"`
+  RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
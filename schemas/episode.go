@@ -0,0 +1,27 @@
+package schemas
+
+import (
+  "time"
+)
+
+type VapeEpisodeSchema struct {
+  ID uint `json:"id" binding:"required"`
+  SensorID uint `json:"sensor_id" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  StartedAt time.Time `json:"started_at" binding:"required"`
+  // Empty while episode is ongoing
+  EndedAt *time.Time `json:"ended_at"`
+  PeakTvoc int `json:"peak_tvoc" binding:"required"`
+  PeakCo2 int `json:"peak_co2" binding:"required"`
+  BaselineTvoc int `json:"baseline_tvoc" binding:"required"`
+}
+
+type VapeEpisodeFindSchema struct {
+  SensorID *uint `json:"sensor_id,omitempty" query:"sensor_id"`
+  RoomID *uint `json:"room_id,omitempty" query:"room_id"`
+  ZoneID *uint `json:"zone_id,omitempty" query:"zone_id"`
+  // RFC3339, episodes started in [from, to)
+  From string `json:"from,omitempty" query:"from"`
+  To string `json:"to,omitempty" query:"to"`
+}
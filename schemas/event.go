@@ -0,0 +1,33 @@
+package schemas
+
+import (
+  "time"
+)
+
+const (
+  EventIncidentOpened = "incident.opened"
+  EventIncidentAcknowledged = "incident.acknowledged"
+  EventIncidentResolved = "incident.resolved"
+  // Incident stayed open longer than zone policy allows
+  EventIncidentEscalated = "incident.escalated"
+  EventSensorOffline = "sensor.offline"
+  EventSensorOnline = "sensor.online"
+  // Sensor reports the same values for too long, it may be covered or broken
+  EventSensorFlatline = "sensor.flatline"
+  EventSensorBatteryDrop = "sensor.battery_drop"
+  EventSensorBatteryLow = "sensor.battery_low"
+  EventTest = "test"
+)
+
+// EventSchema is published to subscribers and sent as webhook payload
+type EventSchema struct {
+  // incident.opened, incident.acknowledged, incident.resolved, incident.escalated,
+  // sensor.offline, sensor.online, sensor.flatline, sensor.battery_drop, sensor.battery_low or test
+  Type string `json:"type" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  RoomID uint `json:"room_id,omitempty"`
+  SensorID uint `json:"sensor_id,omitempty"`
+  OccurredAt time.Time `json:"occurred_at" binding:"required"`
+  // Incident for incident events, device event for sensor events
+  Data interface{} `json:"data,omitempty"`
+}
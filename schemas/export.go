@@ -0,0 +1,62 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+const (
+  ExportCSV = "csv"
+  ExportNDJSON = "ndjson"
+)
+
+// ExportFindSchema selects readings of one sensor, room or zone in time
+// interval like of StatisticFindSchema
+type ExportFindSchema struct {
+  SensorID *uint `json:"sensor_id,omitempty" query:"sensor_id"`
+  RoomID *uint `json:"room_id,omitempty" query:"room_id"`
+  ZoneID *uint `json:"zone_id,omitempty" query:"zone_id"`
+  // RFC3339, readings measured in [from, to)
+  From string `json:"from,omitempty" query:"from"`
+  To string `json:"to,omitempty" query:"to"`
+  // Interval before now like 30m, 1h or 7d
+  Last string `json:"last,omitempty" query:"last"`
+  // csv or ndjson, csv by default
+  Format string `json:"format,omitempty" query:"format"`
+}
+
+func (s ExportFindSchema) Validate() error {
+  scopes := 0
+  for _, id := range []*uint{s.SensorID, s.RoomID, s.ZoneID} {
+    if id != nil {
+      scopes++
+    }
+  }
+  if scopes != 1 {
+    return errors.New("one of sensor_id, room_id and zone_id must be set")
+  }
+  if len(s.Format) > 0 && s.Format != ExportCSV && s.Format != ExportNDJSON {
+    return errors.New("format must be csv or ndjson")
+  }
+  return s.Statistic().Validate()
+}
+
+// Statistic returns statistic find schema of the same interval
+func (s ExportFindSchema) Statistic() StatisticFindSchema {
+  return StatisticFindSchema{From: s.From, To: s.To, Last: s.Last}
+}
+
+// ExportRowSchema is reading with names of its sensor, room and zone
+type ExportRowSchema struct {
+  MeasuredAt time.Time `json:"measured_at"`
+  SensorID uint `json:"sensor_id"`
+  SensorName string `json:"sensor_name"`
+  Guid string `json:"guid"`
+  RoomID uint `json:"room_id"`
+  RoomName string `json:"room_name"`
+  ZoneID uint `json:"zone_id"`
+  ZoneName string `json:"zone_name"`
+  Co2 int `json:"co2"`
+  Tvoc int `json:"tvoc"`
+  BatteryCharge int `json:"battery_charge"`
+}
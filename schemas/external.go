@@ -2,7 +2,9 @@ package schemas
 
 import (
   "encoding/json"
+  "errors"
   "log"
+  "time"
 )
 
 type (
@@ -11,9 +13,40 @@ type (
     Co2 int `json:"co2" binding:"required" redis:"co2"`
     Tvoc int `json:"tvoc" binding:"required" redis:"tvoc"`
     BatteryCharge int `json:"batteryCharge" binding:"required" redis:"batteryCharge"`
+    // Time of measurement on device, receive time is used if it is omitted
+    MeasuredAt *time.Time `json:"measured_at,omitempty" redis:"-"`
+  }
+
+  ExternalBatchItemResultSchema struct {
+    Index int `json:"index" binding:"required"`
+    Guid string `json:"guid,omitempty"`
+    Accepted bool `json:"accepted" binding:"required"`
+    Error string `json:"error,omitempty"`
+  }
+
+  ExternalBatchResultSchema struct {
+    Accepted int `json:"accepted" binding:"required"`
+    Rejected int `json:"rejected" binding:"required"`
+    Items []ExternalBatchItemResultSchema `json:"items" binding:"required"`
   }
 )
 
+func (s ExternalSensorDataSchema) Validate() error {
+  if len(s.Guid) == 0 {
+    return errors.New("guid is required")
+  }
+  if s.Co2 < 0 {
+    return errors.New("co2 must not be negative")
+  }
+  if s.Tvoc < 0 {
+    return errors.New("tvoc must not be negative")
+  }
+  if s.BatteryCharge < 0 {
+    return errors.New("batteryCharge must not be negative")
+  }
+  return nil
+}
+
 func (s ExternalSensorDataSchema) MarshalBinary() ([]byte, error) {
 	return json.Marshal(s)
 }
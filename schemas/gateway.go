@@ -0,0 +1,25 @@
+package schemas
+
+import (
+  "time"
+)
+
+type GatewayCreateSchema struct {
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+}
+
+type GatewaySchema struct {
+  ID uint `json:"id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+  // Sent by gateway in X-Gateway-Guid header
+  Guid string `json:"guid" binding:"required"`
+  // Returned only on create and rotation
+  Secret string `json:"secret,omitempty"`
+  CreatedAt time.Time `json:"created_at" binding:"required"`
+}
+
+type GatewayFindSchema struct {
+  ZoneID *uint `json:"zone_id,omitempty" query:"zone_id"`
+}
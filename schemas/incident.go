@@ -0,0 +1,53 @@
+package schemas
+
+import (
+  "time"
+)
+
+const (
+  IncidentOpen = "open"
+  IncidentAcknowledged = "acknowledged"
+  IncidentResolved = "resolved"
+)
+
+type IncidentSchema struct {
+  ID uint `json:"id" binding:"required"`
+  SensorID uint `json:"sensor_id" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  // Rule which opened incident, empty for vape episodes
+  RuleID *uint `json:"rule_id"`
+  EpisodeID *uint `json:"episode_id"`
+  // info, warning or critical
+  Severity string `json:"severity" binding:"required"`
+  // open, acknowledged or resolved
+  State string `json:"state" binding:"required"`
+  StartedAt time.Time `json:"started_at" binding:"required"`
+  // Empty while condition lasts
+  EndedAt *time.Time `json:"ended_at"`
+  PeakTvoc int `json:"peak_tvoc" binding:"required"`
+  PeakCo2 int `json:"peak_co2" binding:"required"`
+  AcknowledgedAt *time.Time `json:"acknowledged_at"`
+  AcknowledgedBy *uint `json:"acknowledged_by"`
+  AcknowledgeNote string `json:"acknowledge_note,omitempty"`
+  ResolvedAt *time.Time `json:"resolved_at"`
+  ResolvedBy *uint `json:"resolved_by"`
+  ResolveNote string `json:"resolve_note,omitempty"`
+  // Triggers grouped into incident by zone policy
+  Occurrences int `json:"occurrences" binding:"required"`
+  EscalatedAt *time.Time `json:"escalated_at"`
+}
+
+type IncidentFindSchema struct {
+  SensorID *uint `json:"sensor_id,omitempty" query:"sensor_id"`
+  RoomID *uint `json:"room_id,omitempty" query:"room_id"`
+  ZoneID *uint `json:"zone_id,omitempty" query:"zone_id"`
+  State string `json:"state,omitempty" query:"state"`
+  // RFC3339, incidents started in [from, to)
+  From string `json:"from,omitempty" query:"from"`
+  To string `json:"to,omitempty" query:"to"`
+}
+
+type IncidentNoteSchema struct {
+  Note string `json:"note,omitempty"`
+}
@@ -0,0 +1,82 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+const (
+  ChannelEmail = "email"
+  ChannelSms = "sms"
+)
+
+type SubscriptionCreateSchema struct {
+  ZoneID uint `json:"zone_id" binding:"required"`
+  // Only events of this room, events of whole zone if empty
+  RoomID *uint `json:"room_id,omitempty"`
+  // email or sms
+  Channel string `json:"channel" binding:"required"`
+  // Email address or phone number
+  Target string `json:"target" binding:"required"`
+  // Event types to send, every type if empty
+  EventTypes []string `json:"event_types"`
+  Enabled *bool `json:"enabled,omitempty"`
+  // Subscription of escalation contact, it gets only escalated incidents
+  Escalation bool `json:"escalation"`
+}
+
+type SubscriptionUpdateSchema struct {
+  Target *string `json:"target,omitempty"`
+  EventTypes *[]string `json:"event_types,omitempty"`
+  Enabled *bool `json:"enabled,omitempty"`
+}
+
+type SubscriptionSchema struct {
+  ID uint `json:"id" binding:"required"`
+  UserID uint `json:"user_id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  RoomID *uint `json:"room_id"`
+  Channel string `json:"channel" binding:"required"`
+  Target string `json:"target" binding:"required"`
+  EventTypes []string `json:"event_types" binding:"required"`
+  Enabled bool `json:"enabled" binding:"required"`
+  Escalation bool `json:"escalation" binding:"required"`
+}
+
+type NotificationSchema struct {
+  ID uint `json:"id" binding:"required"`
+  SubscriptionID uint `json:"subscription_id" binding:"required"`
+  Channel string `json:"channel" binding:"required"`
+  Target string `json:"target" binding:"required"`
+  EventType string `json:"event_type" binding:"required"`
+  Subject string `json:"subject" binding:"required"`
+  Text string `json:"text" binding:"required"`
+  // pending, delivered, failed or suppressed
+  State string `json:"state" binding:"required"`
+  Attempts int `json:"attempts" binding:"required"`
+  // Pending notification is held until this time during quiet hours
+  NextAttemptAt time.Time `json:"next_attempt_at" binding:"required"`
+  LastError string `json:"last_error,omitempty"`
+  SentAt *time.Time `json:"sent_at"`
+  CreatedAt time.Time `json:"created_at" binding:"required"`
+}
+
+func (s SubscriptionCreateSchema) Validate() error {
+  if s.Channel != ChannelEmail && s.Channel != ChannelSms {
+    return errors.New("channel must be email or sms")
+  }
+  if len(s.Target) == 0 {
+    return errors.New("target is required")
+  }
+  return validateEventTypes(s.EventTypes)
+}
+
+func (s SubscriptionUpdateSchema) Validate() error {
+  if s.Target != nil && len(*s.Target) == 0 {
+    return errors.New("target is required")
+  }
+  if s.EventTypes != nil {
+    return validateEventTypes(*s.EventTypes)
+  }
+  return nil
+}
@@ -0,0 +1,44 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+type PolicySchema struct {
+  ZoneID uint `json:"zone_id"`
+  // Seconds after incident end while new trigger of the same rule and sensor joins it instead of opening new incident
+  GroupWindow int `json:"group_window"`
+  // Seconds after incident notification while notifications of next incidents in the same room are suppressed
+  Cooldown int `json:"cooldown"`
+  // HH:MM, notifications are held until quiet hours end. Both empty disable quiet hours
+  QuietStart string `json:"quiet_start,omitempty"`
+  QuietEnd string `json:"quiet_end,omitempty"`
+  // IANA time zone of quiet hours, UTC by default
+  Timezone string `json:"timezone,omitempty"`
+  // Notify about critical incidents during quiet hours too
+  QuietAllowCritical bool `json:"quiet_allow_critical"`
+  // Minutes incident may stay open before it is escalated to escalation contacts, 0 disables escalation
+  EscalateAfter int `json:"escalate_after"`
+}
+
+func (s PolicySchema) Validate() error {
+  if s.GroupWindow < 0 || s.Cooldown < 0 || s.EscalateAfter < 0 {
+    return errors.New("group_window, cooldown and escalate_after must not be negative")
+  }
+  if (len(s.QuietStart) == 0) != (len(s.QuietEnd) == 0) {
+    return errors.New("quiet_start and quiet_end must be set together")
+  }
+  if len(s.QuietStart) > 0 {
+    if _, err := time.Parse("15:04", s.QuietStart); err != nil {
+      return errors.New("quiet_start must be HH:MM")
+    }
+    if _, err := time.Parse("15:04", s.QuietEnd); err != nil {
+      return errors.New("quiet_end must be HH:MM")
+    }
+  }
+  if _, err := time.LoadLocation(s.Timezone); err != nil {
+    return errors.New("unknown timezone " + s.Timezone)
+  }
+  return nil
+}
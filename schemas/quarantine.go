@@ -0,0 +1,27 @@
+package schemas
+
+import (
+  "time"
+)
+
+type UnclaimedReadingSchema struct {
+  Co2 int `json:"co2" binding:"required"`
+  Tvoc int `json:"tvoc" binding:"required"`
+  BatteryCharge int `json:"batteryCharge" binding:"required"`
+  MeasuredAt time.Time `json:"measured_at" binding:"required"`
+}
+
+type UnclaimedDeviceSchema struct {
+  Guid string `json:"guid" binding:"required"`
+  FirstSeen time.Time `json:"first_seen" binding:"required"`
+  LastSeen time.Time `json:"last_seen" binding:"required"`
+  Readings int `json:"readings" binding:"required"`
+  Samples []UnclaimedReadingSchema `json:"samples" binding:"required"`
+}
+
+type UnclaimedDeviceClaimSchema struct {
+  Name string `json:"name" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  // Move quarantined readings into sensor data, otherwise they are discarded
+  ImportReadings bool `json:"import_readings"`
+}
@@ -0,0 +1,154 @@
+package schemas
+
+import (
+  "errors"
+  "net/mail"
+  "time"
+)
+
+const (
+  ReportDaily = "daily"
+  ReportWeekly = "weekly"
+  ReportMonthly = "monthly"
+)
+
+type ReportDefinitionCreateSchema struct {
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+  // daily, weekly (from monday) or monthly
+  Period string `json:"period" binding:"required"`
+  // IANA timezone of period bounds, UTC by default
+  Timezone string `json:"timezone,omitempty"`
+  // Emails report is mailed to, report is only stored if empty
+  Recipients []string `json:"recipients"`
+  // Rooms in report, every zone room if empty
+  RoomIDs []uint `json:"room_ids"`
+  Enabled *bool `json:"enabled,omitempty"`
+}
+
+type ReportDefinitionUpdateSchema struct {
+  Name *string `json:"name,omitempty"`
+  Period *string `json:"period,omitempty"`
+  Timezone *string `json:"timezone,omitempty"`
+  Recipients *[]string `json:"recipients,omitempty"`
+  RoomIDs *[]uint `json:"room_ids,omitempty"`
+  Enabled *bool `json:"enabled,omitempty"`
+}
+
+type ReportDefinitionSchema struct {
+  ID uint `json:"id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+  Period string `json:"period" binding:"required"`
+  Timezone string `json:"timezone" binding:"required"`
+  Recipients []string `json:"recipients" binding:"required"`
+  RoomIDs []uint `json:"room_ids" binding:"required"`
+  Enabled bool `json:"enabled" binding:"required"`
+  // End of period report is made for next
+  NextRunAt time.Time `json:"next_run_at" binding:"required"`
+  // Why report of the last period wasn't made, the period is skipped
+  LastError string `json:"last_error,omitempty"`
+}
+
+type ReportDefinitionFindSchema struct {
+  ZoneID *uint `json:"zone_id,omitempty" query:"zone_id"`
+}
+
+type ReportFindSchema struct {
+  ZoneID *uint `json:"zone_id,omitempty" query:"zone_id"`
+  DefinitionID *uint `json:"definition_id,omitempty" query:"definition_id"`
+}
+
+// ReportSchema is generated report, its HTML and CSV are downloaded separately
+type ReportSchema struct {
+  ID uint `json:"id" binding:"required"`
+  DefinitionID uint `json:"definition_id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  // Readings measured in [from, to) are reported
+  From time.Time `json:"from" binding:"required"`
+  To time.Time `json:"to" binding:"required"`
+  CreatedAt time.Time `json:"created_at" binding:"required"`
+  // Recipients report is not mailed to yet
+  PendingRecipients []string `json:"pending_recipients" binding:"required"`
+  LastError string `json:"last_error,omitempty"`
+}
+
+// ReportRoomSchema is room summary of report period
+type ReportRoomSchema struct {
+  RoomID uint `json:"room_id"`
+  Name string `json:"name"`
+  Samples int `json:"samples"`
+  Co2Avg int `json:"co2_avg"`
+  TvocAvg int `json:"tvoc_avg"`
+  // Peaks, empty without readings
+  Co2Max *float64 `json:"co2_max"`
+  TvocMax *float64 `json:"tvoc_max"`
+  // Air quality band of averages
+  Band string `json:"band"`
+  // Share of time in every overall band
+  Bands []BandShareSchema `json:"bands"`
+}
+
+// ReportSensorSchema is sensor state when report is made
+type ReportSensorSchema struct {
+  SensorID uint `json:"sensor_id"`
+  Name string `json:"name"`
+  RoomID uint `json:"room_id"`
+  // online, offline or never_seen
+  Status string `json:"status"`
+  BatteryCharge int `json:"battery_charge"`
+  BatteryEmptyAt *time.Time `json:"battery_empty_at"`
+  // Battery is low or gets empty within a week
+  ReplaceBattery bool `json:"replace_battery"`
+}
+
+// ReportDataSchema is everything rendered into report
+type ReportDataSchema struct {
+  ZoneID uint `json:"zone_id"`
+  ZoneName string `json:"zone_name"`
+  Name string `json:"name"`
+  From time.Time `json:"from"`
+  To time.Time `json:"to"`
+  Rooms []ReportRoomSchema `json:"rooms"`
+  Sensors []ReportSensorSchema `json:"sensors"`
+}
+
+func (s ReportDefinitionCreateSchema) Validate() error {
+  if len(s.Name) == 0 {
+    return errors.New("name must be set")
+  }
+  return validateReport(s.Period, s.Timezone, s.Recipients)
+}
+
+func (s ReportDefinitionUpdateSchema) Validate() error {
+  if s.Name != nil && len(*s.Name) == 0 {
+    return errors.New("name must be set")
+  }
+  period, timezone, recipients := ReportDaily, "", []string(nil)
+  if s.Period != nil {
+    period = *s.Period
+  }
+  if s.Timezone != nil {
+    timezone = *s.Timezone
+  }
+  if s.Recipients != nil {
+    recipients = *s.Recipients
+  }
+  return validateReport(period, timezone, recipients)
+}
+
+func validateReport(period string, timezone string, recipients []string) error {
+  if period != ReportDaily && period != ReportWeekly && period != ReportMonthly {
+    return errors.New("period must be daily, weekly or monthly")
+  }
+  if _, err := time.LoadLocation(timezone); err != nil {
+    return errors.New("unknown timezone " + timezone)
+  }
+  for _, recipient := range recipients {
+    address, err := mail.ParseAddress(recipient)
+    if err != nil || address.Address != recipient {
+      return errors.New("invalid recipient " + recipient)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,54 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+// RetentionSchema is days data is kept, 0 is global retention for zone and
+// forever globally
+type RetentionSchema struct {
+  ZoneID uint `json:"zone_id,omitempty"`
+  // Raw sensor data
+  RawDays int `json:"raw_days"`
+  // Minute, hour and day rollups
+  MinuteDays int `json:"minute_days"`
+  HourDays int `json:"hour_days"`
+  DayDays int `json:"day_days"`
+}
+
+func (s RetentionSchema) Validate() error {
+  if s.RawDays < 0 || s.MinuteDays < 0 || s.HourDays < 0 || s.DayDays < 0 {
+    return errors.New("retention days must not be negative")
+  }
+  return nil
+}
+
+type StorageTableSchema struct {
+  Table string `json:"table" binding:"required"`
+  // Bytes of table with indexes
+  Bytes int64 `json:"bytes" binding:"required"`
+  // Planner estimate of rows
+  Rows int64 `json:"rows" binding:"required"`
+}
+
+type ZoneStorageSchema struct {
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+  RawRows int64 `json:"raw_rows" binding:"required"`
+  MinuteRows int64 `json:"minute_rows" binding:"required"`
+  HourRows int64 `json:"hour_rows" binding:"required"`
+  DayRows int64 `json:"day_rows" binding:"required"`
+  OldestRawAt *time.Time `json:"oldest_raw_at"`
+  // Rows of zone times average row size of their tables
+  EstimatedBytes int64 `json:"estimated_bytes" binding:"required"`
+  // Effective retention of zone
+  Retention RetentionSchema `json:"retention" binding:"required"`
+}
+
+type StorageSchema struct {
+  Tables []StorageTableSchema `json:"tables" binding:"required"`
+  Zones []ZoneStorageSchema `json:"zones" binding:"required"`
+  // Global retention
+  Retention RetentionSchema `json:"retention" binding:"required"`
+}
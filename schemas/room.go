@@ -15,7 +15,7 @@ type RoomSchema struct {
   Name string `json:"name" binding:"required"`
   ZoneID uint `json:"zone_id" binding:"required"`
   OwnerID uint `json:"owner_id" binding:"required"`
-  Sensors []SensorSchema `json:"sensors`
+  Sensors []SensorSchema `json:"sensors"`
 }
 
 type RoomUpdateSchema struct {
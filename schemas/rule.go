@@ -0,0 +1,103 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+const (
+  MetricCo2 = "co2"
+  MetricTvoc = "tvoc"
+  MetricBattery = "battery"
+
+  OperatorAbove = "above"
+  OperatorBelow = "below"
+
+  SeverityInfo = "info"
+  SeverityWarning = "warning"
+  SeverityCritical = "critical"
+)
+
+type RuleCreateSchema struct {
+  Name string `json:"name" binding:"required"`
+  // co2, tvoc or battery
+  Metric string `json:"metric" binding:"required"`
+  // above or below
+  Operator string `json:"operator" binding:"required"`
+  Threshold int `json:"threshold" binding:"required"`
+  // Seconds threshold must be breached before rule triggers
+  MinDuration int `json:"min_duration"`
+  // Seconds after trigger while rule doesn't trigger again for the same sensor
+  Cooldown int `json:"cooldown"`
+  Enabled *bool `json:"enabled,omitempty"`
+  // info, warning or critical severity of incidents opened by rule, warning by default
+  Severity string `json:"severity,omitempty"`
+}
+
+type RuleUpdateSchema struct {
+  Name *string `json:"name,omitempty"`
+  Threshold *int `json:"threshold,omitempty"`
+  MinDuration *int `json:"min_duration,omitempty"`
+  Cooldown *int `json:"cooldown,omitempty"`
+  Enabled *bool `json:"enabled,omitempty"`
+  Severity *string `json:"severity,omitempty"`
+}
+
+type RuleSchema struct {
+  ID uint `json:"id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  RoomID *uint `json:"room_id"`
+  Name string `json:"name" binding:"required"`
+  Metric string `json:"metric" binding:"required"`
+  Operator string `json:"operator" binding:"required"`
+  Threshold int `json:"threshold" binding:"required"`
+  MinDuration int `json:"min_duration" binding:"required"`
+  Cooldown int `json:"cooldown" binding:"required"`
+  Enabled bool `json:"enabled" binding:"required"`
+  Severity string `json:"severity" binding:"required"`
+  // Zone rule which applies to room
+  Inherited bool `json:"inherited" binding:"required"`
+}
+
+type RuleTriggerSchema struct {
+  ID uint `json:"id" binding:"required"`
+  RuleID uint `json:"rule_id" binding:"required"`
+  SensorID uint `json:"sensor_id" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Metric string `json:"metric" binding:"required"`
+  Value int `json:"value" binding:"required"`
+  Threshold int `json:"threshold" binding:"required"`
+  BreachStartedAt time.Time `json:"breach_started_at" binding:"required"`
+  TriggeredAt time.Time `json:"triggered_at" binding:"required"`
+}
+
+func (s RuleCreateSchema) Validate() error {
+  if s.Metric != MetricCo2 && s.Metric != MetricTvoc && s.Metric != MetricBattery {
+    return errors.New("metric must be co2, tvoc or battery")
+  }
+  if s.Operator != OperatorAbove && s.Operator != OperatorBelow {
+    return errors.New("operator must be above or below")
+  }
+  if s.MinDuration < 0 || s.Cooldown < 0 {
+    return errors.New("min_duration and cooldown must not be negative")
+  }
+  if len(s.Severity) > 0 && !validSeverity(s.Severity) {
+    return errors.New("severity must be info, warning or critical")
+  }
+  return nil
+}
+
+func (s RuleUpdateSchema) Validate() error {
+  if (s.MinDuration != nil && *s.MinDuration < 0) || (s.Cooldown != nil && *s.Cooldown < 0) {
+    return errors.New("min_duration and cooldown must not be negative")
+  }
+  if s.Severity != nil && !validSeverity(*s.Severity) {
+    return errors.New("severity must be info, warning or critical")
+  }
+  return nil
+}
+
+func validSeverity(severity string) bool {
+  return severity == SeverityInfo || severity == SeverityWarning || severity == SeverityCritical
+}
@@ -1,6 +1,9 @@
 package schemas
 
 import (
+  "errors"
+  "time"
+
   models "antivape/db"
 )
 
@@ -16,12 +19,25 @@ type SensorSchema struct {
   Name string `json:"name" binding:"required"`
   Guid string `json:"guid" binding:"required"`
   RoomID uint `json:"room_id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
   OwnerID uint `json:"owner_id" binding:"required"`
+  Secret string `json:"secret,omitempty"`
+  // Seconds without readings after which sensor is offline, 0 for default
+  Heartbeat int `json:"heartbeat"`
 }
 
 type SensorUpdateSchema struct {
   Name string `json:"name,omitempty"`
   Guid string `json:"guid,omitempty"`
+  // Seconds without readings after which sensor is offline, 0 for default
+  Heartbeat *int `json:"heartbeat,omitempty"`
+}
+
+func (s SensorUpdateSchema) Validate() error {
+  if s.Heartbeat != nil && *s.Heartbeat < 0 {
+    return errors.New("heartbeat must not be negative")
+  }
+  return nil
 }
 
 type SensorFindSchema struct {
@@ -29,6 +45,46 @@ type SensorFindSchema struct {
   OwnerID *uint `json:"owner_id,omitempty"`
 }
 
+const (
+  DeviceOffline = "offline"
+  DeviceFlatline = "flatline"
+  DeviceBatteryDrop = "battery_drop"
+  DeviceBatteryLow = "battery_low"
+
+  SensorOnline = "online"
+  SensorOffline = "offline"
+  SensorNeverSeen = "never_seen"
+)
+
+type DeviceEventSchema struct {
+  ID uint `json:"id" binding:"required"`
+  SensorID uint `json:"sensor_id" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  // offline, flatline, battery_drop or battery_low
+  Type string `json:"type" binding:"required"`
+  StartedAt time.Time `json:"started_at" binding:"required"`
+  // Empty while condition lasts, battery drops have no duration
+  EndedAt *time.Time `json:"ended_at"`
+  // Battery percent lost for battery_drop, charge for battery_low
+  Value int `json:"value,omitempty"`
+}
+
+type SensorHealthSchema struct {
+  SensorID uint `json:"sensor_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+  Guid string `json:"guid" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  // online, offline or never_seen
+  Status string `json:"status" binding:"required"`
+  LastSeenAt *time.Time `json:"last_seen_at"`
+  // Seconds
+  Heartbeat int `json:"heartbeat" binding:"required"`
+  BatteryCharge int `json:"battery_charge" binding:"required"`
+  // Offline, flatline and battery_low events which are not over
+  OpenEvents []DeviceEventSchema `json:"open_events" binding:"required"`
+}
+
 func (s SensorSchema) ToModel() models.Sensor {
   return models.Sensor{
     Name: s.Name,
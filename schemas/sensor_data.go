@@ -1,5 +1,12 @@
 package schemas
 
+import (
+  "errors"
+  "strconv"
+  "strings"
+  "time"
+)
+
 type SensorDataSchema struct {
   Guid string
   Co2 int
@@ -7,13 +14,187 @@ type SensorDataSchema struct {
   BatteryCharge int
 }
 
+const (
+  AggregateAvg = "avg"
+  AggregateMin = "min"
+  AggregateMax = "max"
+  AggregateMedian = "median"
+  AggregateP95 = "p95"
+  AggregateStddev = "stddev"
+  AggregateCount = "count"
+  AggregateTimeAbove = "time_above"
+)
+
+const (
+  WeightingSample = "sample"
+  WeightingTime = "time"
+)
+
 type SensorDataRoomSchema struct {
   Co2 int
   Tvoc int
   RoomID uint
+  // Number of readings averaged, 0 when room has no data in the interval
+  Samples int
+  // Air quality bands of averages, the worse of them in Band. Empty when
+  // room has no data in the interval
+  Co2Band string `json:",omitempty"`
+  TvocBand string `json:",omitempty"`
+  Band string `json:",omitempty"`
+  // Selected aggregates, empty when none is selected
+  Co2Aggregates *AggregatesSchema `json:",omitempty"`
+  TvocAggregates *AggregatesSchema `json:",omitempty"`
+}
+
+// AggregatesSchema has selected aggregates of metric and avg, values are empty
+// when aggregate isn't selected or room has no readings
+type AggregatesSchema struct {
+  Avg *float64 `json:"avg,omitempty"`
+  Min *float64 `json:"min,omitempty"`
+  Max *float64 `json:"max,omitempty"`
+  Median *float64 `json:"median,omitempty"`
+  P95 *float64 `json:"p95,omitempty"`
+  // Population standard deviation
+  Stddev *float64 `json:"stddev,omitempty"`
+  Count int `json:"count"`
+  // Seconds value stayed above threshold, summed over room sensors
+  TimeAbove *float64 `json:"time_above,omitempty"`
+}
+
+// SensorDataSensorSchema is SensorDataRoomSchema of single sensor
+type SensorDataSensorSchema struct {
+  Co2 int
+  Tvoc int
+  SensorID uint
+  Samples int
+  Co2Band string `json:",omitempty"`
+  TvocBand string `json:",omitempty"`
+  Band string `json:",omitempty"`
+  Co2Aggregates *AggregatesSchema `json:",omitempty"`
+  TvocAggregates *AggregatesSchema `json:",omitempty"`
+}
+
+// LatestReadingSchema is the newest reading of sensor, reading fields are
+// empty when sensor has none
+type LatestReadingSchema struct {
+  SensorID uint `json:"sensor_id" binding:"required"`
+  Name string `json:"name" binding:"required"`
+  RoomID uint `json:"room_id" binding:"required"`
+  // online, offline or never_seen
+  Status string `json:"status" binding:"required"`
+  Co2 int `json:"co2"`
+  Tvoc int `json:"tvoc"`
+  BatteryCharge int `json:"battery_charge"`
+  MeasuredAt *time.Time `json:"measured_at"`
+  // Air quality bands of reading, the worse of them in band
+  Co2Band string `json:"co2_band,omitempty"`
+  TvocBand string `json:"tvoc_band,omitempty"`
+  Band string `json:"band,omitempty"`
+  // Reading is in ingestion buffer and may be not stored yet
+  Buffered bool `json:"buffered"`
 }
 
 type SensorDataZoneSchema struct {
   Rooms []SensorDataRoomSchema
   ZoneID uint
 }
+
+// StatisticFindSchema is time interval of readings by measured_at. Either
+// from and to or last can be set, interval is unbounded when none is.
+type StatisticFindSchema struct {
+  // RFC3339, readings measured in [from, to)
+  From string `json:"from,omitempty" query:"from"`
+  To string `json:"to,omitempty" query:"to"`
+  // Interval before now like 30m, 1h or 7d
+  Last string `json:"last,omitempty" query:"last"`
+  // Comma separated avg, min, max, median, p95, stddev, count, time_above
+  Aggregates string `json:"aggregates,omitempty" query:"aggregates"`
+  // Thresholds for time_above
+  Co2Above *float64 `json:"co2_above,omitempty" query:"co2_above"`
+  TvocAbove *float64 `json:"tvoc_above,omitempty" query:"tvoc_above"`
+  // sample weighs every reading equally, time weighs it by time until next
+  // reading of its sensor. Sample by default
+  Weighting string `json:"weighting,omitempty" query:"weighting"`
+}
+
+func (s StatisticFindSchema) Validate() error {
+  if len(s.Weighting) > 0 && s.Weighting != WeightingSample && s.Weighting != WeightingTime {
+    return errors.New("weighting must be sample or time")
+  }
+  for _, aggregate := range s.AggregateList() {
+    switch aggregate {
+    case AggregateAvg, AggregateMin, AggregateMax, AggregateMedian, AggregateP95, AggregateStddev, AggregateCount:
+    case AggregateTimeAbove:
+      if s.Co2Above == nil && s.TvocAbove == nil {
+        return errors.New("time_above needs co2_above or tvoc_above threshold")
+      }
+    default:
+      return errors.New("Unknown aggregate " + aggregate)
+    }
+  }
+  _, _, err := s.Range(time.Now())
+  return err
+}
+
+func (s StatisticFindSchema) AggregateList() []string {
+  if len(s.Aggregates) == 0 {
+    return nil
+  }
+  aggregates := strings.Split(s.Aggregates, ",")
+  for i := range aggregates {
+    aggregates[i] = strings.TrimSpace(aggregates[i])
+  }
+  return aggregates
+}
+
+// Range resolves interval bounds at now, empty bound is unbounded
+func (s StatisticFindSchema) Range(now time.Time) (*time.Time, *time.Time, error) {
+  if len(s.Last) > 0 {
+    if len(s.From) > 0 || len(s.To) > 0 {
+      return nil, nil, errors.New("last can't be used with from and to")
+    }
+    last, err := parseLast(s.Last)
+    if err != nil {
+      return nil, nil, err
+    }
+    from := now.Add(-last)
+    return &from, &now, nil
+  }
+
+  var from, to *time.Time
+  if len(s.From) > 0 {
+    parsed, err := time.Parse(time.RFC3339, s.From)
+    if err != nil {
+      return nil, nil, errors.New("from must be RFC3339 time")
+    }
+    from = &parsed
+  }
+  if len(s.To) > 0 {
+    parsed, err := time.Parse(time.RFC3339, s.To)
+    if err != nil {
+      return nil, nil, errors.New("to must be RFC3339 time")
+    }
+    to = &parsed
+  }
+  if from != nil && to != nil && !from.Before(*to) {
+    return nil, nil, errors.New("from must be before to")
+  }
+  return from, to, nil
+}
+
+// parseLast parses positive duration, with d suffix for days
+func parseLast(last string) (time.Duration, error) {
+  var duration time.Duration
+  var err error
+  if days, ok := strings.CutSuffix(last, "d"); ok {
+    var count int
+    count, err = strconv.Atoi(days)
+    duration = time.Duration(count) * 24 * time.Hour
+  } else {
+    duration, err = time.ParseDuration(last)
+  }
+  if err != nil || duration <= 0 {
+    return 0, errors.New("last must be positive duration like 30m, 1h or 7d")
+  }
+  return duration, nil
+}
@@ -0,0 +1,68 @@
+package schemas
+
+import (
+  "errors"
+  "time"
+)
+
+const (
+  GranularityRoom = "room"
+  GranularitySensor = "sensor"
+)
+
+// SeriesBuckets are bucket sizes allowed in series
+var SeriesBuckets = map[string]time.Duration{
+  "1m": time.Minute,
+  "5m": 5 * time.Minute,
+  "1h": time.Hour,
+  "1d": 24 * time.Hour,
+}
+
+type SeriesFindSchema struct {
+  // RFC3339, readings measured in [from, to), last 24 hours by default
+  From string `json:"from,omitempty" query:"from"`
+  To string `json:"to,omitempty" query:"to"`
+  // Interval before now like 30m, 1h or 7d
+  Last string `json:"last,omitempty" query:"last"`
+  // 1m, 5m, 1h or 1d, 5m by default
+  Bucket string `json:"bucket,omitempty" query:"bucket"`
+  // Add buckets without readings with zero count and empty values
+  Fill bool `json:"fill,omitempty" query:"fill"`
+  // room or sensor, one series per room or per sensor. Room by default
+  Granularity string `json:"granularity,omitempty" query:"granularity"`
+}
+
+func (s SeriesFindSchema) Validate() error {
+  if _, ok := SeriesBuckets[s.Bucket]; len(s.Bucket) > 0 && !ok {
+    return errors.New("bucket must be 1m, 5m, 1h or 1d")
+  }
+  if len(s.Granularity) > 0 && s.Granularity != GranularityRoom && s.Granularity != GranularitySensor {
+    return errors.New("granularity must be room or sensor")
+  }
+  _, _, err := s.Interval().Range(time.Now())
+  return err
+}
+
+func (s SeriesFindSchema) Interval() StatisticFindSchema {
+  return StatisticFindSchema{From: s.From, To: s.To, Last: s.Last}
+}
+
+type SeriesPointSchema struct {
+  // Bucket start
+  At time.Time `json:"at" binding:"required"`
+  Count int `json:"count" binding:"required"`
+  // Empty for filled buckets without readings
+  Co2Avg *float64 `json:"co2_avg"`
+  Co2Min *float64 `json:"co2_min"`
+  Co2Max *float64 `json:"co2_max"`
+  TvocAvg *float64 `json:"tvoc_avg"`
+  TvocMin *float64 `json:"tvoc_min"`
+  TvocMax *float64 `json:"tvoc_max"`
+}
+
+type SeriesSchema struct {
+  RoomID uint `json:"room_id" binding:"required"`
+  // Set for sensor granularity
+  SensorID *uint `json:"sensor_id,omitempty"`
+  Points []SeriesPointSchema `json:"points" binding:"required"`
+}
@@ -0,0 +1,96 @@
+package schemas
+
+import (
+  "errors"
+  "net/url"
+  "time"
+)
+
+const (
+  DeliveryPending = "pending"
+  DeliveryDelivered = "delivered"
+  DeliveryFailed = "failed"
+  // Notification dropped by zone policy cooldown
+  DeliverySuppressed = "suppressed"
+)
+
+type WebhookCreateSchema struct {
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Url string `json:"url" binding:"required"`
+  // Event types to send, every type if empty
+  EventTypes []string `json:"event_types"`
+  Enabled *bool `json:"enabled,omitempty"`
+}
+
+type WebhookUpdateSchema struct {
+  Url *string `json:"url,omitempty"`
+  EventTypes *[]string `json:"event_types,omitempty"`
+  Enabled *bool `json:"enabled,omitempty"`
+}
+
+type WebhookSchema struct {
+  ID uint `json:"id" binding:"required"`
+  ZoneID uint `json:"zone_id" binding:"required"`
+  Url string `json:"url" binding:"required"`
+  EventTypes []string `json:"event_types" binding:"required"`
+  Enabled bool `json:"enabled" binding:"required"`
+  // Signing secret, returned only on create
+  Secret string `json:"secret,omitempty"`
+}
+
+type WebhookFindSchema struct {
+  ZoneID *uint `json:"zone_id,omitempty" query:"zone_id"`
+}
+
+type WebhookDeliverySchema struct {
+  ID uint `json:"id" binding:"required"`
+  WebhookID uint `json:"webhook_id" binding:"required"`
+  EventType string `json:"event_type" binding:"required"`
+  // pending, delivered or failed
+  State string `json:"state" binding:"required"`
+  Attempts int `json:"attempts" binding:"required"`
+  NextAttemptAt time.Time `json:"next_attempt_at" binding:"required"`
+  LastStatusCode int `json:"last_status_code,omitempty"`
+  LastError string `json:"last_error,omitempty"`
+  DeliveredAt *time.Time `json:"delivered_at"`
+  CreatedAt time.Time `json:"created_at" binding:"required"`
+}
+
+func (s WebhookCreateSchema) Validate() error {
+  if err := validateWebhookUrl(s.Url); err != nil {
+    return err
+  }
+  return validateEventTypes(s.EventTypes)
+}
+
+func (s WebhookUpdateSchema) Validate() error {
+  if s.Url != nil {
+    if err := validateWebhookUrl(*s.Url); err != nil {
+      return err
+    }
+  }
+  if s.EventTypes != nil {
+    return validateEventTypes(*s.EventTypes)
+  }
+  return nil
+}
+
+func validateWebhookUrl(value string) error {
+  parsed, err := url.Parse(value)
+  if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+    return errors.New("url must be absolute http or https url")
+  }
+  return nil
+}
+
+func validateEventTypes(eventTypes []string) error {
+  for _, eventType := range eventTypes {
+    switch eventType {
+    case EventIncidentOpened, EventIncidentAcknowledged, EventIncidentResolved, EventIncidentEscalated:
+    case EventSensorOffline, EventSensorOnline, EventSensorFlatline, EventSensorBatteryDrop, EventSensorBatteryLow, EventTest:
+    default:
+      return errors.New("unknown event type " + eventType)
+    }
+  }
+  return nil
+}
@@ -9,7 +9,7 @@ type ZoneSchema struct {
   ID uint `json:"id" binding:"required"`
   Name string `json:"name" binding:"required"`
   OwnerID uint `json:"owner_id" binding:"required"`
-  Rooms []RoomSchema `json:"rooms`
+  Rooms []RoomSchema `json:"rooms"`
 }
 
 type ZoneUpdateSchema struct {
@@ -0,0 +1,157 @@
+package services
+
+import (
+  "errors"
+  "time"
+
+  "gorm.io/gorm"
+  models "antivape/db"
+  "antivape/repositories"
+  "antivape/schemas"
+)
+
+// AirQualityConfig has upper bounds of excellent, good, moderate and poor
+// bands, values at the last bound and above are unhealthy
+type AirQualityConfig struct {
+  Co2Bounds []float64
+  TvocBounds []float64
+}
+
+func (c AirQualityConfig) Validate() error {
+  for _, bounds := range [][]float64{c.Co2Bounds, c.TvocBounds} {
+    if len(bounds) != len(schemas.Bands) - 1 {
+      return errors.New("air quality needs 4 band bounds of co2 and tvoc")
+    }
+    for i := 1; i < len(bounds); i++ {
+      if bounds[i] <= bounds[i - 1] {
+        return errors.New("air quality band bounds must be ascending")
+      }
+    }
+  }
+  return nil
+}
+
+// AirQuality classifies co2 and tvoc values into bands
+type AirQuality struct {
+  config AirQualityConfig
+}
+
+// bandIndex is number of bounds not above value, like width_bucket in postgres
+func bandIndex(bounds []float64, value float64) int {
+  index := 0
+  for index < len(bounds) && bounds[index] <= value {
+    index++
+  }
+  return index
+}
+
+// Classify returns bands of co2 and tvoc and the worse of them
+func (q AirQuality) Classify(co2 float64, tvoc float64) (string, string, string) {
+  co2Band, tvocBand := bandIndex(q.config.Co2Bounds, co2), bandIndex(q.config.TvocBounds, tvoc)
+  return schemas.Bands[co2Band], schemas.Bands[tvocBand], schemas.Bands[max(co2Band, tvocBand)]
+}
+
+// RoomBands sums spans of room by band into shares of room time
+func (q AirQuality) RoomBands(roomID uint, spans []repositories.BandSpan) schemas.RoomBandsSchema {
+  co2 := make([]float64, len(schemas.Bands))
+  tvoc := make([]float64, len(schemas.Bands))
+  overall := make([]float64, len(schemas.Bands))
+  total := 0.0
+  for _, span := range spans {
+    if span.RoomID != roomID {
+      continue
+    }
+    co2[span.Co2Band] += span.Seconds
+    tvoc[span.TvocBand] += span.Seconds
+    overall[max(span.Co2Band, span.TvocBand)] += span.Seconds
+    total += span.Seconds
+  }
+  return schemas.RoomBandsSchema{
+    RoomID: roomID,
+    Seconds: total,
+    Co2: bandShares(co2, total),
+    Tvoc: bandShares(tvoc, total),
+    Overall: bandShares(overall, total),
+  }
+}
+
+func bandShares(seconds []float64, total float64) []schemas.BandShareSchema {
+  shares := make([]schemas.BandShareSchema, 0, len(seconds))
+  for i, band := range schemas.Bands {
+    share := schemas.BandShareSchema{Band: band, Seconds: seconds[i]}
+    if total > 0 {
+      share.Share = seconds[i] / total
+    }
+    shares = append(shares, share)
+  }
+  return shares
+}
+
+// classifyRoom sets bands of room statistic averages
+func (q AirQuality) classifyRoom(statistic *schemas.SensorDataRoomSchema) {
+  if statistic.Samples == 0 {
+    return
+  }
+  statistic.Co2Band, statistic.TvocBand, statistic.Band = q.Classify(float64(statistic.Co2), float64(statistic.Tvoc))
+}
+
+func NewAirQuality(config AirQualityConfig) AirQuality {
+  return AirQuality{config: config}
+}
+
+type AirQualityService interface {
+  // FindRoomBands returns time room spent in every band
+  FindRoomBands(roomID uint, schema schemas.BandFindSchema) schemas.RoomBandsSchema
+  // FindZoneBands returns time every zone room spent in every band
+  FindZoneBands(zoneID uint, schema schemas.BandFindSchema) ([]schemas.RoomBandsSchema, error)
+}
+
+type airQualityService struct {
+  baseService
+  sensorDataRep repositories.SensorDataRepository
+  airQuality AirQuality
+}
+
+func (s airQualityService) filters(schema schemas.BandFindSchema) map[string]interface{} {
+  now := time.Now()
+  filters := map[string]interface{}{
+    "max_gap": statisticMaxGap.Seconds(),
+    "end": now,
+    "co2_bounds": s.airQuality.config.Co2Bounds,
+    "tvoc_bounds": s.airQuality.config.TvocBounds,
+  }
+  from, to, _ := schema.Statistic().Range(now)
+  if from != nil {
+    filters["from"] = *from
+  }
+  if to != nil {
+    filters["to"] = *to
+    filters["end"] = *to
+  }
+  return filters
+}
+
+func (s airQualityService) FindRoomBands(roomID uint, schema schemas.BandFindSchema) schemas.RoomBandsSchema {
+  filters := s.filters(schema)
+  filters["room_id"] = roomID
+  return s.airQuality.RoomBands(roomID, s.sensorDataRep.GetBandSpans(filters))
+}
+
+func (s airQualityService) FindZoneBands(zoneID uint, schema schemas.BandFindSchema) ([]schemas.RoomBandsSchema, error) {
+  var rooms []models.Room
+  if err := s.db.Where("zone_id = ?", zoneID).Order("id").Find(&rooms).Error; err != nil {
+    return nil, err
+  }
+  filters := s.filters(schema)
+  filters["zone_id"] = zoneID
+  spans := s.sensorDataRep.GetBandSpans(filters)
+  bands := make([]schemas.RoomBandsSchema, 0, len(rooms))
+  for _, room := range rooms {
+    bands = append(bands, s.airQuality.RoomBands(room.ID, spans))
+  }
+  return bands, nil
+}
+
+func NewAirQualityService(db *gorm.DB, sensorDataRep repositories.SensorDataRepository, airQuality AirQuality) AirQualityService {
+  return airQualityService{baseService: baseService{db: db}, sensorDataRep: sensorDataRep, airQuality: airQuality}
+}
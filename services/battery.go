@@ -0,0 +1,186 @@
+package services
+
+import (
+  "errors"
+  "sort"
+  "time"
+
+  "gorm.io/gorm"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+var ErrSensorNotFound = errors.New("Sensor not found")
+
+// Battery rising more than this between hours means it was replaced or charged
+const batteryRecharge = 5
+
+type BatteryConfig struct {
+  // Readings of this period are used for forecast
+  Window time.Duration
+  LowLevel int
+}
+
+// BatteryPoint is average charge of sensor within hour
+type BatteryPoint struct {
+  At time.Time
+  Level float64
+}
+
+// ForecastBattery returns discharge rate in percents per day by least squares
+// fit of points since the last recharge. Points must be ordered by time.
+func ForecastBattery(points []BatteryPoint) (float64, bool) {
+  start := 0
+  for i := 1; i < len(points); i++ {
+    if points[i].Level - points[i-1].Level > batteryRecharge {
+      start = i
+    }
+  }
+  points = points[start:]
+  if len(points) < 2 {
+    return 0, false
+  }
+
+  var meanX, meanY float64
+  for _, point := range points {
+    meanX += point.At.Sub(points[0].At).Hours() / 24
+    meanY += point.Level
+  }
+  meanX /= float64(len(points))
+  meanY /= float64(len(points))
+  var covariance, variance float64
+  for _, point := range points {
+    x := point.At.Sub(points[0].At).Hours() / 24
+    covariance += (x - meanX) * (point.Level - meanY)
+    variance += (x - meanX) * (x - meanX)
+  }
+  if variance == 0 {
+    return 0, false
+  }
+  rate := -covariance / variance
+  return rate, rate > 0
+}
+
+type BatteryService interface {
+  Take(sensorID uint) (schemas.BatterySchema, error)
+  // FindReplacements returns zone sensors with low battery or battery getting
+  // empty within days, the ones to be empty sooner first
+  FindReplacements(zoneID uint, days int) ([]schemas.BatterySchema, error)
+}
+
+type batteryService struct {
+  baseService
+  config BatteryConfig
+}
+
+type batteryPointRow struct {
+  Guid string
+  At time.Time
+  Level float64
+}
+
+// points loads hourly battery averages of sensors within forecast window
+func (s batteryService) points(guids []string, now time.Time) (map[string][]BatteryPoint, error) {
+  var rows []batteryPointRow
+  err := s.db.Model(&models.SensorData{}).
+    Select("guid, date_trunc('hour', measured_at) AS at, AVG(battery_charge)::float8 AS level").
+    Where("guid IN ? AND measured_at >= ?", guids, now.Add(-s.config.Window)).
+    Group("guid, at").
+    Order("guid, at").
+    Scan(&rows).Error
+  if err != nil {
+    return nil, err
+  }
+  points := make(map[string][]BatteryPoint)
+  for _, row := range rows {
+    points[row.Guid] = append(points[row.Guid], BatteryPoint{At: row.At, Level: row.Level})
+  }
+  return points, nil
+}
+
+func (s batteryService) forecast(sensor models.Sensor, points []BatteryPoint, now time.Time) schemas.BatterySchema {
+  battery := schemas.BatterySchema{
+    SensorID: sensor.ID,
+    Name: sensor.Name,
+    RoomID: sensor.RoomID,
+    Level: sensor.LastBatteryCharge,
+    LastSeenAt: sensor.LastSeenAt,
+    Low: sensor.LastSeenAt != nil && sensor.LastBatteryCharge < s.config.LowLevel,
+  }
+  if sensor.LastSeenAt == nil {
+    return battery
+  }
+  rate, ok := ForecastBattery(points)
+  if !ok {
+    return battery
+  }
+  days := float64(sensor.LastBatteryCharge) / rate
+  emptyAt := sensor.LastSeenAt.Add(time.Duration(days * 24 * float64(time.Hour)))
+  if emptyAt.Before(now) {
+    emptyAt = now
+  }
+  days = emptyAt.Sub(now).Hours() / 24
+  battery.DischargeRate = &rate
+  battery.DaysUntilEmpty = &days
+  battery.EmptyAt = &emptyAt
+  return battery
+}
+
+func (s batteryService) Take(sensorID uint) (schemas.BatterySchema, error) {
+  var sensor models.Sensor
+  err := s.db.Where("id = ?", sensorID).Take(&sensor).Error
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+    return schemas.BatterySchema{}, ErrSensorNotFound
+  } else if err != nil {
+    return schemas.BatterySchema{}, err
+  }
+  now := time.Now()
+  points, err := s.points([]string{sensor.Guid}, now)
+  if err != nil {
+    return schemas.BatterySchema{}, err
+  }
+  return s.forecast(sensor, points[sensor.Guid], now), nil
+}
+
+func (s batteryService) FindReplacements(zoneID uint, days int) ([]schemas.BatterySchema, error) {
+  var sensors []models.Sensor
+  if err := s.db.Where("zone_id = ? AND last_seen_at IS NOT NULL", zoneID).Find(&sensors).Error; err != nil {
+    return nil, err
+  }
+  if len(sensors) == 0 {
+    return []schemas.BatterySchema{}, nil
+  }
+  guids := make([]string, 0, len(sensors))
+  for _, sensor := range sensors {
+    guids = append(guids, sensor.Guid)
+  }
+  now := time.Now()
+  points, err := s.points(guids, now)
+  if err != nil {
+    return nil, err
+  }
+
+  deadline := now.AddDate(0, 0, days)
+  replacements := make([]schemas.BatterySchema, 0)
+  for _, sensor := range sensors {
+    battery := s.forecast(sensor, points[sensor.Guid], now)
+    if battery.Low || (battery.EmptyAt != nil && !battery.EmptyAt.After(deadline)) {
+      replacements = append(replacements, battery)
+    }
+  }
+  sort.SliceStable(replacements, func(i, j int) bool {
+    a, b := replacements[i], replacements[j]
+    if (a.EmptyAt == nil) != (b.EmptyAt == nil) {
+      return a.EmptyAt != nil
+    }
+    if a.EmptyAt != nil && !a.EmptyAt.Equal(*b.EmptyAt) {
+      return a.EmptyAt.Before(*b.EmptyAt)
+    }
+    return a.Level < b.Level
+  })
+  return replacements, nil
+}
+
+func NewBatteryService(db *gorm.DB, config BatteryConfig) BatteryService {
+  return batteryService{baseService: baseService{db: db}, config: config}
+}
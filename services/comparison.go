@@ -0,0 +1,176 @@
+package services
+
+import (
+  "sort"
+  "time"
+
+  "gorm.io/gorm"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+// CompareValues returns change of current value against previous one
+func CompareValues(current *float64, previous *float64) schemas.ChangeSchema {
+  change := schemas.ChangeSchema{Current: current, Previous: previous}
+  if current == nil || previous == nil {
+    return change
+  }
+  delta := *current - *previous
+  change.Delta = &delta
+  if *previous != 0 {
+    percent := delta / *previous * 100
+    change.Percent = &percent
+  }
+  return change
+}
+
+// aggregateValue returns selected aggregate of aggregates, empty if it isn't set
+func aggregateValue(aggregates *schemas.AggregatesSchema, aggregate string) *float64 {
+  if aggregates == nil {
+    return nil
+  }
+  switch aggregate {
+  case schemas.AggregateAvg:
+    return aggregates.Avg
+  case schemas.AggregateMin:
+    return aggregates.Min
+  case schemas.AggregateMax:
+    return aggregates.Max
+  case schemas.AggregateMedian:
+    return aggregates.Median
+  case schemas.AggregateP95:
+    return aggregates.P95
+  case schemas.AggregateStddev:
+    return aggregates.Stddev
+  case schemas.AggregateTimeAbove:
+    return aggregates.TimeAbove
+  case schemas.AggregateCount:
+    count := float64(aggregates.Count)
+    return &count
+  }
+  return nil
+}
+
+func compareAggregates(current *schemas.AggregatesSchema, previous *schemas.AggregatesSchema, aggregates []string) map[string]schemas.ChangeSchema {
+  if len(aggregates) == 0 {
+    return nil
+  }
+  changes := make(map[string]schemas.ChangeSchema, len(aggregates))
+  for _, aggregate := range aggregates {
+    changes[aggregate] = CompareValues(aggregateValue(current, aggregate), aggregateValue(previous, aggregate))
+  }
+  return changes
+}
+
+// roomAverages returns room averages, empty when room has no readings
+func roomAverages(statistic schemas.SensorDataRoomSchema) (*float64, *float64) {
+  if statistic.Samples == 0 {
+    return nil, nil
+  }
+  co2 := float64(statistic.Co2)
+  tvoc := float64(statistic.Tvoc)
+  return &co2, &tvoc
+}
+
+// CompareRoom returns change of room statistic and episodes count against previous interval
+func CompareRoom(current schemas.SensorDataRoomSchema, previous schemas.SensorDataRoomSchema, currentEpisodes int, previousEpisodes int, aggregates []string) schemas.RoomChangeSchema {
+  currentCo2, currentTvoc := roomAverages(current)
+  previousCo2, previousTvoc := roomAverages(previous)
+  episodes := float64(currentEpisodes)
+  lastEpisodes := float64(previousEpisodes)
+  return schemas.RoomChangeSchema{
+    RoomID: current.RoomID,
+    Current: current,
+    Previous: previous,
+    Co2: CompareValues(currentCo2, previousCo2),
+    Tvoc: CompareValues(currentTvoc, previousTvoc),
+    Episodes: CompareValues(&episodes, &lastEpisodes),
+    Co2Aggregates: compareAggregates(current.Co2Aggregates, previous.Co2Aggregates, aggregates),
+    TvocAggregates: compareAggregates(current.TvocAggregates, previous.TvocAggregates, aggregates),
+  }
+}
+
+// RankRooms orders rooms by delta of metric, the most grown first, and sets
+// their rank. Rooms without delta are left unranked at the end.
+func RankRooms(rooms []schemas.RoomChangeSchema, metric string) {
+  delta := func(room schemas.RoomChangeSchema) *float64 {
+    switch metric {
+    case schemas.CompareCo2:
+      return room.Co2.Delta
+    case schemas.CompareEpisodes:
+      return room.Episodes.Delta
+    default:
+      return room.Tvoc.Delta
+    }
+  }
+  sort.SliceStable(rooms, func(i, j int) bool {
+    left, right := delta(rooms[i]), delta(rooms[j])
+    if left == nil || right == nil {
+      return left != nil
+    }
+    if *left != *right {
+      return *left > *right
+    }
+    return rooms[i].RoomID < rooms[j].RoomID
+  })
+  for i := range rooms {
+    rooms[i].Rank = 0
+    if delta(rooms[i]) != nil {
+      rooms[i].Rank = i + 1
+    }
+  }
+}
+
+// CompareZone returns change of zone averages, weighted by room samples, and
+// of episodes count over its compared rooms
+func CompareZone(rooms []schemas.RoomChangeSchema) (schemas.ChangeSchema, schemas.ChangeSchema, schemas.ChangeSchema) {
+  average := func(statistic func(schemas.RoomChangeSchema) schemas.SensorDataRoomSchema, value func(schemas.SensorDataRoomSchema) int) *float64 {
+    var sum float64
+    var samples int
+    for _, room := range rooms {
+      s := statistic(room)
+      sum += float64(value(s)) * float64(s.Samples)
+      samples += s.Samples
+    }
+    if samples == 0 {
+      return nil
+    }
+    avg := sum / float64(samples)
+    return &avg
+  }
+  current := func(room schemas.RoomChangeSchema) schemas.SensorDataRoomSchema { return room.Current }
+  previous := func(room schemas.RoomChangeSchema) schemas.SensorDataRoomSchema { return room.Previous }
+  co2 := func(s schemas.SensorDataRoomSchema) int { return s.Co2 }
+  tvoc := func(s schemas.SensorDataRoomSchema) int { return s.Tvoc }
+
+  var episodes, lastEpisodes float64
+  for _, room := range rooms {
+    episodes += *room.Episodes.Current
+    lastEpisodes += *room.Episodes.Previous
+  }
+  return CompareValues(average(current, co2), average(previous, co2)),
+    CompareValues(average(current, tvoc), average(previous, tvoc)),
+    CompareValues(&episodes, &lastEpisodes)
+}
+
+// episodeCounts returns counts of vape episodes started in [from, to) by room
+// of zone_id or room_id column scope
+func episodeCounts(db *gorm.DB, column string, id uint, from time.Time, to time.Time) (map[uint]int, error) {
+  var rows []struct {
+    RoomID uint
+    Count int
+  }
+  err := db.Model(&models.VapeEpisode{}).
+    Select("room_id, COUNT(*) AS count").
+    Where(column + " = ? AND started_at >= ? AND started_at < ?", id, from, to).
+    Group("room_id").
+    Scan(&rows).Error
+  if err != nil {
+    return nil, err
+  }
+  counts := make(map[uint]int, len(rows))
+  for _, row := range rows {
+    counts[row.RoomID] = row.Count
+  }
+  return counts, nil
+}
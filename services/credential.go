@@ -0,0 +1,273 @@
+package services
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "log"
+  "strconv"
+  "time"
+
+  models "antivape/db"
+  "antivape/schemas"
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+)
+
+var (
+  ErrInvalidCredentials = errors.New("Invalid sensor credentials")
+  ErrExpiredSignature = errors.New("Signature timestamp is outside of replay window")
+  ErrReplayedSignature = errors.New("Signature was already used")
+)
+
+type CredentialService interface {
+  Take(sensorID uint) (schemas.SensorCredentialSchema, error)
+  Issue(sensorID uint) (schemas.SensorCredentialSchema, error)
+  Revoke(sensorID uint) error
+  VerifySecret(guid, secret string) error
+  VerifySignature(guid, timestamp, signature string, body []byte) error
+  IsKnown(guid string) bool
+  VerifyGatewaySecret(guid, secret string) error
+  VerifyGatewaySignature(guid, timestamp, signature string, body []byte) error
+  // GatewayGuids tells which of guids gateway may relay readings of: sensors
+  // of gateway zone only, unknown guids are sent by sensors themselves
+  GatewayGuids(gatewayGuid string, guids []string) (map[string]bool, error)
+  RunPurgeCycle()
+}
+
+type credentialService struct {
+  baseService
+  replayWindow time.Duration
+}
+
+func (s credentialService) modelToSchema(model models.SensorCredential, sensor models.Sensor) schemas.SensorCredentialSchema {
+  return schemas.SensorCredentialSchema{
+    SensorID: model.SensorID,
+    Guid: sensor.Guid,
+    Active: model.RevokedAt == nil,
+    IssuedAt: model.CreatedAt,
+    RevokedAt: model.RevokedAt,
+  }
+}
+
+func (s credentialService) Take(sensorID uint) (schemas.SensorCredentialSchema, error) {
+  var sensor models.Sensor
+  if err := s.take(sensorID, &sensor, nil); err != nil {
+    return schemas.SensorCredentialSchema{}, err
+  }
+  var model models.SensorCredential
+  result := s.db.Where("sensor_id = ?", sensorID).Order("id DESC").Take(&model)
+  if result.Error != nil {
+    return schemas.SensorCredentialSchema{}, result.Error
+  }
+  return s.modelToSchema(model, sensor), nil
+}
+
+// Issue creates new secret for sensor and revokes all previous ones.
+// Secret is returned only once, it can't be taken later.
+func (s credentialService) Issue(sensorID uint) (schemas.SensorCredentialSchema, error) {
+  var sensor models.Sensor
+  if err := s.take(sensorID, &sensor, nil); err != nil {
+    return schemas.SensorCredentialSchema{}, err
+  }
+  var model models.SensorCredential
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    var err error
+    model, err = issueCredential(tx, sensorID)
+    return err
+  })
+  if err != nil {
+    return schemas.SensorCredentialSchema{}, err
+  }
+
+  schema := s.modelToSchema(model, sensor)
+  schema.Secret = model.Secret
+  return schema, nil
+}
+
+func (s credentialService) Revoke(sensorID uint) error {
+  return revokeCredentials(s.db, sensorID)
+}
+
+func (s credentialService) VerifySecret(guid, secret string) error {
+  credentials, err := s.activeCredentials(guid)
+  if err != nil {
+    return err
+  }
+  for _, credential := range credentials {
+    if hmac.Equal([]byte(credential.Secret), []byte(secret)) {
+      return nil
+    }
+  }
+  return ErrInvalidCredentials
+}
+
+// VerifySignature checks hex encoded HMAC-SHA256 of "<timestamp>.<body>",
+// where timestamp is unix seconds and must be inside of replay window.
+// Every signature is accepted only once.
+func (s credentialService) VerifySignature(guid, timestamp, signature string, body []byte) error {
+  credentials, err := s.activeCredentials(guid)
+  if err != nil {
+    return err
+  }
+  secrets := make([]string, 0, len(credentials))
+  for _, credential := range credentials {
+    secrets = append(secrets, credential.Secret)
+  }
+  return s.verifySignature(guid, secrets, timestamp, signature, body)
+}
+
+func (s credentialService) VerifyGatewaySecret(guid, secret string) error {
+  gateway, err := s.gateway(guid)
+  if err != nil {
+    return err
+  }
+  if !hmac.Equal([]byte(gateway.Secret), []byte(secret)) {
+    return ErrInvalidCredentials
+  }
+  return nil
+}
+
+// VerifyGatewaySignature checks gateway signature like VerifySignature
+func (s credentialService) VerifyGatewaySignature(guid, timestamp, signature string, body []byte) error {
+  gateway, err := s.gateway(guid)
+  if err != nil {
+    return err
+  }
+  return s.verifySignature("gateway:" + guid, []string{gateway.Secret}, timestamp, signature, body)
+}
+
+func (s credentialService) GatewayGuids(gatewayGuid string, guids []string) (map[string]bool, error) {
+  gateway, err := s.gateway(gatewayGuid)
+  if err != nil {
+    return nil, err
+  }
+  var sensors []models.Sensor
+  if err := s.db.Select("guid", "zone_id").Where("guid IN ?", guids).Find(&sensors).Error; err != nil {
+    return nil, err
+  }
+  allowed := make(map[string]bool, len(sensors))
+  for _, sensor := range sensors {
+    allowed[sensor.Guid] = sensor.ZoneID == gateway.ZoneID
+  }
+  return allowed, nil
+}
+
+// verifySignature checks signature made with one of secrets, key identifies
+// signer in replay cache
+func (s credentialService) verifySignature(key string, secrets []string, timestamp, signature string, body []byte) error {
+  unix, err := strconv.ParseInt(timestamp, 10, 64)
+  if err != nil {
+    return ErrInvalidCredentials
+  }
+  signedAt := time.Unix(unix, 0)
+  if time.Since(signedAt).Abs() > s.replayWindow {
+    return ErrExpiredSignature
+  }
+
+  for _, secret := range secrets {
+    if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature)) {
+      continue
+    }
+    return s.use(key + ":" + signature, signedAt.Add(s.replayWindow))
+  }
+  return ErrInvalidCredentials
+}
+
+// use stores signature key until expiresAt, key stored by any replica before
+// means request is replayed
+func (s credentialService) use(key string, expiresAt time.Time) error {
+  result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UsedSignature{Key: key, ExpiresAt: expiresAt})
+  if result.Error != nil {
+    return result.Error
+  }
+  if result.RowsAffected == 0 {
+    return ErrReplayedSignature
+  }
+  return nil
+}
+
+// RunPurgeCycle drops used signatures whose timestamps are out of replay window
+func (s credentialService) RunPurgeCycle() {
+  for range(time.Tick(time.Minute)) {
+    if err := s.db.Where("expires_at < ?", time.Now()).Delete(&models.UsedSignature{}).Error; err != nil {
+      log.Println("Error purge used signatures: ", err)
+    }
+  }
+}
+
+// IsKnown reports whether guid belongs to some sensor
+func (s credentialService) IsKnown(guid string) bool {
+  var count int64
+  s.db.Model(&models.Sensor{}).Where("guid = ?", guid).Count(&count)
+  return count > 0
+}
+
+func (s credentialService) activeCredentials(guid string) ([]models.SensorCredential, error) {
+  var credentials []models.SensorCredential
+  result := s.db.
+    Where("revoked_at IS NULL").
+    Where("sensor_id IN (?)", s.db.Model(&models.Sensor{}).Select("id").Where("guid = ?", guid)).
+    Find(&credentials)
+  if result.Error != nil {
+    return nil, result.Error
+  }
+  if len(credentials) == 0 {
+    return nil, ErrInvalidCredentials
+  }
+  return credentials, nil
+}
+
+func (s credentialService) gateway(guid string) (models.Gateway, error) {
+  var gateway models.Gateway
+  if err := s.db.Where("guid = ?", guid).Take(&gateway).Error; err != nil {
+    return models.Gateway{}, ErrInvalidCredentials
+  }
+  return gateway, nil
+}
+
+func NewCredentialService(db *gorm.DB, replayWindow time.Duration) CredentialService {
+  return credentialService{
+    baseService: baseService{db: db},
+    replayWindow: replayWindow,
+  }
+}
+
+// Sign returns hex encoded HMAC-SHA256 of "<timestamp>.<body>" with sensor secret.
+func Sign(secret, timestamp string, body []byte) string {
+  mac := hmac.New(sha256.New, []byte(secret))
+  mac.Write([]byte(timestamp + "."))
+  mac.Write(body)
+  return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issueCredential creates new secret for sensor and revokes previous ones, db
+// should be transaction
+func issueCredential(db *gorm.DB, sensorID uint) (models.SensorCredential, error) {
+  secret, err := generateSecret()
+  if err != nil {
+    return models.SensorCredential{}, err
+  }
+  if err := revokeCredentials(db, sensorID); err != nil {
+    return models.SensorCredential{}, err
+  }
+  model := models.SensorCredential{SensorID: sensorID, Secret: secret}
+  return model, db.Create(&model).Error
+}
+
+func revokeCredentials(db *gorm.DB, sensorID uint) error {
+  result := db.Model(&models.SensorCredential{}).
+    Where("sensor_id = ? AND revoked_at IS NULL", sensorID).
+    Update("revoked_at", time.Now())
+  return result.Error
+}
+
+func generateSecret() (string, error) {
+  secret := make([]byte, 32)
+  if _, err := rand.Read(secret); err != nil {
+    return "", err
+  }
+  return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+  "log"
+  "math"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+type DetectionEvent int
+
+const (
+  DetectionNone DetectionEvent = iota
+  // Episode lasted for MinDuration and is confirmed
+  DetectionStarted
+  // Confirmed episode got new reading
+  DetectionUpdated
+  DetectionEnded
+)
+
+// DetectionConfig describes TVOC spike of vape aerosol: fast rise well above
+// rolling baseline, which lasts at least MinDuration.
+type DetectionConfig struct {
+  // Time constant of exponentially weighted baseline
+  BaselineWindow time.Duration
+  // TVOC above baseline, ppb
+  MinRise float64
+  // TVOC growth between consecutive readings, ppb per second
+  MinRiseRate float64
+  MinDuration time.Duration
+  // Spike longer than this is baseline shift, not vape
+  MaxDuration time.Duration
+  // Episode ends when TVOC above baseline falls below MinRise*EndRatio
+  EndRatio float64
+  // Readings further apart than this restart baseline
+  MaxGap time.Duration
+}
+
+// VapeDetector is pure detection algorithm over per-sensor state
+type VapeDetector struct {
+  config DetectionConfig
+}
+
+// Feed advances state by reading. Readings older than the last one are
+// ignored, they are stored but can't change rolling state.
+func (d VapeDetector) Feed(state *models.DetectionState, reading models.SensorData) DetectionEvent {
+  if !state.LastAt.IsZero() && !reading.MeasuredAt.After(state.LastAt) {
+    return DetectionNone
+  }
+  if state.LastAt.IsZero() || reading.MeasuredAt.Sub(state.LastAt) > d.config.MaxGap {
+    event := DetectionNone
+    if state.EpisodeStartedAt != nil {
+      event = d.end(state, state.LastAt)
+    }
+    state.Baseline = float64(reading.Tvoc)
+    state.LastAt = reading.MeasuredAt
+    state.LastTvoc = reading.Tvoc
+    return event
+  }
+
+  elapsed := reading.MeasuredAt.Sub(state.LastAt)
+  excess := float64(reading.Tvoc) - state.Baseline
+  rate := float64(reading.Tvoc - state.LastTvoc) / elapsed.Seconds()
+  state.LastAt = reading.MeasuredAt
+  state.LastTvoc = reading.Tvoc
+
+  if state.EpisodeStartedAt == nil {
+    if excess >= d.config.MinRise && rate >= d.config.MinRiseRate {
+      startedAt := reading.MeasuredAt
+      state.EpisodeStartedAt = &startedAt
+      state.EpisodeEndedAt = nil
+      state.EpisodeConfirmed = false
+      state.EpisodeBaseline = state.Baseline
+      state.EpisodePeakTvoc = reading.Tvoc
+      state.EpisodePeakCo2 = reading.Co2
+      return d.confirm(state, reading.MeasuredAt)
+    }
+    alpha := 1 - math.Exp(-elapsed.Seconds() / d.config.BaselineWindow.Seconds())
+    state.Baseline += alpha * (float64(reading.Tvoc) - state.Baseline)
+    return DetectionNone
+  }
+
+  state.EpisodePeakTvoc = max(state.EpisodePeakTvoc, reading.Tvoc)
+  state.EpisodePeakCo2 = max(state.EpisodePeakCo2, reading.Co2)
+  duration := reading.MeasuredAt.Sub(*state.EpisodeStartedAt)
+  if duration > d.config.MaxDuration {
+    event := d.end(state, reading.MeasuredAt)
+    state.Baseline = float64(reading.Tvoc)
+    return event
+  }
+  if excess < d.config.MinRise * d.config.EndRatio {
+    return d.end(state, reading.MeasuredAt)
+  }
+  return d.confirm(state, reading.MeasuredAt)
+}
+
+func (d VapeDetector) confirm(state *models.DetectionState, at time.Time) DetectionEvent {
+  if state.EpisodeConfirmed {
+    return DetectionUpdated
+  }
+  if at.Sub(*state.EpisodeStartedAt) < d.config.MinDuration {
+    return DetectionNone
+  }
+  state.EpisodeConfirmed = true
+  return DetectionStarted
+}
+
+// end closes episode, unconfirmed ones are dropped silently
+func (d VapeDetector) end(state *models.DetectionState, at time.Time) DetectionEvent {
+  confirmed := state.EpisodeConfirmed
+  state.EpisodeStartedAt = nil
+  state.EpisodeConfirmed = false
+  if !confirmed {
+    return DetectionNone
+  }
+  state.EpisodeEndedAt = &at
+  return DetectionEnded
+}
+
+func NewVapeDetector(config DetectionConfig) VapeDetector {
+  return VapeDetector{config: config}
+}
+
+type DetectionService interface {
+  SensorDataConsumer
+  FindEpisodes(ownerID uint, filters schemas.VapeEpisodeFindSchema) ([]schemas.VapeEpisodeSchema, error)
+  TakeEpisode(episodeID uint) (schemas.VapeEpisodeSchema, error)
+}
+
+type detectionService struct {
+  baseService
+  detector VapeDetector
+  incidentService IncidentService
+}
+
+func (s detectionService) modelToSchema(model models.VapeEpisode) schemas.VapeEpisodeSchema {
+  return schemas.VapeEpisodeSchema{
+    ID: model.ID,
+    SensorID: model.SensorID,
+    RoomID: model.RoomID,
+    ZoneID: model.ZoneID,
+    StartedAt: model.StartedAt,
+    EndedAt: model.EndedAt,
+    PeakTvoc: model.PeakTvoc,
+    PeakCo2: model.PeakCo2,
+    BaselineTvoc: model.BaselineTvoc,
+  }
+}
+
+// Consume feeds stored readings to detector. State rows are locked for the
+// batch, so replicas reading the same buffer don't overwrite each other.
+func (s detectionService) Consume(data []models.SensorData) {
+  readings := make(map[string][]models.SensorData)
+  guids := make([]string, 0)
+  for _, reading := range data {
+    if _, ok := readings[reading.Guid]; !ok {
+      guids = append(guids, reading.Guid)
+    }
+    readings[reading.Guid] = append(readings[reading.Guid], reading)
+  }
+
+  var sensors []models.Sensor
+  if err := s.db.Where("guid IN ?", guids).Find(&sensors).Error; err != nil {
+    log.Println("Error find sensors for detection: ", err)
+    return
+  }
+  if len(sensors) == 0 {
+    return
+  }
+  sensorIDs := make([]uint, 0, len(sensors))
+  for _, sensor := range sensors {
+    sensorIDs = append(sensorIDs, sensor.ID)
+  }
+
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    var states []models.DetectionState
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("sensor_id IN ?", sensorIDs).Find(&states).Error
+    if err != nil {
+      return err
+    }
+    stateBySensor := make(map[uint]*models.DetectionState, len(sensors))
+    for i := range states {
+      stateBySensor[states[i].SensorID] = &states[i]
+    }
+
+    updated := make([]models.DetectionState, 0, len(sensors))
+    for _, sensor := range sensors {
+      state, ok := stateBySensor[sensor.ID]
+      if !ok {
+        state = &models.DetectionState{SensorID: sensor.ID}
+      }
+      peakChanged := false
+      for _, reading := range readings[sensor.Guid] {
+        event := s.detector.Feed(state, reading)
+        peakChanged = peakChanged || event == DetectionUpdated
+        if err := s.apply(tx, sensor, state, event); err != nil {
+          return err
+        }
+      }
+      if peakChanged && state.EpisodeID != 0 {
+        err := tx.Model(&models.VapeEpisode{}).Where("id = ?", state.EpisodeID).Updates(map[string]interface{}{
+          "peak_tvoc": state.EpisodePeakTvoc,
+          "peak_co2": state.EpisodePeakCo2,
+        }).Error
+        if err != nil {
+          return err
+        }
+        err = s.incidentService.Progress(tx, state.IncidentID, state.EpisodePeakTvoc, state.EpisodePeakCo2, nil)
+        if err != nil {
+          return err
+        }
+      }
+      updated = append(updated, *state)
+    }
+    return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&updated).Error
+  })
+  if err != nil {
+    log.Println("Error detect vape episodes: ", err)
+  }
+}
+
+// apply stores episode start and end with incident it opens, peak updates are
+// written once per batch
+func (s detectionService) apply(tx *gorm.DB, sensor models.Sensor, state *models.DetectionState, event DetectionEvent) error {
+  switch event {
+  case DetectionStarted:
+    episode := models.VapeEpisode{
+      SensorID: sensor.ID,
+      RoomID: sensor.RoomID,
+      ZoneID: sensor.ZoneID,
+      StartedAt: *state.EpisodeStartedAt,
+      PeakTvoc: state.EpisodePeakTvoc,
+      PeakCo2: state.EpisodePeakCo2,
+      BaselineTvoc: int(state.EpisodeBaseline),
+    }
+    if err := tx.Create(&episode).Error; err != nil {
+      return err
+    }
+    state.EpisodeID = episode.ID
+    incident := models.Incident{
+      SensorID: sensor.ID,
+      RoomID: sensor.RoomID,
+      ZoneID: sensor.ZoneID,
+      EpisodeID: &episode.ID,
+      Severity: schemas.SeverityCritical,
+      StartedAt: episode.StartedAt,
+      PeakTvoc: episode.PeakTvoc,
+      PeakCo2: episode.PeakCo2,
+    }
+    if err := s.incidentService.Open(tx, &incident); err != nil {
+      return err
+    }
+    state.IncidentID = incident.ID
+  case DetectionEnded:
+    err := tx.Model(&models.VapeEpisode{}).Where("id = ?", state.EpisodeID).Updates(map[string]interface{}{
+      "peak_tvoc": state.EpisodePeakTvoc,
+      "peak_co2": state.EpisodePeakCo2,
+      "ended_at": state.EpisodeEndedAt,
+    }).Error
+    if err != nil {
+      return err
+    }
+    err = s.incidentService.Progress(tx, state.IncidentID, state.EpisodePeakTvoc, state.EpisodePeakCo2, state.EpisodeEndedAt)
+    state.EpisodeID = 0
+    state.IncidentID = 0
+    return err
+  }
+  return nil
+}
+
+func (s detectionService) FindEpisodes(ownerID uint, filters schemas.VapeEpisodeFindSchema) ([]schemas.VapeEpisodeSchema, error) {
+  query := s.db.Model(&models.VapeEpisode{}).
+    Joins("JOIN rooms ON rooms.id = vape_episodes.room_id").
+    Where("rooms.owner_id = ?", ownerID).
+    Order("vape_episodes.started_at DESC")
+  if filters.SensorID != nil {
+    query = query.Where("vape_episodes.sensor_id = ?", *filters.SensorID)
+  }
+  if filters.RoomID != nil {
+    query = query.Where("vape_episodes.room_id = ?", *filters.RoomID)
+  }
+  if filters.ZoneID != nil {
+    query = query.Where("vape_episodes.zone_id = ?", *filters.ZoneID)
+  }
+  if len(filters.From) > 0 {
+    from, err := time.Parse(time.RFC3339, filters.From)
+    if err != nil {
+      return nil, err
+    }
+    query = query.Where("vape_episodes.started_at >= ?", from)
+  }
+  if len(filters.To) > 0 {
+    to, err := time.Parse(time.RFC3339, filters.To)
+    if err != nil {
+      return nil, err
+    }
+    query = query.Where("vape_episodes.started_at < ?", to)
+  }
+
+  var episodes []models.VapeEpisode
+  if err := query.Find(&episodes).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.VapeEpisodeSchema, 0, len(episodes))
+  for _, episode := range episodes {
+    resp = append(resp, s.modelToSchema(episode))
+  }
+  return resp, nil
+}
+
+func (s detectionService) TakeEpisode(episodeID uint) (schemas.VapeEpisodeSchema, error) {
+  var model models.VapeEpisode
+  if err := s.take(episodeID, &model, nil); err != nil {
+    return schemas.VapeEpisodeSchema{}, err
+  }
+  return s.modelToSchema(model), nil
+}
+
+func NewDetectionService(db *gorm.DB, detector VapeDetector, incidentService IncidentService) DetectionService {
+  return detectionService{baseService: baseService{db: db}, detector: detector, incidentService: incidentService}
+}
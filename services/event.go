@@ -0,0 +1,66 @@
+package services
+
+import (
+  "strings"
+  "time"
+
+  "gorm.io/gorm"
+  "antivape/schemas"
+)
+
+// EventSubscriber handles event in transaction of change which caused it, so
+// subscribers enqueue their work only if the change is committed
+type EventSubscriber interface {
+  Handle(tx *gorm.DB, event schemas.EventSchema) error
+}
+
+type EventPublisher interface {
+  Publish(tx *gorm.DB, event schemas.EventSchema) error
+}
+
+// EventBus fans events out to subscribers. Subscribers are added while app is
+// wired, before any event is published.
+type EventBus struct {
+  subscribers []EventSubscriber
+}
+
+func (b *EventBus) Subscribe(subscriber EventSubscriber) {
+  b.subscribers = append(b.subscribers, subscriber)
+}
+
+func (b *EventBus) Publish(tx *gorm.DB, event schemas.EventSchema) error {
+  for _, subscriber := range b.subscribers {
+    if err := subscriber.Handle(tx, event); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func NewEventBus() *EventBus {
+  return &EventBus{}
+}
+
+// retryBackoff is delay after failed attempt of event delivery, it doubles
+// from base with every attempt up to limit
+func retryBackoff(base, limit time.Duration, attempts int) time.Duration {
+  delay := base
+  for i := 1; i < attempts && delay < limit; i++ {
+    delay *= 2
+  }
+  return min(delay, limit)
+}
+
+// subscribedTo checks event type against comma separated list, empty list
+// means every event type
+func subscribedTo(eventTypes string, eventType string) bool {
+  if len(eventTypes) == 0 {
+    return true
+  }
+  for _, subscribed := range strings.Split(eventTypes, ",") {
+    if subscribed == eventType {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "fmt"
+  "io"
+  "strconv"
+  "time"
+
+  "gorm.io/gorm"
+  "antivape/schemas"
+)
+
+// Rows written between flushes of export stream
+const exportFlushRows = 1000
+
+// export_query selects readings with names joined in, %s is replaced by scope
+// and interval conditions
+const export_query string = `
+SELECT sensor_data.measured_at, sensors.id AS sensor_id, sensors.name AS sensor_name, sensors.guid,
+  rooms.id AS room_id, rooms.name AS room_name, COALESCE(zones.id, 0) AS zone_id, COALESCE(zones.name, '') AS zone_name,
+  sensor_data.co2, sensor_data.tvoc, sensor_data.battery_charge
+FROM sensor_data
+JOIN sensors ON sensor_data.guid = sensors.guid AND sensors.deleted_at IS NULL
+JOIN rooms ON rooms.id = sensors.room_id
+LEFT JOIN zones ON zones.id = rooms.zone_id
+WHERE sensor_data.deleted_at IS NULL AND %s
+ORDER BY sensor_data.measured_at, sensor_data.id;
+`
+
+var exportHeader = []string{
+  "measured_at", "sensor_id", "sensor_name", "guid", "room_id", "room_name", "zone_id", "zone_name", "co2", "tvoc", "battery_charge",
+}
+
+// ExportWriter writes export rows in some format
+type ExportWriter interface {
+  Write(row schemas.ExportRowSchema) error
+  // Flush writes buffered rows to underlying writer
+  Flush() error
+}
+
+type csvExportWriter struct {
+  writer *csv.Writer
+  headerWritten bool
+}
+
+func (w *csvExportWriter) Write(row schemas.ExportRowSchema) error {
+  if !w.headerWritten {
+    w.headerWritten = true
+    if err := w.writer.Write(exportHeader); err != nil {
+      return err
+    }
+  }
+  return w.writer.Write([]string{
+    row.MeasuredAt.UTC().Format(time.RFC3339Nano),
+    strconv.FormatUint(uint64(row.SensorID), 10),
+    row.SensorName,
+    row.Guid,
+    strconv.FormatUint(uint64(row.RoomID), 10),
+    row.RoomName,
+    strconv.FormatUint(uint64(row.ZoneID), 10),
+    row.ZoneName,
+    strconv.Itoa(row.Co2),
+    strconv.Itoa(row.Tvoc),
+    strconv.Itoa(row.BatteryCharge),
+  })
+}
+
+func (w *csvExportWriter) Flush() error {
+  if !w.headerWritten {
+    w.headerWritten = true
+    if err := w.writer.Write(exportHeader); err != nil {
+      return err
+    }
+  }
+  w.writer.Flush()
+  return w.writer.Error()
+}
+
+type ndjsonExportWriter struct {
+  encoder *json.Encoder
+}
+
+func (w ndjsonExportWriter) Write(row schemas.ExportRowSchema) error {
+  row.MeasuredAt = row.MeasuredAt.UTC()
+  return w.encoder.Encode(row)
+}
+
+func (w ndjsonExportWriter) Flush() error {
+  return nil
+}
+
+// NewExportWriter returns writer of csv or ndjson format into w. CSV has
+// header even if there are no rows.
+func NewExportWriter(format string, w io.Writer) ExportWriter {
+  if format == schemas.ExportNDJSON {
+    return ndjsonExportWriter{encoder: json.NewEncoder(w)}
+  }
+  return &csvExportWriter{writer: csv.NewWriter(w)}
+}
+
+type ExportService interface {
+  // Export streams readings selected by valid schema into w row by row
+  Export(schema schemas.ExportFindSchema, w io.Writer) error
+}
+
+type exportService struct {
+  baseService
+}
+
+// flusher is buffered writer of export stream
+type flusher interface {
+  Flush() error
+}
+
+func (s exportService) Export(schema schemas.ExportFindSchema, w io.Writer) error {
+  args := map[string]interface{}{}
+  condition := ""
+  if schema.SensorID != nil {
+    condition = "sensors.id = @scope"
+    args["scope"] = *schema.SensorID
+  } else if schema.RoomID != nil {
+    condition = "rooms.id = @scope"
+    args["scope"] = *schema.RoomID
+  } else if schema.ZoneID != nil {
+    condition = "rooms.zone_id = @scope"
+    args["scope"] = *schema.ZoneID
+  }
+  from, to, _ := schema.Statistic().Range(time.Now())
+  if from != nil {
+    condition += " AND sensor_data.measured_at >= @from"
+    args["from"] = *from
+  }
+  if to != nil {
+    condition += " AND sensor_data.measured_at < @to"
+    args["to"] = *to
+  }
+
+  rows, err := s.db.Raw(fmt.Sprintf(export_query, condition), args).Rows()
+  if err != nil {
+    return err
+  }
+  defer rows.Close()
+
+  writer := NewExportWriter(schema.Format, w)
+  flush := func() error {
+    if err := writer.Flush(); err != nil {
+      return err
+    }
+    if f, ok := w.(flusher); ok {
+      return f.Flush()
+    }
+    return nil
+  }
+  written := 0
+  for rows.Next() {
+    var row schemas.ExportRowSchema
+    if err := s.db.ScanRows(rows, &row); err != nil {
+      return err
+    }
+    if err := writer.Write(row); err != nil {
+      return err
+    }
+    written++
+    if written % exportFlushRows == 0 {
+      if err := flush(); err != nil {
+        return err
+      }
+    }
+  }
+  if err := rows.Err(); err != nil {
+    return err
+  }
+  return flush()
+}
+
+func NewExportService(db *gorm.DB) ExportService {
+  return exportService{baseService: baseService{db: db}}
+}
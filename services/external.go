@@ -1,83 +1,287 @@
 package services
 
 import (
-  "context"
+  "errors"
+  "fmt"
   "log"
+  "sort"
   "time"
 
+  "antivape/buffers"
   "antivape/schemas"
   models "antivape/db"
   "gorm.io/gorm"
-  "github.com/redis/go-redis/v9"
+  "gorm.io/gorm/clause"
 )
 
+const (
+  transferBatchSize = 1000
+  transferBlock = 3 * time.Second
+  // Entry of failed batch delivered this many times is stored alone and
+  // dead-lettered if it still fails
+  transferMaxDeliveries = 5
+)
+
+var ErrInvalidSensorData = errors.New("Invalid sensor data")
+
+// MeasurementLimits bound device supplied measured_at relative to receive time.
+// Readings buffered on device while it was offline are accepted up to MaxAge late.
+type MeasurementLimits struct {
+  MaxFutureSkew time.Duration
+  MaxAge time.Duration
+}
+
+// SensorDataConsumer is given readings of known sensors after they are stored
+type SensorDataConsumer interface {
+  Consume(data []models.SensorData)
+}
+
 type ExternalService interface {
-  Store(schema schemas.ExternalSensorDataSchema)
-  PopAll() []schemas.ExternalSensorDataSchema
+  Store(schema schemas.ExternalSensorDataSchema) error
+  StoreBatch(batch []schemas.ExternalSensorDataSchema) []error
   RunTransferingCycle()
 }
 
+// externalService validates readings into buffer and moves them to postgres.
+// Entries are acked only after they are stored, so delivery is at-least-once,
+// readings are unique by guid and measured_at, so they are stored once.
 type externalService struct {
   baseService
-  redisConn *redis.Client
-  db *gorm.DB
-  ctx context.Context
+  buffer buffers.SensorDataBuffer
+  limits MeasurementLimits
+  consumers []SensorDataConsumer
 }
 
-func (s externalService) Store(schema schemas.ExternalSensorDataSchema) {
-  key := generateKey(schema.Guid)
-  s.redisConn.HSet(s.ctx, key, "guid", schema.Guid).Err()
-  s.redisConn.HSet(s.ctx, key, "co2", schema.Co2).Err()
-  s.redisConn.HSet(s.ctx, key, "tvoc", schema.Tvoc).Err()
-  err := s.redisConn.HSet(s.ctx, key, "batteryCharge", schema.BatteryCharge).Err()
-  if err != nil {
-    log.Fatal(err)
+func (s externalService) Store(schema schemas.ExternalSensorDataSchema) error {
+  if err := s.validate(&schema, time.Now()); err != nil {
+    return err
   }
+  return s.buffer.Push(schema)[0]
 }
 
-func (s externalService) PopAll() []schemas.ExternalSensorDataSchema {
-  var data []schemas.ExternalSensorDataSchema
-  var schema schemas.ExternalSensorDataSchema
-
-  iter := s.redisConn.Scan(s.ctx, 0, "*", 0).Iterator()
-  for iter.Next(s.ctx) {
-    if err := s.redisConn.HGetAll(s.ctx, iter.Val()).Scan(&schema); err != nil {
-      log.Fatal(err)
+// StoreBatch validates every item and writes the valid ones in a single
+// buffer push. The returned slice is index-aligned with batch, nil meaning
+// the item was accepted.
+func (s externalService) StoreBatch(batch []schemas.ExternalSensorDataSchema) []error {
+  errs := make([]error, len(batch))
+  valid := make([]schemas.ExternalSensorDataSchema, 0, len(batch))
+  validIndexes := make([]int, 0, len(batch))
+  now := time.Now()
+  for i, schema := range batch {
+    if errs[i] = s.validate(&schema, now); errs[i] != nil {
+      continue
     }
-    s.redisConn.Del(s.ctx, iter.Val())
-    data = append(data, schema)
+    valid = append(valid, schema)
+    validIndexes = append(validIndexes, i)
   }
-  if err := iter.Err(); err != nil {
-    log.Fatal(err)
+  if len(valid) == 0 {
+    return errs
   }
 
-  return data
+  for j, err := range s.buffer.Push(valid...) {
+    errs[validIndexes[j]] = err
+  }
+  return errs
 }
 
 func (s externalService) RunTransferingCycle() {
-  for range(time.Tick(time.Second * 3)) {
-    data := s.PopAll()
-    var dataModels []models.SensorData
-    for _, sensorData := range data {
-      dataModels = append(
-        dataModels,
-        models.SensorData{
-          Guid: sensorData.Guid,
-          Co2: sensorData.Co2,
-          Tvoc: sensorData.Tvoc,
-          BatteryCharge: sensorData.BatteryCharge,
+  for {
+    entries, err := s.buffer.Fetch(transferBatchSize, transferBlock)
+    if err != nil {
+      log.Println("Error fetch sensor data: ", err)
+      time.Sleep(transferBlock)
+      continue
+    }
+    s.transfer(entries)
+  }
+}
+
+func (s externalService) transfer(entries []buffers.Entry) {
+  if len(entries) == 0 {
+    return
+  }
+  if err := s.commit(entries); err != nil {
+    log.Println("Error store sensor data: ", err)
+    s.settle(entries)
+  }
+}
+
+// commit stores entries, acks them and passes new readings of known sensors to consumers
+func (s externalService) commit(entries []buffers.Entry) error {
+  knownGuids, err := s.knownGuids(entries)
+  if err != nil {
+    return err
+  }
+
+  ids := make([]string, 0, len(entries))
+  dataModels := make([]models.SensorData, 0, len(entries))
+  quarantinedModels := make([]models.QuarantinedSensorData, 0)
+  for _, entry := range entries {
+    ids = append(ids, entry.ID)
+    if _, ok := knownGuids[entry.Data.Guid]; !ok {
+      quarantinedModels = append(
+        quarantinedModels,
+        models.QuarantinedSensorData{
+          Guid: entry.Data.Guid,
+          Co2: entry.Data.Co2,
+          Tvoc: entry.Data.Tvoc,
+          BatteryCharge: entry.Data.BatteryCharge,
+          MeasuredAt: *entry.Data.MeasuredAt,
         },
       )
+      continue
     }
-    s.create(&dataModels)
+    dataModels = append(
+      dataModels,
+      models.SensorData{
+        Guid: entry.Data.Guid,
+        Co2: entry.Data.Co2,
+        Tvoc: entry.Data.Tvoc,
+        BatteryCharge: entry.Data.BatteryCharge,
+        MeasuredAt: *entry.Data.MeasuredAt,
+      },
+    )
   }
+  // Late readings are placed by measurement time, not by arrival
+  sort.SliceStable(dataModels, func(i, j int) bool { return dataModels[i].MeasuredAt.Before(dataModels[j].MeasuredAt) })
+
+  // Entries delivered again after failed ack are stored already, conflicts
+  // on reading key skip them instead of storing duplicates
+  err = s.db.Transaction(func(tx *gorm.DB) error {
+    fresh, err := newReadings(tx, dataModels)
+    if err != nil {
+      return err
+    }
+    dataModels = fresh
+    if len(dataModels) > 0 {
+      if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&dataModels).Error; err != nil {
+        return err
+      }
+    }
+    if len(quarantinedModels) > 0 {
+      return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&quarantinedModels).Error
+    }
+    return nil
+  })
+  if err != nil {
+    return err
+  }
+  if err := s.buffer.Ack(ids...); err != nil {
+    log.Println("Error ack sensor data: ", err)
+  }
+  if len(dataModels) == 0 {
+    return nil
+  }
+  for _, consumer := range s.consumers {
+    consumer.Consume(dataModels)
+  }
+  return nil
 }
 
-func NewExternalService(redisConn *redis.Client, db *gorm.DB) ExternalService {
-  ctx := context.Background()
-  return externalService{redisConn: redisConn, ctx: ctx, baseService: baseService{db: db}}
+// settle stores entries of failed batch which were delivered too many times
+// one by one, so reading which can't be stored doesn't hold back others, and
+// dead-letters those which still fail. Nothing is given up while postgres is
+// unreachable.
+func (s externalService) settle(entries []buffers.Entry) {
+  exhausted := make([]buffers.Entry, 0)
+  for _, entry := range entries {
+    if entry.Deliveries >= transferMaxDeliveries {
+      exhausted = append(exhausted, entry)
+    }
+  }
+  if len(exhausted) == 0 {
+    return
+  }
+  sqlDB, err := s.db.DB()
+  if err != nil || sqlDB.Ping() != nil {
+    return
+  }
+
+  for _, entry := range exhausted {
+    if err := s.commit([]buffers.Entry{entry}); err != nil {
+      log.Println("Error store sensor data ", entry.ID, ", moving it to dead letter: ", err)
+      if err := s.buffer.DeadLetter(entry); err != nil {
+        log.Println("Error dead letter sensor data: ", err)
+      }
+    }
+  }
+}
+
+// readingKey identifies reading, measured_at is stored with microsecond precision
+type readingKey struct {
+  guid string
+  measuredAt int64
+}
+
+// newReadings drops readings which are stored already or repeated in data
+func newReadings(tx *gorm.DB, data []models.SensorData) ([]models.SensorData, error) {
+  if len(data) == 0 {
+    return data, nil
+  }
+  keys := make([][]interface{}, 0, len(data))
+  for _, model := range data {
+    keys = append(keys, []interface{}{model.Guid, model.MeasuredAt})
+  }
+  var stored []models.SensorData
+  // Soft deleted readings still hold their key
+  err := tx.Unscoped().Select("guid", "measured_at").Where("(guid, measured_at) IN ?", keys).Find(&stored).Error
+  if err != nil {
+    return nil, err
+  }
+
+  seen := make(map[readingKey]struct{}, len(stored) + len(data))
+  for _, model := range stored {
+    seen[readingKey{model.Guid, model.MeasuredAt.UnixMicro()}] = struct{}{}
+  }
+  fresh := make([]models.SensorData, 0, len(data))
+  for _, model := range data {
+    key := readingKey{model.Guid, model.MeasuredAt.UnixMicro()}
+    if _, ok := seen[key]; ok {
+      continue
+    }
+    seen[key] = struct{}{}
+    fresh = append(fresh, model)
+  }
+  return fresh, nil
+}
+
+// knownGuids returns guids of entries which belong to sensors, others go to quarantine
+func (s externalService) knownGuids(entries []buffers.Entry) (map[string]struct{}, error) {
+  guids := make([]string, 0, len(entries))
+  for _, entry := range entries {
+    guids = append(guids, entry.Data.Guid)
+  }
+  var known []string
+  result := s.db.Model(&models.Sensor{}).Distinct().Where("guid IN ?", guids).Pluck("guid", &known)
+  if result.Error != nil {
+    return nil, result.Error
+  }
+
+  knownGuids := make(map[string]struct{}, len(known))
+  for _, guid := range known {
+    knownGuids[guid] = struct{}{}
+  }
+  return knownGuids, nil
+}
+
+// validate checks reading and sets missing measured_at to receive time
+func (s externalService) validate(schema *schemas.ExternalSensorDataSchema, now time.Time) error {
+  if err := schema.Validate(); err != nil {
+    return fmt.Errorf("%w: %s", ErrInvalidSensorData, err)
+  }
+  if schema.MeasuredAt == nil {
+    schema.MeasuredAt = &now
+    return nil
+  }
+  if schema.MeasuredAt.After(now.Add(s.limits.MaxFutureSkew)) {
+    return fmt.Errorf("%w: measured_at is more than %s in the future", ErrInvalidSensorData, s.limits.MaxFutureSkew)
+  }
+  if schema.MeasuredAt.Before(now.Add(-s.limits.MaxAge)) {
+    return fmt.Errorf("%w: measured_at is more than %s in the past", ErrInvalidSensorData, s.limits.MaxAge)
+  }
+  return nil
 }
 
-func generateKey(guid string) string {
-  return time.Now().String() + "-" + guid
+func NewExternalService(buffer buffers.SensorDataBuffer, db *gorm.DB, limits MeasurementLimits, consumers ...SensorDataConsumer) ExternalService {
+  return externalService{buffer: buffer, limits: limits, consumers: consumers, baseService: baseService{db: db}}
 }
@@ -0,0 +1,109 @@
+package services
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "errors"
+
+  "gorm.io/gorm"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+var ErrGatewayNotFound = errors.New("Gateway not found")
+
+type GatewayService interface {
+  Take(gatewayID uint) (schemas.GatewaySchema, error)
+  // Find returns gateways of zones owned by user, all of them for superuser
+  Find(userID uint, superuser bool, filters schemas.GatewayFindSchema) ([]schemas.GatewaySchema, error)
+  // Create returns gateway with its secret, it can't be taken later
+  Create(schema schemas.GatewayCreateSchema) (schemas.GatewaySchema, error)
+  // Rotate replaces gateway secret, previous one stops working at once
+  Rotate(gatewayID uint) (schemas.GatewaySchema, error)
+  Delete(gatewayID uint) error
+}
+
+type gatewayService struct {
+  baseService
+}
+
+func (s gatewayService) modelToSchema(model models.Gateway) schemas.GatewaySchema {
+  return schemas.GatewaySchema{
+    ID: model.ID,
+    ZoneID: model.ZoneID,
+    Name: model.Name,
+    Guid: model.Guid,
+    CreatedAt: model.CreatedAt,
+  }
+}
+
+func (s gatewayService) Take(gatewayID uint) (schemas.GatewaySchema, error) {
+  var model models.Gateway
+  if err := s.take(gatewayID, &model, nil); err != nil {
+    return schemas.GatewaySchema{}, ErrGatewayNotFound
+  }
+  return s.modelToSchema(model), nil
+}
+
+func (s gatewayService) Find(userID uint, superuser bool, filters schemas.GatewayFindSchema) ([]schemas.GatewaySchema, error) {
+  query := s.db.Model(&models.Gateway{}).Order("gateways.id")
+  if !superuser {
+    query = query.Joins("JOIN zones ON zones.id = gateways.zone_id").Where("zones.owner_id = ?", userID)
+  }
+  if filters.ZoneID != nil {
+    query = query.Where("gateways.zone_id = ?", *filters.ZoneID)
+  }
+  var gateways []models.Gateway
+  if err := query.Find(&gateways).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.GatewaySchema, 0, len(gateways))
+  for _, gateway := range gateways {
+    resp = append(resp, s.modelToSchema(gateway))
+  }
+  return resp, nil
+}
+
+func (s gatewayService) Create(schema schemas.GatewayCreateSchema) (schemas.GatewaySchema, error) {
+  guid := make([]byte, 16)
+  if _, err := rand.Read(guid); err != nil {
+    return schemas.GatewaySchema{}, err
+  }
+  secret, err := generateSecret()
+  if err != nil {
+    return schemas.GatewaySchema{}, err
+  }
+  model := models.Gateway{ZoneID: schema.ZoneID, Name: schema.Name, Guid: "gw-" + hex.EncodeToString(guid), Secret: secret}
+  if err := s.create(&model); err != nil {
+    return schemas.GatewaySchema{}, err
+  }
+  resp := s.modelToSchema(model)
+  resp.Secret = secret
+  return resp, nil
+}
+
+func (s gatewayService) Rotate(gatewayID uint) (schemas.GatewaySchema, error) {
+  var model models.Gateway
+  if err := s.take(gatewayID, &model, nil); err != nil {
+    return schemas.GatewaySchema{}, ErrGatewayNotFound
+  }
+  secret, err := generateSecret()
+  if err != nil {
+    return schemas.GatewaySchema{}, err
+  }
+  if err := s.update(&models.Gateway{}, gatewayID, map[string]interface{}{"secret": secret}); err != nil {
+    return schemas.GatewaySchema{}, err
+  }
+  resp := s.modelToSchema(model)
+  resp.Secret = secret
+  return resp, nil
+}
+
+// Delete removes gateway for good, so its guid can't authenticate anymore
+func (s gatewayService) Delete(gatewayID uint) error {
+  return s.db.Unscoped().Delete(&models.Gateway{}, gatewayID).Error
+}
+
+func NewGatewayService(db *gorm.DB) GatewayService {
+  return gatewayService{baseService: baseService{db: db}}
+}
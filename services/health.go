@@ -0,0 +1,419 @@
+package services
+
+import (
+  "log"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+const (
+  healthPoll = 30 * time.Second
+  healthBatchSize = 100
+  // Charge must rise this much above low level to end battery_low, so noisy
+  // readings around the level don't flap
+  batteryLowHysteresis = 5
+)
+
+type HealthConfig struct {
+  // Heartbeat of sensors which have none set
+  Heartbeat time.Duration
+  // Sensor is flatlined when co2 and tvoc don't change for FlatlineDuration
+  FlatlineDuration time.Duration
+  // Battery drop is loss of BatteryDrop percents within BatteryDropWindow
+  BatteryDrop int
+  BatteryDropWindow time.Duration
+  // Charge below which sensor is reported as battery_low, 0 switches it off
+  BatteryLow int
+}
+
+// DeviceMonitor is pure tamper checks algorithm over per-sensor state
+type DeviceMonitor struct {
+  config HealthConfig
+}
+
+// DeviceChange is what reading changed in device state
+type DeviceChange struct {
+  Flatlined bool
+  Recovered bool
+  // Battery percents lost, 0 if no drop
+  BatteryDrop int
+  BatteryLow bool
+  BatteryReplaced bool
+}
+
+// Feed advances state by reading. Readings older than the last one are ignored.
+func (m DeviceMonitor) Feed(state *models.DeviceState, reading models.SensorData) DeviceChange {
+  var change DeviceChange
+  if !state.LastAt.IsZero() && !reading.MeasuredAt.After(state.LastAt) {
+    return change
+  }
+  first := state.LastAt.IsZero()
+  state.LastAt = reading.MeasuredAt
+
+  if first || reading.Co2 != state.FlatCo2 || reading.Tvoc != state.FlatTvoc {
+    change.Recovered = state.FlatlineEventID != 0
+    state.FlatCo2 = reading.Co2
+    state.FlatTvoc = reading.Tvoc
+    state.FlatSince = reading.MeasuredAt
+  } else if state.FlatlineEventID == 0 && m.config.FlatlineDuration > 0 &&
+    reading.MeasuredAt.Sub(state.FlatSince) >= m.config.FlatlineDuration {
+    change.Flatlined = true
+  }
+
+  if first || reading.BatteryCharge > state.BatteryRef || reading.MeasuredAt.Sub(state.BatteryRefAt) > m.config.BatteryDropWindow {
+    state.BatteryRef = reading.BatteryCharge
+    state.BatteryRefAt = reading.MeasuredAt
+  } else if m.config.BatteryDrop > 0 && state.BatteryRef - reading.BatteryCharge >= m.config.BatteryDrop {
+    change.BatteryDrop = state.BatteryRef - reading.BatteryCharge
+    state.BatteryRef = reading.BatteryCharge
+    state.BatteryRefAt = reading.MeasuredAt
+  }
+
+  if state.BatteryLowEventID == 0 && reading.BatteryCharge < m.config.BatteryLow {
+    change.BatteryLow = true
+  } else if state.BatteryLowEventID != 0 && reading.BatteryCharge >= m.config.BatteryLow + batteryLowHysteresis {
+    change.BatteryReplaced = true
+  }
+  return change
+}
+
+type HealthService interface {
+  SensorDataConsumer
+  // FindEvents returns last 100 device events of sensor, newest first
+  FindEvents(sensorID uint) ([]schemas.DeviceEventSchema, error)
+  FindZoneHealth(zoneID uint) ([]schemas.SensorHealthSchema, error)
+  RunHealthCycle()
+}
+
+// healthService tracks when sensors were last seen and records offline,
+// flatline, battery drop and low battery events, publishing them to event bus.
+type healthService struct {
+  baseService
+  monitor DeviceMonitor
+  publisher EventPublisher
+}
+
+func (s healthService) eventToSchema(model models.DeviceEvent) schemas.DeviceEventSchema {
+  return schemas.DeviceEventSchema{
+    ID: model.ID,
+    SensorID: model.SensorID,
+    RoomID: model.RoomID,
+    ZoneID: model.ZoneID,
+    Type: model.Type,
+    StartedAt: model.StartedAt,
+    EndedAt: model.EndedAt,
+    Value: model.Value,
+  }
+}
+
+func (s healthService) publish(tx *gorm.DB, eventType string, event models.DeviceEvent) error {
+  return s.publisher.Publish(tx, schemas.EventSchema{
+    Type: eventType,
+    ZoneID: event.ZoneID,
+    RoomID: event.RoomID,
+    SensorID: event.SensorID,
+    OccurredAt: time.Now(),
+    Data: s.eventToSchema(event),
+  })
+}
+
+// Consume marks sensors seen at their newest readings and feeds readings to tamper checks. State
+// rows are locked for the batch like in detection.
+func (s healthService) Consume(data []models.SensorData) {
+  readings := make(map[string][]models.SensorData)
+  guids := make([]string, 0)
+  for _, reading := range data {
+    if _, ok := readings[reading.Guid]; !ok {
+      guids = append(guids, reading.Guid)
+    }
+    readings[reading.Guid] = append(readings[reading.Guid], reading)
+  }
+
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    var sensors []models.Sensor
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("guid IN ?", guids).Order("id").Find(&sensors).Error
+    if err != nil || len(sensors) == 0 {
+      return err
+    }
+    sensorIDs := make([]uint, 0, len(sensors))
+    for _, sensor := range sensors {
+      sensorIDs = append(sensorIDs, sensor.ID)
+    }
+    var states []models.DeviceState
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("sensor_id IN ?", sensorIDs).Find(&states).Error; err != nil {
+      return err
+    }
+    stateBySensor := make(map[uint]*models.DeviceState, len(states))
+    for i := range states {
+      stateBySensor[states[i].SensorID] = &states[i]
+    }
+
+    now := time.Now()
+    updated := make([]models.DeviceState, 0, len(sensors))
+    for _, sensor := range sensors {
+      state, ok := stateBySensor[sensor.ID]
+      if !ok {
+        state = &models.DeviceState{SensorID: sensor.ID}
+      }
+      if err := s.seen(tx, sensor, readings[sensor.Guid], now); err != nil {
+        return err
+      }
+      if err := s.check(tx, sensor, state, readings[sensor.Guid]); err != nil {
+        return err
+      }
+      updated = append(updated, *state)
+    }
+    return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&updated).Error
+  })
+  if err != nil {
+    log.Println("Error check sensors health: ", err)
+  }
+}
+
+// seen moves last seen time of sensor to the newest measurement of readings,
+// capped at now, and brings offline sensor back online. Readings buffered on
+// device while it was offline don't bring it back unless they are within
+// heartbeat, heartbeat check would take it offline again.
+func (s healthService) seen(tx *gorm.DB, sensor models.Sensor, readings []models.SensorData, now time.Time) error {
+  latest := readings[0]
+  for _, reading := range readings {
+    if reading.MeasuredAt.After(latest.MeasuredAt) {
+      latest = reading
+    }
+  }
+  seenAt := latest.MeasuredAt
+  if seenAt.After(now) {
+    seenAt = now
+  }
+  if sensor.LastSeenAt != nil && !seenAt.After(*sensor.LastSeenAt) {
+    return nil
+  }
+  heartbeat := time.Duration(sensor.Heartbeat) * time.Second
+  if heartbeat == 0 {
+    heartbeat = s.monitor.config.Heartbeat
+  }
+  online := seenAt.Add(heartbeat).After(now)
+
+  fields := map[string]interface{}{"last_seen_at": seenAt, "last_battery_charge": latest.BatteryCharge}
+  if online {
+    fields["offline"] = false
+  }
+  if err := tx.Model(&models.Sensor{}).Where("id = ?", sensor.ID).Updates(fields).Error; err != nil {
+    return err
+  }
+  if !sensor.Offline || !online {
+    return nil
+  }
+
+  var events []models.DeviceEvent
+  err := tx.Where("sensor_id = ? AND type = ? AND ended_at IS NULL", sensor.ID, schemas.DeviceOffline).Find(&events).Error
+  if err != nil {
+    return err
+  }
+  for _, event := range events {
+    event.EndedAt = &seenAt
+    if err := tx.Model(&models.DeviceEvent{}).Where("id = ?", event.ID).Update("ended_at", seenAt).Error; err != nil {
+      return err
+    }
+    if err := s.publish(tx, schemas.EventSensorOnline, event); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// check feeds readings to monitor and records events it reports
+func (s healthService) check(tx *gorm.DB, sensor models.Sensor, state *models.DeviceState, readings []models.SensorData) error {
+  for _, reading := range readings {
+    change := s.monitor.Feed(state, reading)
+    if change.Recovered {
+      endedAt := reading.MeasuredAt
+      if err := tx.Model(&models.DeviceEvent{}).Where("id = ?", state.FlatlineEventID).Update("ended_at", endedAt).Error; err != nil {
+        return err
+      }
+      state.FlatlineEventID = 0
+    }
+    if change.Flatlined {
+      event := models.DeviceEvent{
+        SensorID: sensor.ID,
+        RoomID: sensor.RoomID,
+        ZoneID: sensor.ZoneID,
+        Type: schemas.DeviceFlatline,
+        StartedAt: state.FlatSince,
+      }
+      if err := tx.Create(&event).Error; err != nil {
+        return err
+      }
+      state.FlatlineEventID = event.ID
+      if err := s.publish(tx, schemas.EventSensorFlatline, event); err != nil {
+        return err
+      }
+    }
+    if change.BatteryDrop > 0 {
+      endedAt := reading.MeasuredAt
+      event := models.DeviceEvent{
+        SensorID: sensor.ID,
+        RoomID: sensor.RoomID,
+        ZoneID: sensor.ZoneID,
+        Type: schemas.DeviceBatteryDrop,
+        StartedAt: reading.MeasuredAt,
+        EndedAt: &endedAt,
+        Value: change.BatteryDrop,
+      }
+      if err := tx.Create(&event).Error; err != nil {
+        return err
+      }
+      if err := s.publish(tx, schemas.EventSensorBatteryDrop, event); err != nil {
+        return err
+      }
+    }
+    if change.BatteryReplaced {
+      endedAt := reading.MeasuredAt
+      if err := tx.Model(&models.DeviceEvent{}).Where("id = ?", state.BatteryLowEventID).Update("ended_at", endedAt).Error; err != nil {
+        return err
+      }
+      state.BatteryLowEventID = 0
+    }
+    if change.BatteryLow {
+      event := models.DeviceEvent{
+        SensorID: sensor.ID,
+        RoomID: sensor.RoomID,
+        ZoneID: sensor.ZoneID,
+        Type: schemas.DeviceBatteryLow,
+        StartedAt: reading.MeasuredAt,
+        Value: reading.BatteryCharge,
+      }
+      if err := tx.Create(&event).Error; err != nil {
+        return err
+      }
+      state.BatteryLowEventID = event.ID
+      if err := s.publish(tx, schemas.EventSensorBatteryLow, event); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+func (s healthService) FindEvents(sensorID uint) ([]schemas.DeviceEventSchema, error) {
+  var events []models.DeviceEvent
+  if err := s.db.Where("sensor_id = ?", sensorID).Order("started_at DESC, id DESC").Limit(100).Find(&events).Error; err != nil {
+    return nil, err
+  }
+  schemasList := make([]schemas.DeviceEventSchema, 0, len(events))
+  for _, event := range events {
+    schemasList = append(schemasList, s.eventToSchema(event))
+  }
+  return schemasList, nil
+}
+
+func sensorStatus(sensor models.Sensor) string {
+  if sensor.LastSeenAt == nil {
+    return schemas.SensorNeverSeen
+  } else if sensor.Offline {
+    return schemas.SensorOffline
+  }
+  return schemas.SensorOnline
+}
+
+func (s healthService) FindZoneHealth(zoneID uint) ([]schemas.SensorHealthSchema, error) {
+  var sensors []models.Sensor
+  if err := s.db.Where("zone_id = ?", zoneID).Order("id").Find(&sensors).Error; err != nil {
+    return nil, err
+  }
+  var events []models.DeviceEvent
+  if err := s.db.Where("zone_id = ? AND ended_at IS NULL", zoneID).Order("started_at").Find(&events).Error; err != nil {
+    return nil, err
+  }
+  openEvents := make(map[uint][]schemas.DeviceEventSchema)
+  for _, event := range events {
+    openEvents[event.SensorID] = append(openEvents[event.SensorID], s.eventToSchema(event))
+  }
+
+  health := make([]schemas.SensorHealthSchema, 0, len(sensors))
+  for _, sensor := range sensors {
+    status := sensorStatus(sensor)
+    heartbeat := sensor.Heartbeat
+    if heartbeat == 0 {
+      heartbeat = int(s.monitor.config.Heartbeat.Seconds())
+    }
+    sensorEvents := openEvents[sensor.ID]
+    if sensorEvents == nil {
+      sensorEvents = []schemas.DeviceEventSchema{}
+    }
+    health = append(health, schemas.SensorHealthSchema{
+      SensorID: sensor.ID,
+      Name: sensor.Name,
+      Guid: sensor.Guid,
+      RoomID: sensor.RoomID,
+      Status: status,
+      LastSeenAt: sensor.LastSeenAt,
+      Heartbeat: heartbeat,
+      BatteryCharge: sensor.LastBatteryCharge,
+      OpenEvents: sensorEvents,
+    })
+  }
+  return health, nil
+}
+
+// RunHealthCycle marks sensors offline when they miss heartbeat. Every app
+// replica runs it, sensors are locked with SKIP LOCKED.
+func (s healthService) RunHealthCycle() {
+  for {
+    if err := s.markOffline(); err != nil {
+      log.Println("Error check sensors heartbeat: ", err)
+    }
+    time.Sleep(healthPoll)
+  }
+}
+
+func (s healthService) markOffline() error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    now := time.Now()
+    var sensors []models.Sensor
+    err := tx.
+      Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+      Where("NOT offline AND last_seen_at IS NOT NULL").
+      Where(
+        "last_seen_at + (CASE WHEN heartbeat > 0 THEN heartbeat ELSE ? END) * INTERVAL '1 second' < ?",
+        int(s.monitor.config.Heartbeat.Seconds()),
+        now,
+      ).
+      Limit(healthBatchSize).
+      Find(&sensors).Error
+    if err != nil {
+      return err
+    }
+    for _, sensor := range sensors {
+      if err := tx.Model(&models.Sensor{}).Where("id = ?", sensor.ID).Update("offline", true).Error; err != nil {
+        return err
+      }
+      event := models.DeviceEvent{
+        SensorID: sensor.ID,
+        RoomID: sensor.RoomID,
+        ZoneID: sensor.ZoneID,
+        Type: schemas.DeviceOffline,
+        StartedAt: *sensor.LastSeenAt,
+      }
+      if err := tx.Create(&event).Error; err != nil {
+        return err
+      }
+      if err := s.publish(tx, schemas.EventSensorOffline, event); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+}
+
+func NewDeviceMonitor(config HealthConfig) DeviceMonitor {
+  return DeviceMonitor{config: config}
+}
+
+func NewHealthService(db *gorm.DB, config HealthConfig, publisher EventPublisher) HealthService {
+  return healthService{baseService: baseService{db: db}, monitor: NewDeviceMonitor(config), publisher: publisher}
+}
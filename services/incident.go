@@ -0,0 +1,275 @@
+package services
+
+import (
+  "errors"
+  "log"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+const (
+  escalationPoll = 30 * time.Second
+  escalationBatchSize = 100
+)
+
+var (
+  ErrIncidentNotFound = errors.New("Incident not found")
+  ErrIncidentState = errors.New("Incident state doesn't allow this action")
+)
+
+type IncidentService interface {
+  // Open stores incident in transaction of detection which caused it. Incident
+  // is grouped into recent one of the same cause if zone policy says so.
+  Open(tx *gorm.DB, incident *models.Incident) error
+  // Progress raises peak values of ongoing incident and closes it when endedAt is given
+  Progress(tx *gorm.DB, incidentID uint, peakTvoc int, peakCo2 int, endedAt *time.Time) error
+  // Find returns incidents in rooms or zones owned by user, all of them for superuser
+  Find(userID uint, superuser bool, filters schemas.IncidentFindSchema) ([]schemas.IncidentSchema, error)
+  Take(incidentID uint) (schemas.IncidentSchema, error)
+  Acknowledge(incidentID uint, userID uint, note string) error
+  Resolve(incidentID uint, userID uint, note string) error
+  RunEscalationCycle()
+}
+
+type incidentService struct {
+  baseService
+  publisher EventPublisher
+}
+
+func (s incidentService) modelToSchema(model models.Incident) schemas.IncidentSchema {
+  return schemas.IncidentSchema{
+    ID: model.ID,
+    SensorID: model.SensorID,
+    RoomID: model.RoomID,
+    ZoneID: model.ZoneID,
+    RuleID: model.RuleID,
+    EpisodeID: model.EpisodeID,
+    Severity: model.Severity,
+    State: model.State,
+    StartedAt: model.StartedAt,
+    EndedAt: model.EndedAt,
+    PeakTvoc: model.PeakTvoc,
+    PeakCo2: model.PeakCo2,
+    AcknowledgedAt: model.AcknowledgedAt,
+    AcknowledgedBy: model.AcknowledgedBy,
+    AcknowledgeNote: model.AcknowledgeNote,
+    ResolvedAt: model.ResolvedAt,
+    ResolvedBy: model.ResolvedBy,
+    ResolveNote: model.ResolveNote,
+    Occurrences: model.Occurrences,
+    EscalatedAt: model.EscalatedAt,
+  }
+}
+
+func (s incidentService) Open(tx *gorm.DB, incident *models.Incident) error {
+  policy, err := zonePolicy(tx, incident.ZoneID)
+  if err != nil {
+    return err
+  }
+  if policy.GroupWindow > 0 {
+    grouped, err := s.group(tx, incident, time.Duration(policy.GroupWindow) * time.Second)
+    if err != nil || grouped {
+      return err
+    }
+  }
+
+  incident.State = schemas.IncidentOpen
+  incident.Occurrences = 1
+  if err := tx.Create(incident).Error; err != nil {
+    return err
+  }
+  return s.publish(tx, schemas.EventIncidentOpened, *incident)
+}
+
+// group reopens unresolved incident of the same sensor and rule, or of the
+// same sensor vape detection, which ended less than window before new one
+// started. Grouped incident is not published again.
+func (s incidentService) group(tx *gorm.DB, incident *models.Incident, window time.Duration) (bool, error) {
+  query := tx.
+    Where("sensor_id = ? AND state <> ?", incident.SensorID, schemas.IncidentResolved).
+    Where("ended_at IS NULL OR ended_at >= ?", incident.StartedAt.Add(-window))
+  if incident.RuleID != nil {
+    query = query.Where("rule_id = ?", *incident.RuleID)
+  } else {
+    query = query.Where("rule_id IS NULL")
+  }
+  var recent models.Incident
+  err := query.Order("started_at DESC").Take(&recent).Error
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+    return false, nil
+  } else if err != nil {
+    return false, err
+  }
+
+  err = tx.Model(&models.Incident{}).Where("id = ?", recent.ID).Updates(map[string]interface{}{
+    "ended_at": nil,
+    "occurrences": gorm.Expr("occurrences + 1"),
+    "peak_tvoc": gorm.Expr("GREATEST(peak_tvoc, ?)", incident.PeakTvoc),
+    "peak_co2": gorm.Expr("GREATEST(peak_co2, ?)", incident.PeakCo2),
+  }).Error
+  if err != nil {
+    return false, err
+  }
+  *incident = recent
+  return true, nil
+}
+
+func (s incidentService) publish(tx *gorm.DB, eventType string, incident models.Incident) error {
+  return s.publisher.Publish(tx, schemas.EventSchema{
+    Type: eventType,
+    ZoneID: incident.ZoneID,
+    RoomID: incident.RoomID,
+    SensorID: incident.SensorID,
+    OccurredAt: time.Now(),
+    Data: s.modelToSchema(incident),
+  })
+}
+
+func (s incidentService) Progress(tx *gorm.DB, incidentID uint, peakTvoc int, peakCo2 int, endedAt *time.Time) error {
+  fields := map[string]interface{}{
+    "peak_tvoc": gorm.Expr("GREATEST(peak_tvoc, ?)", peakTvoc),
+    "peak_co2": gorm.Expr("GREATEST(peak_co2, ?)", peakCo2),
+  }
+  if endedAt != nil {
+    fields["ended_at"] = *endedAt
+  }
+  return tx.Model(&models.Incident{}).Where("id = ?", incidentID).Updates(fields).Error
+}
+
+func (s incidentService) Find(userID uint, superuser bool, filters schemas.IncidentFindSchema) ([]schemas.IncidentSchema, error) {
+  query := s.db.Model(&models.Incident{}).Order("incidents.started_at DESC")
+  if !superuser {
+    query = query.
+      Joins("JOIN rooms ON rooms.id = incidents.room_id").
+      Joins("JOIN zones ON zones.id = incidents.zone_id").
+      Where("rooms.owner_id = ? OR zones.owner_id = ?", userID, userID)
+  }
+  if filters.SensorID != nil {
+    query = query.Where("incidents.sensor_id = ?", *filters.SensorID)
+  }
+  if filters.RoomID != nil {
+    query = query.Where("incidents.room_id = ?", *filters.RoomID)
+  }
+  if filters.ZoneID != nil {
+    query = query.Where("incidents.zone_id = ?", *filters.ZoneID)
+  }
+  if len(filters.State) > 0 {
+    query = query.Where("incidents.state = ?", filters.State)
+  }
+  if len(filters.From) > 0 {
+    from, err := time.Parse(time.RFC3339, filters.From)
+    if err != nil {
+      return nil, err
+    }
+    query = query.Where("incidents.started_at >= ?", from)
+  }
+  if len(filters.To) > 0 {
+    to, err := time.Parse(time.RFC3339, filters.To)
+    if err != nil {
+      return nil, err
+    }
+    query = query.Where("incidents.started_at < ?", to)
+  }
+
+  var incidents []models.Incident
+  if err := query.Find(&incidents).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.IncidentSchema, 0, len(incidents))
+  for _, incident := range incidents {
+    resp = append(resp, s.modelToSchema(incident))
+  }
+  return resp, nil
+}
+
+func (s incidentService) Take(incidentID uint) (schemas.IncidentSchema, error) {
+  var model models.Incident
+  if err := s.take(incidentID, &model, nil); err != nil {
+    return schemas.IncidentSchema{}, ErrIncidentNotFound
+  }
+  return s.modelToSchema(model), nil
+}
+
+func (s incidentService) Acknowledge(incidentID uint, userID uint, note string) error {
+  return s.transition(incidentID, schemas.EventIncidentAcknowledged, []string{schemas.IncidentOpen}, map[string]interface{}{
+    "state": schemas.IncidentAcknowledged,
+    "acknowledged_at": time.Now(),
+    "acknowledged_by": userID,
+    "acknowledge_note": note,
+  })
+}
+
+func (s incidentService) Resolve(incidentID uint, userID uint, note string) error {
+  return s.transition(incidentID, schemas.EventIncidentResolved, []string{schemas.IncidentOpen, schemas.IncidentAcknowledged}, map[string]interface{}{
+    "state": schemas.IncidentResolved,
+    "resolved_at": time.Now(),
+    "resolved_by": userID,
+    "resolve_note": note,
+  })
+}
+
+// transition changes state only if incident is still in one of from states,
+// so concurrent acknowledge and resolve can't overwrite each other
+func (s incidentService) transition(incidentID uint, eventType string, from []string, fields map[string]interface{}) error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    result := tx.Model(&models.Incident{}).Where("id = ? AND state IN ?", incidentID, from).Updates(fields)
+    if result.Error != nil {
+      return result.Error
+    }
+    var incident models.Incident
+    if err := tx.Where("id = ?", incidentID).Take(&incident).Error; err != nil {
+      return ErrIncidentNotFound
+    }
+    if result.RowsAffected == 0 {
+      return ErrIncidentState
+    }
+    return s.publish(tx, eventType, incident)
+  })
+}
+
+// RunEscalationCycle escalates incidents which stay open longer than zone
+// policy allows. Every app replica runs it, incidents are locked with SKIP LOCKED.
+func (s incidentService) RunEscalationCycle() {
+  for {
+    if err := s.escalateDue(); err != nil {
+      log.Println("Error escalate incidents: ", err)
+    }
+    time.Sleep(escalationPoll)
+  }
+}
+
+func (s incidentService) escalateDue() error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    now := time.Now()
+    var incidents []models.Incident
+    err := tx.
+      Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "incidents"}, Options: "SKIP LOCKED"}).
+      Joins("JOIN notification_policies ON notification_policies.zone_id = incidents.zone_id").
+      Where("incidents.state = ? AND incidents.escalated_at IS NULL", schemas.IncidentOpen).
+      Where("notification_policies.escalate_after > 0").
+      Where("incidents.created_at + notification_policies.escalate_after * INTERVAL '1 minute' <= ?", now).
+      Limit(escalationBatchSize).
+      Find(&incidents).Error
+    if err != nil {
+      return err
+    }
+    for _, incident := range incidents {
+      incident.EscalatedAt = &now
+      if err := tx.Model(&models.Incident{}).Where("id = ?", incident.ID).Update("escalated_at", now).Error; err != nil {
+        return err
+      }
+      if err := s.publish(tx, schemas.EventIncidentEscalated, incident); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+}
+
+func NewIncidentService(db *gorm.DB, publisher EventPublisher) IncidentService {
+  return incidentService{baseService: baseService{db: db}, publisher: publisher}
+}
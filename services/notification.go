@@ -0,0 +1,392 @@
+package services
+
+import (
+  "errors"
+  "fmt"
+  "log"
+  "strings"
+  "sync"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/notifiers"
+  "antivape/schemas"
+)
+
+var (
+  ErrSubscriptionNotFound = errors.New("Subscription not found")
+  ErrChannelUnavailable = errors.New("Notification channel is not configured")
+)
+
+type NotificationConfig struct {
+  // Notification is failed after this many attempts
+  MaxAttempts int
+  // Delay before second attempt, it doubles with every next one up to BackoffMax
+  BackoffBase time.Duration
+  BackoffMax time.Duration
+  // Lease of claimed notifications, longer than any notifier timeout
+  Lease time.Duration
+}
+
+type NotificationService interface {
+  EventSubscriber
+  Take(subscriptionID uint) (schemas.SubscriptionSchema, error)
+  // Find returns subscriptions of user
+  Find(userID uint) ([]schemas.SubscriptionSchema, error)
+  Create(userID uint, schema schemas.SubscriptionCreateSchema) (schemas.SubscriptionSchema, error)
+  Update(subscriptionID uint, schema schemas.SubscriptionUpdateSchema) error
+  Delete(subscriptionID uint) error
+  FindNotifications(subscriptionID uint) ([]schemas.NotificationSchema, error)
+  RunNotificationCycle()
+}
+
+// notificationService renders event for every matching subscription in
+// transaction of event, worker sends them over channel notifier with retries
+type notificationService struct {
+  baseService
+  notifiers map[string]notifiers.Notifier
+  config NotificationConfig
+}
+
+func (s notificationService) modelToSchema(model models.NotificationSubscription) schemas.SubscriptionSchema {
+  eventTypes := make([]string, 0)
+  if len(model.EventTypes) > 0 {
+    eventTypes = strings.Split(model.EventTypes, ",")
+  }
+  return schemas.SubscriptionSchema{
+    ID: model.ID,
+    UserID: model.UserID,
+    ZoneID: model.ZoneID,
+    RoomID: model.RoomID,
+    Channel: model.Channel,
+    Target: model.Target,
+    EventTypes: eventTypes,
+    Enabled: model.Enabled,
+    Escalation: model.Escalation,
+  }
+}
+
+func (s notificationService) notificationToSchema(model models.Notification) schemas.NotificationSchema {
+  return schemas.NotificationSchema{
+    ID: model.ID,
+    SubscriptionID: model.SubscriptionID,
+    Channel: model.Channel,
+    Target: model.Target,
+    EventType: model.EventType,
+    Subject: model.Subject,
+    Text: model.Text,
+    State: model.State,
+    Attempts: model.Attempts,
+    NextAttemptAt: model.NextAttemptAt,
+    LastError: model.LastError,
+    SentAt: model.SentAt,
+    CreatedAt: model.CreatedAt,
+  }
+}
+
+func (s notificationService) Take(subscriptionID uint) (schemas.SubscriptionSchema, error) {
+  var model models.NotificationSubscription
+  if err := s.take(subscriptionID, &model, nil); err != nil {
+    return schemas.SubscriptionSchema{}, ErrSubscriptionNotFound
+  }
+  return s.modelToSchema(model), nil
+}
+
+func (s notificationService) Find(userID uint) ([]schemas.SubscriptionSchema, error) {
+  var subscriptions []models.NotificationSubscription
+  if err := s.db.Where("user_id = ?", userID).Order("id").Find(&subscriptions).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.SubscriptionSchema, 0, len(subscriptions))
+  for _, subscription := range subscriptions {
+    resp = append(resp, s.modelToSchema(subscription))
+  }
+  return resp, nil
+}
+
+func (s notificationService) Create(userID uint, schema schemas.SubscriptionCreateSchema) (schemas.SubscriptionSchema, error) {
+  if _, ok := s.notifiers[schema.Channel]; !ok {
+    return schemas.SubscriptionSchema{}, ErrChannelUnavailable
+  }
+  model := models.NotificationSubscription{
+    UserID: userID,
+    ZoneID: schema.ZoneID,
+    RoomID: schema.RoomID,
+    Channel: schema.Channel,
+    Target: schema.Target,
+    EventTypes: strings.Join(schema.EventTypes, ","),
+    Enabled: schema.Enabled == nil || *schema.Enabled,
+    Escalation: schema.Escalation,
+  }
+  if err := s.create(&model); err != nil {
+    return schemas.SubscriptionSchema{}, err
+  }
+  return s.modelToSchema(model), nil
+}
+
+func (s notificationService) Update(subscriptionID uint, schema schemas.SubscriptionUpdateSchema) error {
+  fields := make(map[string]interface{})
+  if schema.Target != nil {
+    fields["target"] = *schema.Target
+  }
+  if schema.EventTypes != nil {
+    fields["event_types"] = strings.Join(*schema.EventTypes, ",")
+  }
+  if schema.Enabled != nil {
+    fields["enabled"] = *schema.Enabled
+  }
+  if len(fields) == 0 {
+    return nil
+  }
+  return s.update(&models.NotificationSubscription{}, subscriptionID, fields)
+}
+
+func (s notificationService) Delete(subscriptionID uint) error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    err := tx.Model(&models.Notification{}).
+      Where("subscription_id = ? AND state = ?", subscriptionID, schemas.DeliveryPending).
+      Updates(map[string]interface{}{"state": schemas.DeliveryFailed, "last_error": "Subscription deleted"}).Error
+    if err != nil {
+      return err
+    }
+    return tx.Delete(&models.NotificationSubscription{}, subscriptionID).Error
+  })
+}
+
+func (s notificationService) FindNotifications(subscriptionID uint) ([]schemas.NotificationSchema, error) {
+  var notifications []models.Notification
+  err := s.db.Where("subscription_id = ?", subscriptionID).Order("id DESC").Limit(100).Find(&notifications).Error
+  if err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.NotificationSchema, 0, len(notifications))
+  for _, notification := range notifications {
+    resp = append(resp, s.notificationToSchema(notification))
+  }
+  return resp, nil
+}
+
+// Handle enqueues notification for every enabled subscription to event zone
+// or room. Message is rendered now, so it describes event as it happened.
+// Zone policy suppresses notifications within cooldown and holds them during
+// quiet hours.
+func (s notificationService) Handle(tx *gorm.DB, event schemas.EventSchema) error {
+  var subscriptions []models.NotificationSubscription
+  err := tx.
+    Where("enabled AND zone_id = ? AND (room_id IS NULL OR room_id = ?)", event.ZoneID, event.RoomID).
+    Find(&subscriptions).Error
+  if err != nil {
+    return err
+  }
+  policy, err := zonePolicy(tx, event.ZoneID)
+  if err != nil {
+    return err
+  }
+  incident, isIncident := event.Data.(schemas.IncidentSchema)
+  now := time.Now()
+  sendAt := now
+  if quietHours, ok := quietHoursOf(policy); ok {
+    critical := isIncident && incident.Severity == schemas.SeverityCritical
+    if until, quiet := quietHours.Until(now); quiet && !(policy.QuietAllowCritical && critical) {
+      sendAt = until
+    }
+  }
+
+  notifications := make([]models.Notification, 0, len(subscriptions))
+  subject, text := "", ""
+  for _, subscription := range subscriptions {
+    // Escalation contacts are the second line, they hear only about escalated incidents
+    if subscription.Escalation && event.Type != schemas.EventIncidentEscalated {
+      continue
+    }
+    if !subscribedTo(subscription.EventTypes, event.Type) {
+      continue
+    }
+    if len(subject) == 0 {
+      if subject, text, err = s.render(tx, event); err != nil {
+        return err
+      }
+    }
+    notification := models.Notification{
+      SubscriptionID: subscription.ID,
+      RoomID: event.RoomID,
+      IncidentID: incident.ID,
+      Channel: subscription.Channel,
+      Target: subscription.Target,
+      EventType: event.Type,
+      Subject: subject,
+      Text: text,
+      State: schemas.DeliveryPending,
+      NextAttemptAt: sendAt,
+    }
+    if event.Type == schemas.EventIncidentOpened && policy.Cooldown > 0 {
+      suppressed, err := s.inCooldown(tx, notification, now.Add(-time.Duration(policy.Cooldown) * time.Second))
+      if err != nil {
+        return err
+      }
+      if suppressed {
+        notification.State = schemas.DeliverySuppressed
+      }
+    }
+    notifications = append(notifications, notification)
+  }
+  if len(notifications) == 0 {
+    return nil
+  }
+  return tx.Create(&notifications).Error
+}
+
+// inCooldown checks if subscription was notified about incident in the same room since
+func (s notificationService) inCooldown(tx *gorm.DB, notification models.Notification, since time.Time) (bool, error) {
+  var count int64
+  err := tx.Model(&models.Notification{}).
+    Where("subscription_id = ? AND room_id = ? AND event_type = ?", notification.SubscriptionID, notification.RoomID, notification.EventType).
+    Where("state <> ? AND created_at >= ?", schemas.DeliverySuppressed, since).
+    Count(&count).Error
+  return count > 0, err
+}
+
+// render describes event for people, with room and zone names
+func (s notificationService) render(tx *gorm.DB, event schemas.EventSchema) (string, string, error) {
+  var zone models.Zone
+  if err := tx.Where("id = ?", event.ZoneID).Take(&zone).Error; err != nil {
+    return "", "", err
+  }
+  place := "zone " + zone.Name
+  if event.RoomID != 0 {
+    var room models.Room
+    if err := tx.Where("id = ?", event.RoomID).Take(&room).Error; err != nil {
+      return "", "", err
+    }
+    place = "room " + room.Name + ", " + place
+  }
+  at := event.OccurredAt.Format(time.RFC1123)
+
+  incident, ok := event.Data.(schemas.IncidentSchema)
+  if !ok {
+    switch event.Type {
+    case schemas.EventSensorOffline:
+      return fmt.Sprintf("Sensor #%d is offline", event.SensorID),
+        fmt.Sprintf("Sensor #%d in %s stopped sending data. Checked at %s.", event.SensorID, place, at), nil
+    case schemas.EventSensorOnline:
+      return fmt.Sprintf("Sensor #%d is back online", event.SensorID),
+        fmt.Sprintf("Sensor #%d in %s sends data again since %s.", event.SensorID, place, at), nil
+    case schemas.EventSensorFlatline:
+      return fmt.Sprintf("Sensor #%d values flatlined", event.SensorID),
+        fmt.Sprintf("Sensor #%d in %s reports the same CO2 and TVOC for too long, it may be covered or broken. Detected at %s.", event.SensorID, place, at), nil
+    case schemas.EventSensorBatteryDrop:
+      drop := 0
+      if deviceEvent, ok := event.Data.(schemas.DeviceEventSchema); ok {
+        drop = deviceEvent.Value
+      }
+      return fmt.Sprintf("Sensor #%d battery dropped", event.SensorID),
+        fmt.Sprintf("Battery of sensor #%d in %s dropped by %d%% at %s, it may be tampered with.", event.SensorID, place, drop, at), nil
+    case schemas.EventSensorBatteryLow:
+      charge := 0
+      if deviceEvent, ok := event.Data.(schemas.DeviceEventSchema); ok {
+        charge = deviceEvent.Value
+      }
+      return fmt.Sprintf("Sensor #%d battery is low", event.SensorID),
+        fmt.Sprintf("Battery of sensor #%d in %s is at %d%% since %s, replace it.", event.SensorID, place, charge, at), nil
+    default:
+      return "AntiVape notification", fmt.Sprintf("Event %s in %s at %s.", event.Type, place, at), nil
+    }
+  }
+
+  cause := "Vape aerosol detected"
+  if incident.RuleID != nil {
+    cause = fmt.Sprintf("Rule #%d triggered", *incident.RuleID)
+  }
+  action := strings.TrimPrefix(event.Type, "incident.")
+  subject := fmt.Sprintf("[%s] Incident #%d %s in %s", incident.Severity, incident.ID, action, place)
+  text := fmt.Sprintf(
+    "%s by sensor #%d in %s.\nStarted at %s, peak TVOC %d ppb, peak CO2 %d ppm.\nIncident is %s at %s.",
+    cause,
+    incident.SensorID,
+    place,
+    incident.StartedAt.Format(time.RFC1123),
+    incident.PeakTvoc,
+    incident.PeakCo2,
+    incident.State,
+    at,
+  )
+  return subject, text, nil
+}
+
+// RunNotificationCycle sends due notifications. Every app replica runs it,
+// due notifications are claimed with SKIP LOCKED and leased while sent.
+func (s notificationService) RunNotificationCycle() {
+  for {
+    if s.sendDue() == 0 {
+      time.Sleep(deliveryPoll)
+    }
+  }
+}
+
+func (s notificationService) sendDue() int {
+  var notifications []models.Notification
+  now := time.Now()
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+      Where("state = ? AND next_attempt_at <= ?", schemas.DeliveryPending, now).
+      Order("next_attempt_at").
+      Limit(deliveryBatchSize).
+      Find(&notifications).Error
+    if err != nil || len(notifications) == 0 {
+      return err
+    }
+    ids := make([]uint, 0, len(notifications))
+    for _, notification := range notifications {
+      ids = append(ids, notification.ID)
+    }
+    return tx.Model(&models.Notification{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(s.config.Lease)).Error
+  })
+  if err != nil {
+    log.Println("Error claim notifications: ", err)
+    return 0
+  }
+
+  var wg sync.WaitGroup
+  for i := range notifications {
+    wg.Add(1)
+    go func(notification *models.Notification) {
+      defer wg.Done()
+      s.attempt(notification)
+    }(&notifications[i])
+  }
+  wg.Wait()
+  return len(notifications)
+}
+
+// attempt sends notification and stores result. Failed notification is
+// scheduled with exponential backoff until MaxAttempts.
+func (s notificationService) attempt(notification *models.Notification) {
+  err := ErrChannelUnavailable
+  if notifier, ok := s.notifiers[notification.Channel]; ok {
+    err = notifier.Notify(notifiers.Message{To: notification.Target, Subject: notification.Subject, Text: notification.Text})
+  }
+  now := time.Now()
+  notification.Attempts++
+  if err == nil {
+    notification.State = schemas.DeliveryDelivered
+    notification.SentAt = &now
+    notification.LastError = ""
+  } else {
+    notification.LastError = err.Error()
+    if notification.Attempts >= s.config.MaxAttempts {
+      notification.State = schemas.DeliveryFailed
+    } else {
+      notification.NextAttemptAt = now.Add(retryBackoff(s.config.BackoffBase, s.config.BackoffMax, notification.Attempts))
+    }
+  }
+  if err := s.db.Save(notification).Error; err != nil {
+    log.Println("Error save notification: ", err)
+  }
+}
+
+// NewNotificationService takes notifiers of configured channels by channel name
+func NewNotificationService(db *gorm.DB, channels map[string]notifiers.Notifier, config NotificationConfig) NotificationService {
+  return notificationService{baseService: baseService{db: db}, notifiers: channels, config: config}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+  "errors"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+// QuietHours is daily interval of local time, it spans midnight when End is
+// before Start
+type QuietHours struct {
+  Start string
+  End string
+  Location *time.Location
+}
+
+// Until returns end of quiet hours which now falls into
+func (q QuietHours) Until(now time.Time) (time.Time, bool) {
+  start, err := time.Parse("15:04", q.Start)
+  if err != nil {
+    return time.Time{}, false
+  }
+  end, err := time.Parse("15:04", q.End)
+  if err != nil {
+    return time.Time{}, false
+  }
+  local := now.In(q.Location)
+  startAt := time.Date(local.Year(), local.Month(), local.Day(), start.Hour(), start.Minute(), 0, 0, q.Location)
+  endAt := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, q.Location)
+
+  switch {
+  case startAt.Equal(endAt):
+    return time.Time{}, false
+  case startAt.Before(endAt):
+    if !local.Before(startAt) && local.Before(endAt) {
+      return endAt, true
+    }
+  case local.Before(endAt):
+    return endAt, true
+  case !local.Before(startAt):
+    return endAt.AddDate(0, 0, 1), true
+  }
+  return time.Time{}, false
+}
+
+type PolicyService interface {
+  // Take returns zone policy, policy with everything switched off if zone has none
+  Take(zoneID uint) (schemas.PolicySchema, error)
+  Put(zoneID uint, schema schemas.PolicySchema) (schemas.PolicySchema, error)
+  Delete(zoneID uint) error
+}
+
+type policyService struct {
+  baseService
+}
+
+func (s policyService) modelToSchema(model models.NotificationPolicy) schemas.PolicySchema {
+  return schemas.PolicySchema{
+    ZoneID: model.ZoneID,
+    GroupWindow: model.GroupWindow,
+    Cooldown: model.Cooldown,
+    QuietStart: model.QuietStart,
+    QuietEnd: model.QuietEnd,
+    Timezone: model.Timezone,
+    QuietAllowCritical: model.QuietAllowCritical,
+    EscalateAfter: model.EscalateAfter,
+  }
+}
+
+func (s policyService) Take(zoneID uint) (schemas.PolicySchema, error) {
+  policy, err := zonePolicy(s.db, zoneID)
+  if err != nil {
+    return schemas.PolicySchema{}, err
+  }
+  return s.modelToSchema(policy), nil
+}
+
+func (s policyService) Put(zoneID uint, schema schemas.PolicySchema) (schemas.PolicySchema, error) {
+  model := models.NotificationPolicy{
+    ZoneID: zoneID,
+    GroupWindow: schema.GroupWindow,
+    Cooldown: schema.Cooldown,
+    QuietStart: schema.QuietStart,
+    QuietEnd: schema.QuietEnd,
+    Timezone: schema.Timezone,
+    QuietAllowCritical: schema.QuietAllowCritical,
+    EscalateAfter: schema.EscalateAfter,
+  }
+  err := s.db.Clauses(clause.OnConflict{
+    Columns: []clause.Column{{Name: "zone_id"}},
+    DoUpdates: clause.AssignmentColumns([]string{
+      "updated_at",
+      "group_window",
+      "cooldown",
+      "quiet_start",
+      "quiet_end",
+      "timezone",
+      "quiet_allow_critical",
+      "escalate_after",
+    }),
+  }).Create(&model).Error
+  if err != nil {
+    return schemas.PolicySchema{}, err
+  }
+  return s.modelToSchema(model), nil
+}
+
+// Delete removes policy row for good, so zone can get a new one with the same unique zone_id
+func (s policyService) Delete(zoneID uint) error {
+  return s.db.Unscoped().Where("zone_id = ?", zoneID).Delete(&models.NotificationPolicy{}).Error
+}
+
+// zonePolicy takes policy of zone in db or tx, zero policy if zone has none
+func zonePolicy(db *gorm.DB, zoneID uint) (models.NotificationPolicy, error) {
+  var policy models.NotificationPolicy
+  err := db.Where("zone_id = ?", zoneID).Take(&policy).Error
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+    return models.NotificationPolicy{ZoneID: zoneID}, nil
+  }
+  return policy, err
+}
+
+func quietHoursOf(policy models.NotificationPolicy) (QuietHours, bool) {
+  if len(policy.QuietStart) == 0 {
+    return QuietHours{}, false
+  }
+  location, err := time.LoadLocation(policy.Timezone)
+  if err != nil {
+    return QuietHours{}, false
+  }
+  return QuietHours{Start: policy.QuietStart, End: policy.QuietEnd, Location: location}, true
+}
+
+func NewPolicyService(db *gorm.DB) PolicyService {
+  return policyService{baseService: baseService{db: db}}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+  "errors"
+  "log"
+  "sync"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+  "antivape/repositories"
+)
+
+const (
+  unclaimedSamples = 5
+  // Quarantined readings are counted in database this often, readings
+  // admitted since are counted in memory
+  quarantineCountInterval = 10 * time.Second
+)
+
+var (
+  ErrUnknownDevice = errors.New("No quarantined readings for this guid")
+  ErrSensorExists = errors.New("Sensor with this guid already exists")
+  ErrQuarantineFull = errors.New("Too many readings of this guid are quarantined")
+  ErrQuarantineDevices = errors.New("Too many unknown guids are quarantined")
+)
+
+// QuarantineLimits bound readings written without credentials
+type QuarantineLimits struct {
+  // Quarantined readings kept per guid
+  MaxReadings int
+  // Guids with quarantined readings
+  MaxDevices int
+}
+
+// QuarantineQuota admits readings of unknown guids into quarantine
+type QuarantineQuota interface {
+  Admit(guid string, readings int) error
+}
+
+// quarantineQuota counts quarantined readings per guid. Readings admitted by
+// other replicas since the last count are not seen, so limits may be passed
+// by what replicas admit within quarantineCountInterval.
+type quarantineQuota struct {
+  db *gorm.DB
+  limits QuarantineLimits
+  mu sync.Mutex
+  counts map[string]int
+  countedAt time.Time
+}
+
+func (q *quarantineQuota) Admit(guid string, readings int) error {
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  if time.Since(q.countedAt) > quarantineCountInterval {
+    q.count()
+  }
+  count, ok := q.counts[guid]
+  if !ok && len(q.counts) >= q.limits.MaxDevices {
+    return ErrQuarantineDevices
+  }
+  if count + readings > q.limits.MaxReadings {
+    return ErrQuarantineFull
+  }
+  q.counts[guid] = count + readings
+  return nil
+}
+
+func (q *quarantineQuota) count() {
+  var rows []struct {
+    Guid string
+    Count int
+  }
+  err := q.db.Model(&models.QuarantinedSensorData{}).Select("guid, COUNT(*) AS count").Group("guid").Scan(&rows).Error
+  if err != nil {
+    log.Println("Error count quarantined sensor data: ", err)
+    return
+  }
+  q.counts = make(map[string]int, len(rows))
+  for _, row := range rows {
+    q.counts[row.Guid] = row.Count
+  }
+  q.countedAt = time.Now()
+}
+
+func NewQuarantineQuota(db *gorm.DB, limits QuarantineLimits) QuarantineQuota {
+  return &quarantineQuota{db: db, limits: limits, counts: make(map[string]int)}
+}
+
+type QuarantineService interface {
+  FindUnclaimed() ([]schemas.UnclaimedDeviceSchema, error)
+  Claim(guid string, schema schemas.UnclaimedDeviceClaimSchema, ownerID uint) (schemas.SensorSchema, error)
+  Discard(guid string) error
+  RunPurgeCycle()
+}
+
+type quarantineService struct {
+  baseService
+  quarantineRep repositories.QuarantineRepository
+  sensorService SensorService
+  retention time.Duration
+}
+
+func (s quarantineService) FindUnclaimed() ([]schemas.UnclaimedDeviceSchema, error) {
+  return s.quarantineRep.FindDevices(unclaimedSamples)
+}
+
+// Claim creates sensor for quarantined guid and imports or discards its
+// readings in one transaction, so concurrent claims of guid create one sensor.
+// Imported readings are history of sensor: they are rolled up into statistics,
+// but not passed to detection, rules and health, which watch live readings.
+func (s quarantineService) Claim(guid string, schema schemas.UnclaimedDeviceClaimSchema, ownerID uint) (schemas.SensorSchema, error) {
+  var sensor models.Sensor
+  var credential models.SensorCredential
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    var quarantined []uint
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+      Model(&models.QuarantinedSensorData{}).
+      Where("guid = ?", guid).
+      Pluck("id", &quarantined).Error
+    if err != nil {
+      return err
+    }
+    var count int64
+    if err := tx.Model(&models.Sensor{}).Where("guid = ?", guid).Count(&count).Error; err != nil {
+      return err
+    }
+    if count > 0 {
+      return ErrSensorExists
+    }
+    if len(quarantined) == 0 {
+      return ErrUnknownDevice
+    }
+
+    var room models.Room
+    if err := tx.Where("id = ?", schema.RoomID).Take(&room).Error; err != nil {
+      return err
+    }
+    sensor = models.Sensor{Name: schema.Name, Guid: guid, RoomID: room.ID, ZoneID: room.ZoneID, OwnerID: ownerID}
+    if err := tx.Create(&sensor).Error; err != nil {
+      return err
+    }
+    if credential, err = issueCredential(tx, sensor.ID); err != nil {
+      return err
+    }
+
+    if schema.ImportReadings {
+      return s.quarantineRep.WithTx(tx).Import(guid)
+    }
+    return s.quarantineRep.WithTx(tx).Delete(guid)
+  })
+  if err != nil {
+    return schemas.SensorSchema{}, err
+  }
+
+  resp := s.sensorService.Take(sensor.ID)
+  resp.Secret = credential.Secret
+  return resp, nil
+}
+
+func (s quarantineService) Discard(guid string) error {
+  return s.quarantineRep.Delete(guid)
+}
+
+// RunPurgeCycle drops quarantined readings which nobody claimed during retention
+func (s quarantineService) RunPurgeCycle() {
+  for range(time.Tick(time.Hour)) {
+    if err := s.quarantineRep.DeleteOlderThan(time.Now().Add(-s.retention)); err != nil {
+      log.Println("Error purge quarantined sensor data: ", err)
+    }
+  }
+}
+
+func NewQuarantineService(db *gorm.DB, quarantineRep repositories.QuarantineRepository, sensorService SensorService, retention time.Duration) QuarantineService {
+  return quarantineService{
+    baseService: baseService{db: db},
+    quarantineRep: quarantineRep,
+    sensorService: sensorService,
+    retention: retention,
+  }
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+  "errors"
+  "log"
+  "time"
+
+  "gorm.io/gorm"
+  models "antivape/db"
+  "antivape/buffers"
+  "antivape/schemas"
+)
+
+// latest_reading_query takes the newest stored reading of every sensor by
+// guid and measured_at index
+const latest_reading_query string = `
+SELECT sensors.guid, latest.co2, latest.tvoc, latest.battery_charge, latest.measured_at
+FROM sensors CROSS JOIN LATERAL (
+  SELECT co2, tvoc, battery_charge, measured_at FROM sensor_data
+  WHERE sensor_data.guid = sensors.guid AND sensor_data.deleted_at IS NULL
+  ORDER BY measured_at DESC LIMIT 1
+) latest
+WHERE sensors.id IN @sensors;
+`
+
+type ReadingService interface {
+  // TakeLatest returns the newest reading of sensor, stored or buffered
+  TakeLatest(sensorID uint) (schemas.LatestReadingSchema, error)
+  // FindZoneLatest returns the newest reading of every zone sensor
+  FindZoneLatest(zoneID uint) ([]schemas.LatestReadingSchema, error)
+}
+
+type readingService struct {
+  baseService
+  buffer buffers.SensorDataBuffer
+  airQuality AirQuality
+}
+
+type dbLatestReading struct {
+  Guid string
+  Co2 int
+  Tvoc int
+  BatteryCharge int
+  MeasuredAt time.Time
+}
+
+func (s readingService) TakeLatest(sensorID uint) (schemas.LatestReadingSchema, error) {
+  var sensor models.Sensor
+  err := s.db.Where("id = ?", sensorID).Take(&sensor).Error
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+    return schemas.LatestReadingSchema{}, ErrSensorNotFound
+  } else if err != nil {
+    return schemas.LatestReadingSchema{}, err
+  }
+  latest, err := s.latest([]models.Sensor{sensor})
+  if err != nil {
+    return schemas.LatestReadingSchema{}, err
+  }
+  return latest[0], nil
+}
+
+func (s readingService) FindZoneLatest(zoneID uint) ([]schemas.LatestReadingSchema, error) {
+  var sensors []models.Sensor
+  if err := s.db.Where("zone_id = ?", zoneID).Order("id").Find(&sensors).Error; err != nil {
+    return nil, err
+  }
+  return s.latest(sensors)
+}
+
+// latest merges stored readings of sensors with buffered ones, which are newer
+// unless they were stored already or came late from device
+func (s readingService) latest(sensors []models.Sensor) ([]schemas.LatestReadingSchema, error) {
+  if len(sensors) == 0 {
+    return []schemas.LatestReadingSchema{}, nil
+  }
+  ids := make([]uint, 0, len(sensors))
+  guids := make([]string, 0, len(sensors))
+  for _, sensor := range sensors {
+    ids = append(ids, sensor.ID)
+    guids = append(guids, sensor.Guid)
+  }
+  var stored []dbLatestReading
+  if err := s.db.Raw(latest_reading_query, map[string]interface{}{"sensors": ids}).Scan(&stored).Error; err != nil {
+    return nil, err
+  }
+  storedByGuid := make(map[string]dbLatestReading, len(stored))
+  for _, reading := range stored {
+    storedByGuid[reading.Guid] = reading
+  }
+  // Stored readings are still shown when buffer is unavailable
+  buffered, err := s.buffer.Latest(guids...)
+  if err != nil {
+    log.Println("Error take buffered sensor data: ", err)
+  }
+
+  latest := make([]schemas.LatestReadingSchema, 0, len(sensors))
+  for _, sensor := range sensors {
+    schema := schemas.LatestReadingSchema{
+      SensorID: sensor.ID,
+      Name: sensor.Name,
+      RoomID: sensor.RoomID,
+      Status: sensorStatus(sensor),
+    }
+    if reading, ok := storedByGuid[sensor.Guid]; ok {
+      measuredAt := reading.MeasuredAt
+      schema.Co2 = reading.Co2
+      schema.Tvoc = reading.Tvoc
+      schema.BatteryCharge = reading.BatteryCharge
+      schema.MeasuredAt = &measuredAt
+    }
+    if reading, ok := buffered[sensor.Guid]; ok && (schema.MeasuredAt == nil || reading.MeasuredAt.After(*schema.MeasuredAt)) {
+      schema.Co2 = reading.Co2
+      schema.Tvoc = reading.Tvoc
+      schema.BatteryCharge = reading.BatteryCharge
+      schema.MeasuredAt = reading.MeasuredAt
+      schema.Buffered = true
+    }
+    if schema.MeasuredAt != nil {
+      schema.Co2Band, schema.TvocBand, schema.Band = s.airQuality.Classify(float64(schema.Co2), float64(schema.Tvoc))
+    }
+    latest = append(latest, schema)
+  }
+  return latest, nil
+}
+
+func NewReadingService(db *gorm.DB, buffer buffers.SensorDataBuffer, airQuality AirQuality) ReadingService {
+  return readingService{baseService: baseService{db: db}, buffer: buffer, airQuality: airQuality}
+}
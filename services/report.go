@@ -0,0 +1,662 @@
+package services
+
+import (
+  "bytes"
+  "encoding/csv"
+  "errors"
+  "fmt"
+  "html/template"
+  "log"
+  "strconv"
+  "strings"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/notifiers"
+  "antivape/repositories"
+  "antivape/schemas"
+)
+
+const (
+  reportPoll = time.Minute
+  // Batteries to be empty within this many days are reported for replacement
+  reportBatteryDays = 7
+)
+
+var (
+  ErrReportDefinitionNotFound = errors.New("Report definition not found")
+  ErrReportNotFound = errors.New("Report not found")
+  ErrReportZoneNotFound = errors.New("Zone of report definition not found")
+)
+
+type ReportConfig struct {
+  // Report is not mailed to recipients who failed this many attempts
+  MaxAttempts int
+  // Delay before second attempt, it doubles with every next one up to BackoffMax
+  BackoffBase time.Duration
+  BackoffMax time.Duration
+  // Lease of report being mailed, longer than mailing to all its recipients
+  Lease time.Duration
+}
+
+// ReportPeriodEnd returns the first period bound after at, bounds are
+// midnights, mondays and first days of month in location
+func ReportPeriodEnd(period string, at time.Time, location *time.Location) time.Time {
+  local := at.In(location)
+  switch period {
+  case schemas.ReportWeekly:
+    days := (8 - int(local.Weekday())) % 7
+    if days == 0 {
+      days = 7
+    }
+    return time.Date(local.Year(), local.Month(), local.Day() + days, 0, 0, 0, 0, location)
+  case schemas.ReportMonthly:
+    return time.Date(local.Year(), local.Month() + 1, 1, 0, 0, 0, 0, location)
+  default:
+    return time.Date(local.Year(), local.Month(), local.Day() + 1, 0, 0, 0, 0, location)
+  }
+}
+
+// ReportPeriodStart returns start of period which ends at end bound
+func ReportPeriodStart(period string, end time.Time, location *time.Location) time.Time {
+  local := end.In(location)
+  switch period {
+  case schemas.ReportWeekly:
+    return time.Date(local.Year(), local.Month(), local.Day() - 7, 0, 0, 0, 0, location)
+  case schemas.ReportMonthly:
+    return time.Date(local.Year(), local.Month() - 1, 1, 0, 0, 0, 0, location)
+  default:
+    return time.Date(local.Year(), local.Month(), local.Day() - 1, 0, 0, 0, 0, location)
+  }
+}
+
+const report_html string = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Name}}</title></head>
+<body>
+<h1>{{.Name}}</h1>
+<p>Zone {{.ZoneName}}, {{date .From}} - {{date .To}}</p>
+<h2>Rooms</h2>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Room</th><th>Readings</th><th>CO2 avg</th><th>CO2 peak</th><th>TVOC avg</th><th>TVOC peak</th><th>Air quality</th>{{range bands}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rooms}}<tr><td>{{.Name}}</td><td>{{.Samples}}</td>{{if .Samples}}<td>{{.Co2Avg}}</td><td>{{float .Co2Max}}</td><td>{{.TvocAvg}}</td><td>{{float .TvocMax}}</td><td>{{.Band}}</td>{{range .Bands}}<td>{{percent .Share}}</td>{{end}}{{else}}<td colspan="{{columns}}">No readings</td>{{end}}</tr>
+{{end}}</table>
+<h2>Sensors</h2>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr><th>Sensor</th><th>Room</th><th>Status</th><th>Battery</th><th>Battery empty</th><th>Replace battery</th></tr>
+{{range .Sensors}}<tr><td>{{.Name}}</td><td>{{.RoomID}}</td><td>{{.Status}}</td><td>{{.BatteryCharge}}%</td><td>{{if .BatteryEmptyAt}}{{date .BatteryEmptyAt}}{{end}}</td><td>{{if .ReplaceBattery}}yes{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+  "bands": func() []string { return schemas.Bands },
+  "columns": func() int { return 5 + len(schemas.Bands) },
+  "date": func(value interface{}) string {
+    switch at := value.(type) {
+    case time.Time:
+      return at.Format("2006-01-02 15:04 MST")
+    case *time.Time:
+      return at.Format("2006-01-02 15:04 MST")
+    }
+    return ""
+  },
+  "float": func(value *float64) string {
+    if value == nil {
+      return ""
+    }
+    return strconv.FormatFloat(*value, 'f', 0, 64)
+  },
+  "percent": func(value float64) string {
+    return strconv.FormatFloat(value * 100, 'f', 1, 64) + "%"
+  },
+}).Parse(report_html))
+
+// RenderReportHTML renders report as HTML page
+func RenderReportHTML(data schemas.ReportDataSchema) (string, error) {
+  var b bytes.Buffer
+  if err := reportTemplate.Execute(&b, data); err != nil {
+    return "", err
+  }
+  return b.String(), nil
+}
+
+// RenderReportCSV renders rooms table and, after empty line, sensors table
+func RenderReportCSV(data schemas.ReportDataSchema) (string, error) {
+  var b bytes.Buffer
+  w := csv.NewWriter(&b)
+  header := []string{"room_id", "room", "readings", "co2_avg", "co2_max", "tvoc_avg", "tvoc_max", "band"}
+  for _, band := range schemas.Bands {
+    header = append(header, band + "_share")
+  }
+  w.Write(header)
+  formatFloat := func(value *float64) string {
+    if value == nil {
+      return ""
+    }
+    return strconv.FormatFloat(*value, 'f', -1, 64)
+  }
+  for _, room := range data.Rooms {
+    record := []string{
+      strconv.FormatUint(uint64(room.RoomID), 10),
+      room.Name,
+      strconv.Itoa(room.Samples),
+      strconv.Itoa(room.Co2Avg),
+      formatFloat(room.Co2Max),
+      strconv.Itoa(room.TvocAvg),
+      formatFloat(room.TvocMax),
+      room.Band,
+    }
+    for _, share := range room.Bands {
+      record = append(record, strconv.FormatFloat(share.Share, 'f', 4, 64))
+    }
+    w.Write(record)
+  }
+  w.Write(nil)
+  w.Write([]string{"sensor_id", "sensor", "room_id", "status", "battery_charge", "battery_empty_at", "replace_battery"})
+  for _, sensor := range data.Sensors {
+    emptyAt := ""
+    if sensor.BatteryEmptyAt != nil {
+      emptyAt = sensor.BatteryEmptyAt.UTC().Format(time.RFC3339)
+    }
+    w.Write([]string{
+      strconv.FormatUint(uint64(sensor.SensorID), 10),
+      sensor.Name,
+      strconv.FormatUint(uint64(sensor.RoomID), 10),
+      sensor.Status,
+      strconv.Itoa(sensor.BatteryCharge),
+      emptyAt,
+      strconv.FormatBool(sensor.ReplaceBattery),
+    })
+  }
+  w.Flush()
+  return b.String(), w.Error()
+}
+
+type ReportService interface {
+  TakeDefinition(definitionID uint) (schemas.ReportDefinitionSchema, error)
+  // FindDefinitions returns definitions of zones owned by user, all of them for superuser
+  FindDefinitions(userID uint, superuser bool, filters schemas.ReportDefinitionFindSchema) ([]schemas.ReportDefinitionSchema, error)
+  CreateDefinition(schema schemas.ReportDefinitionCreateSchema) (schemas.ReportDefinitionSchema, error)
+  UpdateDefinition(definitionID uint, schema schemas.ReportDefinitionUpdateSchema) error
+  DeleteDefinition(definitionID uint) error
+  Take(reportID uint) (schemas.ReportSchema, error)
+  // Find returns the last 100 reports of zones owned by user, all zones for superuser
+  Find(userID uint, superuser bool, filters schemas.ReportFindSchema) ([]schemas.ReportSchema, error)
+  // TakeFile returns report rendered as html or csv
+  TakeFile(reportID uint, format string) (string, error)
+  RunReportCycle()
+}
+
+// reportService makes reports of due definitions and mails them. Every app
+// replica runs the cycle, definitions and reports are claimed with SKIP LOCKED.
+type reportService struct {
+  baseService
+  sensorDataRep repositories.SensorDataRepository
+  airQuality AirQuality
+  airQualityService AirQualityService
+  healthService HealthService
+  batteryService BatteryService
+  // Email notifier, reports are only stored when it is nil
+  mailer notifiers.Notifier
+  config ReportConfig
+}
+
+func splitList(value string) []string {
+  if len(value) == 0 {
+    return []string{}
+  }
+  return strings.Split(value, ",")
+}
+
+func joinIDs(ids []uint) string {
+  items := make([]string, 0, len(ids))
+  for _, id := range ids {
+    items = append(items, strconv.FormatUint(uint64(id), 10))
+  }
+  return strings.Join(items, ",")
+}
+
+func splitIDs(value string) []uint {
+  ids := make([]uint, 0)
+  for _, item := range splitList(value) {
+    if id, err := strconv.ParseUint(item, 10, 64); err == nil {
+      ids = append(ids, uint(id))
+    }
+  }
+  return ids
+}
+
+func (s reportService) definitionToSchema(model models.ReportDefinition) schemas.ReportDefinitionSchema {
+  return schemas.ReportDefinitionSchema{
+    ID: model.ID,
+    ZoneID: model.ZoneID,
+    Name: model.Name,
+    Period: model.Period,
+    Timezone: model.Timezone,
+    Recipients: splitList(model.Recipients),
+    RoomIDs: splitIDs(model.RoomIDs),
+    Enabled: model.Enabled,
+    NextRunAt: model.NextRunAt,
+    LastError: model.LastError,
+  }
+}
+
+func (s reportService) reportToSchema(model models.Report) schemas.ReportSchema {
+  return schemas.ReportSchema{
+    ID: model.ID,
+    DefinitionID: model.DefinitionID,
+    ZoneID: model.ZoneID,
+    From: model.PeriodFrom,
+    To: model.PeriodTo,
+    CreatedAt: model.CreatedAt,
+    PendingRecipients: splitList(model.PendingRecipients),
+    LastError: model.LastError,
+  }
+}
+
+// nextRunAt returns end of period which is in progress at now
+func nextRunAt(period string, timezone string, now time.Time) time.Time {
+  location, err := time.LoadLocation(timezone)
+  if err != nil {
+    location = time.UTC
+  }
+  return ReportPeriodEnd(period, now, location)
+}
+
+func (s reportService) TakeDefinition(definitionID uint) (schemas.ReportDefinitionSchema, error) {
+  var model models.ReportDefinition
+  if err := s.take(definitionID, &model, nil); err != nil {
+    return schemas.ReportDefinitionSchema{}, ErrReportDefinitionNotFound
+  }
+  return s.definitionToSchema(model), nil
+}
+
+func (s reportService) FindDefinitions(userID uint, superuser bool, filters schemas.ReportDefinitionFindSchema) ([]schemas.ReportDefinitionSchema, error) {
+  query := s.db.Model(&models.ReportDefinition{}).Order("report_definitions.id")
+  if !superuser {
+    query = query.Joins("JOIN zones ON zones.id = report_definitions.zone_id").Where("zones.owner_id = ?", userID)
+  }
+  if filters.ZoneID != nil {
+    query = query.Where("report_definitions.zone_id = ?", *filters.ZoneID)
+  }
+  var definitions []models.ReportDefinition
+  if err := query.Find(&definitions).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.ReportDefinitionSchema, 0, len(definitions))
+  for _, definition := range definitions {
+    resp = append(resp, s.definitionToSchema(definition))
+  }
+  return resp, nil
+}
+
+func (s reportService) CreateDefinition(schema schemas.ReportDefinitionCreateSchema) (schemas.ReportDefinitionSchema, error) {
+  if len(schema.Timezone) == 0 {
+    schema.Timezone = "UTC"
+  }
+  model := models.ReportDefinition{
+    ZoneID: schema.ZoneID,
+    Name: schema.Name,
+    Period: schema.Period,
+    Timezone: schema.Timezone,
+    Recipients: strings.Join(schema.Recipients, ","),
+    RoomIDs: joinIDs(schema.RoomIDs),
+    Enabled: schema.Enabled == nil || *schema.Enabled,
+    NextRunAt: nextRunAt(schema.Period, schema.Timezone, time.Now()),
+  }
+  if err := s.create(&model); err != nil {
+    return schemas.ReportDefinitionSchema{}, err
+  }
+  return s.definitionToSchema(model), nil
+}
+
+// UpdateDefinition schedules the next report for period in progress when
+// period, timezone or enabled change, so missed periods aren't made
+func (s reportService) UpdateDefinition(definitionID uint, schema schemas.ReportDefinitionUpdateSchema) error {
+  var model models.ReportDefinition
+  if err := s.take(definitionID, &model, nil); err != nil {
+    return ErrReportDefinitionNotFound
+  }
+  fields := make(map[string]interface{})
+  if schema.Name != nil {
+    fields["name"] = *schema.Name
+  }
+  if schema.Recipients != nil {
+    fields["recipients"] = strings.Join(*schema.Recipients, ",")
+  }
+  if schema.RoomIDs != nil {
+    fields["room_ids"] = joinIDs(*schema.RoomIDs)
+  }
+  if schema.Period != nil || schema.Timezone != nil || schema.Enabled != nil {
+    if schema.Period != nil {
+      model.Period = *schema.Period
+      fields["period"] = model.Period
+    }
+    if schema.Timezone != nil {
+      model.Timezone = *schema.Timezone
+      fields["timezone"] = model.Timezone
+    }
+    if schema.Enabled != nil {
+      fields["enabled"] = *schema.Enabled
+    }
+    fields["next_run_at"] = nextRunAt(model.Period, model.Timezone, time.Now())
+  }
+  if len(fields) == 0 {
+    return nil
+  }
+  return s.update(&models.ReportDefinition{}, definitionID, fields)
+}
+
+// DeleteDefinition keeps its reports downloadable
+func (s reportService) DeleteDefinition(definitionID uint) error {
+  return s.delete(&models.ReportDefinition{}, definitionID)
+}
+
+func (s reportService) Take(reportID uint) (schemas.ReportSchema, error) {
+  var model models.Report
+  if err := s.db.Omit("html", "csv").Where("id = ?", reportID).Take(&model).Error; err != nil {
+    return schemas.ReportSchema{}, ErrReportNotFound
+  }
+  return s.reportToSchema(model), nil
+}
+
+func (s reportService) Find(userID uint, superuser bool, filters schemas.ReportFindSchema) ([]schemas.ReportSchema, error) {
+  query := s.db.Model(&models.Report{}).Omit("html", "csv").Order("reports.id DESC").Limit(100)
+  if !superuser {
+    query = query.Joins("JOIN zones ON zones.id = reports.zone_id").Where("zones.owner_id = ?", userID)
+  }
+  if filters.ZoneID != nil {
+    query = query.Where("reports.zone_id = ?", *filters.ZoneID)
+  }
+  if filters.DefinitionID != nil {
+    query = query.Where("reports.definition_id = ?", *filters.DefinitionID)
+  }
+  var reports []models.Report
+  if err := query.Find(&reports).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.ReportSchema, 0, len(reports))
+  for _, report := range reports {
+    resp = append(resp, s.reportToSchema(report))
+  }
+  return resp, nil
+}
+
+func (s reportService) TakeFile(reportID uint, format string) (string, error) {
+  column := "html"
+  if format == schemas.ExportCSV {
+    column = "csv"
+  }
+  var content []string
+  if err := s.db.Model(&models.Report{}).Where("id = ?", reportID).Limit(1).Pluck(column, &content).Error; err != nil {
+    return "", err
+  }
+  if len(content) == 0 {
+    return "", ErrReportNotFound
+  }
+  return content[0], nil
+}
+
+// data collects report of definition rooms for [from, to)
+func (s reportService) data(definition models.ReportDefinition, from time.Time, to time.Time) (schemas.ReportDataSchema, error) {
+  var zone models.Zone
+  err := s.db.Where("id = ?", definition.ZoneID).Take(&zone).Error
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+    return schemas.ReportDataSchema{}, ErrReportZoneNotFound
+  } else if err != nil {
+    return schemas.ReportDataSchema{}, err
+  }
+  query := s.db.Where("zone_id = ?", zone.ID).Order("id")
+  if roomIDs := splitIDs(definition.RoomIDs); len(roomIDs) > 0 {
+    query = query.Where("id IN ?", roomIDs)
+  }
+  var rooms []models.Room
+  if err := query.Find(&rooms).Error; err != nil {
+    return schemas.ReportDataSchema{}, err
+  }
+
+  interval := schemas.StatisticFindSchema{From: from.Format(time.RFC3339), To: to.Format(time.RFC3339), Aggregates: schemas.AggregateMax}
+  filters := statisticFilters(s.db, interval)
+  filters["zone_id"] = zone.ID
+  statistic := make(map[uint]schemas.SensorDataRoomSchema)
+  for _, room := range s.sensorDataRep.GetStatistic(filters) {
+    statistic[room.RoomID] = room
+  }
+  roomBands, err := s.airQualityService.FindZoneBands(zone.ID, schemas.BandFindSchema{From: interval.From, To: interval.To})
+  if err != nil {
+    return schemas.ReportDataSchema{}, err
+  }
+  bands := make(map[uint][]schemas.BandShareSchema)
+  for _, room := range roomBands {
+    bands[room.RoomID] = room.Overall
+  }
+
+  data := schemas.ReportDataSchema{
+    ZoneID: zone.ID,
+    ZoneName: zone.Name,
+    Name: definition.Name,
+    From: from,
+    To: to,
+    Rooms: make([]schemas.ReportRoomSchema, 0, len(rooms)),
+    Sensors: make([]schemas.ReportSensorSchema, 0),
+  }
+  included := make(map[uint]bool, len(rooms))
+  for _, room := range rooms {
+    included[room.ID] = true
+    report := schemas.ReportRoomSchema{RoomID: room.ID, Name: room.Name, Bands: bands[room.ID]}
+    if roomStatistic, ok := statistic[room.ID]; ok && roomStatistic.Samples > 0 {
+      report.Samples = roomStatistic.Samples
+      report.Co2Avg = roomStatistic.Co2
+      report.TvocAvg = roomStatistic.Tvoc
+      report.Co2Max = roomStatistic.Co2Aggregates.Max
+      report.TvocMax = roomStatistic.TvocAggregates.Max
+      _, _, report.Band = s.airQuality.Classify(float64(roomStatistic.Co2), float64(roomStatistic.Tvoc))
+    }
+    data.Rooms = append(data.Rooms, report)
+  }
+
+  health, err := s.healthService.FindZoneHealth(zone.ID)
+  if err != nil {
+    return schemas.ReportDataSchema{}, err
+  }
+  replacements, err := s.batteryService.FindReplacements(zone.ID, reportBatteryDays)
+  if err != nil {
+    return schemas.ReportDataSchema{}, err
+  }
+  replace := make(map[uint]schemas.BatterySchema, len(replacements))
+  for _, battery := range replacements {
+    replace[battery.SensorID] = battery
+  }
+  for _, sensor := range health {
+    if !included[sensor.RoomID] {
+      continue
+    }
+    battery, ok := replace[sensor.SensorID]
+    data.Sensors = append(data.Sensors, schemas.ReportSensorSchema{
+      SensorID: sensor.SensorID,
+      Name: sensor.Name,
+      RoomID: sensor.RoomID,
+      Status: sensor.Status,
+      BatteryCharge: sensor.BatteryCharge,
+      BatteryEmptyAt: battery.EmptyAt,
+      ReplaceBattery: ok,
+    })
+  }
+  return data, nil
+}
+
+// RunReportCycle makes reports of due definitions and mails pending reports
+func (s reportService) RunReportCycle() {
+  for {
+    made := s.makeDue()
+    mailed := s.mailDue()
+    if !made && !mailed {
+      time.Sleep(reportPoll)
+    }
+  }
+}
+
+// makeDue makes report of one due definition, missed periods are made one by
+// one. Period which report can't be made for is skipped with error recorded on
+// definition, so it doesn't hold back other definitions; definition of deleted
+// zone is disabled.
+func (s reportService) makeDue() bool {
+  made := false
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    var definition models.ReportDefinition
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+      Where("enabled AND next_run_at <= ?", time.Now()).
+      Order("next_run_at").
+      Limit(1).
+      Find(&definition).Error
+    if err != nil || definition.ID == 0 {
+      return err
+    }
+    location, err := time.LoadLocation(definition.Timezone)
+    if err != nil {
+      location = time.UTC
+    }
+    to := definition.NextRunAt
+    from := ReportPeriodStart(definition.Period, to, location)
+    next := ReportPeriodEnd(definition.Period, to, location)
+    made = true
+    html, csv, err := s.render(definition, from.In(location), to.In(location))
+    if err != nil {
+      log.Println("Error make report of definition ", definition.ID, ": ", err)
+      return tx.Model(&definition).Updates(map[string]interface{}{
+        "next_run_at": next,
+        "last_error": err.Error(),
+        "enabled": !errors.Is(err, ErrReportZoneNotFound),
+      }).Error
+    }
+
+    report := models.Report{
+      DefinitionID: definition.ID,
+      ZoneID: definition.ZoneID,
+      PeriodFrom: from,
+      PeriodTo: to,
+      HTML: html,
+      CSV: csv,
+      PendingRecipients: definition.Recipients,
+      NextMailAt: time.Now(),
+    }
+    if s.mailer == nil && len(report.PendingRecipients) > 0 {
+      report.PendingRecipients = ""
+      report.LastError = ErrChannelUnavailable.Error()
+    }
+    if err := tx.Create(&report).Error; err != nil {
+      return err
+    }
+    return tx.Model(&definition).Updates(map[string]interface{}{"next_run_at": next, "last_error": ""}).Error
+  })
+  if err != nil {
+    log.Println("Error make report: ", err)
+    return false
+  }
+  return made
+}
+
+// render renders report of definition for [from, to) as HTML and CSV
+func (s reportService) render(definition models.ReportDefinition, from time.Time, to time.Time) (string, string, error) {
+  data, err := s.data(definition, from, to)
+  if err != nil {
+    return "", "", err
+  }
+  html, err := RenderReportHTML(data)
+  if err != nil {
+    return "", "", err
+  }
+  csv, err := RenderReportCSV(data)
+  return html, csv, err
+}
+
+// mailDue mails one due report to its pending recipients. Recipients who got
+// it are removed, the rest are retried with backoff until MaxAttempts.
+func (s reportService) mailDue() bool {
+  if s.mailer == nil {
+    return false
+  }
+  var report models.Report
+  now := time.Now()
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+      Where("pending_recipients <> '' AND next_mail_at <= ?", now).
+      Order("next_mail_at").
+      Limit(1).
+      Find(&report).Error
+    if err != nil || report.ID == 0 {
+      return err
+    }
+    return tx.Model(&report).Update("next_mail_at", now.Add(s.config.Lease)).Error
+  })
+  if err != nil {
+    log.Println("Error claim report: ", err)
+    return false
+  }
+  if report.ID == 0 {
+    return false
+  }
+
+  var definition models.ReportDefinition
+  s.db.Unscoped().Where("id = ?", report.DefinitionID).Take(&definition)
+  message := notifiers.Message{
+    Subject: fmt.Sprintf("%s, %s - %s", definition.Name, report.PeriodFrom.Format("2006-01-02"), report.PeriodTo.Format("2006-01-02")),
+    Text: fmt.Sprintf("Report %d is included as HTML, CSV is downloaded from /report/%d/csv", report.ID, report.ID),
+    HTML: report.HTML,
+  }
+  pending := make([]string, 0)
+  errs := make([]string, 0)
+  for _, recipient := range splitList(report.PendingRecipients) {
+    message.To = recipient
+    if err := s.mailer.Notify(message); err != nil {
+      pending = append(pending, recipient)
+      errs = append(errs, recipient + ": " + err.Error())
+    }
+  }
+
+  report.MailAttempts++
+  report.PendingRecipients = strings.Join(pending, ",")
+  report.LastError = strings.Join(errs, "; ")
+  if len(pending) > 0 && report.MailAttempts >= s.config.MaxAttempts {
+    report.PendingRecipients = ""
+    report.LastError = "Not mailed to " + report.LastError
+  } else if len(pending) > 0 {
+    report.NextMailAt = time.Now().Add(retryBackoff(s.config.BackoffBase, s.config.BackoffMax, report.MailAttempts))
+  }
+  err = s.db.Model(&report).Updates(map[string]interface{}{
+    "mail_attempts": report.MailAttempts,
+    "pending_recipients": report.PendingRecipients,
+    "last_error": report.LastError,
+    "next_mail_at": report.NextMailAt,
+  }).Error
+  if err != nil {
+    log.Println("Error save report: ", err)
+  }
+  return true
+}
+
+func NewReportService(
+  db *gorm.DB,
+  sensorDataRep repositories.SensorDataRepository,
+  airQuality AirQuality,
+  airQualityService AirQualityService,
+  healthService HealthService,
+  batteryService BatteryService,
+  mailer notifiers.Notifier,
+  config ReportConfig,
+) ReportService {
+  return reportService{
+    baseService: baseService{db: db},
+    sensorDataRep: sensorDataRep,
+    airQuality: airQuality,
+    airQualityService: airQualityService,
+    healthService: healthService,
+    batteryService: batteryService,
+    mailer: mailer,
+    config: config,
+  }
+}
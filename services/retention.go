@@ -0,0 +1,299 @@
+package services
+
+import (
+  "errors"
+  "fmt"
+  "log"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+const (
+  retentionBatchSize = 5000
+  // Pause between delete batches leaves database time for ingestion
+  retentionPause = 100 * time.Millisecond
+)
+
+// purge_raw_query deletes batch of raw sensor data, only rolled up rows are
+// deleted so rollups never miss readings. %s is replaced by guid condition.
+const purge_raw_query string = `
+DELETE FROM sensor_data WHERE id IN (
+  SELECT id FROM sensor_data WHERE measured_at < @cutoff AND id <= @watermark AND %s LIMIT @batch
+);
+`
+
+// purge_rollup_query deletes batch of rollup rows, %s are replaced by rollup
+// table and guid condition
+const purge_rollup_query string = `
+DELETE FROM %[1]s WHERE ctid IN (
+  SELECT ctid FROM %[1]s WHERE bucket_at < @cutoff AND %[2]s LIMIT @batch
+);
+`
+
+const storage_tables_query string = `
+SELECT @table AS "table", pg_total_relation_size(@table::regclass) AS bytes,
+  GREATEST((SELECT reltuples FROM pg_class WHERE oid = @table::regclass), 0)::int8 AS rows;
+`
+
+// retentionTarget is data kept for days of retention schema field
+type retentionTarget struct {
+  table string
+  days func(schemas.RetentionSchema) int
+}
+
+var retentionTargets = []retentionTarget{
+  {table: "sensor_data", days: func(s schemas.RetentionSchema) int { return s.RawDays }},
+  {table: "sensor_data_minutes", days: func(s schemas.RetentionSchema) int { return s.MinuteDays }},
+  {table: "sensor_data_hours", days: func(s schemas.RetentionSchema) int { return s.HourDays }},
+  {table: "sensor_data_days", days: func(s schemas.RetentionSchema) int { return s.DayDays }},
+}
+
+// EffectiveRetention returns zone retention with global one in place of zeros
+func EffectiveRetention(zone schemas.RetentionSchema, global schemas.RetentionSchema) schemas.RetentionSchema {
+  effective := zone
+  if effective.RawDays == 0 {
+    effective.RawDays = global.RawDays
+  }
+  if effective.MinuteDays == 0 {
+    effective.MinuteDays = global.MinuteDays
+  }
+  if effective.HourDays == 0 {
+    effective.HourDays = global.HourDays
+  }
+  if effective.DayDays == 0 {
+    effective.DayDays = global.DayDays
+  }
+  return effective
+}
+
+type RetentionService interface {
+  // Take returns zone retention, zeros if zone has no own retention
+  Take(zoneID uint) (schemas.RetentionSchema, error)
+  Put(zoneID uint, schema schemas.RetentionSchema) (schemas.RetentionSchema, error)
+  Delete(zoneID uint) error
+  Storage() (schemas.StorageSchema, error)
+  // CheckStatistic returns ErrStatisticRetention if statistics of zone need
+  // data purged by its effective retention
+  CheckStatistic(zoneID uint, statistics ...schemas.StatisticFindSchema) error
+  RunRetentionCycle()
+}
+
+type retentionService struct {
+  baseService
+  global schemas.RetentionSchema
+}
+
+func (s retentionService) modelToSchema(model models.RetentionPolicy) schemas.RetentionSchema {
+  return schemas.RetentionSchema{
+    ZoneID: model.ZoneID,
+    RawDays: model.RawDays,
+    MinuteDays: model.MinuteDays,
+    HourDays: model.HourDays,
+    DayDays: model.DayDays,
+  }
+}
+
+func (s retentionService) Take(zoneID uint) (schemas.RetentionSchema, error) {
+  var policy models.RetentionPolicy
+  err := s.db.Where("zone_id = ?", zoneID).Take(&policy).Error
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+    return schemas.RetentionSchema{ZoneID: zoneID}, nil
+  } else if err != nil {
+    return schemas.RetentionSchema{}, err
+  }
+  return s.modelToSchema(policy), nil
+}
+
+func (s retentionService) Put(zoneID uint, schema schemas.RetentionSchema) (schemas.RetentionSchema, error) {
+  model := models.RetentionPolicy{
+    ZoneID: zoneID,
+    RawDays: schema.RawDays,
+    MinuteDays: schema.MinuteDays,
+    HourDays: schema.HourDays,
+    DayDays: schema.DayDays,
+  }
+  err := s.db.Clauses(clause.OnConflict{
+    Columns: []clause.Column{{Name: "zone_id"}},
+    DoUpdates: clause.AssignmentColumns([]string{"updated_at", "raw_days", "minute_days", "hour_days", "day_days"}),
+  }).Create(&model).Error
+  if err != nil {
+    return schemas.RetentionSchema{}, err
+  }
+  return s.modelToSchema(model), nil
+}
+
+// Delete removes retention row for good, so zone can get a new one with the same unique zone_id
+func (s retentionService) Delete(zoneID uint) error {
+  return s.db.Unscoped().Where("zone_id = ?", zoneID).Delete(&models.RetentionPolicy{}).Error
+}
+
+type dbZoneRows struct {
+  ZoneID uint
+  Rows int64
+  OldestAt *time.Time
+}
+
+func (s retentionService) Storage() (schemas.StorageSchema, error) {
+  storage := schemas.StorageSchema{
+    Tables: make([]schemas.StorageTableSchema, 0, len(retentionTargets)),
+    Zones: make([]schemas.ZoneStorageSchema, 0),
+    Retention: s.global,
+  }
+  rowBytes := make(map[string]int64, len(retentionTargets))
+  for _, target := range retentionTargets {
+    var table schemas.StorageTableSchema
+    if err := s.db.Raw(storage_tables_query, map[string]interface{}{"table": target.table}).Scan(&table).Error; err != nil {
+      return schemas.StorageSchema{}, err
+    }
+    if table.Rows > 0 {
+      rowBytes[target.table] = table.Bytes / table.Rows
+    }
+    storage.Tables = append(storage.Tables, table)
+  }
+
+  var zones []models.Zone
+  if err := s.db.Order("id").Find(&zones).Error; err != nil {
+    return schemas.StorageSchema{}, err
+  }
+  var policies []models.RetentionPolicy
+  if err := s.db.Find(&policies).Error; err != nil {
+    return schemas.StorageSchema{}, err
+  }
+  zoneRetention := make(map[uint]schemas.RetentionSchema, len(policies))
+  for _, policy := range policies {
+    zoneRetention[policy.ZoneID] = s.modelToSchema(policy)
+  }
+  zoneRows := make(map[string]map[uint]dbZoneRows, len(retentionTargets))
+  for _, target := range retentionTargets {
+    oldest := "NULL::timestamptz"
+    if target.table == "sensor_data" {
+      oldest = "MIN(t.measured_at)"
+    }
+    var rows []dbZoneRows
+    query := fmt.Sprintf(
+      "SELECT sensors.zone_id, COUNT(*) AS rows, %s AS oldest_at FROM %s t JOIN sensors ON t.guid = sensors.guid GROUP BY sensors.zone_id",
+      oldest,
+      target.table,
+    )
+    if err := s.db.Raw(query).Scan(&rows).Error; err != nil {
+      return schemas.StorageSchema{}, err
+    }
+    zoneRows[target.table] = make(map[uint]dbZoneRows, len(rows))
+    for _, row := range rows {
+      zoneRows[target.table][row.ZoneID] = row
+    }
+  }
+
+  for _, zone := range zones {
+    retention, ok := zoneRetention[zone.ID]
+    if !ok {
+      retention = schemas.RetentionSchema{ZoneID: zone.ID}
+    }
+    zoneStorage := schemas.ZoneStorageSchema{
+      ZoneID: zone.ID,
+      Name: zone.Name,
+      RawRows: zoneRows["sensor_data"][zone.ID].Rows,
+      MinuteRows: zoneRows["sensor_data_minutes"][zone.ID].Rows,
+      HourRows: zoneRows["sensor_data_hours"][zone.ID].Rows,
+      DayRows: zoneRows["sensor_data_days"][zone.ID].Rows,
+      OldestRawAt: zoneRows["sensor_data"][zone.ID].OldestAt,
+      Retention: EffectiveRetention(retention, s.global),
+    }
+    for _, target := range retentionTargets {
+      zoneStorage.EstimatedBytes += zoneRows[target.table][zone.ID].Rows * rowBytes[target.table]
+    }
+    storage.Zones = append(storage.Zones, zoneStorage)
+  }
+  return storage, nil
+}
+
+func (s retentionService) CheckStatistic(zoneID uint, statistics ...schemas.StatisticFindSchema) error {
+  retention, err := s.Take(zoneID)
+  if err != nil {
+    return err
+  }
+  retention = EffectiveRetention(retention, s.global)
+  now := time.Now()
+  for _, statistic := range statistics {
+    if err := StatisticRetained(statistic, retention, now); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// RunRetentionCycle deletes data older than retention in bounded batches, so
+// ingestion is never blocked for long. Replicas may purge at the same time,
+// deletes of the same rows just wait for each other.
+func (s retentionService) RunRetentionCycle() {
+  for range(time.Tick(time.Hour)) {
+    if err := s.purge(); err != nil {
+      log.Println("Error purge sensor data: ", err)
+    }
+  }
+}
+
+func (s retentionService) purge() error {
+  var policies []models.RetentionPolicy
+  if err := s.db.Find(&policies).Error; err != nil {
+    return err
+  }
+  watermark, err := rollupWatermark(s.db)
+  if err != nil {
+    return err
+  }
+  now := time.Now()
+  for _, target := range retentionTargets {
+    overridden := make([]uint, 0)
+    for _, policy := range policies {
+      days := target.days(s.modelToSchema(policy))
+      if days == 0 {
+        continue
+      }
+      overridden = append(overridden, policy.ZoneID)
+      condition := "guid IN (SELECT guid FROM sensors WHERE zone_id = @zone)"
+      if err := s.purgeTarget(target, condition, policy.ZoneID, now.AddDate(0, 0, -days), watermark); err != nil {
+        return err
+      }
+    }
+    days := target.days(s.global)
+    if days == 0 {
+      continue
+    }
+    condition := "true"
+    if len(overridden) > 0 {
+      condition = "guid NOT IN (SELECT guid FROM sensors WHERE zone_id IN @zone)"
+    }
+    if err := s.purgeTarget(target, condition, overridden, now.AddDate(0, 0, -days), watermark); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// purgeTarget deletes batches of target data older than cutoff within guid condition
+func (s retentionService) purgeTarget(target retentionTarget, condition string, zone interface{}, cutoff time.Time, watermark uint) error {
+  query := fmt.Sprintf(purge_rollup_query, target.table, condition)
+  if target.table == "sensor_data" {
+    query = fmt.Sprintf(purge_raw_query, condition)
+  }
+  args := map[string]interface{}{"cutoff": cutoff, "watermark": watermark, "zone": zone, "batch": retentionBatchSize}
+  for {
+    result := s.db.Exec(query, args)
+    if result.Error != nil {
+      return result.Error
+    }
+    if result.RowsAffected < retentionBatchSize {
+      return nil
+    }
+    time.Sleep(retentionPause)
+  }
+}
+
+func NewRetentionService(db *gorm.DB, global schemas.RetentionSchema) RetentionService {
+  return retentionService{baseService: baseService{db: db}, global: global}
+}
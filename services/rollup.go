@@ -0,0 +1,180 @@
+package services
+
+import (
+  "errors"
+  "fmt"
+  "log"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/repositories"
+)
+
+const (
+  rollupPoll = 10 * time.Second
+  rollupBatchSize = 50000
+  // Sensor data is rolled up when it is this old, ids of transactions which
+  // are still running when batch is chosen are waited for by snapshot
+  rollupLag = 30 * time.Second
+  rollupStateID = 1
+)
+
+// rollup_query adds sensor data with id in (@from, @to] to rollup table
+// buckets, %s are replaced by table and bucket unit
+const rollup_query string = `
+INSERT INTO %[1]s AS r (guid, bucket_at, count, co2_sum, co2_min, co2_max, co2_squares, tvoc_sum, tvoc_min, tvoc_max, tvoc_squares)
+SELECT guid, date_trunc('%[2]s', measured_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', COUNT(*),
+  SUM(co2), MIN(co2), MAX(co2), SUM(co2::float8 * co2), SUM(tvoc), MIN(tvoc), MAX(tvoc), SUM(tvoc::float8 * tvoc)
+FROM sensor_data WHERE id > @from AND id <= @to AND deleted_at IS NULL
+GROUP BY 1, 2
+ON CONFLICT (guid, bucket_at) DO UPDATE SET
+  count = r.count + excluded.count,
+  co2_sum = r.co2_sum + excluded.co2_sum,
+  co2_min = LEAST(r.co2_min, excluded.co2_min),
+  co2_max = GREATEST(r.co2_max, excluded.co2_max),
+  co2_squares = r.co2_squares + excluded.co2_squares,
+  tvoc_sum = r.tvoc_sum + excluded.tvoc_sum,
+  tvoc_min = LEAST(r.tvoc_min, excluded.tvoc_min),
+  tvoc_max = GREATEST(r.tvoc_max, excluded.tvoc_max),
+  tvoc_squares = r.tvoc_squares + excluded.tvoc_squares;
+`
+
+// next_rollup_query returns the last id of next batch, sensor data newer than lag is left for later
+const next_rollup_query string = `
+SELECT COALESCE(MAX(id), 0) FROM (SELECT id, created_at FROM sensor_data WHERE id > ? ORDER BY id LIMIT ?) batch
+WHERE created_at < ?;
+`
+
+// Snapshot bounds are xid8, they are scanned as bigint
+const (
+  snapshot_xmin_query string = `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint;`
+  snapshot_xmax_query string = `SELECT pg_snapshot_xmax(pg_current_snapshot())::text::bigint;`
+)
+
+var rollupUnits = map[string]string{
+  "sensor_data_minutes": "minute",
+  "sensor_data_hours": "hour",
+  "sensor_data_days": "day",
+}
+
+// PlanRollups splits [from, to) into parts served by the coarsest rollups
+// not coarser than maxSize, edges which no rollup bucket fits are raw
+func PlanRollups(from time.Time, to time.Time, maxSize time.Duration) []repositories.RollupPart {
+  resolutions := make([]repositories.RollupResolution, 0, len(repositories.RollupResolutions))
+  for _, resolution := range repositories.RollupResolutions {
+    if resolution.Size <= maxSize {
+      resolutions = append(resolutions, resolution)
+    }
+  }
+  return planRollups(from, to, resolutions)
+}
+
+func planRollups(from time.Time, to time.Time, resolutions []repositories.RollupResolution) []repositories.RollupPart {
+  if !from.Before(to) {
+    return nil
+  }
+  for i, resolution := range resolutions {
+    start := from.Truncate(resolution.Size)
+    if start.Before(from) {
+      start = start.Add(resolution.Size)
+    }
+    end := to.Truncate(resolution.Size)
+    if !start.Before(end) {
+      continue
+    }
+    parts := planRollups(from, start, resolutions[i+1:])
+    parts = append(parts, repositories.RollupPart{Table: resolution.Table, From: start, To: end})
+    return append(parts, planRollups(end, to, resolutions[i+1:])...)
+  }
+  return []repositories.RollupPart{{From: from, To: to}}
+}
+
+// rollupWatermark returns id of the last sensor data in rollups
+func rollupWatermark(db *gorm.DB) (uint, error) {
+  var state models.RollupState
+  err := db.Where("id = ?", rollupStateID).Take(&state).Error
+  if errors.Is(err, gorm.ErrRecordNotFound) {
+    return 0, nil
+  }
+  return state.LastID, err
+}
+
+type RollupService interface {
+  RunRollupCycle()
+}
+
+// rollupService rolls sensor data up into minute, hour and day tables in
+// order of id. It covers every way sensor data is stored, including import of
+// quarantined readings, and catches up with history on first run.
+type rollupService struct {
+  baseService
+}
+
+// RunRollupCycle rolls up new sensor data. Every app replica runs it, state
+// row is locked with SKIP LOCKED, so one replica works at a time.
+func (s rollupService) RunRollupCycle() {
+  for {
+    rolled, err := s.rollup()
+    if err != nil {
+      log.Println("Error roll up sensor data: ", err)
+    }
+    if rolled < rollupBatchSize {
+      time.Sleep(rollupPoll)
+    }
+  }
+}
+
+// rollup rolls up batch chosen in previous call and chooses next one. Ids are
+// taken before commit, so transaction which took lower id may commit after
+// higher ids are visible; batch waits until every transaction which was
+// running when it was chosen is over, then none of its ids can appear anymore.
+func (s rollupService) rollup() (int, error) {
+  s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.RollupState{ID: rollupStateID})
+  rolled := 0
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    // Taken before the state is locked, so this transaction isn't running yet
+    var xmin uint64
+    if err := tx.Raw(snapshot_xmin_query).Scan(&xmin).Error; err != nil {
+      return err
+    }
+    var state models.RollupState
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("id = ?", rollupStateID).Find(&state).Error
+    if err != nil || state.ID == 0 {
+      return err
+    }
+
+    if state.PendingID > state.LastID {
+      if xmin < state.PendingXmax {
+        return nil
+      }
+      for _, resolution := range repositories.RollupResolutions {
+        query := fmt.Sprintf(rollup_query, resolution.Table, rollupUnits[resolution.Table])
+        if err := tx.Exec(query, map[string]interface{}{"from": state.LastID, "to": state.PendingID}).Error; err != nil {
+          return err
+        }
+      }
+      rolled = int(state.PendingID - state.LastID)
+      state.LastID = state.PendingID
+    }
+
+    err = tx.Raw(next_rollup_query, state.LastID, rollupBatchSize, time.Now().Add(-rollupLag)).Scan(&state.PendingID).Error
+    if err != nil {
+      return err
+    }
+    if err := tx.Raw(snapshot_xmax_query).Scan(&state.PendingXmax).Error; err != nil {
+      return err
+    }
+    return tx.Model(&state).Updates(map[string]interface{}{
+      "last_id": state.LastID,
+      "pending_id": state.PendingID,
+      "pending_xmax": state.PendingXmax,
+    }).Error
+  })
+  return rolled, err
+}
+
+func NewRollupService(db *gorm.DB) RollupService {
+  return rollupService{baseService: baseService{db: db}}
+}
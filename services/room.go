@@ -1,6 +1,8 @@
 package services
 
 import (
+  "time"
+
   "gorm.io/gorm"
   models "antivape/db"
   "antivape/schemas"
@@ -13,14 +15,16 @@ type RoomService interface {
   Find(schema schemas.RoomFindSchema) []schemas.RoomSchema
   Update(roomID uint, schema schemas.RoomUpdateSchema)
   Delete(roomID uint)
-  GetStatistic(roomID uint) schemas.SensorDataRoomSchema
+  GetStatistic(roomID uint, filters schemas.StatisticFindSchema) schemas.SensorDataRoomSchema
+  // Compare returns room statistic of interval against previous interval
+  Compare(roomID uint, filters schemas.ComparisonFindSchema) (schemas.RoomComparisonSchema, error)
   FilterByOwnerID(ownerID uint, rooms ...schemas.RoomSchema) []schemas.RoomSchema
 }
 
 type roomService struct {
   baseService
-  db *gorm.DB
   sensorDataRep repositories.SensorDataRepository
+  airQuality AirQuality
 }
 
 func (s roomService) modelToSchema(model models.Room) schemas.RoomSchema {
@@ -80,11 +84,36 @@ func (s roomService) Delete(roomID uint) {
   s.delete(&models.Room{}, roomID)
 }
 
-func (s roomService) GetStatistic(roomID uint) schemas.SensorDataRoomSchema {
-  filters := make(map[string]interface{}, 1)
+func (s roomService) GetStatistic(roomID uint, schema schemas.StatisticFindSchema) schemas.SensorDataRoomSchema {
+  filters := statisticFilters(s.db, schema)
   filters["room_id"] = roomID
   statistic := s.sensorDataRep.GetStatistic(filters)
-  return schemas.SensorDataRoomSchema{Co2: statistic[0].Co2, Tvoc: statistic[0].Tvoc, RoomID: roomID}
+  if len(statistic) == 0 {
+    return schemas.SensorDataRoomSchema{RoomID: roomID}
+  }
+  s.airQuality.classifyRoom(&statistic[0])
+  return statistic[0]
+}
+
+func (s roomService) Compare(roomID uint, schema schemas.ComparisonFindSchema) (schemas.RoomComparisonSchema, error) {
+  window, err := schema.Windows(time.Now())
+  if err != nil {
+    return schemas.RoomComparisonSchema{}, err
+  }
+  episodes, err := episodeCounts(s.db, "room_id", roomID, window.From, window.To)
+  if err != nil {
+    return schemas.RoomComparisonSchema{}, err
+  }
+  previousEpisodes, err := episodeCounts(s.db, "room_id", roomID, window.PreviousFrom, window.PreviousTo)
+  if err != nil {
+    return schemas.RoomComparisonSchema{}, err
+  }
+  current := s.GetStatistic(roomID, schema.Statistic(window.From, window.To))
+  previous := s.GetStatistic(roomID, schema.Statistic(window.PreviousFrom, window.PreviousTo))
+  return schemas.RoomComparisonSchema{
+    ComparisonWindowSchema: window,
+    RoomChangeSchema: CompareRoom(current, previous, episodes[roomID], previousEpisodes[roomID], schema.AggregateList()),
+  }, nil
 }
 
 func (s roomService) FilterByOwnerID(ownerID uint, rooms ...schemas.RoomSchema) []schemas.RoomSchema {
@@ -96,6 +125,6 @@ func (s roomService) FilterByOwnerID(ownerID uint, rooms ...schemas.RoomSchema)
   return filtered
 }
 
-func NewRoomService(db *gorm.DB, sensorDataRep repositories.SensorDataRepository) RoomService {
-  return roomService{baseService: baseService{db: db}, sensorDataRep: sensorDataRep}
+func NewRoomService(db *gorm.DB, sensorDataRep repositories.SensorDataRepository, airQuality AirQuality) RoomService {
+  return roomService{baseService: baseService{db: db}, sensorDataRep: sensorDataRep, airQuality: airQuality}
 }
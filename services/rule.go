@@ -0,0 +1,491 @@
+package services
+
+import (
+  "errors"
+  "log"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+var ErrRuleNotFound = errors.New("Rule not found")
+
+// RuleEvaluator is pure rule algorithm over per-rule, per-sensor state
+type RuleEvaluator struct{}
+
+func (e RuleEvaluator) value(rule models.DetectionRule, reading models.SensorData) int {
+  switch rule.Metric {
+  case schemas.MetricCo2:
+    return reading.Co2
+  case schemas.MetricTvoc:
+    return reading.Tvoc
+  default:
+    return reading.BatteryCharge
+  }
+}
+
+// Feed advances state by reading and reports whether rule triggers. Rule
+// triggers when threshold is breached for MinDuration, then it is silent for
+// Cooldown. Readings older than the last one are ignored.
+func (e RuleEvaluator) Feed(rule models.DetectionRule, state *models.RuleState, reading models.SensorData) bool {
+  if !state.LastAt.IsZero() && !reading.MeasuredAt.After(state.LastAt) {
+    return false
+  }
+  state.LastAt = reading.MeasuredAt
+
+  value := e.value(rule, reading)
+  breached := value > rule.Threshold
+  if rule.Operator == schemas.OperatorBelow {
+    breached = value < rule.Threshold
+  }
+  if !breached {
+    state.BreachStartedAt = nil
+    return false
+  }
+  if state.BreachStartedAt == nil {
+    startedAt := reading.MeasuredAt
+    state.BreachStartedAt = &startedAt
+  }
+  if reading.MeasuredAt.Sub(*state.BreachStartedAt) < time.Duration(rule.MinDuration) * time.Second {
+    return false
+  }
+  if state.LastTriggeredAt != nil && reading.MeasuredAt.Sub(*state.LastTriggeredAt) < time.Duration(rule.Cooldown) * time.Second {
+    return false
+  }
+  triggeredAt := reading.MeasuredAt
+  state.LastTriggeredAt = &triggeredAt
+  return true
+}
+
+type RuleService interface {
+  SensorDataConsumer
+  Take(ruleID uint) (schemas.RuleSchema, error)
+  // FindForZone returns rules defined on zone
+  FindForZone(zoneID uint) ([]schemas.RuleSchema, error)
+  // FindForRoom returns rules effective for room: its own and inherited zone rules
+  FindForRoom(roomID uint, zoneID uint) ([]schemas.RuleSchema, error)
+  Create(zoneID uint, roomID *uint, schema schemas.RuleCreateSchema) (schemas.RuleSchema, error)
+  Update(ruleID uint, schema schemas.RuleUpdateSchema) error
+  Delete(ruleID uint) error
+  FindTriggers(roomID uint) ([]schemas.RuleTriggerSchema, error)
+}
+
+type ruleService struct {
+  baseService
+  evaluator RuleEvaluator
+  incidentService IncidentService
+}
+
+func (s ruleService) modelToSchema(model models.DetectionRule) schemas.RuleSchema {
+  return schemas.RuleSchema{
+    ID: model.ID,
+    ZoneID: model.ZoneID,
+    RoomID: model.RoomID,
+    Name: model.Name,
+    Metric: model.Metric,
+    Operator: model.Operator,
+    Threshold: model.Threshold,
+    MinDuration: model.MinDuration,
+    Cooldown: model.Cooldown,
+    Enabled: model.Enabled,
+    Severity: model.Severity,
+  }
+}
+
+func (s ruleService) Take(ruleID uint) (schemas.RuleSchema, error) {
+  var model models.DetectionRule
+  if err := s.take(ruleID, &model, nil); err != nil {
+    return schemas.RuleSchema{}, ErrRuleNotFound
+  }
+  return s.modelToSchema(model), nil
+}
+
+func (s ruleService) FindForZone(zoneID uint) ([]schemas.RuleSchema, error) {
+  var rules []models.DetectionRule
+  if err := s.db.Where("zone_id = ? AND room_id IS NULL", zoneID).Order("id").Find(&rules).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.RuleSchema, 0, len(rules))
+  for _, rule := range rules {
+    resp = append(resp, s.modelToSchema(rule))
+  }
+  return resp, nil
+}
+
+func (s ruleService) FindForRoom(roomID uint, zoneID uint) ([]schemas.RuleSchema, error) {
+  var rules []models.DetectionRule
+  err := s.db.Where("room_id = ? OR (zone_id = ? AND room_id IS NULL)", roomID, zoneID).Order("id").Find(&rules).Error
+  if err != nil {
+    return nil, err
+  }
+  effective := effectiveRules(roomID, rules)
+  resp := make([]schemas.RuleSchema, 0, len(effective))
+  for _, rule := range effective {
+    schema := s.modelToSchema(rule)
+    schema.Inherited = rule.RoomID == nil
+    resp = append(resp, schema)
+  }
+  return resp, nil
+}
+
+func (s ruleService) Create(zoneID uint, roomID *uint, schema schemas.RuleCreateSchema) (schemas.RuleSchema, error) {
+  model := models.DetectionRule{
+    ZoneID: zoneID,
+    RoomID: roomID,
+    Name: schema.Name,
+    Metric: schema.Metric,
+    Operator: schema.Operator,
+    Threshold: schema.Threshold,
+    MinDuration: schema.MinDuration,
+    Cooldown: schema.Cooldown,
+    Enabled: schema.Enabled == nil || *schema.Enabled,
+    Severity: schema.Severity,
+  }
+  if len(model.Severity) == 0 {
+    model.Severity = schemas.SeverityWarning
+  }
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    if err := tx.Create(&model).Error; err != nil {
+      return err
+    }
+    if roomID == nil {
+      return nil
+    }
+    // Room rule overrides zone rule with the same metric and operator
+    return s.endIneffective(tx, zoneID)
+  })
+  if err != nil {
+    log.Println("Error create rule: ", err)
+    return schemas.RuleSchema{}, err
+  }
+  return s.modelToSchema(model), nil
+}
+
+func (s ruleService) Update(ruleID uint, schema schemas.RuleUpdateSchema) error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    if err := tx.Model(&models.DetectionRule{}).Where("id = ?", ruleID).Updates(schemas.SchemaToMap(schema)).Error; err != nil {
+      return err
+    }
+    var rule models.DetectionRule
+    if err := tx.Take(&rule, ruleID).Error; err != nil {
+      return err
+    }
+    return s.endIneffective(tx, rule.ZoneID)
+  })
+}
+
+func (s ruleService) Delete(ruleID uint) error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    var rule models.DetectionRule
+    if err := tx.Take(&rule, ruleID).Error; err != nil {
+      return err
+    }
+    if err := tx.Delete(&rule).Error; err != nil {
+      return err
+    }
+    if err := s.endIneffective(tx, rule.ZoneID); err != nil {
+      return err
+    }
+    return tx.Where("rule_id = ?", ruleID).Delete(&models.RuleState{}).Error
+  })
+}
+
+// endIneffective ends open incidents of zone rules which don't apply to their
+// sensors anymore: deleted, disabled or overridden by room rule
+func (s ruleService) endIneffective(tx *gorm.DB, zoneID uint) error {
+  // Deleted rules are included, their states are removed after incidents are ended
+  var rules []models.DetectionRule
+  if err := tx.Unscoped().Where("zone_id = ?", zoneID).Find(&rules).Error; err != nil {
+    return err
+  }
+  ruleIDs := make([]uint, 0, len(rules))
+  live := make([]models.DetectionRule, 0, len(rules))
+  for _, rule := range rules {
+    ruleIDs = append(ruleIDs, rule.ID)
+    if !rule.DeletedAt.Valid {
+      live = append(live, rule)
+    }
+  }
+  if len(ruleIDs) == 0 {
+    return nil
+  }
+
+  var states []models.RuleState
+  err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("rule_id IN ? AND incident_id <> 0", ruleIDs).Find(&states).Error
+  if err != nil || len(states) == 0 {
+    return err
+  }
+  sensorIDs := make([]uint, 0, len(states))
+  for _, state := range states {
+    sensorIDs = append(sensorIDs, state.SensorID)
+  }
+  var sensors []models.Sensor
+  if err := tx.Where("id IN ?", sensorIDs).Find(&sensors).Error; err != nil {
+    return err
+  }
+  sensorByID := make(map[uint]models.Sensor, len(sensors))
+  for _, sensor := range sensors {
+    sensorByID[sensor.ID] = sensor
+  }
+
+  now := time.Now()
+  for i := range states {
+    sensor, ok := sensorByID[states[i].SensorID]
+    if ok && sensor.ZoneID == zoneID && ruleApplies(states[i].RuleID, sensor.RoomID, live) {
+      continue
+    }
+    if err := s.endIncident(tx, &states[i], now); err != nil {
+      return err
+    }
+    if err := tx.Save(&states[i]).Error; err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// endIncident ends incident of state whose rule stopped applying, breach
+// starts over if rule applies again
+func (s ruleService) endIncident(tx *gorm.DB, state *models.RuleState, endedAt time.Time) error {
+  if err := s.incidentService.Progress(tx, state.IncidentID, 0, 0, &endedAt); err != nil {
+    return err
+  }
+  state.IncidentID = 0
+  state.BreachStartedAt = nil
+  return nil
+}
+
+func (s ruleService) FindTriggers(roomID uint) ([]schemas.RuleTriggerSchema, error) {
+  var triggers []models.RuleTrigger
+  if err := s.db.Where("room_id = ?", roomID).Order("triggered_at DESC").Find(&triggers).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.RuleTriggerSchema, 0, len(triggers))
+  for _, trigger := range triggers {
+    resp = append(resp, schemas.RuleTriggerSchema{
+      ID: trigger.ID,
+      RuleID: trigger.RuleID,
+      SensorID: trigger.SensorID,
+      RoomID: trigger.RoomID,
+      ZoneID: trigger.ZoneID,
+      Metric: trigger.Metric,
+      Value: trigger.Value,
+      Threshold: trigger.Threshold,
+      BreachStartedAt: trigger.BreachStartedAt,
+      TriggeredAt: trigger.TriggeredAt,
+    })
+  }
+  return resp, nil
+}
+
+// Consume evaluates enabled effective rules of every sensor room. State rows
+// are locked for the batch like in detection.
+func (s ruleService) Consume(data []models.SensorData) {
+  readings := make(map[string][]models.SensorData)
+  guids := make([]string, 0)
+  for _, reading := range data {
+    if _, ok := readings[reading.Guid]; !ok {
+      guids = append(guids, reading.Guid)
+    }
+    readings[reading.Guid] = append(readings[reading.Guid], reading)
+  }
+
+  var sensors []models.Sensor
+  if err := s.db.Where("guid IN ?", guids).Find(&sensors).Error; err != nil {
+    log.Println("Error find sensors for rules: ", err)
+    return
+  }
+  if len(sensors) == 0 {
+    return
+  }
+  roomIDs := make([]uint, 0, len(sensors))
+  zoneIDs := make([]uint, 0, len(sensors))
+  sensorIDs := make([]uint, 0, len(sensors))
+  for _, sensor := range sensors {
+    roomIDs = append(roomIDs, sensor.RoomID)
+    zoneIDs = append(zoneIDs, sensor.ZoneID)
+    sensorIDs = append(sensorIDs, sensor.ID)
+  }
+
+  var rules []models.DetectionRule
+  err := s.db.Where("room_id IN ? OR (zone_id IN ? AND room_id IS NULL)", roomIDs, zoneIDs).Order("id").Find(&rules).Error
+  if err != nil {
+    log.Println("Error find rules: ", err)
+    return
+  }
+  if len(rules) == 0 {
+    return
+  }
+  zoneRules := make(map[uint][]models.DetectionRule)
+  roomRules := make(map[uint][]models.DetectionRule)
+  for _, rule := range rules {
+    if rule.RoomID == nil {
+      zoneRules[rule.ZoneID] = append(zoneRules[rule.ZoneID], rule)
+    } else {
+      roomRules[*rule.RoomID] = append(roomRules[*rule.RoomID], rule)
+    }
+  }
+
+  err = s.db.Transaction(func(tx *gorm.DB) error {
+    var states []models.RuleState
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("sensor_id IN ?", sensorIDs).Find(&states).Error
+    if err != nil {
+      return err
+    }
+    type stateKey struct{ ruleID, sensorID uint }
+    stateByKey := make(map[stateKey]*models.RuleState, len(states))
+    for i := range states {
+      stateByKey[stateKey{states[i].RuleID, states[i].SensorID}] = &states[i]
+    }
+
+    updated := make([]models.RuleState, 0)
+    triggers := make([]models.RuleTrigger, 0)
+    evaluated := make(map[stateKey]bool)
+    for _, sensor := range sensors {
+      candidates := append(append([]models.DetectionRule{}, zoneRules[sensor.ZoneID]...), roomRules[sensor.RoomID]...)
+      for _, rule := range effectiveRules(sensor.RoomID, candidates) {
+        if !rule.Enabled {
+          continue
+        }
+        state, ok := stateByKey[stateKey{rule.ID, sensor.ID}]
+        if !ok {
+          state = &models.RuleState{RuleID: rule.ID, SensorID: sensor.ID}
+        }
+        sensorTriggers, err := s.evaluate(tx, rule, sensor, state, readings[sensor.Guid])
+        if err != nil {
+          return err
+        }
+        evaluated[stateKey{rule.ID, sensor.ID}] = true
+        triggers = append(triggers, sensorTriggers...)
+        updated = append(updated, *state)
+      }
+    }
+    // Rule may stop applying to sensor without rule change, like when sensor
+    // is moved to another room
+    now := time.Now()
+    for i := range states {
+      if states[i].IncidentID == 0 || evaluated[stateKey{states[i].RuleID, states[i].SensorID}] {
+        continue
+      }
+      if err := s.endIncident(tx, &states[i], now); err != nil {
+        return err
+      }
+      updated = append(updated, states[i])
+    }
+    if len(triggers) > 0 {
+      if err := tx.Create(&triggers).Error; err != nil {
+        return err
+      }
+    }
+    if len(updated) == 0 {
+      return nil
+    }
+    return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&updated).Error
+  })
+  if err != nil {
+    log.Println("Error evaluate rules: ", err)
+  }
+}
+
+// evaluate feeds readings of sensor to rule. Trigger opens incident which lasts
+// until breach is over, triggers after cooldown within the same breach are
+// recorded but don't open another incident.
+func (s ruleService) evaluate(tx *gorm.DB, rule models.DetectionRule, sensor models.Sensor, state *models.RuleState, readings []models.SensorData) ([]models.RuleTrigger, error) {
+  var triggers []models.RuleTrigger
+  peakTvoc, peakCo2 := 0, 0
+  for _, reading := range readings {
+    triggered := s.evaluator.Feed(rule, state, reading)
+    if state.IncidentID != 0 && state.BreachStartedAt == nil {
+      endedAt := reading.MeasuredAt
+      if err := s.incidentService.Progress(tx, state.IncidentID, peakTvoc, peakCo2, &endedAt); err != nil {
+        return nil, err
+      }
+      state.IncidentID = 0
+    }
+    if state.IncidentID != 0 {
+      peakTvoc = max(peakTvoc, reading.Tvoc)
+      peakCo2 = max(peakCo2, reading.Co2)
+    }
+    if !triggered {
+      continue
+    }
+
+    triggers = append(triggers, models.RuleTrigger{
+      RuleID: rule.ID,
+      SensorID: sensor.ID,
+      RoomID: sensor.RoomID,
+      ZoneID: sensor.ZoneID,
+      Metric: rule.Metric,
+      Value: s.evaluator.value(rule, reading),
+      Threshold: rule.Threshold,
+      BreachStartedAt: *state.BreachStartedAt,
+      TriggeredAt: reading.MeasuredAt,
+    })
+    if state.IncidentID != 0 {
+      continue
+    }
+    ruleID := rule.ID
+    incident := models.Incident{
+      SensorID: sensor.ID,
+      RoomID: sensor.RoomID,
+      ZoneID: sensor.ZoneID,
+      RuleID: &ruleID,
+      Severity: rule.Severity,
+      StartedAt: *state.BreachStartedAt,
+      PeakTvoc: reading.Tvoc,
+      PeakCo2: reading.Co2,
+    }
+    if err := s.incidentService.Open(tx, &incident); err != nil {
+      return nil, err
+    }
+    state.IncidentID = incident.ID
+    peakTvoc, peakCo2 = 0, 0
+  }
+  if state.IncidentID != 0 && (peakTvoc > 0 || peakCo2 > 0) {
+    if err := s.incidentService.Progress(tx, state.IncidentID, peakTvoc, peakCo2, nil); err != nil {
+      return nil, err
+    }
+  }
+  return triggers, nil
+}
+
+// ruleApplies tells whether rule is enabled and effective in room
+func ruleApplies(ruleID uint, roomID uint, rules []models.DetectionRule) bool {
+  for _, rule := range effectiveRules(roomID, rules) {
+    if rule.ID == ruleID {
+      return rule.Enabled
+    }
+  }
+  return false
+}
+
+// effectiveRules picks rules of room from its own rules and rules of its zone.
+// Zone rule is inherited unless room has own rule with the same metric and
+// operator, disabled room rule switches inherited one off.
+func effectiveRules(roomID uint, rules []models.DetectionRule) []models.DetectionRule {
+  type override struct{ metric, operator string }
+  overridden := make(map[override]bool)
+  for _, rule := range rules {
+    if rule.RoomID != nil && *rule.RoomID == roomID {
+      overridden[override{rule.Metric, rule.Operator}] = true
+    }
+  }
+  effective := make([]models.DetectionRule, 0, len(rules))
+  for _, rule := range rules {
+    if rule.RoomID == nil && overridden[override{rule.Metric, rule.Operator}] {
+      continue
+    }
+    if rule.RoomID != nil && *rule.RoomID != roomID {
+      continue
+    }
+    effective = append(effective, rule)
+  }
+  return effective
+}
+
+func NewRuleService(db *gorm.DB, incidentService IncidentService) RuleService {
+  return ruleService{baseService: baseService{db: db}, incidentService: incidentService}
+}
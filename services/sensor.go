@@ -1,23 +1,31 @@
 package services
 
 import (
+  "errors"
+  "log"
+
   "gorm.io/gorm"
   models "antivape/db"
   "antivape/schemas"
+  "antivape/repositories"
 )
 
+var ErrRoomNotFound = errors.New("Room not found")
+
 type SensorService interface {
   Take(sensorID uint) schemas.SensorSchema
-  Create(schema schemas.SensorCreateSchema) schemas.SensorSchema
+  Create(schema schemas.SensorCreateSchema) (schemas.SensorSchema, error)
   Find(schema schemas.SensorFindSchema) []schemas.SensorSchema
   Update(sensorID uint, schema schemas.SensorUpdateSchema)
   Delete(sensorID uint)
+  GetStatistic(sensorID uint, filters schemas.StatisticFindSchema) schemas.SensorDataSensorSchema
   FilterByOwnerID(ownerID uint, sensors ...schemas.SensorSchema) []schemas.SensorSchema
 }
 
 type sensorService struct {
   baseService
-  db *gorm.DB
+  sensorDataRep repositories.SensorDataRepository
+  airQuality AirQuality
 }
 
 func (s sensorService) modelToSchema(model models.Sensor) schemas.SensorSchema {
@@ -26,7 +34,9 @@ func (s sensorService) modelToSchema(model models.Sensor) schemas.SensorSchema {
     Name: model.Name,
     Guid: model.Guid,
     RoomID: model.RoomID,
+    ZoneID: model.ZoneID,
     OwnerID: model.OwnerID,
+    Heartbeat: model.Heartbeat,
   }
 }
 
@@ -36,19 +46,40 @@ func (s sensorService) Take(sensorID uint) schemas.SensorSchema {
   return s.modelToSchema(model)
 }
 
-func (s sensorService) Create(schema schemas.SensorCreateSchema) schemas.SensorSchema {
-  var room models.Room
-  s.take(schema.RoomID, &room, nil)
-
-  model := models.Sensor{
-    Name: schema.Name,
-    Guid: schema.Guid,
-    RoomID: schema.RoomID,
-    ZoneID: room.ZoneID,
-    OwnerID: schema.OwnerID,
+// Create creates sensor with its secret in one transaction, so sensor never
+// exists without credentials
+func (s sensorService) Create(schema schemas.SensorCreateSchema) (schemas.SensorSchema, error) {
+  var model models.Sensor
+  var credential models.SensorCredential
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    var room models.Room
+    if err := tx.Where("id = ?", schema.RoomID).Take(&room).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+      return ErrRoomNotFound
+    } else if err != nil {
+      return err
+    }
+    model = models.Sensor{
+      Name: schema.Name,
+      Guid: schema.Guid,
+      RoomID: schema.RoomID,
+      ZoneID: room.ZoneID,
+      OwnerID: schema.OwnerID,
+    }
+    if err := tx.Create(&model).Error; err != nil {
+      return err
+    }
+    var err error
+    credential, err = issueCredential(tx, model.ID)
+    return err
+  })
+  if err != nil {
+    log.Println("Error create sensor: ", err)
+    return schemas.SensorSchema{}, err
   }
-  s.create(&model)
-  return s.modelToSchema(model)
+
+  resp := s.modelToSchema(model)
+  resp.Secret = credential.Secret
+  return resp, nil
 }
 
 func (s sensorService) Find(schema schemas.SensorFindSchema) []schemas.SensorSchema {
@@ -75,6 +106,27 @@ func (s sensorService) Delete(sensorID uint) {
   s.delete(&models.Sensor{}, sensorID)
 }
 
+func (s sensorService) GetStatistic(sensorID uint, schema schemas.StatisticFindSchema) schemas.SensorDataSensorSchema {
+  filters := statisticFilters(s.db, schema)
+  filters["sensor_id"] = sensorID
+  statistic := s.sensorDataRep.GetStatistic(filters)
+  if len(statistic) == 0 {
+    return schemas.SensorDataSensorSchema{SensorID: sensorID}
+  }
+  s.airQuality.classifyRoom(&statistic[0])
+  return schemas.SensorDataSensorSchema{
+    Co2: statistic[0].Co2,
+    Tvoc: statistic[0].Tvoc,
+    SensorID: sensorID,
+    Samples: statistic[0].Samples,
+    Co2Band: statistic[0].Co2Band,
+    TvocBand: statistic[0].TvocBand,
+    Band: statistic[0].Band,
+    Co2Aggregates: statistic[0].Co2Aggregates,
+    TvocAggregates: statistic[0].TvocAggregates,
+  }
+}
+
 func (s sensorService) FilterByOwnerID(ownerID uint, sensors ...schemas.SensorSchema) []schemas.SensorSchema {
   var filtered []schemas.SensorSchema
   for _, sensor := range sensors {
@@ -84,6 +136,14 @@ func (s sensorService) FilterByOwnerID(ownerID uint, sensors ...schemas.SensorSc
   return filtered
 }
 
-func NewSensorService(db *gorm.DB) SensorService {
-  return sensorService{baseService: baseService{db: db}}
+func NewSensorService(
+  db *gorm.DB,
+  sensorDataRep repositories.SensorDataRepository,
+  airQuality AirQuality,
+) SensorService {
+  return sensorService{
+    baseService: baseService{db: db},
+    sensorDataRep: sensorDataRep,
+    airQuality: airQuality,
+  }
 }
@@ -0,0 +1,115 @@
+package services
+
+import (
+  "errors"
+  "time"
+
+  "gorm.io/gorm"
+  "antivape/schemas"
+  "antivape/repositories"
+)
+
+const (
+  seriesDefaultInterval = 24 * time.Hour
+  seriesDefaultBucket = "5m"
+  // Longer series would be too heavy to return and to draw
+  maxSeriesBuckets = 10000
+)
+
+var ErrTooManyBuckets = errors.New("Too many buckets, use larger bucket or shorter interval")
+
+// FillSeries adds empty points for buckets in [from, to) without readings.
+// Points must be ordered and aligned to bucket.
+func FillSeries(points []schemas.SeriesPointSchema, from time.Time, to time.Time, bucket time.Duration) []schemas.SeriesPointSchema {
+  filled := make([]schemas.SeriesPointSchema, 0, int(to.Sub(from) / bucket) + 1)
+  next := 0
+  for at := from.Truncate(bucket); at.Before(to); at = at.Add(bucket) {
+    for next < len(points) && points[next].At.Before(at) {
+      next++
+    }
+    if next < len(points) && points[next].At.Equal(at) {
+      filled = append(filled, points[next])
+      next++
+      continue
+    }
+    filled = append(filled, schemas.SeriesPointSchema{At: at})
+  }
+  return filled
+}
+
+type SeriesService interface {
+  FindRoomSeries(roomID uint, schema schemas.SeriesFindSchema) ([]schemas.SeriesSchema, error)
+  FindSensorSeries(sensorID uint, schema schemas.SeriesFindSchema) ([]schemas.SeriesSchema, error)
+}
+
+type seriesService struct {
+  baseService
+  sensorDataRep repositories.SensorDataRepository
+}
+
+// seriesInterval resolves interval and bucket of valid schema
+func seriesInterval(schema schemas.SeriesFindSchema, now time.Time) (time.Time, time.Time, time.Duration, error) {
+  from, to, _ := schema.Interval().Range(now)
+  if to == nil {
+    to = &now
+  }
+  if from == nil {
+    start := to.Add(-seriesDefaultInterval)
+    from = &start
+  }
+  if len(schema.Bucket) == 0 {
+    schema.Bucket = seriesDefaultBucket
+  }
+  bucket := schemas.SeriesBuckets[schema.Bucket]
+  if to.Sub(*from) / bucket > maxSeriesBuckets {
+    return time.Time{}, time.Time{}, 0, ErrTooManyBuckets
+  }
+  return *from, *to, bucket, nil
+}
+
+// find loads series of filters, series without readings are omitted
+func (s seriesService) find(filters map[string]interface{}, schema schemas.SeriesFindSchema) ([]schemas.SeriesSchema, error) {
+  from, to, bucket, err := seriesInterval(schema, time.Now())
+  if err != nil {
+    return nil, err
+  }
+  filters["from"] = from
+  filters["to"] = to
+  filters["bucket"] = int(bucket.Seconds())
+  watermark, err := rollupWatermark(s.db)
+  if err != nil {
+    return nil, err
+  }
+  filters["parts"] = PlanRollups(from, to, bucket)
+  filters["watermark"] = watermark
+  series := s.sensorDataRep.GetSeries(filters)
+  if schema.Fill {
+    for i := range series {
+      series[i].Points = FillSeries(series[i].Points, from, to, bucket)
+    }
+  }
+  return series, nil
+}
+
+func (s seriesService) FindRoomSeries(roomID uint, schema schemas.SeriesFindSchema) ([]schemas.SeriesSchema, error) {
+  bySensor := schema.Granularity == schemas.GranularitySensor
+  series, err := s.find(map[string]interface{}{"room_id": roomID, "by_sensor": bySensor}, schema)
+  if err != nil || len(series) > 0 || bySensor {
+    return series, err
+  }
+  // Room without readings has empty series, like in statistic
+  empty := schemas.SeriesSchema{RoomID: roomID, Points: []schemas.SeriesPointSchema{}}
+  if schema.Fill {
+    from, to, bucket, _ := seriesInterval(schema, time.Now())
+    empty.Points = FillSeries(nil, from, to, bucket)
+  }
+  return append(series, empty), nil
+}
+
+func (s seriesService) FindSensorSeries(sensorID uint, schema schemas.SeriesFindSchema) ([]schemas.SeriesSchema, error) {
+  return s.find(map[string]interface{}{"sensor_id": sensorID, "by_sensor": true}, schema)
+}
+
+func NewSeriesService(db *gorm.DB, sensorDataRep repositories.SensorDataRepository) SeriesService {
+  return seriesService{baseService: baseService{db: db}, sensorDataRep: sensorDataRep}
+}
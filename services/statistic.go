@@ -0,0 +1,118 @@
+package services
+
+import (
+  "errors"
+  "fmt"
+  "log"
+  "time"
+
+  "gorm.io/gorm"
+  "antivape/schemas"
+  "antivape/repositories"
+)
+
+// Readings further apart than this are a gap, it doesn't count in time above
+// threshold and time weighting
+const statisticMaxGap = 5 * time.Minute
+
+var ErrStatisticRetention = errors.New("from is older than retention")
+
+// Bounds of unbounded interval when it is split into rollup parts
+var (
+  statisticBeginning = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+  statisticEnd = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// statisticFilters turns statistic interval and aggregates into repository
+// filters, schema must be valid. Rollups serve statistic unless it needs
+// single readings.
+func statisticFilters(db *gorm.DB, schema schemas.StatisticFindSchema) map[string]interface{} {
+  now := time.Now()
+  filters := map[string]interface{}{
+    "max_gap": statisticMaxGap.Seconds(),
+    "aggregates": schema.AggregateList(),
+    "weighting": schema.Weighting,
+    "end": now,
+  }
+  if schema.Co2Above != nil {
+    filters["co2_above"] = *schema.Co2Above
+  }
+  if schema.TvocAbove != nil {
+    filters["tvoc_above"] = *schema.TvocAbove
+  }
+  from, to, _ := schema.Range(now)
+  if from != nil {
+    filters["from"] = *from
+  }
+  if to != nil {
+    filters["to"] = *to
+    filters["end"] = *to
+  }
+  if !rollupsServe(schema) {
+    return filters
+  }
+
+  watermark, err := rollupWatermark(db)
+  if err != nil {
+    log.Println("Error take rollup watermark: ", err)
+    return filters
+  }
+  if from == nil {
+    from = &statisticBeginning
+  }
+  if to == nil {
+    to = &statisticEnd
+  }
+  filters["parts"] = PlanRollups(*from, *to, 24 * time.Hour)
+  filters["watermark"] = watermark
+  return filters
+}
+
+// rollupsServe checks if statistic can be computed from rollups, which have
+// only counts, sums, minimums and maximums
+func rollupsServe(schema schemas.StatisticFindSchema) bool {
+  if schema.Weighting == schemas.WeightingTime {
+    return false
+  }
+  for _, aggregate := range schema.AggregateList() {
+    switch aggregate {
+    case schemas.AggregateAvg, schemas.AggregateMin, schemas.AggregateMax, schemas.AggregateStddev, schemas.AggregateCount:
+    default:
+      return false
+    }
+  }
+  return true
+}
+
+// StatisticRetained checks that readings and rollups statistic is computed
+// from at now aren't purged by retention. Statistic of interval without from
+// is statistic of data kept, so only explicit from may be too old.
+func StatisticRetained(schema schemas.StatisticFindSchema, retention schemas.RetentionSchema, now time.Time) error {
+  from, to, _ := schema.Range(now)
+  if from == nil {
+    return nil
+  }
+  if to == nil {
+    to = &statisticEnd
+  }
+  parts := []repositories.RollupPart{{From: *from, To: *to}}
+  if rollupsServe(schema) {
+    parts = PlanRollups(*from, *to, 24 * time.Hour)
+  }
+  for _, part := range parts {
+    table := part.Table
+    if len(table) == 0 {
+      table = "sensor_data"
+    }
+    for _, target := range retentionTargets {
+      days := target.days(retention)
+      if target.table != table || days == 0 {
+        continue
+      }
+      if part.From.Before(now.AddDate(0, 0, -days)) {
+        return fmt.Errorf("%w: %s is kept for %d days", ErrStatisticRetention, table, days)
+      }
+    }
+  }
+  return nil
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  models "antivape/db"
+  "antivape/schemas"
+)
+
+const (
+  deliveryBatchSize = 20
+  deliveryPoll = 5 * time.Second
+
+  WebhookDeliveryHeader = "X-Webhook-Delivery"
+  WebhookEventHeader = "X-Webhook-Event"
+  WebhookTimestampHeader = "X-Webhook-Timestamp"
+  WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var ErrWebhookNotFound = errors.New("Webhook not found")
+
+type WebhookConfig struct {
+  // Delivery is failed after this many attempts
+  MaxAttempts int
+  // Delay before second attempt, it doubles with every next one up to BackoffMax
+  BackoffBase time.Duration
+  BackoffMax time.Duration
+  Timeout time.Duration
+}
+
+// WebhookSender posts payload signed like sensor requests: hex HMAC-SHA256
+// of "<timestamp>.<body>" with webhook secret
+type WebhookSender struct {
+  client *http.Client
+}
+
+// Send returns response status code, non-2xx status is an error
+func (s WebhookSender) Send(url, secret string, deliveryID uint, eventType string, body []byte) (int, error) {
+  req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+  if err != nil {
+    return 0, err
+  }
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(deliveryID), 10))
+  req.Header.Set(WebhookEventHeader, eventType)
+  req.Header.Set(WebhookTimestampHeader, timestamp)
+  req.Header.Set(WebhookSignatureHeader, Sign(secret, timestamp, body))
+
+  resp, err := s.client.Do(req)
+  if err != nil {
+    return 0, err
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+    return resp.StatusCode, fmt.Errorf("Unexpected status code %d", resp.StatusCode)
+  }
+  return resp.StatusCode, nil
+}
+
+func NewWebhookSender(timeout time.Duration) WebhookSender {
+  return WebhookSender{client: &http.Client{Timeout: timeout}}
+}
+
+type WebhookService interface {
+  EventSubscriber
+  Take(webhookID uint) (schemas.WebhookSchema, error)
+  // Find returns webhooks of zones owned by user, all of them for superuser
+  Find(userID uint, superuser bool, filters schemas.WebhookFindSchema) ([]schemas.WebhookSchema, error)
+  Create(schema schemas.WebhookCreateSchema) (schemas.WebhookSchema, error)
+  Update(webhookID uint, schema schemas.WebhookUpdateSchema) error
+  Delete(webhookID uint) error
+  FindDeliveries(webhookID uint) ([]schemas.WebhookDeliverySchema, error)
+  // SendTest sends test event once, without retries
+  SendTest(webhookID uint) (schemas.WebhookDeliverySchema, error)
+  RunDeliveryCycle()
+}
+
+// webhookService enqueues deliveries in transaction of event, worker sends
+// them with retries. Delivery is at-least-once, receivers dedupe by delivery id.
+type webhookService struct {
+  baseService
+  sender WebhookSender
+  config WebhookConfig
+}
+
+func (s webhookService) modelToSchema(model models.Webhook) schemas.WebhookSchema {
+  eventTypes := make([]string, 0)
+  if len(model.EventTypes) > 0 {
+    eventTypes = strings.Split(model.EventTypes, ",")
+  }
+  return schemas.WebhookSchema{
+    ID: model.ID,
+    ZoneID: model.ZoneID,
+    Url: model.Url,
+    EventTypes: eventTypes,
+    Enabled: model.Enabled,
+  }
+}
+
+func (s webhookService) deliveryToSchema(model models.WebhookDelivery) schemas.WebhookDeliverySchema {
+  return schemas.WebhookDeliverySchema{
+    ID: model.ID,
+    WebhookID: model.WebhookID,
+    EventType: model.EventType,
+    State: model.State,
+    Attempts: model.Attempts,
+    NextAttemptAt: model.NextAttemptAt,
+    LastStatusCode: model.LastStatusCode,
+    LastError: model.LastError,
+    DeliveredAt: model.DeliveredAt,
+    CreatedAt: model.CreatedAt,
+  }
+}
+
+func (s webhookService) Take(webhookID uint) (schemas.WebhookSchema, error) {
+  var model models.Webhook
+  if err := s.take(webhookID, &model, nil); err != nil {
+    return schemas.WebhookSchema{}, ErrWebhookNotFound
+  }
+  return s.modelToSchema(model), nil
+}
+
+func (s webhookService) Find(userID uint, superuser bool, filters schemas.WebhookFindSchema) ([]schemas.WebhookSchema, error) {
+  query := s.db.Model(&models.Webhook{}).Order("webhooks.id")
+  if !superuser {
+    query = query.Joins("JOIN zones ON zones.id = webhooks.zone_id").Where("zones.owner_id = ?", userID)
+  }
+  if filters.ZoneID != nil {
+    query = query.Where("webhooks.zone_id = ?", *filters.ZoneID)
+  }
+  var webhooks []models.Webhook
+  if err := query.Find(&webhooks).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.WebhookSchema, 0, len(webhooks))
+  for _, webhook := range webhooks {
+    resp = append(resp, s.modelToSchema(webhook))
+  }
+  return resp, nil
+}
+
+func (s webhookService) Create(schema schemas.WebhookCreateSchema) (schemas.WebhookSchema, error) {
+  secret, err := generateSecret()
+  if err != nil {
+    return schemas.WebhookSchema{}, err
+  }
+  model := models.Webhook{
+    ZoneID: schema.ZoneID,
+    Url: schema.Url,
+    Secret: secret,
+    EventTypes: strings.Join(schema.EventTypes, ","),
+    Enabled: schema.Enabled == nil || *schema.Enabled,
+  }
+  if err := s.create(&model); err != nil {
+    return schemas.WebhookSchema{}, err
+  }
+  resp := s.modelToSchema(model)
+  resp.Secret = secret
+  return resp, nil
+}
+
+func (s webhookService) Update(webhookID uint, schema schemas.WebhookUpdateSchema) error {
+  fields := make(map[string]interface{})
+  if schema.Url != nil {
+    fields["url"] = *schema.Url
+  }
+  if schema.EventTypes != nil {
+    fields["event_types"] = strings.Join(*schema.EventTypes, ",")
+  }
+  if schema.Enabled != nil {
+    fields["enabled"] = *schema.Enabled
+  }
+  if len(fields) == 0 {
+    return nil
+  }
+  return s.update(&models.Webhook{}, webhookID, fields)
+}
+
+func (s webhookService) Delete(webhookID uint) error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    err := tx.Model(&models.WebhookDelivery{}).
+      Where("webhook_id = ? AND state = ?", webhookID, schemas.DeliveryPending).
+      Updates(map[string]interface{}{"state": schemas.DeliveryFailed, "last_error": "Webhook deleted"}).Error
+    if err != nil {
+      return err
+    }
+    return tx.Delete(&models.Webhook{}, webhookID).Error
+  })
+}
+
+func (s webhookService) FindDeliveries(webhookID uint) ([]schemas.WebhookDeliverySchema, error) {
+  var deliveries []models.WebhookDelivery
+  if err := s.db.Where("webhook_id = ?", webhookID).Order("id DESC").Limit(100).Find(&deliveries).Error; err != nil {
+    return nil, err
+  }
+  resp := make([]schemas.WebhookDeliverySchema, 0, len(deliveries))
+  for _, delivery := range deliveries {
+    resp = append(resp, s.deliveryToSchema(delivery))
+  }
+  return resp, nil
+}
+
+// Handle enqueues delivery of event to every enabled zone webhook subscribed to it
+func (s webhookService) Handle(tx *gorm.DB, event schemas.EventSchema) error {
+  var webhooks []models.Webhook
+  if err := tx.Where("zone_id = ? AND enabled", event.ZoneID).Find(&webhooks).Error; err != nil {
+    return err
+  }
+  payload, err := json.Marshal(event)
+  if err != nil {
+    return err
+  }
+
+  deliveries := make([]models.WebhookDelivery, 0, len(webhooks))
+  for _, webhook := range webhooks {
+    if !subscribedTo(webhook.EventTypes, event.Type) {
+      continue
+    }
+    deliveries = append(deliveries, models.WebhookDelivery{
+      WebhookID: webhook.ID,
+      EventType: event.Type,
+      Payload: string(payload),
+      State: schemas.DeliveryPending,
+      NextAttemptAt: time.Now(),
+    })
+  }
+  if len(deliveries) == 0 {
+    return nil
+  }
+  return tx.Create(&deliveries).Error
+}
+
+func (s webhookService) SendTest(webhookID uint) (schemas.WebhookDeliverySchema, error) {
+  var webhook models.Webhook
+  if err := s.take(webhookID, &webhook, nil); err != nil {
+    return schemas.WebhookDeliverySchema{}, ErrWebhookNotFound
+  }
+  payload, err := json.Marshal(schemas.EventSchema{Type: schemas.EventTest, ZoneID: webhook.ZoneID, OccurredAt: time.Now()})
+  if err != nil {
+    return schemas.WebhookDeliverySchema{}, err
+  }
+  delivery := models.WebhookDelivery{
+    WebhookID: webhook.ID,
+    EventType: schemas.EventTest,
+    Payload: string(payload),
+    State: schemas.DeliveryPending,
+    // Worker must not pick it up while it is sent here
+    NextAttemptAt: time.Now().Add(s.lease()),
+  }
+  if err := s.create(&delivery); err != nil {
+    return schemas.WebhookDeliverySchema{}, err
+  }
+  s.attempt(webhook, &delivery, false)
+  return s.deliveryToSchema(delivery), nil
+}
+
+// RunDeliveryCycle sends due deliveries. Every app replica runs it, due
+// deliveries are claimed with SKIP LOCKED and leased for the send timeout.
+func (s webhookService) RunDeliveryCycle() {
+  for {
+    if s.deliverDue() == 0 {
+      time.Sleep(deliveryPoll)
+    }
+  }
+}
+
+func (s webhookService) lease() time.Duration {
+  return s.config.Timeout + 30 * time.Second
+}
+
+func (s webhookService) deliverDue() int {
+  var deliveries []models.WebhookDelivery
+  now := time.Now()
+  err := s.db.Transaction(func(tx *gorm.DB) error {
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+      Where("state = ? AND next_attempt_at <= ?", schemas.DeliveryPending, now).
+      Order("next_attempt_at").
+      Limit(deliveryBatchSize).
+      Find(&deliveries).Error
+    if err != nil || len(deliveries) == 0 {
+      return err
+    }
+    ids := make([]uint, 0, len(deliveries))
+    for _, delivery := range deliveries {
+      ids = append(ids, delivery.ID)
+    }
+    return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(s.lease())).Error
+  })
+  if err != nil {
+    log.Println("Error claim webhook deliveries: ", err)
+    return 0
+  }
+  if len(deliveries) == 0 {
+    return 0
+  }
+
+  webhookIDs := make([]uint, 0, len(deliveries))
+  for _, delivery := range deliveries {
+    webhookIDs = append(webhookIDs, delivery.WebhookID)
+  }
+  var webhooks []models.Webhook
+  if err := s.db.Where("id IN ?", webhookIDs).Find(&webhooks).Error; err != nil {
+    log.Println("Error find webhooks: ", err)
+    return 0
+  }
+  webhookByID := make(map[uint]models.Webhook, len(webhooks))
+  for _, webhook := range webhooks {
+    webhookByID[webhook.ID] = webhook
+  }
+
+  var wg sync.WaitGroup
+  for i := range deliveries {
+    webhook, ok := webhookByID[deliveries[i].WebhookID]
+    if !ok {
+      deliveries[i].State = schemas.DeliveryFailed
+      deliveries[i].LastError = "Webhook deleted"
+      s.db.Save(&deliveries[i])
+      continue
+    }
+    wg.Add(1)
+    go func(delivery *models.WebhookDelivery) {
+      defer wg.Done()
+      s.attempt(webhook, delivery, true)
+    }(&deliveries[i])
+  }
+  wg.Wait()
+  return len(deliveries)
+}
+
+// attempt sends delivery and stores result. Failed delivery is scheduled
+// with exponential backoff until MaxAttempts if retry is set.
+func (s webhookService) attempt(webhook models.Webhook, delivery *models.WebhookDelivery, retry bool) {
+  status, err := s.sender.Send(webhook.Url, webhook.Secret, delivery.ID, delivery.EventType, []byte(delivery.Payload))
+  now := time.Now()
+  delivery.Attempts++
+  delivery.LastStatusCode = status
+  if err == nil {
+    delivery.State = schemas.DeliveryDelivered
+    delivery.DeliveredAt = &now
+    delivery.LastError = ""
+  } else {
+    delivery.LastError = err.Error()
+    if !retry || delivery.Attempts >= s.config.MaxAttempts {
+      delivery.State = schemas.DeliveryFailed
+    } else {
+      delivery.NextAttemptAt = now.Add(retryBackoff(s.config.BackoffBase, s.config.BackoffMax, delivery.Attempts))
+    }
+  }
+  if err := s.db.Save(delivery).Error; err != nil {
+    log.Println("Error save webhook delivery: ", err)
+  }
+}
+
+func NewWebhookService(db *gorm.DB, config WebhookConfig) WebhookService {
+  return webhookService{baseService: baseService{db: db}, sender: NewWebhookSender(config.Timeout), config: config}
+}
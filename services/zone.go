@@ -1,6 +1,8 @@
 package services
 
 import (
+  "time"
+
   "gorm.io/gorm"
   models "antivape/db"
   "antivape/schemas"
@@ -12,16 +14,18 @@ type ZoneService interface {
   Create(schema schemas.ZoneCreateSchema) schemas.ZoneSchema
   Find(schema schemas.ZoneFindSchema) []schemas.ZoneSchema
   Update(zoneID uint, schema schemas.ZoneUpdateSchema)
-  Delete(zoneID uint)
+  Delete(zoneID uint) error
   FilterByOwnerID(ownerID uint, zones ...schemas.ZoneSchema) []schemas.ZoneSchema
-  GetStatistic(zoneID uint) schemas.SensorDataZoneSchema
+  GetStatistic(zoneID uint, filters schemas.StatisticFindSchema) schemas.SensorDataZoneSchema
+  // Compare returns zone rooms statistic of interval against previous interval, rooms are ranked by change
+  Compare(zoneID uint, filters schemas.ComparisonFindSchema) (schemas.ZoneComparisonSchema, error)
 }
 
 type zoneService struct {
   baseService
-  db *gorm.DB
   sensorDataRep repositories.SensorDataRepository
   sensorRep repositories.SensorRepository
+  airQuality AirQuality
 }
 
 func (s zoneService) modelToSchema(model models.Zone) schemas.ZoneSchema {
@@ -75,19 +79,92 @@ func (s zoneService) Update(zoneID uint, schema schemas.ZoneUpdateSchema) {
   s.update(&models.Zone{}, zoneID, m)
 }
 
-func (s zoneService) Delete(zoneID uint) {
-  s.delete(&models.Zone{}, zoneID)
+// zoneConfiguration are models configured for zone, they are deleted with
+// it. History of zone, like incidents and reports, is kept.
+var zoneConfiguration = []interface{}{
+  &models.DetectionRule{},
+  &models.Gateway{},
+  &models.Webhook{},
+  &models.NotificationSubscription{},
+  &models.NotificationPolicy{},
+  &models.RetentionPolicy{},
+  &models.ReportDefinition{},
+}
+
+// Delete removes zone with its configuration in one transaction, so nothing
+// configured for zone, like its gateways, works after it is gone
+func (s zoneService) Delete(zoneID uint) error {
+  return s.db.Transaction(func(tx *gorm.DB) error {
+    // Incidents of zone rules are ended like when rule is deleted
+    err := tx.Model(&models.Incident{}).
+      Where("zone_id = ? AND rule_id IS NOT NULL AND ended_at IS NULL", zoneID).
+      Update("ended_at", time.Now()).Error
+    if err != nil {
+      return err
+    }
+    rules := tx.Model(&models.DetectionRule{}).Select("id").Where("zone_id = ?", zoneID)
+    if err := tx.Where("rule_id IN (?)", rules).Delete(&models.RuleState{}).Error; err != nil {
+      return err
+    }
+    for _, model := range zoneConfiguration {
+      if err := tx.Where("zone_id = ?", zoneID).Delete(model).Error; err != nil {
+        return err
+      }
+    }
+    return tx.Delete(&models.Zone{}, zoneID).Error
+  })
 }
 
-func (s zoneService) GetStatistic(zoneID uint) schemas.SensorDataZoneSchema {
-  filters := make(map[string]interface{}, 1)
+func (s zoneService) GetStatistic(zoneID uint, schema schemas.StatisticFindSchema) schemas.SensorDataZoneSchema {
+  filters := statisticFilters(s.db, schema)
   filters["zone_id"] = zoneID
 
   statistic := s.sensorDataRep.GetStatistic(filters)
+  for i := range statistic {
+    s.airQuality.classifyRoom(&statistic[i])
+  }
 
   return schemas.SensorDataZoneSchema{Rooms: statistic, ZoneID: zoneID}
 }
 
+func (s zoneService) Compare(zoneID uint, schema schemas.ComparisonFindSchema) (schemas.ZoneComparisonSchema, error) {
+  window, err := schema.Windows(time.Now())
+  if err != nil {
+    return schemas.ZoneComparisonSchema{}, err
+  }
+  episodes, err := episodeCounts(s.db, "zone_id", zoneID, window.From, window.To)
+  if err != nil {
+    return schemas.ZoneComparisonSchema{}, err
+  }
+  previousEpisodes, err := episodeCounts(s.db, "zone_id", zoneID, window.PreviousFrom, window.PreviousTo)
+  if err != nil {
+    return schemas.ZoneComparisonSchema{}, err
+  }
+  current := s.GetStatistic(zoneID, schema.Statistic(window.From, window.To))
+  previous := make(map[uint]schemas.SensorDataRoomSchema)
+  for _, room := range s.GetStatistic(zoneID, schema.Statistic(window.PreviousFrom, window.PreviousTo)).Rooms {
+    previous[room.RoomID] = room
+  }
+
+  rooms := make([]schemas.RoomChangeSchema, 0, len(current.Rooms))
+  for _, room := range current.Rooms {
+    lastRoom, ok := previous[room.RoomID]
+    if !ok {
+      lastRoom = schemas.SensorDataRoomSchema{RoomID: room.RoomID}
+    }
+    rooms = append(rooms, CompareRoom(room, lastRoom, episodes[room.RoomID], previousEpisodes[room.RoomID], schema.AggregateList()))
+  }
+  RankRooms(rooms, schema.RankMetric())
+  comparison := schemas.ZoneComparisonSchema{
+    ComparisonWindowSchema: window,
+    ZoneID: zoneID,
+    Rank: schema.RankMetric(),
+    Rooms: rooms,
+  }
+  comparison.Co2, comparison.Tvoc, comparison.Episodes = CompareZone(rooms)
+  return comparison, nil
+}
+
 func (s zoneService) FilterByOwnerID(ownerID uint, zones ...schemas.ZoneSchema) []schemas.ZoneSchema {
   var filtered []schemas.ZoneSchema
   for _, zone := range zones {
@@ -97,6 +174,6 @@ func (s zoneService) FilterByOwnerID(ownerID uint, zones ...schemas.ZoneSchema)
   return filtered
 }
 
-func NewZoneService(db *gorm.DB, sensorDataRep repositories.SensorDataRepository) ZoneService {
-  return zoneService{baseService: baseService{db: db}, sensorDataRep: sensorDataRep}
+func NewZoneService(db *gorm.DB, sensorDataRep repositories.SensorDataRepository, airQuality AirQuality) ZoneService {
+  return zoneService{baseService: baseService{db: db}, sensorDataRep: sensorDataRep, airQuality: airQuality}
 }